@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bundleOutput string
+	bundleBinary string
+	bundleDir    string
+	bundleEngine string
+)
+
+// bundleCmd packages functions for an edge machine
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Bundle the config's functions for an edge machine",
+	Long:  "Build the config's functions and write their images, the config and the slrun binary to a single archive, installed with slrun bundle install on a machine without network access.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		err = slrun.ConnectDocker(config.Backend)
+		if err != nil {
+			return err
+		}
+		slrun.UseRegistryAuths(config.Registry)
+		err = slrun.UseRemoteBuild(config.RemoteBuild)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(bundleOutput)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		manifest, err := slrun.CreateBundle(config, cfgFile, bundleBinary, file)
+		if err != nil {
+			os.Remove(bundleOutput)
+			return err
+		}
+		for name, img := range manifest.Functions {
+			fmt.Printf("Bundled function %v image %v\n", name, img)
+		}
+		fmt.Printf("Wrote %v\n", bundleOutput)
+		return nil
+	},
+}
+
+// bundleInstallCmd installs a bundle on the edge machine
+var bundleInstallCmd = &cobra.Command{
+	Use:   "install <archive>",
+	Short: "Install a bundle created with slrun bundle",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := slrun.ConnectDocker(&types.Backend{Engine: bundleEngine})
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		manifest, err := slrun.InstallBundle(file, bundleDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %v functions bundled by slrun %v\n", len(manifest.Functions), manifest.Version)
+		fmt.Printf("Run: %v --config %v --offline\n", filepath.Join(bundleDir, "slrun"), filepath.Join(bundleDir, "config.json"))
+		return nil
+	},
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleOutput, "output", "o", "bundle.tar.gz", "archive to write")
+	bundleCmd.Flags().StringVar(&bundleBinary, "binary", "", "slrun binary to bundle, for another platform (default this one)")
+	bundleInstallCmd.Flags().StringVar(&bundleDir, "dir", ".", "directory to install the config and slrun binary in")
+	bundleInstallCmd.Flags().StringVar(&bundleEngine, "engine", slrun.BackendDocker, "container engine to load the images into, docker or podman")
+	bundleCmd.AddCommand(bundleInstallCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
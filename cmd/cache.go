@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd groups commands managing function build caches
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage function build caches",
+}
+
+// cachePruneCmd removes cache volumes and BuildKit cache mounts
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune [function...]",
+	Short: "Remove function build caches",
+	Long:  "Remove the cache volumes of the config's functions, or only of the functions named, and, if none are named, BuildKit's cache mounts. Caches in use by running containers are kept.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		err = slrun.ConnectDocker(config.Backend)
+		if err != nil {
+			return err
+		}
+
+		pruned, err := slrun.PruneCaches(config, args)
+		if err != nil {
+			return err
+		}
+		for _, v := range pruned.Volumes {
+			fmt.Printf("Removed volume %v\n", v)
+		}
+		if len(args) == 0 {
+			fmt.Printf("Removed BuildKit cache mounts, reclaiming %.1f MB\n", float64(pruned.BuildBytes)/1e6)
+		} else if len(pruned.Volumes) == 0 {
+			fmt.Println("Nothing to prune")
+		}
+		return nil
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// checkRangeCmd verifies a function's range request handling through the gateway
+var checkRangeCmd = &cobra.Command{
+	Use:   "check-range <url>",
+	Short: "Verify range and conditional request handling",
+	Long:  "Verify that range and conditional requests to a URL, e.g. a function behind a running gateway, get correct 206, 304 and 416 responses.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		checks, err := slrun.CheckRange(args[0])
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, c := range checks {
+			result := "PASS"
+			if !c.Passed {
+				result = "FAIL"
+				failed++
+			}
+			fmt.Printf("%v  %-20v %v\n", result, c.Name, c.Detail)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%v of %v checks failed", failed, len(checks))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkRangeCmd)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFrom          string
+	diffTarget        string
+	diffIgnoreHeaders []string
+	diffIgnoreFields  []string
+)
+
+// diffCmd compares the responses of two functions to the same requests
+var diffCmd = &cobra.Command{
+	Use:   "diff <function> <other-function>",
+	Short: "Compare two functions' responses to recorded requests",
+	Long: "Send each request recorded in a HAR capture or an access log to two functions, e.g. a function and its canary, " +
+		"and report the requests whose responses differ in status, headers or normalized body.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		requests, err := slrun.ReadReplayFile(config, diffFrom)
+		if err != nil {
+			return err
+		}
+		target := strings.TrimSuffix(cmp.Or(diffTarget, slrun.GatewayURL(config, port)), "/")
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		opts := slrun.GoldenOptions{IgnoreHeaders: diffIgnoreHeaders, IgnoreFields: diffIgnoreFields}
+		diffs, err := slrun.DiffFunctions(ctx, requests, target+"/"+args[0], target+"/"+args[1], opts)
+		for _, d := range diffs {
+			fmt.Printf("%v %v\n", d.Request.Method, cmp.Or(d.Request.Path, "/"))
+			for _, line := range d.Diffs {
+				fmt.Printf("  %v\n", line)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%v requests, %v with different responses\n", len(requests), len(diffs))
+		if len(diffs) > 0 {
+			return fmt.Errorf("%v and %v responded differently", args[0], args[1])
+		}
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffFrom, "from", "", "HAR capture or Common Log Format access log of the requests to send")
+	diffCmd.Flags().StringVar(&diffTarget, "target", "", "gateway URL, default the config's first listener")
+	diffCmd.Flags().StringSliceVar(&diffIgnoreHeaders, "ignore-header", nil, "response header to ignore, besides Date, Content-Length and other per-response headers")
+	diffCmd.Flags().StringSliceVar(&diffIgnoreFields, "ignore-field", nil, "JSON body field to ignore at any depth, e.g. a timestamp")
+	diffCmd.Flags().IntVar(&port, "port", 8080, "gateway port, if the config has no listeners")
+	diffCmd.MarkFlagRequired("from")
+	rootCmd.AddCommand(diffCmd)
+}
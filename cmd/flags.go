@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// flagsCmd lists and sets functions' feature flags
+var flagsCmd = &cobra.Command{
+	Use:   "flags [function [flag=on|off|reset]...]",
+	Short: "List or set feature flags",
+	Long: "List the running slrun's feature flags, of every function or of one, or set a function's flags, " +
+		"e.g. slrun flags func1 beta-ui=on new-pricing=reset. Changes apply from the next request, without restarts.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+
+		if len(args) > 1 {
+			for _, arg := range args[1:] {
+				flag, value, _ := strings.Cut(arg, "=")
+				switch value {
+				case "on", "true":
+					_, err = client.SetFlag(args[0], flag, true)
+				case "off", "false":
+					_, err = client.SetFlag(args[0], flag, false)
+				case "reset":
+					_, err = client.ResetFlag(args[0], flag)
+				default:
+					return fmt.Errorf("invalid flag setting %q, expected flag=on, flag=off or flag=reset", arg)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		flags, err := client.Flags()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "FUNCTION\tFLAG\tVALUE")
+		for _, function := range slices.Sorted(maps.Keys(flags)) {
+			if len(args) > 0 && function != args[0] {
+				continue
+			}
+			for _, flag := range slices.Sorted(maps.Keys(flags[function])) {
+				value := "off"
+				if flags[function][flag] {
+					value = "on"
+				}
+				fmt.Fprintf(w, "%v\t%v\t%v\n", function, flag, value)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(flagsCmd)
+}
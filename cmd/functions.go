@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// adminClient returns a client of the admin API of the daemon running the config.
+func adminClient() (*slrun.AdminClient, error) {
+	config, err := slrun.ReadConfigFile(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	if config.AdminAddress == "" {
+		return nil, fmt.Errorf("config %v has no admin_address", cfgFile)
+	}
+	return slrun.NewAdminClient(config.AdminAddress, config.AdminToken), nil
+}
+
+// statusCmd shows the state of the running daemon's functions
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show function status",
+	Long:  "Show the state of the functions of the running slrun, through its admin API.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATE\tHEALTH\tRESTARTS\tURL\tPORT\tDEBUG PORT")
+		for _, f := range status.Functions {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", f.Name, f.State, cmp.Or(f.Health, "-"), f.Restarts, f.URL, portString(f.Port), portString(f.DebugPort))
+		}
+		w.Flush()
+
+		for _, f := range status.Functions {
+			if f.RetryAt == nil {
+				continue
+			}
+			fmt.Printf("\n%v is crash looping, retrying at %v: %v", f.Name, f.RetryAt.Format(time.TimeOnly), f.Reason)
+			if len(f.ExitCodes) > 0 {
+				fmt.Printf(" (recent exit codes %v)", f.ExitCodes)
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+// sloCmd shows the running daemon's functions' compliance with their SLOs
+var sloCmd = &cobra.Command{
+	Use:   "slo",
+	Short: "Show SLO compliance",
+	Long:  "Show the compliance of functions with their latency and error objectives, through the admin API of the running slrun.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		statuses, err := client.SLOs()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "NAME\tWINDOW\tINVOCATIONS\tLATENCY\tERRORS\tBUDGET LEFT\tBURNING")
+		for _, s := range statuses {
+			burning := "-"
+			if len(s.Burning) > 0 {
+				burning = strings.Join(s.Burning, ",")
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%.1f%%\t%v\n", s.Function, s.Window, s.Invocations,
+				objectiveString(s.LatencyGood, s.LatencyObjective), objectiveString(s.ErrorGood, s.ErrorObjective),
+				s.BudgetRemaining*100, burning)
+		}
+		return nil
+	},
+}
+
+// quarantineCmd lists the running daemon's quarantined functions
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "List quarantined functions",
+	Long:  "List the functions the running slrun quarantined for violating rules, with their violations, through its admin API.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		records, err := client.Quarantine()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "NAME\tSINCE\tSOURCE\tREASON")
+		for _, record := range records {
+			for i, v := range record.Violations {
+				name, since := "", ""
+				if i == 0 {
+					name, since = record.Function, record.Since.Format(time.DateTime)
+				}
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", name, since, v.Source, v.Reason)
+			}
+		}
+		return nil
+	},
+}
+
+// fleetCmd lists the functions of the fleet reporting to the running daemon
+var fleetCmd = &cobra.Command{
+	Use:   "fleet",
+	Short: "List the functions of a fleet",
+	Long:  "List the functions every instance of the fleet reported to the running slrun, its aggregator, through its admin API.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		instances, err := client.Fleet()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "INSTANCE\tFUNCTION\tSTATE\tHEALTH\tINVOCATIONS\tLAST SEEN")
+		for _, instance := range instances {
+			seen := instance.LastSeen.Format(time.DateTime)
+			if instance.Stale {
+				seen += " (stale)"
+			}
+			for _, f := range instance.Functions {
+				health := f.Health
+				if health == "" {
+					health = "-"
+				}
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", instance.Name, f.Name, f.State, health, f.Invocations, seen)
+			}
+		}
+		return nil
+	},
+}
+
+var (
+	drainTimeout time.Duration
+	drainStop    bool
+)
+
+// drainCmd stops the running daemon's gateway taking invocations
+var drainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Stop the gateway accepting invocations",
+	Long:  "Stop the gateway of the running slrun accepting invocations, and wait for those in flight to finish. Undo with undrain.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		err = client.Drain(drainTimeout, drainStop)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Gateway drained")
+		return nil
+	},
+}
+
+var undrainCmd = &cobra.Command{
+	Use:   "undrain",
+	Short: "Make a drained gateway accept invocations again",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		err = client.Undrain()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Gateway accepting invocations")
+		return nil
+	},
+}
+
+// objectiveString shows the share of good invocations against its objective.
+func objectiveString(good float64, objective float64) string {
+	if objective == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%/%.2f%%", good*100, objective*100)
+}
+
+func portString(port int) string {
+	if port == 0 {
+		return "-"
+	}
+	return fmt.Sprint(port)
+}
+
+// functionActionCmd returns a command applying action to a function of the running daemon.
+func functionActionCmd(action string, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " <function>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := adminClient()
+			if err != nil {
+				return err
+			}
+			state, err := client.FunctionAction(args[0], action)
+			if err != nil {
+				return err
+			}
+			if action == "rebuild" {
+				fmt.Printf("Rebuilding function %v, watch `slrun status` or the admin events for the outcome\n", state.Name)
+				return nil
+			}
+			fmt.Printf("Function %v is %v\n", state.Name, state.State)
+			return nil
+		},
+	}
+}
+
+func init() {
+	drainCmd.Flags().DurationVar(&drainTimeout, "timeout", 30*time.Second, "how long to wait for invocations in flight")
+	drainCmd.Flags().BoolVar(&drainStop, "stop", false, "stop function containers once drained")
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(sloCmd)
+	rootCmd.AddCommand(quarantineCmd)
+	rootCmd.AddCommand(fleetCmd)
+	rootCmd.AddCommand(functionActionCmd("start", "Start a function's container"))
+	rootCmd.AddCommand(functionActionCmd("stop", "Stop a function's container, until its next invocation"))
+	rootCmd.AddCommand(functionActionCmd("restart", "Restart a function"))
+	rootCmd.AddCommand(functionActionCmd("rebuild", "Rebuild a function and roll it out"))
+	rootCmd.AddCommand(drainCmd)
+	rootCmd.AddCommand(undrainCmd)
+	rootCmd.AddCommand(functionActionCmd("enable", "Enable a function"))
+	rootCmd.AddCommand(functionActionCmd("disable", "Disable a function, stopping its containers"))
+	rootCmd.AddCommand(functionActionCmd("unquarantine", "Release a quarantined function and enable it"))
+}
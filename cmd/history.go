@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var diffIds []int
+
+// historyCmd shows a function's deployment history
+var historyCmd = &cobra.Command{
+	Use:   "history <function>",
+	Short: "Show a function's deployment history",
+	Long:  "Show a function's deployment history. With --diff, show what changed between two deployments.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		history, err := slrun.ReadHistory(config.StateDir, args[0])
+		if err != nil {
+			return err
+		}
+
+		if len(diffIds) > 0 {
+			if len(diffIds) != 2 {
+				return fmt.Errorf("--diff takes two deployment IDs")
+			}
+			var deployments [2]*slrun.Deployment
+			for i, id := range diffIds {
+				// Retention may have pruned the first deployments
+				j := slices.IndexFunc(history, func(d *slrun.Deployment) bool { return d.ID == id })
+				if j < 0 {
+					return fmt.Errorf("function %v has no deployment %v", args[0], id)
+				}
+				deployments[i] = history[j]
+			}
+
+			diff := slrun.DiffDeployments(deployments[0], deployments[1])
+			if len(diff) == 0 {
+				fmt.Println("No changes")
+			}
+			for _, line := range diff {
+				fmt.Println(line)
+			}
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "ID\tTIME\tRESULT\tIMAGE\tERROR")
+		for _, d := range history {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", d.ID, d.Time.Format(time.DateTime), d.Result, shortImageID(d.Image), d.Error)
+		}
+		return nil
+	},
+}
+
+// shortImageID shortens sha256:<hex> image IDs like docker does.
+func shortImageID(id string) string {
+	const prefix = len("sha256:")
+	if len(id) < prefix+12 {
+		return id
+	}
+	return id[prefix : prefix+12]
+}
+
+func init() {
+	historyCmd.Flags().IntSliceVar(&diffIds, "diff", nil, "two deployment IDs to diff, e.g. --diff 3,5")
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imagesOutput string
+	imagesEngine string
+)
+
+// imagesCmd groups commands moving base images to offline machines
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Export and import function base images",
+}
+
+// imagesExportCmd saves the config's base images to an archive
+var imagesExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the base images of the config's functions",
+	Long:  "Save the base images of the config's functions to an archive, pulling any missing locally, for import on an offline machine.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		err = slrun.ConnectDocker(config.Backend)
+		if err != nil {
+			return err
+		}
+		slrun.UseRegistryAuths(config.Registry)
+
+		file, err := os.Create(imagesOutput)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		images, err := slrun.ExportImages(config.Functions, config.Offline, file)
+		if err != nil {
+			os.Remove(imagesOutput)
+			return err
+		}
+		for _, img := range images {
+			fmt.Printf("Exported %v\n", img)
+		}
+		return nil
+	},
+}
+
+// imagesImportCmd loads images from an archive
+var imagesImportCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Import images exported with slrun images export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := slrun.ConnectDocker(&types.Backend{Engine: imagesEngine})
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		err = slrun.ImportImages(file)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported images from %v\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	imagesExportCmd.Flags().StringVarP(&imagesOutput, "output", "o", "images.tar", "archive to write")
+	imagesCmd.AddCommand(imagesExportCmd)
+	imagesImportCmd.Flags().StringVar(&imagesEngine, "engine", slrun.BackendDocker, "container engine to load the images into, docker or podman")
+	imagesCmd.AddCommand(imagesImportCmd)
+	rootCmd.AddCommand(imagesCmd)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initFunction string
+	initForce    bool
+)
+
+// initCmd scaffolds a config with an example function
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Scaffold a config and an example function",
+	Long:  "Write a config, at --config, with one function and its build dir under ./functions, ready for slrun up.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		written, err := slrun.Scaffold(cfgFile, initFunction, initForce)
+		for _, path := range written {
+			fmt.Printf("Wrote %v\n", path)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Run `slrun up --config %v`, then `slrun invoke %v`\n", cfgFile, initFunction)
+		return nil
+	},
+}
+
+func init() {
+	initCmd.Flags().StringVar(&initFunction, "function", "hello", "name of the example function")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "overwrite existing files")
+	rootCmd.AddCommand(initCmd)
+}
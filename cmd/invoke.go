@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	invokeData    string
+	invokeMethod  string
+	invokeHeaders []string
+	invokeVerbose bool
+)
+
+// invokeCmd calls a function through the running daemon's gateway
+var invokeCmd = &cobra.Command{
+	Use:   "invoke <function> [path]",
+	Short: "Invoke a function",
+	Long: "Invoke a function through the gateway of the running slrun, printing its response body. " +
+		"--data sends a body, read from a file with @file or from stdin with @-.",
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		url := slrun.GatewayURL(config, port) + "/" + args[0]
+		if len(args) == 2 {
+			url += "/" + strings.TrimPrefix(args[1], "/")
+		}
+
+		var body io.Reader
+		switch {
+		case invokeData == "@-":
+			body = os.Stdin
+		case strings.HasPrefix(invokeData, "@"):
+			f, err := os.Open(invokeData[1:])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			body = f
+		case invokeData != "":
+			body = strings.NewReader(invokeData)
+		}
+		method := invokeMethod
+		if method == "" {
+			method = http.MethodGet
+			if body != nil {
+				method = http.MethodPost
+			}
+		}
+
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return err
+		}
+		for _, h := range invokeHeaders {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return fmt.Errorf("invalid header %q, expected Name: value", h)
+			}
+			req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if invokeVerbose {
+			fmt.Fprintf(os.Stderr, "%v %v\n", resp.Proto, resp.Status)
+			resp.Header.Write(os.Stderr)
+			fmt.Fprintln(os.Stderr)
+		}
+		_, err = io.Copy(os.Stdout, resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("function %v responded %v", args[0], resp.Status)
+		}
+		return nil
+	},
+}
+
+func init() {
+	invokeCmd.Flags().StringVarP(&invokeData, "data", "d", "", "request body, @file to read it from a file or @- from stdin")
+	invokeCmd.Flags().StringVarP(&invokeMethod, "method", "X", "", "request method, default GET, or POST with --data")
+	invokeCmd.Flags().StringArrayVarP(&invokeHeaders, "header", "H", nil, "request header, e.g. 'Content-Type: application/json'")
+	invokeCmd.Flags().BoolVarP(&invokeVerbose, "verbose", "v", false, "print the response status and headers to stderr")
+	invokeCmd.Flags().IntVar(&port, "port", 8080, "gateway port, if the config has no listeners")
+	rootCmd.AddCommand(invokeCmd)
+}
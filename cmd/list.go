@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var wide bool
+
+// listCmd lists the functions defined in the config
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List functions",
+	Long:  "List functions defined in the config. With --wide, also show their description, owner and routes.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		defer w.Flush()
+
+		if !wide {
+			fmt.Fprintln(w, "NAME\tBUILD DIR")
+			for _, f := range config.Functions {
+				fmt.Fprintf(w, "%v\t%v\n", f.Name, f.BuildDir)
+			}
+			return nil
+		}
+
+		fmt.Fprintln(w, "NAME\tBUILD DIR\tOWNER\tROUTES\tDESCRIPTION")
+		for _, f := range config.Functions {
+			var owner, routes, description string
+			if f.Metadata != nil {
+				owner = f.Metadata.Owner
+				routes = strings.Join(f.Metadata.Routes, ",")
+				description = f.Metadata.Description
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", f.Name, f.BuildDir, owner, routes, description)
+		}
+		return nil
+	},
+}
+
+func init() {
+	listCmd.Flags().BoolVar(&wide, "wide", false, "show function metadata")
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsTail   int
+	logsFollow bool
+)
+
+// logsCmd shows a function container's output
+var logsCmd = &cobra.Command{
+	Use:   "logs <function>",
+	Short: "Show a function's logs",
+	Long:  "Show the output of a function's container, with its secrets redacted, through the running slrun's admin API.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		return client.Logs(args[0], logsTail, logsFollow, os.Stdout)
+	},
+}
+
+func init() {
+	logsCmd.Flags().IntVar(&logsTail, "tail", 100, "lines of past output to show")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep showing new output")
+	rootCmd.AddCommand(logsCmd)
+}
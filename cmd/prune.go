@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// pruneCmd removes containers and images slrun left behind
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove stopped slrun containers and unused function images",
+	Long:  "Remove the stopped function containers of this slrun instance, and the images built for functions no longer in the config or left by interrupted builds. slrun must not be running.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		pid, err := slrun.DaemonPID(config.StateDir)
+		if err != nil {
+			return err
+		}
+		if pid != 0 {
+			return fmt.Errorf("slrun is running (pid %v), stop it before pruning", pid)
+		}
+		err = slrun.ConnectDocker(config.Backend)
+		if err != nil {
+			return err
+		}
+
+		pruned, err := slrun.Prune(config)
+		if err != nil {
+			return err
+		}
+		for _, c := range pruned.Containers {
+			fmt.Printf("Removed container %v\n", c)
+		}
+		for _, img := range pruned.Images {
+			fmt.Printf("Removed image %v\n", img)
+		}
+		for _, n := range pruned.Networks {
+			fmt.Printf("Removed network %v\n", n)
+		}
+		if len(pruned.Containers) == 0 && len(pruned.Images) == 0 && len(pruned.Networks) == 0 {
+			fmt.Println("Nothing to prune")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayFrom     string
+	replayFunction string
+	replaySpeed    string
+	replayTarget   string
+)
+
+// replayCmd re-sends recorded traffic to a function
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay recorded traffic against a function",
+	Long: "Re-send the requests recorded in a HAR capture or an access log to a function, at their original pace or --speed times it, " +
+		"and report the requests whose status regressed from the recorded one.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		speed, err := parseSpeed(replaySpeed)
+		if err != nil {
+			return err
+		}
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		requests, err := slrun.ReadReplayFile(config, replayFrom)
+		if err != nil {
+			return err
+		}
+		target := replayTarget
+		if target == "" {
+			target = slrun.GatewayURL(config, port)
+		}
+		functionURL := strings.TrimSuffix(target, "/") + "/" + replayFunction
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fmt.Printf("Replaying %v requests to %v\n", len(requests), functionURL)
+		changed, regressed := 0, 0
+		slrun.Replay(ctx, requests, functionURL, speed, func(result *slrun.ReplayResult) {
+			req := result.Request
+			switch {
+			case result.Regressed():
+				regressed++
+			case result.Status != req.Status:
+				changed++
+			default:
+				return
+			}
+
+			mark := "CHANGED"
+			if result.Regressed() {
+				mark = "REGRESSED"
+			}
+			got := strconv.Itoa(result.Status)
+			if result.Err != nil {
+				got = result.Err.Error()
+			}
+			fmt.Printf("%-9v  %v %v: %v -> %v\n", mark, req.Method, cmp.Or(req.Path, "/"), req.Status, got)
+		})
+
+		fmt.Printf("%v requests, %v regressed, %v changed status\n", len(requests), regressed, changed)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if regressed > 0 {
+			return fmt.Errorf("%v requests regressed", regressed)
+		}
+		return nil
+	},
+}
+
+// parseSpeed parses a replay speed such as 2x or 0.5.
+func parseSpeed(s string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed < 0 {
+		return 0, fmt.Errorf("invalid speed %q, expected e.g. 2x, or 0 for as fast as possible", s)
+	}
+	return speed, nil
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayFrom, "from", "", "HAR capture or Common Log Format access log to replay")
+	replayCmd.Flags().StringVar(&replayFunction, "function", "", "function to replay the requests against")
+	replayCmd.Flags().StringVar(&replaySpeed, "speed", "1x", "pace relative to the recording, e.g. 2x, or 0 for as fast as possible")
+	replayCmd.Flags().StringVar(&replayTarget, "target", "", "gateway URL, default the config's first listener")
+	replayCmd.Flags().IntVar(&port, "port", 8080, "gateway port, if the config has no listeners")
+	replayCmd.MarkFlagRequired("from")
+	replayCmd.MarkFlagRequired("function")
+	rootCmd.AddCommand(replayCmd)
+}
@@ -11,6 +11,9 @@ var (
 	cfgFile string
 	host    string
 	port    int
+	dev     bool
+	offline bool
+	watch   bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -23,10 +26,18 @@ var rootCmd = &cobra.Command{
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return slrun.Start(cfgFile, host, port)
+		return slrun.Start(cfgFile, host, port, flagOverride(cmd, "dev", dev), flagOverride(cmd, "offline", offline), flagOverride(cmd, "watch", watch))
 	},
 }
 
+// flagOverride returns the value of a bool flag if it was set on the command line, nil otherwise.
+func flagOverride(cmd *cobra.Command, name string, value bool) *bool {
+	if !cmd.Flags().Changed(name) {
+		return nil
+	}
+	return &value
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -37,7 +48,10 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringVar(&cfgFile, "config", "slrun.json", "config file (default ./slrun.json)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "slrun.json", "config file (default ./slrun.json)")
 	rootCmd.Flags().StringVar(&host, "host", "0.0.0.0", "host to listen on")
 	rootCmd.Flags().IntVar(&port, "port", 8080, "port to listen on")
+	rootCmd.Flags().BoolVar(&dev, "dev", false, "development mode, overrides the config's dev setting if set")
+	rootCmd.Flags().BoolVar(&offline, "offline", false, "never pull images, overrides the config's offline setting if set")
+	rootCmd.Flags().BoolVar(&watch, "watch", false, "rebuild functions and apply config changes as files change, overrides the config's watch setting if set")
 }
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	updateChannel  string
+	updateVersion  string
+	updatePin      bool
+	updateInsecure bool
+)
+
+// selfUpdateCmd replaces the slrun binary with a verified release
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Update slrun",
+	Long: "Update slrun to the latest release on its channel, or to an exact version. " +
+		"With --pin, the channel and version are saved and used by later updates.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pin, err := slrun.ReadUpdatePin()
+		if err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("channel") {
+			pin.Channel = updateChannel
+			pin.Version = ""
+		}
+		if updateVersion != "" {
+			pin.Version = updateVersion
+		}
+		if pin.Channel == "" {
+			pin.Channel = slrun.ChannelStable
+		}
+		if pin.Channel != slrun.ChannelStable && pin.Channel != slrun.ChannelPrerelease {
+			return fmt.Errorf("unknown channel: %v", pin.Channel)
+		}
+
+		if updatePin {
+			err = slrun.WriteUpdatePin(pin)
+			if err != nil {
+				return err
+			}
+		}
+
+		version, err := slrun.SelfUpdate(pin, updateInsecure)
+		if err != nil {
+			return err
+		}
+		if version == slrun.Version {
+			fmt.Printf("slrun %v is up to date\n", version)
+		} else {
+			fmt.Printf("Updated slrun %v => %v\n", slrun.Version, version)
+		}
+		return nil
+	},
+}
+
+func init() {
+	selfUpdateCmd.Flags().StringVar(&updateChannel, "channel", slrun.ChannelStable, "release channel, stable or prerelease")
+	selfUpdateCmd.Flags().StringVar(&updateVersion, "version", "", "exact release to install, e.g. v1.2.3")
+	selfUpdateCmd.Flags().BoolVar(&updatePin, "pin", false, "save the channel and version for later updates")
+	selfUpdateCmd.Flags().BoolVar(&updateInsecure, "insecure", false, "update without verifying the release signature, if slrun can't")
+	rootCmd.AddCommand(selfUpdateCmd)
+}
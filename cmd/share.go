@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shareDriver string
+	shareFor    time.Duration
+	shareQR     bool
+)
+
+// shareCmd exposes a function of the running daemon publicly through a tunnel
+var shareCmd = &cobra.Command{
+	Use:   "share <function>",
+	Short: "Share a function publicly through a tunnel",
+	Long: "Share a function of the running slrun publicly with a temporary URL, through cloudflared or ngrok. " +
+		"Only the function is exposed: the URL's paths map to the function's. The tunnel is closed on interrupt, or after --for.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		var functionURL string
+		for _, f := range status.Functions {
+			if f.Name == args[0] {
+				functionURL = f.URL
+			}
+		}
+		if functionURL == "" {
+			return fmt.Errorf("function %v is not served by the gateway", args[0])
+		}
+
+		tunnel, err := slrun.StartTunnel(shareDriver, functionURL)
+		if err != nil {
+			return err
+		}
+		defer tunnel.Stop()
+		fmt.Printf("Sharing %v at %v\n", args[0], tunnel.URL)
+		if shareQR {
+			err = printQR(tunnel.URL)
+			if err != nil {
+				return err
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if shareFor > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, shareFor)
+			defer cancel()
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Printf("Stopped sharing %v\n", args[0])
+			return nil
+		case err := <-tunnel.Done():
+			return fmt.Errorf("tunnel closed: %v", err)
+		}
+	},
+}
+
+func init() {
+	shareCmd.Flags().StringVar(&shareDriver, "driver", slrun.TunnelCloudflared, "tunnel driver, cloudflared or ngrok")
+	shareCmd.Flags().DurationVar(&shareFor, "for", 0, "stop sharing after this long, e.g. 30m, never if zero")
+	shareCmd.Flags().BoolVar(&shareQR, "qr", false, "show the public URL as a QR code")
+	rootCmd.AddCommand(shareCmd)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stateOutput string
+	stateForce  bool
+)
+
+// stateCmd groups commands moving a deployment between hosts
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Export and import the state of a deployment",
+}
+
+// stateExportCmd writes a state snapshot
+var stateExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the config and state of a deployment",
+	Long:  "Write the config, with routing, each function's deployment history and secret references, and the feature flag overrides and quarantines set while slrun ran, to move the deployment to another host with slrun state import. Secret values aren't exported.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		snapshot, err := slrun.ExportState(config, cfgFile)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return err
+		}
+		if stateOutput == "-" {
+			_, err = os.Stdout.Write(append(data, '\n'))
+			return err
+		}
+		err = os.WriteFile(stateOutput, append(data, '\n'), 0600)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %v functions to %v\n", len(snapshot.Functions), stateOutput)
+		return nil
+	},
+}
+
+// stateImportCmd restores a state snapshot
+var stateImportCmd = &cobra.Command{
+	Use:   "import <snapshot>",
+	Short: "Import a state snapshot exported with slrun state export",
+	Long:  "Write the snapshot's config to --config and its state to the config's state dir, so slrun up deploys the same functions as the host it was exported on. Secrets this host can't read are listed, they have to be provided before starting.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		snapshot, err := slrun.ReadStateSnapshot(file)
+		if err != nil {
+			return err
+		}
+		err = slrun.ImportState(snapshot, cfgFile, stateForce)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Imported state of %v exported by slrun %v on %v\n", cfgFile, snapshot.Version, snapshot.Host)
+		for _, f := range snapshot.Functions {
+			status := "enabled"
+			if !f.Enabled {
+				status = "disabled"
+			}
+			deployed := "never deployed"
+			if f.Image != "" {
+				deployed = "image " + shortImageID(f.Image)
+			}
+			fmt.Printf("  %v: %v, %v\n", f.Name, status, deployed)
+		}
+		missing := snapshot.MissingSecrets()
+		for _, m := range missing {
+			fmt.Printf("Missing %v\n", m)
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%v secrets can't be read on this host, provide them before starting slrun", len(missing))
+		}
+		fmt.Printf("Run: slrun up --config %v\n", cfgFile)
+		return nil
+	},
+}
+
+func init() {
+	stateExportCmd.Flags().StringVarP(&stateOutput, "output", "o", "slrun-state.json", "file to write, - for stdout")
+	stateImportCmd.Flags().BoolVar(&stateForce, "force", false, "replace the config file if it exists")
+	stateCmd.AddCommand(stateExportCmd, stateImportCmd)
+	rootCmd.AddCommand(stateCmd)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tasksDir    string
+	tasksStdout bool
+)
+
+// tasksCmd groups editor task integrations
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "Editor task integration",
+}
+
+// tasksExportCmd writes VS Code tasks and launch configurations for the config
+var tasksExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export VS Code tasks.json and launch.json entries",
+	Long: "Add tasks starting slrun and restarting its functions to tasks.json, and configurations attaching " +
+		"to function debuggers to launch.json. Entries slrun exported before are replaced, others are kept.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		if tasksStdout {
+			bytes, err := json.MarshalIndent(map[string]any{
+				"tasks":  slrun.VSCodeTasks(config, cfgFile),
+				"launch": slrun.VSCodeLaunch(config),
+			}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bytes))
+			return nil
+		}
+
+		err = slrun.ExportVSCode(config, cfgFile, tasksDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported slrun tasks and launch configurations to %v\n", tasksDir)
+		return nil
+	},
+}
+
+func init() {
+	tasksExportCmd.Flags().StringVar(&tasksDir, "dir", ".vscode", "directory of tasks.json and launch.json")
+	tasksExportCmd.Flags().BoolVar(&tasksStdout, "stdout", false, "print the entries instead of writing them")
+	tasksCmd.AddCommand(tasksExportCmd)
+	rootCmd.AddCommand(tasksCmd)
+}
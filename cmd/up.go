@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// Set in the env of daemons started by up --detach
+const detachedEnv = "SLRUN_DETACHED"
+
+var (
+	detach      bool
+	downTimeout time.Duration
+)
+
+// upCmd starts slrun, in the foreground or detached
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Start slrun",
+	Long:  "Start slrun with the config, like slrun itself. With --detach, run it in the background, logging to <state_dir>/slrun.log; stop it with slrun down.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !detach {
+			if os.Getenv(detachedEnv) != "" {
+				// Outlive the terminal that started us
+				signal.Ignore(syscall.SIGHUP)
+			}
+			return slrun.Start(cfgFile, host, port, flagOverride(cmd, "dev", dev), flagOverride(cmd, "offline", offline), flagOverride(cmd, "watch", watch))
+		}
+
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		pid, err := slrun.DaemonPID(config.StateDir)
+		if err != nil {
+			return err
+		}
+		if pid != 0 {
+			return fmt.Errorf("slrun is already running (pid %v)", pid)
+		}
+		err = os.MkdirAll(config.StateDir, 0755)
+		if err != nil {
+			return err
+		}
+		logPath := slrun.DaemonLogFile(config.StateDir)
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		child := exec.Command(exe, slices.DeleteFunc(slices.Clone(os.Args[1:]), isDetachFlag)...)
+		child.Env = append(os.Environ(), detachedEnv+"=1")
+		child.Stdout = logFile
+		child.Stderr = logFile
+		err = child.Start()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("slrun started in the background (pid %v), logging to %v\n", child.Process.Pid, logPath)
+		if config.AdminAddress != "" {
+			fmt.Printf("Show a function's URL with slrun url <function>\n")
+		}
+		return child.Process.Release()
+	},
+}
+
+func isDetachFlag(arg string) bool {
+	return arg == "-d" || arg == "--detach" || arg == "--detach=true"
+}
+
+// downCmd stops slrun started with the config
+var downCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Stop slrun",
+	Long:  "Stop the slrun running with the config's state dir, as on Ctrl-C, and wait for it to shut down.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil {
+			return err
+		}
+		err = slrun.StopDaemon(config, downTimeout)
+		if err != nil {
+			return err
+		}
+		fmt.Println("slrun stopped")
+		return nil
+	},
+}
+
+func init() {
+	upCmd.Flags().StringVar(&host, "host", "0.0.0.0", "host to listen on")
+	upCmd.Flags().IntVar(&port, "port", 8080, "port to listen on")
+	upCmd.Flags().BoolVar(&dev, "dev", false, "development mode, overrides the config's dev setting if set")
+	upCmd.Flags().BoolVar(&offline, "offline", false, "never pull images, overrides the config's offline setting if set")
+	upCmd.Flags().BoolVar(&watch, "watch", false, "rebuild functions and apply config changes as files change, overrides the config's watch setting if set")
+	upCmd.Flags().BoolVarP(&detach, "detach", "d", false, "run in the background")
+	downCmd.Flags().DurationVar(&downTimeout, "timeout", time.Minute, "how long to wait for slrun to stop")
+	rootCmd.AddCommand(upCmd)
+	rootCmd.AddCommand(downCmd)
+}
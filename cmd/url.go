@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+)
+
+var urlQR bool
+
+// urlCmd shows where the running daemon serves a function
+var urlCmd = &cobra.Command{
+	Use:   "url <function>",
+	Short: "Show a function's URL",
+	Long: "Show where the gateway of the running slrun serves the function, on this host and to other devices on the LAN. " +
+		"With --qr, also show the LAN URL, or this host's if the gateway only listens on it, as a QR code to open on a phone. " +
+		"To reach the function from outside the LAN, share it with slrun share --qr.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		for _, f := range status.Functions {
+			if f.Name != args[0] {
+				continue
+			}
+			if f.URL == "" {
+				return fmt.Errorf("function %v is not served by the gateway", args[0])
+			}
+			fmt.Printf("Local: %v\n", f.URL)
+			if f.LANURL != "" {
+				fmt.Printf("LAN:   %v\n", f.LANURL)
+			}
+			if urlQR {
+				return printQR(cmp.Or(f.LANURL, f.URL))
+			}
+			return nil
+		}
+		return fmt.Errorf("function %v not found", args[0])
+	},
+}
+
+// printQR prints url as a QR code of terminal blocks, for a phone to scan.
+func printQR(url string) error {
+	code, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	fmt.Print(code.ToSmallString(false))
+	return nil
+}
+
+func init() {
+	urlCmd.Flags().BoolVar(&urlQR, "qr", false, "show the URL as a QR code")
+	rootCmd.AddCommand(urlCmd)
+}
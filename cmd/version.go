@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// versionCmd prints the client and, if reachable, the daemon version
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show slrun version",
+	Long:  "Show the version of slrun and of the daemon serving the config's admin API, failing if they are incompatible.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Printf("Client: %v (API version %v)\n", slrun.Version, slrun.APIVersion)
+
+		config, err := slrun.ReadConfigFile(cfgFile)
+		if err != nil || config.AdminAddress == "" {
+			return nil
+		}
+		daemon, err := slrun.DaemonVersion(config.AdminAddress)
+		if daemon != nil {
+			fmt.Printf("Daemon: %v (API version %v)\n", daemon.Version, daemon.APIVersion)
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/marcorentap/slrun/internal/slrun"
+	"github.com/spf13/cobra"
+)
+
+// wasmCmd serves the module of a WASM function, run by slrun as the function's process
+var wasmCmd = &cobra.Command{
+	Use:    "wasm <module>",
+	Short:  "Serve a WASM function's module",
+	Long:   "Serve a WASI module on the port in PORT, compiled once and run with wazero for each request as a WAGI handler. slrun runs it for functions with wasm.",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return slrun.ServeWasm(args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(wasmCmd)
+}
@@ -2,6 +2,8 @@ package policy
 
 import (
 	"log"
+	"slices"
+	"sync"
 
 	"github.com/marcorentap/slrun/internal/types"
 )
@@ -10,6 +12,7 @@ type AlwaysCold struct {
 	Funcs     []*types.Function
 	StartFunc func(*types.Function) error
 	StopFunc  func(*types.Function) error
+	mu        sync.Mutex // Guards Funcs, the runtime calls from several goroutines
 }
 
 func (p *AlwaysCold) OnRuntimeStart() error {
@@ -38,3 +41,25 @@ func (p *AlwaysCold) PostFunctionCall(f *types.Function) error {
 func (p *AlwaysCold) OnTick() error {
 	return nil
 }
+
+func (p *AlwaysCold) AddFunction(f *types.Function) error {
+	// Started on demand like the others
+	p.mu.Lock()
+	p.Funcs = append(p.Funcs, f)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *AlwaysCold) RemoveFunction(f *types.Function) error {
+	p.mu.Lock()
+	p.Funcs = slices.DeleteFunc(p.Funcs, func(fun *types.Function) bool { return fun == f })
+	p.mu.Unlock()
+	if f.IsRunning {
+		err := p.StopFunc(f)
+		if err != nil {
+			return err
+		}
+		log.Printf("AlwaysCold: Stopped function %v\n", f.Name)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package policy
 
 import (
 	"log"
+	"slices"
+	"sync"
 
 	"github.com/marcorentap/slrun/internal/types"
 )
@@ -10,10 +12,14 @@ type AlwaysHot struct {
 	Funcs     []*types.Function
 	StartFunc func(*types.Function) error
 	StopFunc  func(*types.Function) error
+	mu        sync.Mutex // Guards Funcs, the runtime calls from several goroutines
 }
 
 func (p *AlwaysHot) OnRuntimeStart() error {
-	for _, f := range p.Funcs {
+	p.mu.Lock()
+	funcs := slices.Clone(p.Funcs)
+	p.mu.Unlock()
+	for _, f := range funcs {
 		err := p.StartFunc(f)
 		if err != nil {
 			return err
@@ -36,3 +42,29 @@ func (p *AlwaysHot) PostFunctionCall(f *types.Function) error {
 func (p *AlwaysHot) OnTick() error {
 	return nil
 }
+
+func (p *AlwaysHot) AddFunction(f *types.Function) error {
+	p.mu.Lock()
+	p.Funcs = append(p.Funcs, f)
+	p.mu.Unlock()
+	err := p.StartFunc(f)
+	if err != nil {
+		return err
+	}
+	log.Printf("AlwaysHot: started function %v\n", f.Name)
+	return nil
+}
+
+func (p *AlwaysHot) RemoveFunction(f *types.Function) error {
+	p.mu.Lock()
+	p.Funcs = slices.DeleteFunc(p.Funcs, func(fun *types.Function) bool { return fun == f })
+	p.mu.Unlock()
+	if f.IsRunning {
+		err := p.StopFunc(f)
+		if err != nil {
+			return err
+		}
+		log.Printf("AlwaysHot: stopped function %v\n", f.Name)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package policy
 
 import (
 	"log"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/marcorentap/slrun/internal/types"
@@ -11,53 +13,133 @@ type ColdOnIdle struct {
 	Funcs         []*types.Function
 	StartFunc     func(*types.Function) error
 	StopFunc      func(*types.Function) error
+	InFlight      func(*types.Function) bool // Whether calls to a function are in flight, it isn't stopped meanwhile
 	idleThreshold time.Duration
+	mu            sync.Mutex                    // Guards Funcs and lastExecTime, the runtime calls from several goroutines
 	lastExecTime  map[*types.Function]time.Time // Remember function last execution times
+
+	locks map[*types.Function]*sync.Mutex // Held while each function starts or stops, guarded by mu
 }
 
 func (p *ColdOnIdle) OnRuntimeStart() error {
 	p.idleThreshold = 5 * time.Second
 	p.lastExecTime = make(map[*types.Function]time.Time)
+	p.locks = make(map[*types.Function]*sync.Mutex)
 	return nil
 }
 
+// lock returns the lock held while the function starts or stops.
+func (p *ColdOnIdle) lock(f *types.Function) *sync.Mutex {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, exists := p.locks[f]
+	if !exists {
+		l = &sync.Mutex{}
+		p.locks[f] = l
+	}
+	return l
+}
+
+// touch restarts the function's idle timer.
+func (p *ColdOnIdle) touch(f *types.Function) {
+	p.mu.Lock()
+	p.lastExecTime[f] = time.Now()
+	p.mu.Unlock()
+}
+
 func (p *ColdOnIdle) PreFunctionCall(f *types.Function) error {
+	// Calls arriving while it cold starts wait for that start
+	l := p.lock(f)
+	l.Lock()
 	if !f.IsRunning {
 		err := p.StartFunc(f)
 		if err != nil {
+			l.Unlock()
 			return err
 		}
 		log.Printf("ColdOnIdle: Started function %v\n", f.Name)
 	}
-
-	// Log last execution time
-	p.lastExecTime[f] = time.Now()
+	l.Unlock()
+	p.touch(f)
 	return nil
 }
 
 func (p *ColdOnIdle) PostFunctionCall(f *types.Function) error {
+	// Idle from the end of its last call
+	p.touch(f)
 	return nil
 }
 
+// idle reports whether the function has had no calls for longer than its idle threshold,
+// and none are in flight. Must hold p.mu.
+func (p *ColdOnIdle) idle(f *types.Function) (time.Duration, bool) {
+	lastExec, exists := p.lastExecTime[f]
+	if !f.IsRunning || !exists || p.InFlight != nil && p.InFlight(f) {
+		return 0, false
+	}
+	threshold := p.idleThreshold
+	if f.IdleAfter > 0 {
+		threshold = f.IdleAfter
+	}
+	idleTime := time.Since(lastExec)
+	return idleTime, idleTime > threshold
+}
+
 func (p *ColdOnIdle) OnTick() error {
-	// Stop idled functions
+	// Stop idled functions, without holding the lock while they stop
+	var idled []*types.Function
+	p.mu.Lock()
 	for _, f := range p.Funcs {
-		if !f.IsRunning {
+		if _, idle := p.idle(f); idle {
+			idled = append(idled, f)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, f := range idled {
+		// Functions starting aren't idle
+		l := p.lock(f)
+		if !l.TryLock() {
 			continue
 		}
-		lastExec, exists := p.lastExecTime[f]
-		if !exists {
+		// Calls may have arrived meanwhile
+		p.mu.Lock()
+		idleTime, idle := p.idle(f)
+		p.mu.Unlock()
+		if !idle {
+			l.Unlock()
 			continue
 		}
+		log.Printf("ColdOnIdle: Function %v idled for %v ms, stopping...", f.Name, idleTime.Milliseconds())
+		err := p.StopFunc(f)
+		l.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ColdOnIdle) AddFunction(f *types.Function) error {
+	// Started on its first call, stopped on idle like the others
+	p.mu.Lock()
+	p.Funcs = append(p.Funcs, f)
+	p.mu.Unlock()
+	return nil
+}
 
-		idleTime := time.Since(lastExec)
-		if idleTime > p.idleThreshold {
-			log.Printf("ColdOnIdle: Function %v idled for %v ms, stopping...", f.Name, idleTime.Milliseconds())
-			err := p.StopFunc(f)
-			if err != nil {
-				return err
-			}
+func (p *ColdOnIdle) RemoveFunction(f *types.Function) error {
+	p.mu.Lock()
+	p.Funcs = slices.DeleteFunc(p.Funcs, func(fun *types.Function) bool { return fun == f })
+	delete(p.lastExecTime, f)
+	delete(p.locks, f)
+	p.mu.Unlock()
+	if f.IsRunning {
+		err := p.StopFunc(f)
+		if err != nil {
+			return err
 		}
+		log.Printf("ColdOnIdle: Stopped function %v\n", f.Name)
 	}
 	return nil
 }
@@ -0,0 +1,81 @@
+package policy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// newColdOnIdle returns the policy of f, counting its starts and stops.
+func newColdOnIdle(f *types.Function, inflight *atomic.Bool) (*ColdOnIdle, *atomic.Int32, *atomic.Int32) {
+	var starts, stops atomic.Int32
+	p := &ColdOnIdle{
+		Funcs: []*types.Function{f},
+		StartFunc: func(f *types.Function) error {
+			starts.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			f.IsRunning = true
+			return nil
+		},
+		StopFunc: func(f *types.Function) error {
+			stops.Add(1)
+			f.IsRunning = false
+			return nil
+		},
+		InFlight: func(*types.Function) bool { return inflight.Load() },
+	}
+	p.OnRuntimeStart()
+	return p, &starts, &stops
+}
+
+func TestColdOnIdleInFlight(t *testing.T) {
+	f := &types.Function{Name: "func1", IdleAfter: 20 * time.Millisecond}
+	var inflight atomic.Bool
+	p, _, stops := newColdOnIdle(f, &inflight)
+
+	inflight.Store(true)
+	if err := p.PreFunctionCall(f); err != nil {
+		t.Fatal(err)
+	}
+	// A call outlasting the idle timeout
+	time.Sleep(30 * time.Millisecond)
+	p.OnTick()
+	if stops.Load() != 0 || !f.IsRunning {
+		t.Fatalf("OnTick() stopped the function with a call in flight")
+	}
+
+	inflight.Store(false)
+	p.PostFunctionCall(f)
+	p.OnTick()
+	if stops.Load() != 0 {
+		t.Fatalf("OnTick() stopped the function as its call ended, want it idle from then")
+	}
+	time.Sleep(30 * time.Millisecond)
+	p.OnTick()
+	if stops.Load() != 1 || f.IsRunning {
+		t.Errorf("OnTick() after the idle timeout = %v stops, want 1", stops.Load())
+	}
+}
+
+func TestColdOnIdleConcurrentColdStarts(t *testing.T) {
+	f := &types.Function{Name: "func1"}
+	p, starts, _ := newColdOnIdle(f, &atomic.Bool{})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.PreFunctionCall(f); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if starts.Load() != 1 || !f.IsRunning {
+		t.Errorf("10 concurrent calls started the function %v times, want once", starts.Load())
+	}
+}
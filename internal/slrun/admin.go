@@ -0,0 +1,592 @@
+package slrun
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Admin serves the runtime control API.
+type Admin struct {
+	runtime *Runtime
+	gateway *Gateway
+	fleet   *FleetAggregator // Nil unless this instance aggregates a fleet
+	server  *http.Server
+	// Receives fleet reports, nil unless this instance aggregates a fleet
+	fleetServer *http.Server
+}
+
+func NewAdmin(address string, runtime *Runtime, gateway *Gateway) *Admin {
+	a := &Admin{runtime: runtime, gateway: gateway, fleet: NewFleetAggregator(gateway.config.Fleet)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/version", a.getVersion)
+	mux.HandleFunc("GET /admin/capabilities", a.getCapabilities)
+	mux.HandleFunc("GET /admin/status", a.getStatus)
+	mux.HandleFunc("GET /admin/functions", a.listFunctions)
+	mux.HandleFunc("POST /admin/functions/{name}/enable", a.functionAction("enable"))
+	mux.HandleFunc("POST /admin/functions/{name}/disable", a.functionAction("disable"))
+	mux.HandleFunc("POST /admin/functions/{name}/start", a.functionAction("start"))
+	mux.HandleFunc("POST /admin/functions/{name}/stop", a.functionAction("stop"))
+	mux.HandleFunc("POST /admin/functions/{name}/restart", a.functionAction("restart"))
+	mux.HandleFunc("POST /admin/functions/{name}/rebuild", a.rebuildFunction)
+	mux.HandleFunc("GET /admin/builds", a.getBuilds)
+	mux.HandleFunc("DELETE /admin/functions/{name}/builds", a.cancelBuilds)
+	mux.HandleFunc("GET /admin/config", a.getConfig)
+	mux.HandleFunc("POST /admin/drain", a.drain)
+	mux.HandleFunc("DELETE /admin/drain", a.undrain)
+	mux.HandleFunc("GET /admin/events", a.streamEvents)
+	mux.HandleFunc("GET /admin/usage", a.getUsage)
+	mux.HandleFunc("GET /admin/usage/export", a.exportUsage)
+	mux.HandleFunc("GET /admin/slos", a.getSLOs)
+	mux.HandleFunc("GET /admin/functions/{name}/invocations", a.getInvocations)
+	mux.HandleFunc("GET /admin/functions/{name}/logs", a.getLogs)
+	mux.HandleFunc("GET /admin/functions/{name}/latency", a.getLatency)
+	mux.HandleFunc("GET /admin/functions/{name}/capture", a.getCapture)
+	mux.HandleFunc("POST /admin/functions/{name}/capture", a.startCapture)
+	mux.HandleFunc("DELETE /admin/functions/{name}/capture", a.stopCapture)
+	mux.HandleFunc("GET /admin/flags", a.getFlags)
+	mux.HandleFunc("PUT /admin/functions/{name}/flags/{flag}", a.setFlag)
+	mux.HandleFunc("DELETE /admin/functions/{name}/flags/{flag}", a.resetFlag)
+	mux.HandleFunc("GET /admin/quarantine", a.getQuarantine)
+	mux.HandleFunc("POST /admin/functions/{name}/violations", a.reportViolation)
+	mux.HandleFunc("POST /admin/functions/{name}/unquarantine", a.functionAction("unquarantine"))
+	mux.HandleFunc("GET /admin/fleet", a.getFleet)
+	mux.HandleFunc("GET /admin/fleet/dashboard", a.fleetDashboard)
+	mux.HandleFunc("GET /admin/dns/hosts", gateway.dns.hostsHandler)
+	mux.HandleFunc("GET /metrics", a.getMetrics)
+
+	a.server = &http.Server{Addr: address, Handler: checkAdminToken(gateway.config.AdminToken, checkAPIVersion(mux))}
+
+	// Other hosts reach it, but none of the admin API
+	if a.fleet != nil {
+		fleetMux := http.NewServeMux()
+		fleetMux.HandleFunc("POST /admin/fleet/reports", a.reportFleet)
+		a.fleetServer = &http.Server{Addr: gateway.config.Fleet.Address, Handler: fleetMux}
+	}
+	return a
+}
+
+// validateAdmin checks an admin API other hosts can reach has a token.
+func validateAdmin(config *types.Config) error {
+	if config.AdminAddress == "" || config.AdminToken != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(config.AdminAddress)
+	if err != nil {
+		return fieldError("admin_address", "invalid admin_address: %w", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fieldError("admin_address", "admin_address %s is reachable from other hosts, it needs an admin_token", config.AdminAddress)
+	}
+	return nil
+}
+
+// checkAdminToken refuses requests without token as their bearer token, unless it is empty.
+// The daemon's version is public, for clients to tell which version to install.
+func checkAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" && r.URL.Path != "/admin/version" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Admin) Start() {
+	go func() {
+		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Admin server failed: %v", err)
+		}
+	}()
+	fmt.Printf("Admin API listening on %v\n", a.server.Addr)
+	if a.fleetServer != nil {
+		go func() {
+			if err := a.fleetServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Fleet report server failed: %v", err)
+			}
+		}()
+		fmt.Printf("Receiving fleet reports on %v\n", a.fleetServer.Addr)
+	}
+}
+
+func (a *Admin) Shutdown(ctx context.Context) error {
+	if a.fleetServer != nil {
+		a.fleetServer.Shutdown(ctx)
+	}
+	return a.server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// function looks up the function named in the request path, writing a 404 if there is none.
+func (a *Admin) function(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if a.runtime.FunctionByName(name) == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("function %v not found", name))
+		return "", false
+	}
+	return name, true
+}
+
+// functionStatus is a function as reported by the admin API.
+type functionStatus struct {
+	Name      string          `json:"name"`
+	BuildDir  string          `json:"build_dir"`
+	Image     string          `json:"image"`
+	Running   bool            `json:"running"`
+	Enabled   bool            `json:"enabled"`
+	Port      int             `json:"port,omitempty"`
+	URL       string          `json:"url,omitempty"`
+	LANURL    string          `json:"lan_url,omitempty"`
+	Capturing bool            `json:"capturing"`
+	Metadata  *types.Metadata `json:"metadata,omitempty"`
+}
+
+func (a *Admin) listFunctions(w http.ResponseWriter, r *http.Request) {
+	statuses := []functionStatus{}
+	for _, f := range a.runtime.functions {
+		statuses = append(statuses, functionStatus{
+			Name:      f.Name,
+			BuildDir:  f.BuildDir,
+			Image:     f.ImageName,
+			Running:   f.IsRunning,
+			Enabled:   f.IsEnabled,
+			Port:      f.Port,
+			URL:       a.gateway.functionURL(f.Name),
+			LANURL:    a.gateway.lanFunctionURL(f.Name),
+			Capturing: a.gateway.captures.Capturing(f.Name),
+			Metadata:  f.Metadata,
+		})
+	}
+	writeEncoded(w, r, http.StatusOK, statuses)
+}
+
+// rebuildFunction rebuilds the function named in the path and rolls it out, in the background.
+// Its outcome is published as build and function.redeployed events.
+func (a *Admin) rebuildFunction(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	f := a.runtime.FunctionByName(name)
+	if f.Remote {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("function %v runs on other cluster nodes", name))
+		return
+	}
+
+	config := a.gateway.config
+	go func() {
+		err := rebuildFunction(config, a.runtime, a.runtime.events, buildContextCompression(config.BuildCompression), f, "requested by admin")
+		if err != nil {
+			log.Printf("Cannot rebuild function %v: %v\n", name, err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, functionState(f, a.gateway.functionURL(name)))
+}
+
+func (a *Admin) getBuilds(w http.ResponseWriter, r *http.Request) {
+	writeEncoded(w, r, http.StatusOK, a.runtime.builds.Builds())
+}
+
+// cancelBuilds cancels the running and queued builds of the function named in the path.
+func (a *Admin) cancelBuilds(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"function": name, "cancelled": a.runtime.builds.Cancel(name)})
+}
+
+// Keys of config values hidden from GET /admin/config
+var redactedConfigKeys = []string{"token", "secret", "password", "key", "auth_tokens", "admin_token"}
+
+// redactConfig replaces the non-empty values of redacted keys, the values of env maps and the
+// credentials of URLs, at any depth, of a config decoded from JSON.
+func redactConfig(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if slices.Contains(redactedConfigKeys, key) && value != nil && value != "" {
+				v[key] = "[redacted]"
+				continue
+			}
+			if env, ok := value.(map[string]any); ok && key == "env" {
+				for name := range env {
+					env[name] = "[redacted]"
+				}
+				continue
+			}
+			if s, ok := value.(string); ok {
+				v[key] = redactURL(s)
+				continue
+			}
+			redactConfig(value)
+		}
+	case []any:
+		for i, value := range v {
+			if s, ok := value.(string); ok {
+				v[i] = redactURL(s)
+				continue
+			}
+			redactConfig(value)
+		}
+	}
+}
+
+// redactURL returns s with its user info redacted if it is a URL with any, tokens often
+// being passed as the user.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = url.User("redacted")
+	return u.String()
+}
+
+// getConfig returns the config in effect, with defaults applied and credentials redacted.
+func (a *Admin) getConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(a.gateway.config)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var config any
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	redactConfig(config)
+	writeJSON(w, http.StatusOK, config)
+}
+
+// drainStatus reports a drain of the gateway.
+type drainStatus struct {
+	Draining bool  `json:"draining"`
+	InFlight int64 `json:"in_flight"` // Invocations still running, if the drain timed out
+}
+
+// drain stops the gateway accepting invocations and waits, up to ?timeout= (default 30s),
+// for those in flight to finish. With ?stop=true, function containers are then stopped.
+func (a *Admin) drain(w http.ResponseWriter, r *http.Request) {
+	timeout := 30 * time.Second
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %w", err))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	err := a.gateway.Drain(ctx)
+	status := drainStatus{Draining: true, InFlight: a.gateway.InFlight()}
+	if err != nil {
+		writeJSON(w, http.StatusGatewayTimeout, status)
+		return
+	}
+
+	if r.URL.Query().Get("stop") == "true" {
+		for _, f := range a.runtime.functions {
+			if f.IsEnabled {
+				if err := a.runtime.StopFunction(f); err != nil {
+					log.Printf("Cannot stop drained function %v: %v\n", f.Name, err)
+				}
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// undrain makes the gateway accept invocations again.
+func (a *Admin) undrain(w http.ResponseWriter, r *http.Request) {
+	a.gateway.Undrain()
+	writeJSON(w, http.StatusOK, drainStatus{InFlight: a.gateway.InFlight()})
+}
+
+// streamEvents streams runtime events as server-sent events.
+// ?type= and ?function= filter the stream.
+func (a *Admin) streamEvents(w http.ResponseWriter, r *http.Request) {
+	eventType := r.URL.Query().Get("type")
+	function := r.URL.Query().Get("function")
+	// Server-sent events, or a stream of encoded events if the request accepts another encoding
+	encoding := negotiateEncoding(r)
+	if encoding == encodings["json"] {
+		encoding = nil
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", encoding.MediaTypes[0])
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	events := a.runtime.events.Subscribe()
+	defer a.runtime.events.Unsubscribe(events)
+
+	for {
+		select {
+		case event := <-events:
+			if eventType != "" && event.Type != eventType {
+				continue
+			}
+			if function != "" && event.Function != function {
+				continue
+			}
+
+			if encoding != nil {
+				if encoding.Encode(w, event) != nil {
+					return
+				}
+				rc.Flush()
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event.Type, data)
+			if err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *Admin) getUsage(w http.ResponseWriter, r *http.Request) {
+	writeEncoded(w, r, http.StatusOK, a.gateway.quotas.Usage())
+}
+
+func (a *Admin) getSLOs(w http.ResponseWriter, r *http.Request) {
+	writeEncoded(w, r, http.StatusOK, a.gateway.slos.Status())
+}
+
+// getInvocations returns a function's latest invocations with their latency breakdown, newest first.
+// ?limit= returns at most that many.
+func (a *Admin) getInvocations(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	writeEncoded(w, r, http.StatusOK, a.gateway.history.Recent(name, limit))
+}
+
+// getLogs returns the last ?tail= (default 100) lines of a function container's output as
+// text, then with ?follow=true streams its new output.
+func (a *Admin) getLogs(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	tail := 100
+	if t := r.URL.Query().Get("tail"); t != "" {
+		var err error
+		tail, err = strconv.Atoi(t)
+		if err != nil || tail < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid tail: %v", t))
+			return
+		}
+	}
+	f := a.runtime.FunctionByName(name)
+	if f.ContainerId == "" {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("function %v isn't running", name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rc := http.NewResponseController(w)
+	err := a.runtime.StreamFunctionLogs(r.Context(), f, tail, r.URL.Query().Get("follow") == "true", w, func() { rc.Flush() })
+	if err != nil {
+		log.Printf("Cannot stream function %v logs: %v\n", name, err)
+	}
+}
+
+func (a *Admin) getLatency(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	writeEncoded(w, r, http.StatusOK, a.gateway.history.Breakdown(name))
+}
+
+// exportUsage returns the invocation usage report, as csv or json (?format=), default csv.
+func (a *Admin) exportUsage(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = a.gateway.billing.config.Format
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown format: %s", format))
+		return
+	}
+
+	err := WriteReport(w, a.gateway.billing.Report(), format)
+	if err != nil {
+		log.Printf("Cannot write usage report: %v\n", err)
+	}
+}
+
+func (a *Admin) getCapture(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+
+	har, capturing := a.gateway.captures.HAR(name)
+	if !capturing {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("function %v is not being captured", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, har)
+}
+
+func (a *Admin) startCapture(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+
+	a.gateway.captures.Start(name)
+	writeJSON(w, http.StatusOK, map[string]any{"function": name, "capturing": true})
+}
+
+func (a *Admin) stopCapture(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := a.gateway.captures.Stop(name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"function": name, "capturing": false, "file": file})
+}
+
+// getFlags lists the feature flags of each function with any.
+func (a *Admin) getFlags(w http.ResponseWriter, r *http.Request) {
+	flags := map[string]map[string]bool{}
+	for _, f := range a.runtime.functions {
+		if fl := a.runtime.flags.Flags(f); len(fl) > 0 {
+			flags[f.Name] = fl
+		}
+	}
+	writeJSON(w, http.StatusOK, flags)
+}
+
+// flagRequest sets a feature flag.
+type flagRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func (a *Admin) setFlag(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	var req flagRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Enabled == nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("flag needs enabled set to true or false"))
+		return
+	}
+	flag := r.PathValue("flag")
+	if !validFlagName.MatchString(flag) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid flag name: %v", flag))
+		return
+	}
+
+	err = a.runtime.flags.Set(name, flag, *req.Enabled)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.runtime.flags.Flags(a.runtime.FunctionByName(name)))
+}
+
+// resetFlag sets a feature flag back to its value in the config, removing it if it has none.
+func (a *Admin) resetFlag(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	err := a.runtime.flags.Reset(name, r.PathValue("flag"))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.runtime.flags.Flags(a.runtime.FunctionByName(name)))
+}
+
+func (a *Admin) getQuarantine(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.runtime.quarantine.List())
+}
+
+// violationRequest reports a function violating a rule, e.g. from an egress proxy or image scanner.
+type violationRequest struct {
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// reportViolation counts a violation by a function, which may get it quarantined.
+func (a *Admin) reportViolation(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.function(w, r)
+	if !ok {
+		return
+	}
+	if a.runtime.quarantine == nil {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("quarantine isn't configured"))
+		return
+	}
+	var req violationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Source == "" || req.Reason == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("violation needs a source and a reason"))
+		return
+	}
+
+	quarantined, err := a.runtime.quarantine.Report(a.runtime.FunctionByName(name), req.Source, req.Reason)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"function": name, "quarantined": quarantined})
+}
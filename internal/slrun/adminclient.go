@@ -0,0 +1,188 @@
+package slrun
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AdminClient calls the admin API of a running slrun daemon.
+type AdminClient struct {
+	address string
+	token   string // Sent as a bearer token, unless empty
+	client  *http.Client
+}
+
+func NewAdminClient(address, token string) *AdminClient {
+	return &AdminClient{
+		address: address,
+		token:   token,
+		client:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+// newRequest returns a request to the admin API with the client's API version and token.
+func (c *AdminClient) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, "http://"+c.address+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(apiVersionHeader, strconv.Itoa(APIVersion))
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request to the admin API, decoding the JSON response into out if not nil.
+func (c *AdminClient) do(method string, path string, out any) error {
+	return c.send(method, path, nil, out)
+}
+
+// send sends a request to the admin API with in as its JSON body, unless nil,
+// decoding the JSON response into out if not nil.
+func (c *AdminClient) send(method string, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach slrun daemon at %v: %w", c.address, err)
+	}
+	defer resp.Body.Close()
+
+	if v := resp.Header.Get(apiVersionHeader); v != "" && v != strconv.Itoa(APIVersion) {
+		return fmt.Errorf("slrun daemon at %v has admin API version %v but this client has %v, "+
+			"run `slrun version` to see which version to install", c.address, v, APIVersion)
+	}
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if body.Error == "" {
+			body.Error = resp.Status
+		}
+		return fmt.Errorf("%v %v: %v", method, path, body.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *AdminClient) Status() (*Status, error) {
+	var status Status
+	err := c.do(http.MethodGet, "/admin/status", &status)
+	return &status, err
+}
+
+// SLOs returns the compliance of functions with their SLOs.
+func (c *AdminClient) SLOs() ([]*SLOStatus, error) {
+	var statuses []*SLOStatus
+	err := c.do(http.MethodGet, "/admin/slos", &statuses)
+	return statuses, err
+}
+
+// FunctionAction enables, disables, starts, stops, restarts, rebuilds or unquarantines a function.
+func (c *AdminClient) FunctionAction(name string, action string) (*FunctionState, error) {
+	var state FunctionState
+	err := c.do(http.MethodPost, "/admin/functions/"+name+"/"+action, &state)
+	return &state, err
+}
+
+// Logs writes the last tail lines of a function's output to w, then with follow its new
+// output until the daemon or the function stops.
+func (c *AdminClient) Logs(name string, tail int, follow bool, w io.Writer) error {
+	path := fmt.Sprintf("/admin/functions/%v/logs?tail=%v&follow=%v", name, tail, follow)
+	req, err := c.newRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+
+	// Followed logs stream for as long as they last
+	client := *c.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach slrun daemon at %v: %w", c.address, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("logs of %v: %v", name, cmp.Or(body.Error, resp.Status))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Drain stops the gateway accepting invocations and waits up to timeout for those in flight,
+// then stops function containers if stop is set.
+func (c *AdminClient) Drain(timeout time.Duration, stop bool) error {
+	path := "/admin/drain?timeout=" + timeout.String()
+	if stop {
+		path += "&stop=true"
+	}
+	return c.do(http.MethodPost, path, nil)
+}
+
+// Undrain makes the gateway accept invocations again.
+func (c *AdminClient) Undrain() error {
+	return c.do(http.MethodDelete, "/admin/drain", nil)
+}
+
+// Quarantine returns the quarantined functions.
+func (c *AdminClient) Quarantine() ([]*QuarantineRecord, error) {
+	var records []*QuarantineRecord
+	err := c.do(http.MethodGet, "/admin/quarantine", &records)
+	return records, err
+}
+
+// Flags returns the feature flags of each function with any.
+func (c *AdminClient) Flags() (map[string]map[string]bool, error) {
+	var flags map[string]map[string]bool
+	err := c.do(http.MethodGet, "/admin/flags", &flags)
+	return flags, err
+}
+
+// SetFlag sets the function's feature flag, returning its flags.
+func (c *AdminClient) SetFlag(name string, flag string, enabled bool) (map[string]bool, error) {
+	var flags map[string]bool
+	err := c.send(http.MethodPut, "/admin/functions/"+name+"/flags/"+flag, flagRequest{Enabled: &enabled}, &flags)
+	return flags, err
+}
+
+// ResetFlag sets the function's feature flag back to its value in the config, returning its flags.
+func (c *AdminClient) ResetFlag(name string, flag string) (map[string]bool, error) {
+	var flags map[string]bool
+	err := c.do(http.MethodDelete, "/admin/functions/"+name+"/flags/"+flag, &flags)
+	return flags, err
+}
+
+func (c *AdminClient) Fleet() ([]*FleetInstance, error) {
+	var instances []*FleetInstance
+	err := c.do(http.MethodGet, "/admin/fleet", &instances)
+	return instances, err
+}
@@ -0,0 +1,366 @@
+package slrun
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Priorities of async invocations, lowest first
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+var priorities = []string{PriorityLow, PriorityNormal, PriorityHigh}
+
+// priorityHeader sets the priority of an async invocation, overriding the function's
+const priorityHeader = "X-Slrun-Priority"
+
+// Async invocation states
+const (
+	AsyncQueued  = "queued"
+	AsyncRunning = "running"
+	AsyncDone    = "done"
+	AsyncFailed  = "failed"
+)
+
+// wantsAsync reports whether the client asked for the invocation to run asynchronously.
+func wantsAsync(r *http.Request) bool {
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, p := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(p), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validateAsync(config *types.Config) error {
+	for _, f := range config.Functions {
+		if f.Priority == "" {
+			f.Priority = PriorityNormal
+		}
+		if !slices.Contains(priorities, f.Priority) {
+			return fieldError(functionField(config, f, "priority"), "function %s has invalid priority: %s", f.Name, f.Priority)
+		}
+	}
+
+	async := config.Async
+	if async == nil {
+		return nil
+	}
+	if async.Workers <= 0 {
+		async.Workers = 4
+	}
+	if async.MaxQueued <= 0 {
+		async.MaxQueued = 1000
+	}
+	if async.Aging == "" {
+		async.Aging = "30s"
+	}
+	if async.ResultTTL == "" {
+		async.ResultTTL = "10m"
+	}
+	for _, d := range []string{async.Aging, async.ResultTTL} {
+		if _, err := time.ParseDuration(d); err != nil {
+			return fieldError("async", "invalid async duration: %w", err)
+		}
+	}
+	return nil
+}
+
+// AsyncResponse is the function's response to an async invocation.
+type AsyncResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"headers"`
+	Body   string      `json:"body"`
+}
+
+// AsyncInvocation is an invocation queued to run in the background.
+type AsyncInvocation struct {
+	ID         string         `json:"id"`
+	Function   string         `json:"function"`
+	Priority   string         `json:"priority"`
+	State      string         `json:"state"`
+	QueuedAt   time.Time      `json:"queued_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Response   *AsyncResponse `json:"response,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	// Reads the invocation, only given to its caller as it is queued
+	Token string `json:"token,omitempty"`
+
+	level  int // Index of Priority in priorities
+	token  string
+	path   string
+	query  string
+	method string
+	header http.Header
+	body   []byte
+}
+
+// AsyncQueue runs async invocations on a pool of workers, highest priority first.
+// Waiting invocations gain a priority level every aging interval, so low priority
+// work still runs under a steady stream of urgent invocations.
+type AsyncQueue struct {
+	gateway   *Gateway
+	config    *types.Async
+	aging     time.Duration
+	resultTTL time.Duration
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queues      [][]*AsyncInvocation // FIFO of each priority level
+	queued      int
+	invocations map[string]*AsyncInvocation // By ID, kept for resultTTL once finished
+	stopped     bool
+	wg          sync.WaitGroup
+}
+
+func NewAsyncQueue(gateway *Gateway, config *types.Async) *AsyncQueue {
+	aging, _ := time.ParseDuration(config.Aging)
+	resultTTL, _ := time.ParseDuration(config.ResultTTL)
+	q := &AsyncQueue{
+		gateway:     gateway,
+		config:      config,
+		aging:       aging,
+		resultTTL:   resultTTL,
+		queues:      make([][]*AsyncInvocation, len(priorities)),
+		invocations: make(map[string]*AsyncInvocation),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Enqueue queues an invocation of function, reading the request body now.
+// It returns the invocation as queued, with its token.
+func (q *AsyncQueue) Enqueue(function *types.Function, path string, r *http.Request) (AsyncInvocation, error) {
+	priority := function.Priority
+	if p := strings.ToLower(r.Header.Get(priorityHeader)); p != "" {
+		if !slices.Contains(priorities, p) {
+			return AsyncInvocation{}, invocationError(ErrClassBadRequest, http.StatusBadRequest, fmt.Errorf("invalid priority: %v", p))
+		}
+		priority = p
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return AsyncInvocation{}, bodyError(err, ErrClassBadRequest, http.StatusBadRequest)
+	}
+	header := r.Header.Clone()
+	header.Del("Prefer")
+	header.Del(deadlineHeader) // The caller doesn't wait for the result
+
+	inv := &AsyncInvocation{
+		ID:       randomHex(16),
+		Function: function.Name,
+		Priority: priority,
+		State:    AsyncQueued,
+		QueuedAt: time.Now(),
+		level:    slices.Index(priorities, priority),
+		path:     path,
+		query:    r.URL.RawQuery,
+		method:   r.Method,
+		header:   header,
+		body:     body,
+		token:    randomHex(32),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	if q.queued >= q.config.MaxQueued {
+		err := fmt.Errorf("async queue is full, %v invocations queued", q.queued)
+		return AsyncInvocation{}, invocationError(ErrClassQueueFull, http.StatusServiceUnavailable, err)
+	}
+	q.queues[inv.level] = append(q.queues[inv.level], inv)
+	q.queued++
+	q.invocations[inv.ID] = inv
+	q.cond.Signal()
+	queued := *inv
+	queued.Token = inv.token
+	return queued, nil
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// effectiveLevel is an invocation's priority level raised by the time it has waited.
+func (q *AsyncQueue) effectiveLevel(inv *AsyncInvocation, now time.Time) int {
+	level := inv.level
+	if q.aging > 0 {
+		level += int(now.Sub(inv.QueuedAt) / q.aging)
+	}
+	return min(level, len(priorities)-1)
+}
+
+// next removes the invocation to run next, the head of the level with the highest effective
+// priority, the oldest among equals. Heads are the oldest of their level, so they have aged most.
+func (q *AsyncQueue) next() *AsyncInvocation {
+	now := time.Now()
+	best := -1
+	for level, queue := range q.queues {
+		if len(queue) == 0 {
+			continue
+		}
+		if best == -1 {
+			best = level
+			continue
+		}
+		head, bestHead := queue[0], q.queues[best][0]
+		l, bl := q.effectiveLevel(head, now), q.effectiveLevel(bestHead, now)
+		if l > bl || (l == bl && head.QueuedAt.Before(bestHead.QueuedAt)) {
+			best = level
+		}
+	}
+	inv := q.queues[best][0]
+	q.queues[best] = q.queues[best][1:]
+	q.queued--
+	return inv
+}
+
+// expire forgets finished invocations older than the result TTL.
+func (q *AsyncQueue) expire() {
+	for id, inv := range q.invocations {
+		if inv.FinishedAt != nil && time.Since(*inv.FinishedAt) > q.resultTTL {
+			delete(q.invocations, id)
+		}
+	}
+}
+
+// Get returns a copy of the invocation with the given ID, if token is its token.
+func (q *AsyncQueue) Get(id, token string) (AsyncInvocation, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	inv, exists := q.invocations[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(token), []byte(inv.token)) != 1 {
+		return AsyncInvocation{}, false
+	}
+	return *inv, true
+}
+
+func (q *AsyncQueue) run(inv *AsyncInvocation) {
+	target := inv.path
+	if inv.query != "" {
+		target += "?" + inv.query
+	}
+	req, err := http.NewRequest(inv.method, target, bytes.NewReader(inv.body))
+	if err != nil {
+		q.finish(inv, nil, nil, err)
+		return
+	}
+	req.Header = inv.header
+
+	fun := q.gateway.runtime.FunctionByName(inv.Function)
+	if fun.Transform == TransformFormToJSON && !fun.Remote {
+		cleanup, err := transformFormToJSON(req, q.gateway.config.UploadDir, inv.Function, fun.MaxUploadBytes)
+		defer cleanup()
+		if err != nil {
+			q.finish(inv, nil, nil, err)
+			return
+		}
+	}
+
+	start := time.Now()
+	resp, err := q.gateway.runtime.CallFunctionByName(inv.Function, inv.path, req)
+	var body []byte
+	if err == nil {
+		body, err = resp.ReadAll(q.gateway.config.ResponseBufferBytes)
+	}
+	timing := InvocationTiming{Queue: start.Sub(inv.QueuedAt), Execution: time.Since(start)}
+	if resp != nil {
+		timing.ColdStart = resp.ColdStart
+		timing.Execution -= resp.ColdStart
+	}
+	q.gateway.recordInvocation(inv.Function, req, resp, err, timing)
+	q.finish(inv, resp, body, err)
+}
+
+func (q *AsyncQueue) finish(inv *AsyncInvocation, resp *FunctionResponse, body []byte, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	inv.FinishedAt = &now
+	inv.body = nil
+	if err != nil {
+		inv.State = AsyncFailed
+		inv.Error = err.Error()
+		log.Printf("Async invocation %v of function %v failed: %v\n", inv.ID, inv.Function, err)
+		return
+	}
+	inv.State = AsyncDone
+	inv.Response = &AsyncResponse{Status: resp.Status, Header: resp.Header, Body: string(body)}
+}
+
+func (q *AsyncQueue) worker() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for q.queued == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mu.Unlock()
+			return
+		}
+		inv := q.next()
+		now := time.Now()
+		inv.State = AsyncRunning
+		inv.StartedAt = &now
+		q.mu.Unlock()
+
+		q.run(inv)
+	}
+}
+
+// Start starts the queue's workers.
+func (q *AsyncQueue) Start() {
+	for range q.config.Workers {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	fmt.Printf("Async queue started with %v workers\n", q.config.Workers)
+}
+
+// Stop waits for running invocations to finish. Queued ones are dropped.
+func (q *AsyncQueue) Stop() {
+	q.mu.Lock()
+	q.stopped = true
+	if q.queued > 0 {
+		log.Printf("Dropping %v queued async invocations\n", q.queued)
+	}
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// invocationHandler reports the state of an async invocation, with its response once done,
+// to callers with its token as a bearer token. Others can't tell whether it exists.
+func (q *AsyncQueue) invocationHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	inv, exists := q.Get(r.PathValue("id"), token)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("async invocation %v not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, inv)
+}
@@ -0,0 +1,46 @@
+package slrun
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestAsyncInvocationToken(t *testing.T) {
+	config := &types.Async{}
+	if err := validateAsync(&types.Config{Async: config}); err != nil {
+		t.Fatal(err)
+	}
+	q := NewAsyncQueue(nil, config)
+	function := &types.Function{Name: "func1", Priority: PriorityNormal}
+
+	r := httptest.NewRequest("POST", "/func1", strings.NewReader("{}"))
+	r.Header.Set(requestIDHeader, "chosen-by-client")
+	inv, err := q.Enqueue(function, "/func1", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.ID == "chosen-by-client" || len(inv.ID) != 32 || len(inv.Token) != 64 {
+		t.Errorf("Enqueue() = id %q, token %q, want random ones", inv.ID, inv.Token)
+	}
+	other, _ := q.Enqueue(function, "/func1", r)
+	if other.ID == inv.ID || other.Token == inv.Token {
+		t.Errorf("Enqueue() twice = the same id or token")
+	}
+
+	for token, want := range map[string]int{inv.Token: http.StatusOK, other.Token: http.StatusNotFound, "": http.StatusNotFound} {
+		r := httptest.NewRequest("GET", "/_slrun/invocations/"+inv.ID, nil)
+		r.SetPathValue("id", inv.ID)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		q.invocationHandler(w, r)
+		if w.Code != want || want == http.StatusOK && (strings.Contains(w.Body.String(), inv.Token) || !strings.Contains(w.Body.String(), inv.ID)) {
+			t.Errorf("invocationHandler() with token %q = %v %s, want %v without the token", token, w.Code, w.Body, want)
+		}
+	}
+}
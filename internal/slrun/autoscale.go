@@ -0,0 +1,263 @@
+package slrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Scaling metric sources
+const (
+	MetricInflight   = "inflight"   // Requests in flight to the function on this node
+	MetricPrometheus = "prometheus" // Value of a PromQL query
+	MetricHTTP       = "http"       // Number at a path in a JSON document, e.g. a broker's queue depth
+)
+
+// metricSources read the metric of a scaling rule, by source.
+var metricSources = map[string]func(a *Autoscaler, f *types.Function, rule *types.ScalingRule) (float64, error){
+	MetricInflight: func(a *Autoscaler, f *types.Function, rule *types.ScalingRule) (float64, error) {
+		return float64(a.runtime.inflight(f.Name).Load()), nil
+	},
+	MetricPrometheus: func(a *Autoscaler, f *types.Function, rule *types.ScalingRule) (float64, error) {
+		return queryPrometheus(a.httpClient, rule.URL, rule.Query)
+	},
+	MetricHTTP: func(a *Autoscaler, f *types.Function, rule *types.ScalingRule) (float64, error) {
+		return fetchJSONNumber(a.httpClient, rule.URL, rule.Field)
+	},
+}
+
+func validateScaling(config *types.Config) error {
+	for _, f := range config.Functions {
+		scaling := f.Scaling
+		if scaling == nil {
+			continue
+		}
+		if config.Policy != types.ColdOnIdlePolicy {
+			return fieldError(functionField(config, f, "scaling"), "function %s scaling needs the %s policy", f.Name, types.ColdOnIdlePolicy)
+		}
+		if scaling.Interval == "" {
+			scaling.Interval = "15s"
+		}
+		if _, err := time.ParseDuration(scaling.Interval); err != nil {
+			return fieldError(functionField(config, f, "scaling.interval"), "function %s has invalid scaling interval: %w", f.Name, err)
+		}
+		if len(scaling.Rules) == 0 {
+			return fieldError(functionField(config, f, "scaling.rules"), "function %s has scaling without rules", f.Name)
+		}
+
+		for i, rule := range scaling.Rules {
+			field := functionField(config, f, fmt.Sprintf("scaling.rules[%d]", i))
+			if _, exists := metricSources[rule.Source]; !exists {
+				return fieldError(field+".source", "function %s has unknown scaling source: %s", f.Name, rule.Source)
+			}
+			if rule.Target <= 0 {
+				return fieldError(field+".target", "function %s %s scaling rule needs a positive target", f.Name, rule.Source)
+			}
+			switch rule.Source {
+			case MetricPrometheus:
+				if rule.URL == "" || rule.Query == "" {
+					return fieldError(field, "function %s prometheus scaling rule needs url and query", f.Name)
+				}
+			case MetricHTTP:
+				if rule.URL == "" || rule.Field == "" {
+					return fieldError(field, "function %s http scaling rule needs url and field", f.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// queryPrometheus returns the value of an instant PromQL query, summing vector results.
+func queryPrometheus(client *http.Client, server string, query string) (float64, error) {
+	resp, err := client.Get(strings.TrimSuffix(server, "/") + "/api/v1/query?query=" + url.QueryEscape(query))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return 0, err
+	}
+	if result.Status != "success" {
+		return 0, fmt.Errorf("prometheus query failed: %v", result.Error)
+	}
+
+	// Samples are [timestamp, "value"]
+	var samples [][]any
+	switch result.Data.ResultType {
+	case "scalar":
+		var sample []any
+		err = json.Unmarshal(result.Data.Result, &sample)
+		samples = append(samples, sample)
+	case "vector":
+		var vector []struct {
+			Value []any `json:"value"`
+		}
+		err = json.Unmarshal(result.Data.Result, &vector)
+		for _, v := range vector {
+			samples = append(samples, v.Value)
+		}
+	default:
+		return 0, fmt.Errorf("prometheus query returned a %v, expected a scalar or vector", result.Data.ResultType)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var sum float64
+	for _, sample := range samples {
+		if len(sample) != 2 {
+			return 0, fmt.Errorf("invalid prometheus sample: %v", sample)
+		}
+		s, _ := sample[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid prometheus sample value: %w", err)
+		}
+		sum += v
+	}
+	return sum, nil
+}
+
+// fetchJSONNumber returns the number at a dot separated field path in the JSON document at url.
+func fetchJSONNumber(client *http.Client, url string, field string) (float64, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%v returned %v", url, resp.Status)
+	}
+
+	var doc any
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range strings.Split(field, ".") {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return 0, fmt.Errorf("%v has no field %v", url, field)
+		}
+		doc = obj[key]
+	}
+	v, ok := doc.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%v field %v is not a number", url, field)
+	}
+	return v, nil
+}
+
+// Autoscaler evaluates functions' scaling rules, keeping functions warm while their metrics ask for replicas.
+// Functions with replicas run as many as asked, up to their max. Others run one container,
+// so any demand keeps it running and none lets the policy stop it on idle.
+type Autoscaler struct {
+	runtime    *Runtime
+	httpClient *http.Client
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewAutoscaler(runtime *Runtime) *Autoscaler {
+	return &Autoscaler{
+		runtime:    runtime,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		stop:       make(chan struct{}),
+	}
+}
+
+// desired returns the replicas a function's rules ask for, the most any rule asks.
+// Rules whose metric can't be read keep asking what they last did.
+func (a *Autoscaler) desired(f *types.Function, last map[*types.ScalingRule]int) int {
+	desired := 0
+	for _, rule := range f.Scaling.Rules {
+		value, err := metricSources[rule.Source](a, f, rule)
+		if err != nil {
+			log.Printf("Autoscaler: cannot read function %v %v metric: %v\n", f.Name, rule.Source, err)
+		} else {
+			last[rule] = int(math.Ceil(value / rule.Target))
+		}
+		desired = max(desired, last[rule])
+	}
+	return desired
+}
+
+func (a *Autoscaler) scale(f *types.Function, last map[*types.ScalingRule]int) {
+	desired := a.desired(f, last)
+	if desired != f.Desired {
+		log.Printf("Autoscaler: function %v wants %v replicas\n", f.Name, desired)
+	}
+	f.Desired = desired
+	if desired == 0 || !f.IsEnabled || f.Replicas != nil {
+		// Functions with replicas are scaled to the desired replicas by the runtime
+		return
+	}
+
+	if !f.IsRunning && a.runtime.crashLoopError(f) != nil {
+		return
+	}
+
+	// Called like an invocation, so the policy starts it if stopped and restarts its idle timer
+	err := a.runtime.policy.PreFunctionCall(f)
+	if err != nil {
+		a.runtime.functionStartFailed(f, err)
+		log.Printf("Autoscaler: cannot start function %v: %v\n", f.Name, err)
+	}
+}
+
+// Start evaluates each function's scaling rules on its interval.
+func (a *Autoscaler) Start() {
+	for _, f := range a.runtime.functions {
+		if f.Scaling == nil || f.Remote {
+			continue
+		}
+		interval, _ := time.ParseDuration(f.Scaling.Interval)
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			last := make(map[*types.ScalingRule]int)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				a.scale(f, last)
+				select {
+				case <-ticker.C:
+				case <-a.stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (a *Autoscaler) Stop() {
+	close(a.stop)
+	a.wg.Wait()
+}
+
+// inflight returns the counter of requests in flight to a function on this node.
+func (r *Runtime) inflight(name string) *atomic.Int64 {
+	counter, _ := r.inflights.LoadOrStore(name, &atomic.Int64{})
+	return counter.(*atomic.Int64)
+}
@@ -0,0 +1,132 @@
+package slrun
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/marcorentap/slrun/internal/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Container engines slrun runs functions on
+const (
+	BackendDocker     = "docker"
+	BackendPodman     = "podman"
+	BackendContainerd = "containerd"
+)
+
+var backendEngines = []string{BackendDocker, BackendPodman, BackendContainerd}
+
+// ContainerBackend runs function containers. Docker's client implements it, for Docker and
+// engines serving Docker's API such as Podman, and containerdBackend for containerd.
+type ContainerBackend interface {
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (dockertypes.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+	ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error)
+	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+}
+
+// EngineClient is the container engine's API for images, from builds to pulls and exports,
+// and for slrun's other containers, volumes and networks. Docker's client implements it, for
+// Docker and Podman, and containerdBackend for containerd.
+type EngineClient interface {
+	ContainerBackend
+	ContainerKill(ctx context.Context, containerID, signal string) error
+	ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error)
+	DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error)
+	ImageBuild(ctx context.Context, buildContext io.Reader, options build.ImageBuildOptions) (build.ImageBuildResponse, error)
+	ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error)
+	ImageLoad(ctx context.Context, input io.Reader, loadOpts ...client.ImageLoadOption) (image.LoadResponse, error)
+	ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error)
+	ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error)
+	ImageSave(ctx context.Context, imageIDs []string, saveOpts ...client.ImageSaveOption) (io.ReadCloser, error)
+	ImageTag(ctx context.Context, source, target string) error
+	NetworkRemove(ctx context.Context, networkID string) error
+	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+	BuildCachePrune(ctx context.Context, opts build.CachePruneOptions) (*build.CachePruneReport, error)
+}
+
+func validateBackend(config *types.Config) error {
+	if config.Backend == nil {
+		config.Backend = &types.Backend{}
+	}
+	if config.Backend.Engine == "" {
+		config.Backend.Engine = BackendDocker
+	}
+	if !slices.Contains(backendEngines, config.Backend.Engine) {
+		return fieldError("backend.engine", "unknown container engine %s, expected one of %v", config.Backend.Engine, backendEngines)
+	}
+	if config.Backend.Engine == BackendContainerd {
+		return validateContainerd(config)
+	}
+	if config.Backend.Host != "" {
+		if _, err := client.ParseHostURL(config.Backend.Host); err != nil {
+			return fieldError("backend.host", "invalid container engine host: %w", err)
+		}
+	}
+	return nil
+}
+
+// backendHost returns the API socket of the backend, empty for Docker's from its env.
+// Podman's is CONTAINER_HOST, else the rootless socket of the user, or the rootful one for root.
+// containerd serves no Docker API, nerdctl is used instead.
+func backendHost(backend *types.Backend) string {
+	if backend.Engine == BackendContainerd {
+		return ""
+	}
+	if backend.Host != "" {
+		return backend.Host
+	}
+	if backend.Engine != BackendPodman {
+		return ""
+	}
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Getuid() != 0 {
+		return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+	}
+	return "unix:///run/podman/podman.sock"
+}
+
+// newBackendClient returns a client of the backend's API, Docker's from its env if nil.
+func newBackendClient(backend *types.Backend, opts ...client.Opt) (*client.Client, error) {
+	base := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if backend != nil {
+		if host := backendHost(backend); host != "" {
+			base = append(base, client.WithHost(host))
+		}
+	}
+	return client.NewClientWithOpts(append(base, opts...)...)
+}
+
+// backendGatewayHostname returns the host name containers of the engine reach the host on.
+func backendGatewayHostname(engine string) string {
+	if engine == BackendPodman {
+		return "host.containers.internal" // Added to containers' hosts by Podman itself
+	}
+	return gatewayHostname
+}
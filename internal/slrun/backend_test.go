@@ -0,0 +1,41 @@
+package slrun
+
+import (
+	"os"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestBackendHost(t *testing.T) {
+	t.Setenv("CONTAINER_HOST", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got := backendHost(&types.Backend{Engine: BackendDocker}); got != "" {
+		t.Errorf("backendHost(docker) = %q, want Docker's from its env", got)
+	}
+	if got := backendHost(&types.Backend{Engine: BackendPodman, Host: "tcp://podman:8080"}); got != "tcp://podman:8080" {
+		t.Errorf("backendHost(podman) with a host = %q, want it", got)
+	}
+	want := "unix:///run/user/1000/podman/podman.sock"
+	if os.Getuid() == 0 {
+		want = "unix:///run/podman/podman.sock"
+	}
+	if got := backendHost(&types.Backend{Engine: BackendPodman}); got != want {
+		t.Errorf("backendHost(podman) = %q, want %q", got, want)
+	}
+	t.Setenv("CONTAINER_HOST", "unix:///tmp/podman.sock")
+	if got := backendHost(&types.Backend{Engine: BackendPodman}); got != "unix:///tmp/podman.sock" {
+		t.Errorf("backendHost(podman) with CONTAINER_HOST = %q, want it", got)
+	}
+}
+
+func TestValidateBackend(t *testing.T) {
+	config := &types.Config{}
+	if err := validateBackend(config); err != nil || config.Backend.Engine != BackendDocker {
+		t.Errorf("validateBackend() = %v, engine %v, want docker", err, config.Backend)
+	}
+	config = &types.Config{Backend: &types.Backend{Engine: "lxc"}}
+	if err := validateBackend(config); err == nil {
+		t.Errorf("validateBackend() of an unknown engine = nil, want an error")
+	}
+}
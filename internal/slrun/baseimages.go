@@ -0,0 +1,120 @@
+package slrun
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func validateBaseImages(config *types.Config) error {
+	bases := config.BaseImages
+	if bases == nil {
+		return nil
+	}
+	for _, pattern := range slices.Concat(bases.Allow, bases.Deny) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fieldError("base_images", "invalid base image pattern %q: %w", pattern, err)
+		}
+	}
+	if bases.MaxAge != "" {
+		if _, err := time.ParseDuration(bases.MaxAge); err != nil {
+			return fieldError("base_images.max_age", "invalid base images max_age: %w", err)
+		}
+	}
+	return nil
+}
+
+// imageNames returns the names of image ref patterns are matched against, as written,
+// in short form, e.g. python:3.12, and in full form, e.g. docker.io/library/python:3.12.
+// Images without a tag or digest are tagged latest.
+func imageNames(ref string) []string {
+	names := []string{ref}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return names
+	}
+	named = reference.TagNameOnly(named)
+	return append(names, reference.FamiliarString(named), named.String())
+}
+
+// imageMatches reports whether any name of image ref matches any of patterns.
+func imageMatches(ref string, patterns []string) bool {
+	for _, name := range imageNames(ref) {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// functionBaseImages returns the images the function builds from, or the prebuilt image it runs.
+func functionBaseImages(function *types.Function) ([]string, error) {
+	images, err := RequiredImages([]*types.Function{function})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(images)), nil
+}
+
+// checkBaseImageNames returns an error if the function builds from or runs an image
+// the allowlist doesn't allow, or the denylist denies. Checked before building or pulling.
+func checkBaseImageNames(bases *types.BaseImages, function *types.Function) error {
+	if bases == nil {
+		return nil
+	}
+	images, err := functionBaseImages(function)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, img := range images {
+		if len(bases.Allow) > 0 && !imageMatches(img, bases.Allow) {
+			errs = append(errs, fmt.Errorf("base image %v isn't allowed", img))
+		} else if imageMatches(img, bases.Deny) {
+			errs = append(errs, fmt.Errorf("base image %v is denied", img))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("function %v %w: %w", function.Name, ErrPolicyDenied, errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkBaseImageAges returns an error if the function builds from or runs an image created
+// longer than max_age ago. Checked once built or pulled, when the images are available locally.
+func checkBaseImageAges(bases *types.BaseImages, function *types.Function) error {
+	if bases == nil || bases.MaxAge == "" {
+		return nil
+	}
+	maxAge, _ := time.ParseDuration(bases.MaxAge)
+	images, err := functionBaseImages(function)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, img := range images {
+		inspect, err := dockerCli.ImageInspect(dockerCtx, img)
+		if err != nil {
+			return fmt.Errorf("cannot inspect base image %v: %w", img, err)
+		}
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			continue // Unknown
+		}
+		if age := time.Since(created); age > maxAge {
+			errs = append(errs, fmt.Errorf("base image %v is outdated, created %v ago", img, age.Round(time.Hour)))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("function %v %w: %w", function.Name, ErrPolicyDenied, errors.Join(errs...))
+	}
+	return nil
+}
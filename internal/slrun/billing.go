@@ -0,0 +1,195 @@
+package slrun
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// UsageRecord is the accumulated usage of a function by an API key.
+type UsageRecord struct {
+	Function    string  `json:"function"`
+	Key         string  `json:"key"` // API key name, empty for requests without a known key
+	Invocations int     `json:"invocations"`
+	Seconds     float64 `json:"seconds"`
+	GBSeconds   float64 `json:"gb_seconds"`
+	ColdStarts  int     `json:"cold_starts"`
+	// Seconds broken down by where they were spent
+	QueueSeconds     float64 `json:"queue_seconds"`
+	ColdStartSeconds float64 `json:"cold_start_seconds"`
+	ExecutionSeconds float64 `json:"execution_seconds"`
+}
+
+// UsageReport is the usage accumulated over a period.
+type UsageReport struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Records []*UsageRecord `json:"records"`
+}
+
+type usageKey struct {
+	function string
+	key      string
+}
+
+// Billing accumulates invocation usage per function and API key.
+type Billing struct {
+	config  types.UsageExport
+	mu      sync.Mutex
+	since   time.Time
+	records map[usageKey]*UsageRecord
+	stop    chan struct{}
+}
+
+func NewBilling(config types.UsageExport) *Billing {
+	return &Billing{
+		config:  config,
+		since:   time.Now(),
+		records: make(map[usageKey]*UsageRecord),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Record adds an invocation of function by key with its timing.
+func (b *Billing) Record(function string, key string, timing InvocationTiming) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := usageKey{function, key}
+	rec, exists := b.records[k]
+	if !exists {
+		rec = &UsageRecord{Function: function, Key: key}
+		b.records[k] = rec
+	}
+	d := timing.Total()
+	rec.Invocations++
+	rec.Seconds += d.Seconds()
+	rec.GBSeconds += d.Seconds() * float64(b.config.MemoryMB) / 1024
+	if timing.ColdStart > 0 {
+		rec.ColdStarts++
+	}
+	rec.QueueSeconds += timing.Queue.Seconds()
+	rec.ColdStartSeconds += timing.ColdStart.Seconds()
+	rec.ExecutionSeconds += timing.Execution.Seconds()
+}
+
+// Report returns the usage accumulated since the runtime started.
+func (b *Billing) Report() *UsageReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	report := &UsageReport{From: b.since, To: time.Now(), Records: []*UsageRecord{}}
+	for _, rec := range b.records {
+		r := *rec
+		report.Records = append(report.Records, &r)
+	}
+	slices.SortFunc(report.Records, func(a, b *UsageRecord) int {
+		return cmp.Or(cmp.Compare(a.Function, b.Function), cmp.Compare(a.Key, b.Key))
+	})
+	return report
+}
+
+// WriteReport writes a usage report as csv or json.
+func WriteReport(w io.Writer, report *UsageReport, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"from", "to", "function", "key", "invocations", "seconds", "gb_seconds",
+			"cold_starts", "queue_seconds", "cold_start_seconds", "execution_seconds"})
+		for _, rec := range report.Records {
+			cw.Write([]string{
+				report.From.Format(time.RFC3339),
+				report.To.Format(time.RFC3339),
+				rec.Function,
+				rec.Key,
+				strconv.Itoa(rec.Invocations),
+				strconv.FormatFloat(rec.Seconds, 'f', 3, 64),
+				strconv.FormatFloat(rec.GBSeconds, 'f', 3, 64),
+				strconv.Itoa(rec.ColdStarts),
+				strconv.FormatFloat(rec.QueueSeconds, 'f', 3, 64),
+				strconv.FormatFloat(rec.ColdStartSeconds, 'f', 3, 64),
+				strconv.FormatFloat(rec.ExecutionSeconds, 'f', 3, 64),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown usage export format: %s", format)
+}
+
+// Export writes the current usage report to the export dir.
+func (b *Billing) Export() (string, error) {
+	err := os.MkdirAll(b.config.Dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(b.config.Dir, fmt.Sprintf("usage-%v.%v", time.Now().Format("20060102-150405"), b.config.Format))
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return file, WriteReport(f, b.Report(), b.config.Format)
+}
+
+// Start exports usage on the configured interval, if any.
+func (b *Billing) Start() error {
+	if b.config.Interval == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(b.config.Interval)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				file, err := b.Export()
+				if err != nil {
+					log.Printf("Cannot export usage: %v\n", err)
+					continue
+				}
+				log.Printf("Exported usage to %v\n", file)
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops scheduled exports, writing a last export if they were enabled.
+func (b *Billing) Stop() {
+	if b.config.Interval == "" {
+		return
+	}
+	close(b.stop)
+
+	file, err := b.Export()
+	if err != nil {
+		log.Printf("Cannot export usage: %v\n", err)
+		return
+	}
+	log.Printf("Exported usage to %v\n", file)
+}
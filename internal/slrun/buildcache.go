@@ -0,0 +1,128 @@
+package slrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Guards the build records file
+var buildRecordsMu sync.Mutex
+
+// buildRecord is the latest build of a function's image.
+type buildRecord struct {
+	Digest string `json:"digest"` // Of what the build used, see contextDigest
+	Image  string `json:"image"`  // ID of the image built
+}
+
+func buildRecordsFile(stateDir string) string {
+	return filepath.Join(stateDir, "builds.json")
+}
+
+// readBuildRecords returns the latest build of each function by name.
+func readBuildRecords(stateDir string) (map[string]*buildRecord, error) {
+	records := make(map[string]*buildRecord)
+	bytes, err := os.ReadFile(buildRecordsFile(stateDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &records)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", buildRecordsFile(stateDir), err)
+	}
+	return records, nil
+}
+
+// recordBuild records the latest build of the function.
+func recordBuild(stateDir string, function string, record *buildRecord) error {
+	buildRecordsMu.Lock()
+	defer buildRecordsMu.Unlock()
+	records, err := readBuildRecords(stateDir)
+	if err != nil {
+		return err
+	}
+	records[function] = record
+	bytes, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(buildRecordsFile(stateDir), bytes, 0644)
+}
+
+// contextDigest returns the digest of what building the function uses: the names, modes,
+// link targets and contents of the files in its build context, leaving out modification
+// times, its test command, and the local IDs of its base images, ids by image name.
+func contextDigest(function *types.Function, tarCtx []byte, ids map[string]string) (string, error) {
+	h := sha256.New()
+	tr := tar.NewReader(bytes.NewReader(tarCtx))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q %c %o %q %d\n", header.Name, header.Typeflag, header.Mode, header.Linkname, header.Size)
+		_, err = io.Copy(h, tr)
+		if err != nil {
+			return "", err
+		}
+	}
+	fmt.Fprintf(h, "test_command %q\n", function.TestCommand)
+	fmt.Fprintf(h, "dockerfile %q target %q\n", function.Dockerfile, function.Target)
+	for _, arg := range sortedKeys(function.BuildArgs) {
+		fmt.Fprintf(h, "build_arg %q %q\n", arg, function.BuildArgs[arg])
+	}
+	for _, img := range sortedKeys(ids) {
+		fmt.Fprintf(h, "base %q %q\n", img, ids[img])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// localImageIDs returns the ID of each image on the daemon building images, empty for images not pulled.
+func localImageIDs(images []string) map[string]string {
+	ids := make(map[string]string, len(images))
+	for _, img := range images {
+		if inspect, err := buildClient().ImageInspect(dockerCtx, img); err == nil {
+			ids[img] = inspect.ID
+		} else {
+			ids[img] = ""
+		}
+	}
+	return ids
+}
+
+// builtImageCurrent reports whether the function's image was built from digest and is still there.
+func builtImageCurrent(stateDir string, function *types.Function, digest string) bool {
+	buildRecordsMu.Lock()
+	records, err := readBuildRecords(stateDir)
+	buildRecordsMu.Unlock()
+	if err != nil {
+		return false
+	}
+	record, ok := records[function.Name]
+	if !ok || record.Digest != digest {
+		return false
+	}
+	inspect, err := dockerCli.ImageInspect(dockerCtx, functionImageName(function))
+	return err == nil && inspect.ID == record.Image
+}
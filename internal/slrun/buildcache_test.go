@@ -0,0 +1,75 @@
+package slrun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestContextDigest(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.go")
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM golang"), 0644)
+	os.WriteFile(main, []byte("package main"), 0644)
+	fun := &types.Function{Name: "func1", BuildDir: dir}
+	bases := map[string]string{"golang": "sha256:aaa"}
+
+	digest := func() string {
+		t.Helper()
+		tarCtx, err := createTarContext(fun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := contextDigest(fun, tarCtx.Bytes(), bases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	first := digest()
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(main, later, later)
+	if got := digest(); got != first {
+		t.Errorf("digest changed when only a modification time did")
+	}
+
+	os.WriteFile(main, []byte("package main // changed"), 0644)
+	changed := digest()
+	if changed == first {
+		t.Errorf("digest didn't change with a file's content")
+	}
+
+	bases["golang"] = "sha256:bbb"
+	if got := digest(); got == changed {
+		t.Errorf("digest didn't change with a base image")
+	}
+
+	fun.TestCommand = "go test ./..."
+	if got := digest(); got == changed {
+		t.Errorf("digest didn't change with the test command")
+	}
+}
+
+func TestBuildRecords(t *testing.T) {
+	dir := t.TempDir()
+	records, err := readBuildRecords(dir)
+	if err != nil || len(records) != 0 {
+		t.Fatalf("readBuildRecords() of a new state dir = %v, %v", records, err)
+	}
+
+	recordBuild(dir, "func1", &buildRecord{Digest: "sha256:1", Image: "sha256:i1"})
+	recordBuild(dir, "func2", &buildRecord{Digest: "sha256:2", Image: "sha256:i2"})
+	recordBuild(dir, "func1", &buildRecord{Digest: "sha256:3", Image: "sha256:i3"})
+
+	records, err = readBuildRecords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records["func1"].Digest != "sha256:3" || records["func2"].Image != "sha256:i2" {
+		t.Errorf("readBuildRecords() = %v", records)
+	}
+}
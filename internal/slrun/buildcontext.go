@@ -0,0 +1,93 @@
+package slrun
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Build context compression
+const (
+	CompressionAuto = "auto" // zstd or gzip for remote daemons, none for local ones
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// First daemon API version decompressing zstd build contexts
+const zstdAPIVersion = "1.42"
+
+// buildContextCompression returns the compression of build contexts sent to the daemon building images.
+func buildContextCompression(configured string) string {
+	// buildx sends build contexts to its builder itself
+	if remoteBuilder != nil && remoteBuilder.cli == nil {
+		return CompressionNone
+	}
+	if configured == CompressionNone || configured == CompressionGzip {
+		return configured
+	}
+
+	// containerd builds from a dir on this host
+	cli, ok := buildClient().(*client.Client)
+	if !ok {
+		return CompressionNone
+	}
+	// Compressing only costs time when the daemon is on this host
+	host := cli.DaemonHost()
+	if configured == CompressionAuto && (strings.HasPrefix(host, "unix://") || strings.HasPrefix(host, "npipe://")) {
+		return CompressionNone
+	}
+
+	server, err := cli.ServerVersion(dockerCtx)
+	if err != nil {
+		log.Printf("Cannot get Docker daemon version, compressing build contexts with gzip: %v\n", err)
+		return CompressionGzip
+	}
+	if versions.LessThan(server.APIVersion, zstdAPIVersion) {
+		if configured == CompressionZstd {
+			log.Printf("Docker daemon API %v can't decompress zstd, compressing build contexts with gzip\n", server.APIVersion)
+		}
+		return CompressionGzip
+	}
+	return CompressionZstd
+}
+
+// compressContext compresses a tar build context as it is read.
+// Closing it stops compression of the rest.
+func compressContext(tar io.Reader, compression string) (io.ReadCloser, error) {
+	var newWriter func(w io.Writer) (io.WriteCloser, error)
+	switch compression {
+	case CompressionNone:
+		return io.NopCloser(tar), nil
+	case CompressionGzip:
+		newWriter = func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestSpeed)
+		}
+	case CompressionZstd:
+		newWriter = func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		}
+	default:
+		return nil, fmt.Errorf("unknown build compression: %v", compression)
+	}
+
+	pr, pw := io.Pipe()
+	zw, err := newWriter(pw)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_, err := io.Copy(zw, tar)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
@@ -0,0 +1,192 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// BuildKit reports build progress in aux messages with this ID, each holding a base64 encoded
+// moby.buildkit.v1.StatusResponse protobuf message
+const buildkitTraceID = "moby.buildkit.trace"
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// buildkitVertex is a step of a BuildKit build, from a StatusResponse.
+type buildkitVertex struct {
+	digest    string
+	name      string
+	cached    bool
+	started   bool
+	completed bool
+	err       string
+}
+
+// buildkitLog is output of a step of a BuildKit build, from a StatusResponse.
+type buildkitLog struct {
+	vertex string // Digest of the step
+	msg    []byte
+}
+
+// protoFields calls fn with each field of a protobuf message, with the value of varint fields
+// and the bytes of length-delimited ones. Fixed size fields are skipped.
+func protoFields(b []byte, fn func(field int, value uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProto
+		}
+		b = b[n:]
+		field := int(key >> 3)
+
+		var value uint64
+		var data []byte
+		switch key & 7 {
+		case 0: // Varint
+			value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errBadProto
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errBadProto
+			}
+			b = b[8:]
+			continue
+		case 2: // Length-delimited
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errBadProto
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errBadProto
+			}
+			b = b[4:]
+			continue
+		default:
+			return errBadProto
+		}
+		err := fn(field, value, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseBuildkitStatus returns the steps and step output of a StatusResponse.
+func parseBuildkitStatus(b []byte) ([]*buildkitVertex, []*buildkitLog, error) {
+	var vertexes []*buildkitVertex
+	var logs []*buildkitLog
+	err := protoFields(b, func(field int, _ uint64, data []byte) error {
+		switch field {
+		case 1: // Vertex
+			v := &buildkitVertex{}
+			vertexes = append(vertexes, v)
+			return protoFields(data, func(field int, value uint64, data []byte) error {
+				switch field {
+				case 1:
+					v.digest = string(data)
+				case 3:
+					v.name = string(data)
+				case 4:
+					v.cached = value != 0
+				case 5:
+					v.started = true
+				case 6:
+					v.completed = true
+				case 7:
+					v.err = string(data)
+				}
+				return nil
+			})
+		case 3: // VertexLog
+			l := &buildkitLog{}
+			logs = append(logs, l)
+			return protoFields(data, func(field int, _ uint64, data []byte) error {
+				switch field {
+				case 1:
+					l.vertex = string(data)
+				case 4:
+					l.msg = data
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return vertexes, logs, err
+}
+
+// buildkitProgress writes the progress of a BuildKit build as plain text: a line per step
+// as it starts and completes, numbered as docker build numbers them, and the steps' output.
+type buildkitProgress struct {
+	out   io.Writer
+	steps map[string]int // Number of each step seen by digest
+	done  map[string]bool
+}
+
+func newBuildkitProgress(out io.Writer) *buildkitProgress {
+	return &buildkitProgress{out: out, steps: make(map[string]int), done: make(map[string]bool)}
+}
+
+// aux writes the progress reported in an aux message of the build's stream.
+func (p *buildkitProgress) aux(msg jsonmessage.JSONMessage) {
+	if msg.ID != buildkitTraceID {
+		return
+	}
+	var data []byte // Decoded from base64
+	if json.Unmarshal(*msg.Aux, &data) != nil {
+		return
+	}
+	vertexes, logs, err := parseBuildkitStatus(data)
+	if err != nil {
+		return
+	}
+
+	for _, v := range vertexes {
+		n, seen := p.steps[v.digest]
+		if !seen {
+			if !v.started && !v.cached {
+				continue
+			}
+			n = len(p.steps) + 1
+			p.steps[v.digest] = n
+			fmt.Fprintf(p.out, "#%d %v\n", n, v.name)
+		}
+		if p.done[v.digest] {
+			continue
+		}
+		switch {
+		case v.cached:
+			fmt.Fprintf(p.out, "#%d CACHED\n", n)
+		case v.err != "":
+			fmt.Fprintf(p.out, "#%d ERROR: %v\n", n, v.err)
+		case v.completed:
+			fmt.Fprintf(p.out, "#%d DONE\n", n)
+		default:
+			continue
+		}
+		p.done[v.digest] = true
+	}
+
+	for _, l := range logs {
+		n, seen := p.steps[l.vertex]
+		if !seen {
+			continue
+		}
+		for line := range bytes.Lines(l.msg) {
+			fmt.Fprintf(p.out, "#%d %s", n, bytes.TrimSuffix(line, []byte("\n")))
+			fmt.Fprintln(p.out)
+		}
+	}
+}
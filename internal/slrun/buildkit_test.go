@@ -0,0 +1,54 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// protoField encodes a length-delimited protobuf field.
+func protoField(field int, data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func buildkitTrace(t *testing.T, fields ...[]byte) jsonmessage.JSONMessage {
+	aux, err := json.Marshal(bytes.Join(fields, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := json.RawMessage(aux)
+	return jsonmessage.JSONMessage{ID: buildkitTraceID, Aux: &raw}
+}
+
+func TestBuildkitProgress(t *testing.T) {
+	started := protoField(5, nil)
+	completed := protoField(6, nil)
+	cached := []byte{4<<3 | 0, 1}
+
+	var buf bytes.Buffer
+	p := newBuildkitProgress(&buf)
+	p.aux(buildkitTrace(t,
+		protoField(1, bytes.Join([][]byte{protoField(1, []byte("sha256:a")), protoField(3, []byte("[1/2] FROM alpine")), cached, started, completed}, nil)),
+		protoField(1, bytes.Join([][]byte{protoField(1, []byte("sha256:b")), protoField(3, []byte("[2/2] RUN make")), started}, nil)),
+		protoField(1, bytes.Join([][]byte{protoField(1, []byte("sha256:c")), protoField(3, []byte("exporting to image"))}, nil)),
+		protoField(3, bytes.Join([][]byte{protoField(1, []byte("sha256:b")), protoField(4, []byte("cc main.c\nld main"))}, nil)),
+	))
+	p.aux(buildkitTrace(t,
+		protoField(1, bytes.Join([][]byte{protoField(1, []byte("sha256:b")), protoField(3, []byte("[2/2] RUN make")), started, completed, protoField(7, []byte("exit code: 2"))}, nil)),
+	))
+	p.aux(jsonmessage.JSONMessage{ID: "moby.image.id", Aux: &json.RawMessage{'{', '}'}})
+
+	want := "#1 [1/2] FROM alpine\n#1 CACHED\n#2 [2/2] RUN make\n#2 cc main.c\n#2 ld main\n#2 ERROR: exit code: 2\n"
+	if got := buf.String(); got != want {
+		t.Errorf("buildkitProgress wrote %q, want %q", got, want)
+	}
+
+	if _, _, err := parseBuildkitStatus([]byte{1<<3 | 2, 10, 'a'}); err == nil {
+		t.Errorf("parseBuildkitStatus() accepted a truncated message")
+	}
+}
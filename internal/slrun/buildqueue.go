@@ -0,0 +1,146 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Kinds of builds, by what requests them. Builds of a function of the same kind are identical.
+const (
+	BuildStartup = "startup" // Deploying functions as slrun starts
+	BuildSources = "sources" // Watch mode, the function's sources changed
+	BuildConfig  = "config"  // Watch mode, the function's config changed
+	BuildRebuild = "rebuild" // Scheduled, as base images changed, or requested through the admin API
+)
+
+// ErrBuildCancelled is returned by builds cancelled while queued or running.
+var ErrBuildCancelled = errors.New("build cancelled")
+
+// BuildQueue runs function builds up to build_parallelism at once, and one at a time per function,
+// in the order they were requested. A build requested while an identical one waits joins it
+// rather than building again, and a superseding build, e.g. as sources changed again, cancels
+// an identical one in progress.
+type BuildQueue struct {
+	slots chan struct{} // Holds a value per build running
+
+	mu     sync.Mutex
+	queues map[string][]*queuedBuild // Builds of each function by name, the first running once started
+}
+
+type queuedBuild struct {
+	function  string
+	kind      string
+	requested time.Time
+	started   bool
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed once it ran, or was cancelled
+	err       error
+}
+
+// QueuedBuild is a build running or waiting, as listed by the admin API.
+type QueuedBuild struct {
+	Function  string    `json:"function"`
+	Kind      string    `json:"kind"`
+	State     string    `json:"state"` // running or queued
+	Requested time.Time `json:"requested"`
+}
+
+func NewBuildQueue(parallelism int) *BuildQueue {
+	return &BuildQueue{
+		slots:  make(chan struct{}, parallelism),
+		queues: make(map[string][]*queuedBuild),
+	}
+}
+
+// Build runs build for the function once its turn comes, returning its error. build must stop
+// once its context is done, as the build is cancelled. If supersede, an identical build in progress
+// is cancelled first.
+func (q *BuildQueue) Build(function string, kind string, supersede bool, build func(ctx context.Context) error) error {
+	q.mu.Lock()
+	queue := slices.Clone(q.queues[function])
+	for _, b := range queue {
+		if b.kind != kind {
+			continue
+		}
+		if !b.started {
+			q.mu.Unlock()
+			<-b.done
+			return b.err
+		}
+		if supersede {
+			b.cancel()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &queuedBuild{function: function, kind: kind, requested: time.Now(), cancel: cancel, done: make(chan struct{})}
+	q.queues[function] = append(q.queues[function], b)
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.queues[function] = slices.DeleteFunc(q.queues[function], func(queued *queuedBuild) bool { return queued == b })
+		if len(q.queues[function]) == 0 {
+			delete(q.queues, function)
+		}
+		q.mu.Unlock()
+		cancel()
+		close(b.done)
+	}()
+
+	// After the function's builds requested before
+	for _, previous := range queue {
+		select {
+		case <-previous.done:
+		case <-ctx.Done():
+			b.err = ErrBuildCancelled
+			return b.err
+		}
+	}
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		b.err = ErrBuildCancelled
+		return b.err
+	}
+	defer func() { <-q.slots }()
+
+	q.mu.Lock()
+	b.started = true
+	q.mu.Unlock()
+	b.err = build(ctx)
+	if b.err != nil && ctx.Err() != nil {
+		b.err = errors.Join(ErrBuildCancelled, b.err)
+	}
+	return b.err
+}
+
+// Cancel cancels the function's builds, running or queued. Returns how many were.
+func (q *BuildQueue) Cancel(function string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, b := range q.queues[function] {
+		b.cancel()
+	}
+	return len(q.queues[function])
+}
+
+// Builds returns the builds running and queued, oldest requested first.
+func (q *BuildQueue) Builds() []QueuedBuild {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var builds []QueuedBuild
+	for _, queue := range q.queues {
+		for _, b := range queue {
+			state := "queued"
+			if b.started {
+				state = "running"
+			}
+			builds = append(builds, QueuedBuild{Function: b.function, Kind: b.kind, State: state, Requested: b.requested})
+		}
+	}
+	slices.SortFunc(builds, func(a, b QueuedBuild) int { return a.Requested.Compare(b.Requested) })
+	return builds
+}
@@ -0,0 +1,85 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBuildQueue(t *testing.T) {
+	q := NewBuildQueue(1)
+	running := make(chan struct{})
+	release := make(chan struct{})
+	var builds atomic.Int32
+	build := func(ctx context.Context) error {
+		if builds.Add(1) == 1 {
+			close(running)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	// A rebuild runs, another one queues and two more join it
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs[0] = q.Build("func1", BuildRebuild, false, build)
+	}()
+	<-running
+	for i := 1; i < len(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = q.Build("func1", BuildRebuild, false, build)
+		}()
+		for len(q.Builds()) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// Until those joining have
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if builds.Load() != 2 {
+		t.Errorf("built %v times, want 2", builds.Load())
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("build %v = %v", i, err)
+		}
+	}
+}
+
+func TestBuildQueueSupersede(t *testing.T) {
+	q := NewBuildQueue(2)
+	running := make(chan struct{})
+	first := make(chan error)
+	go func() {
+		first <- q.Build("func1", BuildSources, true, func(ctx context.Context) error {
+			close(running)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-running
+
+	err := q.Build("func1", BuildSources, true, func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Errorf("superseding build = %v", err)
+	}
+	if err := <-first; !errors.Is(err, ErrBuildCancelled) {
+		t.Errorf("superseded build = %v, want %v", err, ErrBuildCancelled)
+	}
+	if builds := q.Builds(); len(builds) != 0 {
+		t.Errorf("builds left = %v", builds)
+	}
+}
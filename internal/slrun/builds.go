@@ -0,0 +1,120 @@
+package slrun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Builders of function images
+const (
+	BuilderBuildKit = "buildkit"
+	BuilderClassic  = "classic"
+)
+
+// Serializes lines of build output so concurrent builds don't interleave within a line
+var buildOutputMu sync.Mutex
+
+// prefixWriter writes whole lines to w, each prefixed with prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	line   []byte // Partial line not written yet
+}
+
+// buildOutput returns a writer of the function's build output, on stdout, prefixed with its name.
+func buildOutput(function *types.Function) *prefixWriter {
+	return &prefixWriter{w: os.Stdout, prefix: function.Name + " | "}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.line = append(p.line, b...)
+	i := bytes.LastIndexByte(p.line, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+
+	var out bytes.Buffer
+	for line := range bytes.Lines(p.line[:i+1]) {
+		out.WriteString(p.prefix)
+		out.Write(line)
+	}
+	p.line = p.line[i+1:]
+
+	buildOutputMu.Lock()
+	defer buildOutputMu.Unlock()
+	_, err := p.w.Write(out.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes the last line, even if it isn't terminated.
+func (p *prefixWriter) Flush() error {
+	if len(p.line) == 0 {
+		return nil
+	}
+	_, err := p.Write([]byte("\n"))
+	return err
+}
+
+// showBuildOutput writes the progress of a build from the daemon's stream of JSON messages
+// to out, or only if the build fails if quiet. Returns the build's error, if any. BuildKit
+// builds report their progress in aux messages, written as plain text.
+func showBuildOutput(stream io.Reader, out *prefixWriter, quiet bool, version build.BuilderVersion) error {
+	defer out.Flush()
+	var buffered bytes.Buffer
+	var w io.Writer = out
+	if quiet {
+		w = &buffered
+	}
+	var aux func(jsonmessage.JSONMessage)
+	if version == build.BuilderBuildKit {
+		aux = newBuildkitProgress(w).aux
+	}
+	err := jsonmessage.DisplayJSONMessagesStream(stream, w, 0, false, aux)
+	if err != nil && quiet {
+		out.Write(buffered.Bytes())
+	}
+	return err
+}
+
+// deployFunctionImages deploys the functions' images through queue, building up to
+// build_parallelism at a time. Once a deploy fails, the builds still queued are cancelled.
+// Returns the errors of the failed deploys.
+func deployFunctionImages(config *types.Config, events *Events, opa *OPAPolicies, queue *BuildQueue, functions []*types.Function, compression string) error {
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, function := range functions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := queue.Build(function.Name, BuildStartup, false, func(ctx context.Context) error {
+				mu.Lock()
+				failed := len(errs) > 0
+				mu.Unlock()
+				if failed {
+					return ErrBuildCancelled
+				}
+				return deployFunctionImage(ctx, config, events, opa, function, compression, false)
+			})
+			if err != nil && !errors.Is(err, ErrBuildCancelled) {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("function %v: %w", function.Name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,64 @@
+package slrun
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/build"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	p := &prefixWriter{w: &buf, prefix: "func1 | "}
+
+	for _, s := range []string{"Step 1/2", " : FROM alpine\nStep 2/2\n", "", "done"} {
+		p.Write([]byte(s))
+	}
+	if got, want := buf.String(), "func1 | Step 1/2 : FROM alpine\nfunc1 | Step 2/2\n"; got != want {
+		t.Errorf("prefixWriter wrote %q before flushing, want %q", got, want)
+	}
+
+	p.Flush()
+	if got, want := buf.String(), "func1 | Step 1/2 : FROM alpine\nfunc1 | Step 2/2\nfunc1 | done\n"; got != want {
+		t.Errorf("prefixWriter wrote %q, want %q", got, want)
+	}
+}
+
+func TestShowBuildOutput(t *testing.T) {
+	succeeded := `{"stream":"Step 1/2 : FROM alpine\n"}
+{"stream":"Successfully built 0123456789ab\n"}
+`
+	failed := `{"stream":"Step 1/2 : RUN false\n"}
+{"errorDetail":{"code":1,"message":"The command '/bin/sh -c false' returned a non-zero code: 1"},"error":"The command '/bin/sh -c false' returned a non-zero code: 1"}
+`
+
+	tests := []struct {
+		name    string
+		stream  string
+		quiet   bool
+		wantErr bool
+		want    string
+	}{
+		{"succeeded", succeeded, false, false, "func1 | Step 1/2 : FROM alpine\nfunc1 | Successfully built 0123456789ab\n"},
+		{"succeeded quietly", succeeded, true, false, ""},
+		{"failed", failed, false, true, "func1 | Step 1/2 : RUN false\n"},
+		{"failed quietly", failed, true, true, "func1 | Step 1/2 : RUN false\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := showBuildOutput(strings.NewReader(tt.stream), &prefixWriter{w: &buf, prefix: "func1 | "}, tt.quiet, build.BuilderV1)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("showBuildOutput() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && err != nil && !strings.Contains(err.Error(), "non-zero code: 1") {
+				t.Errorf("showBuildOutput() error = %v, want the build's error", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("showBuildOutput() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package slrun
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// ErrTestsFailed is returned when a function's tests fail in a newly built image.
+var ErrTestsFailed = errors.New("tests failed")
+
+// runFunctionTests runs the function's test command in a container of image, labelled as the instance's.
+// Returns the test output and whether the tests passed.
+// Secrets are passed to tests too, and redacted from their output.
+func runFunctionTests(instance string, function *types.Function, imageName string) (string, bool, error) {
+	secrets, secretValues, err := secretEnv(function)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := dockerCli.ContainerCreate(dockerCtx, &container.Config{
+		Image:  imageName,
+		Cmd:    []string{"sh", "-c", function.TestCommand},
+		Env:    append(containerEnv(function.Env), secrets...),
+		Labels: containerLabels(instance, function),
+	}, &container.HostConfig{Resources: functionResources(function)}, nil, nil, "")
+	if err != nil {
+		return "", false, err
+	}
+	defer dockerCli.ContainerRemove(dockerCtx, resp.ID, container.RemoveOptions{Force: true})
+
+	err = dockerCli.ContainerStart(dockerCtx, resp.ID, container.StartOptions{})
+	if err != nil {
+		return "", false, err
+	}
+
+	var exitCode int64
+	waitC, errC := dockerCli.ContainerWait(dockerCtx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errC:
+		return "", false, err
+	case result := <-waitC:
+		exitCode = result.StatusCode
+	}
+
+	out, err := dockerCli.ContainerLogs(dockerCtx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", false, err
+	}
+	defer out.Close()
+
+	var buf bytes.Buffer
+	_, err = stdcopy.StdCopy(&buf, &buf, out)
+	if err != nil {
+		return "", false, err
+	}
+
+	return redactSecrets(buf.String(), secretValues), exitCode == 0, nil
+}
+
+// testCandidateImage runs the function's tests in candidate, removing it if they fail.
+func testCandidateImage(config *types.Config, function *types.Function, candidate string) error {
+	fmt.Printf("Testing function image: %v => %v\n", function.Name, function.TestCommand)
+	output, passed, err := runFunctionTests(instanceID(config.StateDir), function, candidate)
+	if err != nil || !passed {
+		removeCandidateImage(candidate)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot test function %v: %w", function.Name, err)
+	}
+	if !passed {
+		out := buildOutput(function)
+		io.WriteString(out, output)
+		out.Flush()
+		return fmt.Errorf("function %v %w, keeping current image", function.Name, ErrTestsFailed)
+	}
+	fmt.Printf("Function %v tests passed\n", function.Name)
+	return nil
+}
@@ -0,0 +1,128 @@
+package slrun
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func validateBulkheads(config *types.Config) error {
+	err := validateBulkhead("bulkhead", config.Bulkhead)
+	if err != nil {
+		return err
+	}
+	for _, f := range config.Functions {
+		err := validateBulkhead(functionField(config, f, "bulkhead"), f.Bulkhead)
+		if err != nil {
+			return err
+		}
+		if f.Bulkhead == nil {
+			f.Bulkhead = config.Bulkhead
+		}
+	}
+	return nil
+}
+
+func validateBulkhead(field string, bulkhead *types.Bulkhead) error {
+	if bulkhead == nil {
+		return nil
+	}
+	if bulkhead.MaxConcurrent == 0 {
+		bulkhead.MaxConcurrent = 100
+	}
+	if bulkhead.MaxConcurrent < 0 {
+		return fieldError(field+".max_concurrent", "invalid bulkhead max concurrent: %d", bulkhead.MaxConcurrent)
+	}
+	if bulkhead.MaxQueued < 0 {
+		return fieldError(field+".max_queued", "invalid bulkhead max queued: %d", bulkhead.MaxQueued)
+	}
+	if bulkhead.QueueTimeout == "" {
+		bulkhead.QueueTimeout = "1s"
+	}
+	if _, err := time.ParseDuration(bulkhead.QueueTimeout); err != nil {
+		return fieldError(field+".queue_timeout", "invalid bulkhead queue timeout: %w", err)
+	}
+	return nil
+}
+
+// bulkhead caps the calls in flight to a function on this node, with its own pool of
+// connections to the function's containers, so a function saturating its bulkhead is
+// rejected rather than holding the gateway's goroutines and connections.
+type bulkhead struct {
+	config       types.Bulkhead
+	slots        chan struct{} // Holds a value per call in flight
+	queue        chan struct{} // Holds a value per call waiting for a slot
+	queueTimeout time.Duration
+	client       *http.Client // Proxies the function's calls
+}
+
+func newBulkhead(config *types.Bulkhead) *bulkhead {
+	queueTimeout, _ := time.ParseDuration(config.QueueTimeout)
+	client := newProxyClient()
+	transport := client.Transport.(*http.Transport)
+	transport.MaxConnsPerHost = config.MaxConcurrent
+	transport.MaxIdleConnsPerHost = config.MaxConcurrent
+	return &bulkhead{
+		config:       *config,
+		slots:        make(chan struct{}, config.MaxConcurrent),
+		queue:        make(chan struct{}, config.MaxQueued),
+		queueTimeout: queueTimeout,
+		client:       client,
+	}
+}
+
+// acquire takes a slot for a call, waiting up to the queue timeout for one if the queue
+// isn't full. The returned function must be called when the call completes.
+func (b *bulkhead) acquire(ctx context.Context, function string) (func(), error) {
+	release := func() { <-b.slots }
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	select {
+	case b.queue <- struct{}{}:
+		defer func() { <-b.queue }()
+	default:
+		err := fmt.Errorf("function %v has %v calls in flight and %v waiting", function, cap(b.slots), cap(b.queue))
+		return nil, invocationError(ErrClassSaturated, http.StatusServiceUnavailable, err)
+	}
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		err := fmt.Errorf("function %v has had %v calls in flight for %v", function, cap(b.slots), b.queueTimeout)
+		return nil, invocationError(ErrClassSaturated, http.StatusServiceUnavailable, err)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// bulkhead returns the bulkhead of a function or instance, nil if it has none. Instances
+// share their function's.
+func (r *Runtime) bulkhead(function *types.Function) *bulkhead {
+	if function.Bulkhead == nil {
+		return nil
+	}
+	name := cmp.Or(function.Base, function.Name)
+	if b, ok := r.bulkheads.Load(name); ok && b.(*bulkhead).config == *function.Bulkhead {
+		return b.(*bulkhead)
+	}
+
+	// New, or its config was reloaded: calls holding the previous one release it as they complete
+	r.bulkheadsMu.Lock()
+	defer r.bulkheadsMu.Unlock()
+	if b, ok := r.bulkheads.Load(name); ok && b.(*bulkhead).config == *function.Bulkhead {
+		return b.(*bulkhead)
+	}
+	b := newBulkhead(function.Bulkhead)
+	r.bulkheads.Store(name, b)
+	return b
+}
@@ -0,0 +1,74 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestBulkhead(t *testing.T) {
+	config := &types.Bulkhead{MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: "50ms"}
+	b := newBulkhead(config)
+	ctx := context.Background()
+
+	release, err := b.acquire(ctx, "func1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The queued call gets the slot once it is released
+	acquired := make(chan error)
+	go func() {
+		release, err := b.acquire(ctx, "func1")
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	for len(b.queue) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the queue full, calls are rejected right away
+	var ierr *InvocationError
+	if _, err := b.acquire(ctx, "func1"); !errors.As(err, &ierr) || ierr.Class != ErrClassSaturated {
+		t.Errorf("acquire() with a full queue = %v, want %v", err, ErrClassSaturated)
+	}
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("queued acquire() = %v, want a slot", err)
+	}
+
+	// Queued calls time out if no slot frees up
+	release, err = b.acquire(ctx, "func1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err := b.acquire(ctx, "func1"); !errors.As(err, &ierr) || ierr.Class != ErrClassSaturated {
+		t.Errorf("acquire() past the queue timeout = %v, want %v", err, ErrClassSaturated)
+	}
+}
+
+func TestValidateBulkheads(t *testing.T) {
+	shared := &types.Function{Name: "func1"}
+	own := &types.Function{Name: "func2", Bulkhead: &types.Bulkhead{MaxConcurrent: 5}}
+	config := &types.Config{Functions: []*types.Function{shared, own}, Bulkhead: &types.Bulkhead{}}
+	if err := validateBulkheads(config); err != nil {
+		t.Fatal(err)
+	}
+	if shared.Bulkhead != config.Bulkhead || shared.Bulkhead.MaxConcurrent != 100 || shared.Bulkhead.QueueTimeout != "1s" {
+		t.Errorf("func1 bulkhead = %+v, want the config's defaults", shared.Bulkhead)
+	}
+	if own.Bulkhead.MaxConcurrent != 5 {
+		t.Errorf("func2 bulkhead = %+v, want its own", own.Bulkhead)
+	}
+
+	config.Bulkhead = &types.Bulkhead{QueueTimeout: "soon"}
+	if err := validateBulkheads(config); err == nil {
+		t.Errorf("validateBulkheads() accepted an invalid queue timeout")
+	}
+}
@@ -0,0 +1,242 @@
+package slrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Files in a bundle
+const (
+	bundleManifest = "manifest.json"
+	bundleConfig   = "config.json"
+	bundleBinary   = "slrun"
+	bundleImages   = "images.tar"
+)
+
+// BundleManifest describes what a bundle contains.
+type BundleManifest struct {
+	Version   string            `json:"version"` // slrun version that created the bundle
+	Created   time.Time         `json:"created"`
+	Functions map[string]string `json:"functions"` // Image by function
+}
+
+func addBundleFile(tw *tar.Writer, name string, mode int64, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+func addBundleBytes(tw *tar.Writer, name string, mode int64, b []byte) error {
+	return addBundleFile(tw, name, mode, int64(len(b)), bytes.NewReader(b))
+}
+
+func addBundleFileFrom(tw *tar.Writer, name string, mode int64, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return addBundleFile(tw, name, mode, info.Size(), f)
+}
+
+// bundleConfigFile returns the config file at cfgFile, as JSON, with each function
+// running its bundled image instead of building. Base images were checked when bundling,
+// the bundled images would fail the checks themselves.
+func bundleConfigFile(cfgFile string, images map[string]string) ([]byte, error) {
+	raw, err := readConfigJSON(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	err = json.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	functions, _ := doc["functions"].([]any)
+	for _, f := range functions {
+		function, ok := f.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := function["name"].(string)
+		function["image"] = images[name]
+		delete(function, "build_dir")
+		delete(function, "test_command")
+	}
+	delete(doc, "base_images")
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// CreateBundle writes a gzipped tar of everything needed to run the config's functions
+// on a machine without network access: their images, the config and the slrun binary.
+// Functions are built first. binary is the slrun binary included, this one if empty.
+func CreateBundle(config *types.Config, cfgFile string, binary string, w io.Writer) (*BundleManifest, error) {
+	manifest := &BundleManifest{
+		Version:   Version,
+		Created:   time.Now(),
+		Functions: make(map[string]string),
+	}
+
+	compression := buildContextCompression(config.BuildCompression)
+	var refs []string
+	for _, f := range config.Functions {
+		if f.Process != nil {
+			return nil, fmt.Errorf("function %v runs as a process, only containers can be bundled", f.Name)
+		}
+		err := checkBaseImageNames(config.BaseImages, f)
+		if err == nil {
+			err = prepareFunctionImage(dockerCtx, config, f, compression, false, func(string) error {
+				return checkBaseImageAges(config.BaseImages, f)
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("function %v: %w", f.Name, err)
+		}
+		manifest.Functions[f.Name] = f.ImageName
+		if !slices.Contains(refs, f.ImageName) {
+			refs = append(refs, f.ImageName)
+		}
+	}
+
+	if binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		binary = exe
+	}
+	cfg, err := bundleConfigFile(cfgFile, manifest.Functions)
+	if err != nil {
+		return nil, err
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// The tar header needs the size of the saved images
+	images, err := os.CreateTemp("", "slrun-bundle-images-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(images.Name())
+	defer images.Close()
+	saved, err := dockerCli.ImageSave(dockerCtx, refs)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(images, saved)
+	saved.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err = addBundleBytes(tw, bundleManifest, 0644, manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = addBundleBytes(tw, bundleConfig, 0644, cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = addBundleFileFrom(tw, bundleBinary, 0755, binary)
+	if err != nil {
+		return nil, err
+	}
+	err = addBundleFileFrom(tw, bundleImages, 0644, images.Name())
+	if err != nil {
+		return nil, err
+	}
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return manifest, gw.Close()
+}
+
+// InstallBundle loads a bundle's images and writes its config and slrun binary into dir.
+func InstallBundle(r io.Reader, dir string) (*BundleManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *BundleManifest
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Name {
+		case bundleManifest:
+			manifest = &BundleManifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+		case bundleConfig, bundleBinary:
+			err = writeBundleFile(filepath.Join(dir, header.Name), os.FileMode(header.Mode), tr)
+		case bundleImages:
+			err = ImportImages(tr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bundle %v: %w", header.Name, err)
+		}
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("not a slrun bundle, it has no %v", bundleManifest)
+	}
+	return manifest, nil
+}
+
+func writeBundleFile(path string, mode os.FileMode, r io.Reader) error {
+	tmp := path + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Replaced by rename, so a running slrun binary can be updated
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,140 @@
+package slrun
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Label of cache volumes, set to the cache they hold, e.g. npm
+const labelCache = "slrun.cache"
+
+// Build arg naming the function's BuildKit cache mounts, for Dockerfiles to use as their id
+const cacheIDArg = "SLRUN_CACHE_ID"
+
+// Dir language caches are mounted under in containers
+const containerCacheDir = "/slrun/cache"
+
+// Env pointing each language's tools at its cache, mounted at containerCacheDir/<language>
+var cacheEnvs = map[string]map[string]string{
+	"go":  {"GOMODCACHE": containerCacheDir + "/go/mod", "GOCACHE": containerCacheDir + "/go/build"},
+	"npm": {"npm_config_cache": containerCacheDir + "/npm"},
+	"pip": {"PIP_CACHE_DIR": containerCacheDir + "/pip"},
+}
+
+// Characters of cache paths left out of their volume's name
+var cacheNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+func validateCaches(config *types.Config) error {
+	for _, f := range config.Functions {
+		for i, c := range f.Caches {
+			field := fmt.Sprintf("%s[%d]", functionField(config, f, "caches"), i)
+			if _, ok := cacheEnvs[c]; !ok && !path.IsAbs(c) {
+				return fieldError(field, "function %s cache %q must be one of go, npm or pip, or an absolute path", f.Name, c)
+			}
+			if slices.Index(f.Caches, c) != i {
+				return fieldError(field, "function %s has cache %q twice", f.Name, c)
+			}
+		}
+	}
+	return nil
+}
+
+// cacheVolumeName returns the name of the instance's volume holding the function's cache, e.g.
+// slrun-3f2a1b9c7d4e-cache-func1-npm. Replicas and tenant instances share their function's.
+func cacheVolumeName(instance string, function *types.Function, cache string) string {
+	name := strings.Trim(cacheNameUnsafe.ReplaceAllString(cache, "-"), "-")
+	return fmt.Sprintf("slrun-%s-cache-%s-%s", instance, cmp.Or(function.Base, function.Name), name)
+}
+
+// cacheTarget returns where the cache is mounted in the function's containers.
+func cacheTarget(cache string) string {
+	if _, ok := cacheEnvs[cache]; ok {
+		return containerCacheDir + "/" + cache
+	}
+	return cache
+}
+
+// cacheMounts returns the mounts of the function's cache volumes, created as its container
+// is, and the env pointing tools at them. Caches are only mounted in dev mode.
+func (r *Runtime) cacheMounts(function *types.Function) ([]mount.Mount, []string) {
+	if !r.dev {
+		return nil, nil
+	}
+	var mounts []mount.Mount
+	var env []string
+	for _, c := range function.Caches {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: cacheVolumeName(r.instance, function, c),
+			Target: cacheTarget(c),
+			VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{
+				labelInstance: r.instance,
+				labelFunction: cmp.Or(function.Base, function.Name),
+				labelCache:    c,
+			}},
+		})
+		for _, name := range sortedKeys(cacheEnvs[c]) {
+			env = append(env, name+"="+cacheEnvs[c][name])
+		}
+	}
+	return mounts, env
+}
+
+// PrunedCaches lists what PruneCaches removed.
+type PrunedCaches struct {
+	Volumes    []string
+	BuildBytes uint64 // Reclaimed from BuildKit cache mounts
+}
+
+// PruneCaches removes the cache volumes of the config's slrun instance, those of functions
+// only if any are named. Volumes mounted by running containers are kept. Without functions,
+// BuildKit's cache mounts are removed too, those of every build on the daemon.
+func PruneCaches(config *types.Config, functions []string) (*PrunedCaches, error) {
+	pruned := &PrunedCaches{}
+	args := filters.NewArgs(instanceFilter(instanceID(config.StateDir)), filters.Arg("label", labelCache))
+	volumes, err := dockerCli.VolumeList(dockerCtx, volume.ListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range volumes.Volumes {
+		if len(functions) > 0 && !slices.Contains(functions, v.Labels[labelFunction]) {
+			continue
+		}
+		err := dockerCli.VolumeRemove(dockerCtx, v.Name, false)
+		if err != nil {
+			log.Printf("Cannot remove cache volume %v: %v\n", v.Name, err)
+			continue
+		}
+		pruned.Volumes = append(pruned.Volumes, v.Name)
+	}
+
+	if len(functions) > 0 {
+		return pruned, nil
+	}
+	report, err := dockerCli.BuildCachePrune(dockerCtx, build.CachePruneOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("type", "exec.cachemount")),
+	})
+	if errors.Is(err, errors.ErrUnsupported) {
+		// containerd's builder can't prune cache mounts alone
+		log.Printf("Cannot prune build cache mounts: %v\n", err)
+		return pruned, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pruned.BuildBytes = report.SpaceReclaimed
+	return pruned, nil
+}
@@ -0,0 +1,49 @@
+package slrun
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestCacheMounts(t *testing.T) {
+	fun := &types.Function{Name: "func1#2", Base: "func1", Caches: []string{"go", "/root/.m2"}}
+	r := &Runtime{instance: "3f2a1b9c7d4e"}
+	if mounts, env := r.cacheMounts(fun); mounts != nil || env != nil {
+		t.Errorf("cacheMounts() outside dev mode = %v, %v, want none", mounts, env)
+	}
+
+	r.dev = true
+	mounts, env := r.cacheMounts(fun)
+	var got []string
+	for _, m := range mounts {
+		got = append(got, m.Source+":"+m.Target)
+	}
+	want := []string{
+		"slrun-3f2a1b9c7d4e-cache-func1-go:/slrun/cache/go",
+		"slrun-3f2a1b9c7d4e-cache-func1-root-.m2:/root/.m2",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("cacheMounts() = %v, want %v", got, want)
+	}
+	if want := []string{"GOCACHE=/slrun/cache/go/build", "GOMODCACHE=/slrun/cache/go/mod"}; !slices.Equal(env, want) {
+		t.Errorf("cacheMounts() env = %v, want %v", env, want)
+	}
+	if mounts[0].VolumeOptions.Labels[labelFunction] != "func1" {
+		t.Errorf("cache volume labels = %v, want function func1", mounts[0].VolumeOptions.Labels)
+	}
+}
+
+func TestValidateCaches(t *testing.T) {
+	for _, caches := range [][]string{{"cargo"}, {"root/.cache"}, {"npm", "npm"}} {
+		config := &types.Config{Functions: []*types.Function{{Name: "func1", Caches: caches}}}
+		if err := validateCaches(config); err == nil {
+			t.Errorf("validateCaches(%q) = nil, want an error", caches)
+		}
+	}
+	config := &types.Config{Functions: []*types.Function{{Name: "func1", Caches: []string{"go", "npm", "pip", "/root/.m2"}}}}
+	if err := validateCaches(config); err != nil {
+		t.Errorf("validateCaches() = %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package slrun
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Capabilities describe what the running slrun supports and has enabled,
+// so tooling can adapt to it.
+type Capabilities struct {
+	Version     string            `json:"version"`
+	APIVersions []int             `json:"api_versions"` // Admin API versions served
+	Features    []string          `json:"features"`     // Features enabled in this daemon
+	Backends    map[string]string `json:"backends"`     // Backend in use by kind, e.g. container: docker
+	Middleware  []string          `json:"middleware"`   // Available listener middleware
+	Transforms  []string          `json:"transforms"`   // Available request body transforms
+	Notifiers   []string          `json:"notifiers"`    // Available notifier types
+	Encodings   []string          `json:"encodings"`    // Admin API response encodings
+}
+
+func (a *Admin) capabilities() Capabilities {
+	config := a.gateway.config
+
+	features := []string{"events", "captures", "usage", "deployment_history"}
+	enabled := map[string]bool{
+		"dev":           config.Dev,
+		"quotas":        config.Quotas != nil,
+		"usage_export":  config.UsageExport.Interval != "",
+		"redirects":     len(config.Redirects) > 0,
+		"rewrites":      len(config.Rewrites) > 0,
+		"fallback":      config.Fallback != "",
+		"notifications": config.Notifications != nil,
+		"cluster":       config.Cluster != nil,
+		"async":         config.Async != nil,
+		"chaos":         config.Chaos != nil,
+		"quarantine":    config.Quarantine != nil,
+		"watch":         config.Watch,
+		"opa":           config.OPA != nil,
+		"base_images":   config.BaseImages != nil,
+		"rebuilds":      config.Rebuilds != nil,
+		"fleet":         config.Fleet != nil,
+		"peers":         len(config.Peers) > 0,
+		"relay":         config.Relay != nil,
+	}
+	for _, l := range config.Listeners {
+		enabled["tls"] = enabled["tls"] || l.TLSCert != ""
+		enabled["fallback"] = enabled["fallback"] || l.Fallback != ""
+	}
+	for _, f := range config.Functions {
+		enabled["tenancy"] = enabled["tenancy"] || f.Tenancy != nil
+		enabled["scale_profiles"] = enabled["scale_profiles"] || len(f.ScaleProfiles) > 0
+		enabled["build_tests"] = enabled["build_tests"] || f.TestCommand != ""
+		enabled["transforms"] = enabled["transforms"] || f.Transform != ""
+		enabled["scaling"] = enabled["scaling"] || f.Scaling != nil
+		enabled["slos"] = enabled["slos"] || f.SLO != nil
+		enabled["handshake"] = enabled["handshake"] || f.Handshake
+		enabled["coalesce"] = enabled["coalesce"] || f.Coalesce != nil
+		enabled["resource_limits"] = enabled["resource_limits"] || f.CPU > 0 || f.Memory != "" || f.PidsLimit > 0
+	}
+	for feature, on := range enabled {
+		if on {
+			features = append(features, feature)
+		}
+	}
+	slices.Sort(features)
+
+	return Capabilities{
+		Version:     Version,
+		APIVersions: []int{APIVersion},
+		Features:    features,
+		Backends: map[string]string{
+			"container":    "docker",
+			"policy":       string(config.Policy),
+			"usage_export": config.UsageExport.Format,
+		},
+		Middleware: slices.Sorted(maps.Keys(middlewares)),
+		Transforms: []string{TransformFormToJSON},
+		Notifiers:  []string{NotifierSlack, NotifierDiscord, NotifierWebhook, NotifierEmail},
+		Encodings:  slices.Sorted(maps.Keys(encodings)),
+	}
+}
+
+func (a *Admin) getCapabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.capabilities())
+}
@@ -0,0 +1,357 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Value recorded in place of redacted headers and query parameters
+const redactedValue = "REDACTED"
+
+// Headers always redacted from captures
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Query parameters always redacted from captures, matched case-insensitively
+var defaultRedactQuery = []string{"token", "access_token", "api_key", "apikey", "key", "password", "secret", "signature"}
+
+// HAR 1.2 types, only the fields slrun records.
+// See http://www.softwareishard.com/blog/har-12-spec/
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // ms
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// cappedBuffer counts all bytes written to it but only keeps the first max bytes.
+type cappedBuffer struct {
+	bytes.Buffer
+	max  int
+	size int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.size += len(p)
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// captureRecorder records the status and body written to a response.
+type captureRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *cappedBuffer
+}
+
+func (r *captureRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *captureRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *captureRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Captures records gateway traffic of selected functions into per-function ring buffers.
+type Captures struct {
+	config      types.Capture
+	redact      []string
+	redactQuery []string // Lowercase
+	maxBody     int      // Bytes of bodies kept, 0 if bodies aren't captured
+	mu          sync.Mutex
+	entries     map[string][]*harEntry // Function name to captured entries, absent if not capturing
+}
+
+func NewCaptures(config types.Capture) *Captures {
+	redact := slices.Clone(defaultRedactHeaders)
+	for _, h := range config.RedactHeaders {
+		redact = append(redact, http.CanonicalHeaderKey(h))
+	}
+
+	redactQuery := slices.Clone(defaultRedactQuery)
+	for _, q := range config.RedactQuery {
+		redactQuery = append(redactQuery, strings.ToLower(q))
+	}
+
+	maxBody := config.MaxBodyBytes
+	if config.Bodies != nil && !*config.Bodies {
+		maxBody = 0
+	}
+
+	return &Captures{
+		config:      config,
+		redact:      redact,
+		redactQuery: redactQuery,
+		maxBody:     maxBody,
+		entries:     make(map[string][]*harEntry),
+	}
+}
+
+// Start starts capturing traffic of a function.
+func (c *Captures) Start(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, capturing := c.entries[name]; !capturing {
+		c.entries[name] = []*harEntry{}
+		log.Printf("Capture: started capturing function %v\n", name)
+	}
+}
+
+// Stop stops capturing traffic of a function and writes the captured entries to a HAR file,
+// if a capture directory is configured. Returns the written file path. Captures hold
+// whatever clients and functions exchanged, so only slrun's user can read them.
+func (c *Captures) Stop(name string) (string, error) {
+	c.mu.Lock()
+	entries, capturing := c.entries[name]
+	delete(c.entries, name)
+	c.mu.Unlock()
+
+	if !capturing {
+		return "", nil
+	}
+	log.Printf("Capture: stopped capturing function %v\n", name)
+
+	if c.config.Dir == "" {
+		return "", nil
+	}
+
+	err := os.MkdirAll(c.config.Dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(c.config.Dir, fmt.Sprintf("%v-%v.har", name, time.Now().Format("20060102-150405")))
+	bytes, err := json.MarshalIndent(newHAR(entries), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return file, os.WriteFile(file, bytes, 0600)
+}
+
+// Capturing reports whether traffic of a function is being captured.
+func (c *Captures) Capturing(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, capturing := c.entries[name]
+	return capturing
+}
+
+// HAR returns the entries captured so far for a function.
+func (c *Captures) HAR(name string) (*har, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, capturing := c.entries[name]
+	if !capturing {
+		return nil, false
+	}
+	return newHAR(slices.Clone(entries)), true
+}
+
+func newHAR(entries []*harEntry) *har {
+	return &har{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "slrun", Version: "1"},
+		Entries: entries,
+	}}
+}
+
+// Wrap serves a request of function name with next, capturing the exchange if enabled.
+func (c *Captures) Wrap(name string, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !c.Capturing(name) {
+		next(w, r)
+		return
+	}
+
+	// Keep a copy of the request body while passing it on
+	reqBody := &cappedBuffer{max: c.maxBody}
+	if r.Body != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+
+	rec := &captureRecorder{ResponseWriter: w, status: http.StatusOK, body: &cappedBuffer{max: c.maxBody}}
+	start := time.Now()
+	next(rec, r)
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	query := c.query(r.URL.Query())
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		uri += "?" + query.Encode()
+	}
+	entry := &harEntry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request: harRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + uri,
+			HTTPVersion: r.Proto,
+			Headers:     c.headers(r.Header),
+			QueryString: harValues(query),
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    reqBody.size,
+		},
+		Response: harResponse{
+			Status:      rec.status,
+			StatusText:  http.StatusText(rec.status),
+			HTTPVersion: r.Proto,
+			Headers:     c.headers(w.Header()),
+			Cookies:     []harNameValue{},
+			Content: harContent{
+				Size:     rec.body.size,
+				MimeType: w.Header().Get("Content-Type"),
+				Text:     rec.body.String(),
+			},
+			RedirectURL: w.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    rec.body.size,
+		},
+		Timings: harTimings{Send: 0, Wait: elapsed, Receive: 0},
+	}
+	if reqBody.size > 0 && c.maxBody > 0 {
+		entry.Request.PostData = &harPostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Text:     reqBody.String(),
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, capturing := c.entries[name]
+	if !capturing {
+		return // Stopped while serving
+	}
+	entries = append(entries, entry)
+	if len(entries) > c.config.MaxEntries {
+		entries = entries[len(entries)-c.config.MaxEntries:]
+	}
+	c.entries[name] = entries
+}
+
+// headers converts h to HAR headers, redacting sensitive values.
+func (c *Captures) headers(h http.Header) []harNameValue {
+	values := []harNameValue{}
+	for name, vals := range h {
+		for _, v := range vals {
+			if slices.Contains(c.redact, name) {
+				v = redactedValue
+			}
+			values = append(values, harNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
+
+// query returns a copy of q with sensitive values redacted.
+func (c *Captures) query(q url.Values) url.Values {
+	redacted := make(url.Values, len(q))
+	for name, vals := range q {
+		vals = slices.Clone(vals)
+		if slices.Contains(c.redactQuery, strings.ToLower(name)) {
+			for i := range vals {
+				vals[i] = redactedValue
+			}
+		}
+		redacted[name] = vals
+	}
+	return redacted
+}
+
+func harValues(vals map[string][]string) []harNameValue {
+	values := []harNameValue{}
+	for name, vs := range vals {
+		for _, v := range vs {
+			values = append(values, harNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
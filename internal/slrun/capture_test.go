@@ -0,0 +1,73 @@
+package slrun
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestCaptures(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write(body)
+	}
+	capture := func(c *Captures) *harEntry {
+		c.Start("func1")
+		r := httptest.NewRequest("POST", "/func1?token=secret&page=2", strings.NewReader(`{"password":"secret"}`))
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("X-Api-Key", "secret")
+		c.Wrap("func1", httptest.NewRecorder(), r, echo)
+		har, _ := c.HAR("func1")
+		return har.Log.Entries[0]
+	}
+
+	// Credentials in headers and the query string are redacted
+	dir := filepath.Join(t.TempDir(), "captures")
+	c := NewCaptures(types.Capture{Dir: dir, MaxEntries: 10, MaxBodyBytes: 1024, RedactHeaders: []string{"x-api-key"}})
+	entry := capture(c)
+	encoded, _ := json.Marshal(entry.Request.Headers)
+	encodedResponse, _ := json.Marshal(entry.Response.Headers)
+	if strings.Contains(string(encoded)+string(encodedResponse)+entry.Request.URL, "secret") {
+		t.Errorf("captured headers %s %s and URL %v, want credentials redacted", encoded, encodedResponse, entry.Request.URL)
+	}
+	if !strings.Contains(entry.Request.URL, "page=2") {
+		t.Errorf("captured URL %v, want other parameters kept", entry.Request.URL)
+	}
+	if entry.Request.PostData == nil || entry.Response.Content.Text != `{"password":"secret"}` {
+		t.Errorf("captured bodies %+v %q, want them captured by default", entry.Request.PostData, entry.Response.Content.Text)
+	}
+
+	// HAR files are only readable by slrun's user
+	file, err := c.Stop("func1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{dir, file} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			t.Errorf("%v mode = %v, want only its owner to access it", path, info.Mode().Perm())
+		}
+	}
+
+	// Without bodies, only their sizes are captured
+	bodies := false
+	c = NewCaptures(types.Capture{MaxEntries: 10, MaxBodyBytes: 1024, Bodies: &bodies})
+	entry = capture(c)
+	if entry.Request.PostData != nil || entry.Response.Content.Text != "" {
+		t.Errorf("captured bodies %+v %q, want none", entry.Request.PostData, entry.Response.Content.Text)
+	}
+	if entry.Request.BodySize != 21 || entry.Response.Content.Size != 21 {
+		t.Errorf("captured body sizes %v %v, want 21", entry.Request.BodySize, entry.Response.Content.Size)
+	}
+}
@@ -0,0 +1,327 @@
+package slrun
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Marks invocations sent by chaos mode to check a killed function serves again
+const chaosProbeHeader = "X-Slrun-Chaos-Probe"
+
+func validateChaos(config *types.Config) error {
+	chaos := config.Chaos
+	if chaos == nil {
+		return nil
+	}
+	if chaos.Interval == "" {
+		chaos.Interval = "1m"
+	}
+	if chaos.RecoveryTimeout == "" {
+		chaos.RecoveryTimeout = "60s"
+	}
+	for _, d := range []string{chaos.Interval, chaos.RecoveryTimeout} {
+		if _, err := time.ParseDuration(d); err != nil {
+			return fieldError("chaos", "invalid chaos duration: %w", err)
+		}
+	}
+	if chaos.DockerFailureRate < 0 || chaos.DockerFailureRate > 1 {
+		return fieldError("chaos.docker_failure_rate", "chaos docker failure rate must be between 0 and 1")
+	}
+	for _, name := range chaos.Functions {
+		if !hasFunction(config, name) {
+			return fieldError("chaos.functions", "chaos targets unknown function: %s", name)
+		}
+	}
+	if chaos.Report == "" {
+		chaos.Report = filepath.Join(config.StateDir, "chaos-report.json")
+	}
+	return nil
+}
+
+// chaosTransport fails a share of Docker API calls once active.
+type chaosTransport struct {
+	next   http.RoundTripper
+	rate   float64
+	active atomic.Bool
+
+	mu       sync.Mutex
+	injected map[string]int // Failures injected by API path, e.g. /containers/{id}/start
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.active.Load() || rand.Float64() >= t.rate {
+		return t.next.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	path := chaosAPIPath(req.URL.Path)
+	t.mu.Lock()
+	t.injected[req.Method+" "+path]++
+	t.mu.Unlock()
+	log.Printf("Chaos: failing Docker API call %v %v\n", req.Method, path)
+	return nil, fmt.Errorf("chaos: injected Docker API failure")
+}
+
+// chaosAPIPath returns a Docker API path without its version and IDs, to count failures by call.
+func chaosAPIPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "v1.") {
+		parts = parts[1:]
+	}
+	if len(parts) > 2 {
+		parts[1] = "{id}"
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// withChaos returns a Docker client like cli whose calls fail at the chaos transport's rate.
+func withChaos(cli *client.Client, transport *chaosTransport) (*client.Client, error) {
+	httpClient := cli.HTTPClient()
+	transport.next = httpClient.Transport
+	httpClient.Transport = transport
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation(), client.WithHost(cli.DaemonHost()), client.WithHTTPClient(httpClient))
+}
+
+// ChaosKill is a function container killed by chaos mode, and whether the function recovered.
+type ChaosKill struct {
+	Function    string    `json:"function"`
+	ContainerID string    `json:"container_id"`
+	At          time.Time `json:"at"`
+	Recovered   bool      `json:"recovered"`
+	RecoveredMs int64     `json:"recovered_ms,omitempty"` // Time until it served again
+	Error       string    `json:"error,omitempty"`        // Last error serving it, if not recovered
+}
+
+// ChaosReport is what chaos mode did and the recovery gaps it found.
+type ChaosReport struct {
+	Started  time.Time      `json:"started"`
+	Stopped  time.Time      `json:"stopped"`
+	Kills    []*ChaosKill   `json:"kills"`
+	Injected map[string]int `json:"injected_docker_failures"` // By API call
+	Gaps     []string       `json:"gaps"`                     // Functions that failed to recover, and why
+}
+
+// Chaos kills function containers on a schedule and fails Docker API calls,
+// checking that killed functions serve again within the recovery timeout.
+type Chaos struct {
+	config          *types.Chaos
+	runtime         *Runtime
+	interval        time.Duration
+	recoveryTimeout time.Duration
+	stop            chan struct{}
+	wg              sync.WaitGroup
+
+	mu     sync.Mutex
+	report ChaosReport
+}
+
+func NewChaos(config *types.Chaos, runtime *Runtime) *Chaos {
+	interval, _ := time.ParseDuration(config.Interval)
+	recoveryTimeout, _ := time.ParseDuration(config.RecoveryTimeout)
+	return &Chaos{
+		config:          config,
+		runtime:         runtime,
+		interval:        interval,
+		recoveryTimeout: recoveryTimeout,
+		stop:            make(chan struct{}),
+	}
+}
+
+// targets returns the running containers chaos may kill.
+func (c *Chaos) targets() []*types.Function {
+	functions := slices.Clone(c.runtime.functions)
+	c.runtime.mu.Lock()
+	for _, instance := range c.runtime.instances {
+		functions = append(functions, instance)
+	}
+	c.runtime.mu.Unlock()
+
+	var targets []*types.Function
+	for _, f := range functions {
+		name := cmp.Or(f.Base, f.Name)
+		// Processes have no container to kill
+		if f.IsRunning && !f.Remote && f.Process == nil && (len(c.config.Functions) == 0 || slices.Contains(c.config.Functions, name)) {
+			targets = append(targets, f)
+		}
+	}
+	return targets
+}
+
+// kill kills a random target's container, then checks the function recovers.
+func (c *Chaos) kill() {
+	targets := c.targets()
+	if len(targets) == 0 {
+		return
+	}
+	f := targets[rand.IntN(len(targets))]
+
+	// Killed with the global client rather than the runtime's, so the kill itself isn't failed
+	kill := &ChaosKill{Function: f.Name, ContainerID: f.ContainerId, At: time.Now()}
+	err := dockerCli.ContainerKill(dockerCtx, f.ContainerId, "SIGKILL")
+	if err != nil {
+		log.Printf("Chaos: cannot kill function %v container: %v\n", f.Name, err)
+		return
+	}
+	log.Printf("Chaos: killed function %v container %v\n", f.Name, f.ContainerId)
+
+	c.mu.Lock()
+	c.report.Kills = append(c.report.Kills, kill)
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.checkRecovery(f, kill)
+	}()
+}
+
+// checkRecovery invokes a killed function until it serves again, up to the recovery timeout.
+func (c *Chaos) checkRecovery(f *types.Function, kill *ChaosKill) {
+	deadline := time.Now().Add(c.recoveryTimeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		lastErr = c.probe(f)
+		if lastErr == nil {
+			c.mu.Lock()
+			kill.Recovered = true
+			kill.RecoveredMs = time.Since(kill.At).Milliseconds()
+			c.mu.Unlock()
+			log.Printf("Chaos: function %v recovered after %v ms\n", f.Name, kill.RecoveredMs)
+			return
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-c.stop:
+			lastErr = errors.New("chaos mode stopped before it recovered")
+			deadline = time.Now()
+		}
+	}
+
+	c.mu.Lock()
+	kill.Error = lastErr.Error()
+	c.mu.Unlock()
+	log.Printf("Chaos: function %v did not recover within %v: %v\n", f.Name, c.recoveryTimeout, lastErr)
+}
+
+// probe invokes the function through the runtime as the gateway would, failing on 5xx responses.
+func (c *Chaos) probe(f *types.Function) error {
+	name := cmp.Or(f.Base, f.Name)
+	req, err := http.NewRequest(http.MethodGet, f.ReadyPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(chaosProbeHeader, "1")
+	if f.Tenant != "" {
+		function := c.runtime.FunctionByName(name)
+		req.Header.Set(function.Tenancy.Header, f.Tenant)
+	}
+
+	resp, err := c.runtime.CallFunctionByName(name, f.ReadyPath, req)
+	if err != nil {
+		return err
+	}
+	resp.discard()
+	if resp.Status >= 500 {
+		return fmt.Errorf("function responded %v", resp.Status)
+	}
+	return nil
+}
+
+// Start kills a container every interval and starts failing Docker API calls.
+func (c *Chaos) Start() {
+	log.Printf("Chaos mode: killing a function container every %v, failing %v%% of Docker API calls\n",
+		c.interval, c.config.DockerFailureRate*100)
+	c.report.Started = time.Now()
+	if c.runtime.chaos != nil {
+		c.runtime.chaos.active.Store(true)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.kill()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops chaos and writes its report, listing functions that failed to recover as gaps.
+func (c *Chaos) Stop() {
+	if c.runtime.chaos != nil {
+		c.runtime.chaos.active.Store(false)
+	}
+	close(c.stop)
+	c.wg.Wait()
+
+	report := c.Report()
+	bytes, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.config.Report), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(c.config.Report, bytes, 0644)
+	}
+	if err != nil {
+		log.Printf("Cannot write chaos report: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Chaos report written to %v: %v kills, %v gaps\n", c.config.Report, len(report.Kills), len(report.Gaps))
+	for _, gap := range report.Gaps {
+		fmt.Printf("  %v\n", gap)
+	}
+}
+
+// Report returns what chaos mode did so far.
+func (c *Chaos) Report() ChaosReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := c.report
+	report.Stopped = time.Now()
+	report.Kills = nil
+	report.Gaps = []string{}
+	for _, kill := range c.report.Kills {
+		k := *kill
+		report.Kills = append(report.Kills, &k)
+		if !k.Recovered {
+			gap := fmt.Sprintf("function %v did not recover within %v of its container being killed at %v: %v",
+				k.Function, c.recoveryTimeout, k.At.Format(time.RFC3339), k.Error)
+			report.Gaps = append(report.Gaps, gap)
+		}
+	}
+
+	report.Injected = make(map[string]int)
+	if t := c.runtime.chaos; t != nil {
+		t.mu.Lock()
+		for call, n := range t.injected {
+			report.Injected[call] = n
+		}
+		t.mu.Unlock()
+	}
+	return report
+}
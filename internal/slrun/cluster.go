@@ -0,0 +1,440 @@
+package slrun
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	goruntime "runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Carries the cluster secret on invocations forwarded between nodes
+const clusterSecretHeader = "X-Slrun-Cluster-Secret"
+
+// Set on invocation errors of forwarded invocations, to their class
+const clusterErrorHeader = "X-Slrun-Error-Class"
+
+// Set on responses to forwarded invocations, to the time spent cold starting the function
+const clusterColdStartHeader = "X-Slrun-Cold-Start"
+
+// Path prefix of invocations forwarded between nodes: /invoke/funcName/other/parts
+const clusterInvokePrefix = "/invoke/"
+
+// Where invocations of a function are served in cluster mode
+const (
+	LocalityPreferLocal = "prefer_local" // On this node, on others when not placed or unavailable here
+	LocalityLocalOnly   = "local_only"   // Only on this node, never forwarded
+	LocalityAny         = "any"          // On any node running the function, taking turns
+)
+
+// forwardedKey marks the context of invocations forwarded by another node
+type forwardedKey struct{}
+
+// validateCluster finds this node among the cluster's nodes, adding its arch and os labels,
+// and checks every function can be placed on some node.
+func validateCluster(config *types.Config) error {
+	cluster := config.Cluster
+	if cluster == nil {
+		for _, f := range config.Functions {
+			if len(f.Requires) > 0 || len(f.Constraints) > 0 {
+				return fieldError(functionField(config, f, "requires"), "function %s has placement requirements but cluster mode is off", f.Name)
+			}
+			if f.Locality != "" {
+				return fieldError(functionField(config, f, "locality"), "function %s has locality but cluster mode is off", f.Name)
+			}
+		}
+		return nil
+	}
+
+	for _, f := range config.Functions {
+		if f.Locality == "" {
+			f.Locality = LocalityPreferLocal
+		}
+		if !slices.Contains([]string{LocalityPreferLocal, LocalityLocalOnly, LocalityAny}, f.Locality) {
+			return fieldError(functionField(config, f, "locality"), "function %s has invalid locality: %s", f.Name, f.Locality)
+		}
+	}
+
+	if cluster.Node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("cannot name cluster node after hostname: %w", err)
+		}
+		cluster.Node = hostname
+	}
+
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	var self *types.Node
+	for i, n := range cluster.Nodes {
+		field := fmt.Sprintf("cluster.nodes[%d]", i)
+		if n.Name == "" || n.Address == "" {
+			return fieldError(field, "cluster node must have a name and an address")
+		}
+		if names[n.Name] {
+			return fieldError(field+".name", "cluster has duplicate node name: %s", n.Name)
+		}
+		if addresses[n.Address] {
+			return fieldError(field+".address", "cluster has duplicate node address: %s", n.Address)
+		}
+		names[n.Name] = true
+		addresses[n.Address] = true
+		if n.Name == cluster.Node {
+			self = n
+		}
+	}
+	if self == nil {
+		return fieldError("cluster.node", "cluster has no node named %s, this node", cluster.Node)
+	}
+
+	if self.Labels == nil {
+		self.Labels = make(map[string]string)
+	}
+	if _, exists := self.Labels["arch"]; !exists {
+		self.Labels["arch"] = goruntime.GOARCH
+	}
+	if _, exists := self.Labels["os"]; !exists {
+		self.Labels["os"] = goruntime.GOOS
+	}
+
+	if gossip := cluster.Gossip; gossip != nil {
+		if gossip.Address == "" {
+			gossip.Address = "0.0.0.0:7947"
+		}
+		if _, _, err := net.SplitHostPort(gossip.Address); err != nil {
+			return fieldError("cluster.gossip.address", "invalid cluster gossip address: %w", err)
+		}
+		// Nodes running a function may join later
+		return nil
+	}
+
+	for _, f := range config.Functions {
+		placed := false
+		for _, n := range cluster.Nodes {
+			placed = placed || canRun(n, f)
+		}
+		if !placed {
+			return fieldError(functionField(config, f, "requires"), "function %s can't be placed, no cluster node satisfies its requirements", f.Name)
+		}
+	}
+	return nil
+}
+
+// canRun reports whether node's labels satisfy the function's requirements.
+func canRun(node *types.Node, f *types.Function) bool {
+	for _, label := range f.Requires {
+		if _, exists := node.Labels[label]; !exists {
+			return false
+		}
+	}
+	for label, value := range f.Constraints {
+		if node.Labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Cluster runs the functions this node can run and forwards invocations
+// of the others to nodes running them.
+type Cluster struct {
+	config     *types.Cluster
+	self       *types.Node
+	functions  []*types.Function
+	httpClient *http.Client
+	server     *http.Server
+	members    *memberlist.Memberlist // Gossip membership, nil if nodes are static
+
+	mu        sync.Mutex
+	placement map[string][]*types.Node // Other nodes running each function
+	next      map[string]int           // Next node invoked of each remote function
+}
+
+func NewCluster(config *types.Cluster) *Cluster {
+	c := &Cluster{
+		config:     config,
+		placement:  make(map[string][]*types.Node),
+		httpClient: newProxyClient(),
+		next:       make(map[string]int),
+	}
+	for _, n := range config.Nodes {
+		if n.Name == config.Node {
+			c.self = n
+		}
+	}
+	return c
+}
+
+// Place places functions on the nodes able to run them, marking those this node
+// can't run as remote. It returns the functions placed on this node.
+// With gossip, other nodes are placed on as they join.
+func (c *Cluster) Place(functions []*types.Function) []*types.Function {
+	c.functions = functions
+	var local []*types.Function
+	for _, f := range functions {
+		f.Remote = !canRun(c.self, f)
+		if !f.Remote {
+			local = append(local, f)
+		}
+	}
+	if c.config.Gossip != nil {
+		return local
+	}
+
+	for _, n := range c.config.Nodes {
+		if n != c.self {
+			c.addNode(n)
+		}
+	}
+	for _, f := range functions {
+		nodes := c.Nodes(f.Name)
+		if !f.Remote {
+			nodes = append([]string{c.self.Name}, nodes...)
+		}
+		fmt.Printf("Placed function %v on nodes %v\n", f.Name, strings.Join(nodes, ", "))
+	}
+	return local
+}
+
+// addNode places functions on another node able to run them.
+func (c *Cluster) addNode(node *types.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range c.functions {
+		if canRun(node, f) {
+			c.placement[f.Name] = append(c.placement[f.Name], node)
+		}
+	}
+}
+
+// removeNode stops placing functions on another node.
+func (c *Cluster) removeNode(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for function, nodes := range c.placement {
+		c.placement[function] = slices.DeleteFunc(nodes, func(n *types.Node) bool { return n.Name == name })
+	}
+}
+
+// Nodes returns the names of other nodes running the function.
+func (c *Cluster) Nodes(function string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for _, n := range c.placement[function] {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// pick returns the node invoked next for a function, taking turns between the other nodes
+// running it, and this one if withSelf. Returns nil on this node's turn, or if no other node runs it.
+func (c *Cluster) pick(function string, withSelf bool) *types.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nodes := c.placement[function]
+	if withSelf {
+		nodes = append(slices.Clone(nodes), nil)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	n := nodes[c.next[function]%len(nodes)]
+	c.next[function]++
+	return n
+}
+
+// forwarded reports whether req was forwarded by another node.
+func forwarded(req *http.Request) bool {
+	v, _ := req.Context().Value(forwardedKey{}).(bool)
+	return v
+}
+
+// canForward reports whether an invocation of function may be forwarded to another node.
+// Invocations already forwarded never are, so they don't loop between nodes.
+func (c *Cluster) canForward(function *types.Function, req *http.Request) bool {
+	if c == nil || forwarded(req) || function.Locality == LocalityLocalOnly {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.placement[function.Name]) > 0
+}
+
+// route returns the node an invocation of function is forwarded to by its locality, nil if it is served here.
+func (c *Cluster) route(function *types.Function, req *http.Request) *types.Node {
+	if !c.canForward(function, req) {
+		return nil
+	}
+	if function.Remote || !function.IsEnabled {
+		return c.pick(function.Name, false)
+	}
+	if function.Locality == LocalityAny {
+		return c.pick(function.Name, true)
+	}
+	return nil
+}
+
+// Forward invokes function on node.
+func (c *Cluster) Forward(node *types.Node, function *types.Function, path string, prevReq *http.Request) (*FunctionResponse, error) {
+	url := "http://" + node.Address + clusterInvokePrefix + function.Name + path
+	if prevReq.URL.RawQuery != "" {
+		url += "?" + prevReq.URL.RawQuery
+	}
+
+	req, err := http.NewRequest(prevReq.Method, url, prevReq.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = prevReq.Header.Clone()
+	req.Header.Set(clusterSecretHeader, c.config.Secret)
+	forwardDeadline(req.Header, function, prevReq)
+	req.ContentLength = prevReq.ContentLength
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error forwarding function %v to node %v: %v\n", function.Name, node.Name, err)
+		return nil, bodyError(err, ErrClassUnreachable, http.StatusBadGateway)
+	}
+	return forwardedResponse(resp, "node "+node.Name)
+}
+
+// forwardedResponse returns the response of an invocation forwarded to from,
+// or its invocation error if it failed there.
+func forwardedResponse(resp *http.Response, from string) (*FunctionResponse, error) {
+	if class := resp.Header.Get(clusterErrorHeader); class != "" {
+		defer resp.Body.Close()
+		var errBody invocationErrorBody
+		json.NewDecoder(resp.Body).Decode(&errBody)
+		err := fmt.Errorf("%v: %v", from, errBody.Error)
+		return nil, invocationError(class, resp.StatusCode, err)
+	}
+	coldStart, _ := time.ParseDuration(resp.Header.Get(clusterColdStartHeader))
+	resp.Header.Del(clusterColdStartHeader)
+	return &FunctionResponse{Status: resp.StatusCode, Header: resp.Header, Body: resp.Body, ColdStart: coldStart}, nil
+}
+
+// Start serves invocations forwarded by other nodes on this node's address,
+// and joins the cluster's gossip if any.
+func (c *Cluster) Start(runtime *Runtime, uploadDir string) error {
+	if c.config.Gossip != nil {
+		err := c.startGossip()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.server = &http.Server{
+		Addr:    c.self.Address,
+		Handler: c.invokeHandler(runtime, uploadDir),
+	}
+	go func() {
+		if err := c.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Cluster server failed: %v", err)
+		}
+	}()
+	fmt.Printf("Cluster node %v listening on %v\n", c.self.Name, c.self.Address)
+	return nil
+}
+
+func (c *Cluster) Shutdown(ctx context.Context) error {
+	if c.members != nil {
+		c.stopGossip()
+	}
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Shutdown(ctx)
+}
+
+// invokeHandler invokes functions on this node for other nodes.
+// Remote functions aren't forwarded again, so a request never loops between nodes.
+func (c *Cluster) invokeHandler(runtime *Runtime, uploadDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(clusterSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(c.config.Secret)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("invalid cluster secret"))
+			return
+		}
+		r.Header.Del(clusterSecretHeader)
+		r = r.WithContext(context.WithValue(r.Context(), forwardedKey{}, true))
+
+		rest, ok := strings.CutPrefix(r.URL.Path, clusterInvokePrefix)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("not a function invocation"))
+			return
+		}
+		funcName, path, _ := strings.Cut(rest, "/")
+		path = "/" + path
+
+		fun := runtime.FunctionByName(funcName)
+		if fun == nil || fun.Remote {
+			err := fmt.Errorf("function %v isn't placed on node %v", funcName, c.self.Name)
+			writeForwardedError(w, r, funcName, invocationError(ErrClassNotFound, http.StatusNotFound, err))
+			return
+		}
+		// This node's invoke policy applies too, it may differ from the forwarding node's
+		err := runtime.checkInvoke(fun, path, r)
+		var ierr *InvocationError
+		if errors.As(err, &ierr) {
+			writeForwardedError(w, r, funcName, ierr)
+			return
+		}
+		serveForwarded(w, r, runtime, uploadDir, fun, path)
+	})
+}
+
+// serveForwarded invokes a function for the node or peer that forwarded the invocation,
+// reporting failures and cold starts in headers for its gateway.
+func serveForwarded(w http.ResponseWriter, r *http.Request, runtime *Runtime, uploadDir string, fun *types.Function, path string) {
+	funcName := fun.Name
+
+	// Forwarding gateways leave transforms to the node running the function
+	if fun.Transform == TransformFormToJSON {
+		cleanup, err := transformFormToJSON(r, uploadDir, funcName, fun.MaxUploadBytes)
+		defer cleanup()
+		if err != nil {
+			writeForwardedError(w, r, funcName, bodyError(err, ErrClassBadRequest, http.StatusBadRequest))
+			return
+		}
+	}
+
+	resp, err := runtime.CallFunctionByName(funcName, path, r)
+	if err != nil {
+		var ierr *InvocationError
+		if !errors.As(err, &ierr) {
+			ierr = invocationError(ErrClassPolicyFailure, http.StatusInternalServerError, err)
+		}
+		writeForwardedError(w, r, funcName, ierr)
+		return
+	}
+	w.Header().Set(clusterColdStartHeader, resp.ColdStart.String())
+	err = resp.Write(w)
+	if err != nil {
+		log.Printf("Cannot stream function %v response to forwarding gateway: %v\n", funcName, err)
+	}
+}
+
+// writeForwardedError writes a failed forwarded invocation, for the forwarding gateway to report.
+func writeForwardedError(w http.ResponseWriter, r *http.Request, funcName string, err *InvocationError) {
+	log.Printf("Forwarded request %v to function %v failed: %v\n", r.Header.Get(requestIDHeader), funcName, err)
+	w.Header().Set(clusterErrorHeader, err.Class)
+	writeJSON(w, err.Status, invocationErrorBody{
+		Error:     err.Error(),
+		Class:     err.Class,
+		RequestID: r.Header.Get(requestIDHeader),
+		Function:  funcName,
+	})
+}
@@ -0,0 +1,148 @@
+package slrun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Set to true on responses shared from another request's call
+const coalescedHeader = "X-Slrun-Coalesced"
+
+// Request headers always telling coalesced requests apart
+var coalesceVaryHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding"}
+
+func validateCoalesce(config *types.Config) error {
+	for _, f := range config.Functions {
+		if f.Coalesce == nil {
+			continue
+		}
+		for i, p := range f.Coalesce.Paths {
+			if !strings.HasPrefix(p, "/") || strings.Contains(strings.TrimSuffix(p, "/*"), "*") {
+				return fieldError(fmt.Sprintf("%s.paths[%d]", functionField(config, f, "coalesce"), i), "coalesced path %q must start with / and may only end in /*", p)
+			}
+		}
+		if f.Coalesce.MaxBodyBytes < 0 {
+			return fieldError(functionField(config, f, "coalesce")+".max_body_bytes", "invalid coalesce max body bytes: %d", f.Coalesce.MaxBodyBytes)
+		}
+		if f.Coalesce.MaxBodyBytes == 0 {
+			f.Coalesce.MaxBodyBytes = 1 << 20
+		}
+	}
+	return nil
+}
+
+// Coalescer shares calls between identical requests in flight at once.
+type Coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall // By request key
+}
+
+// coalescedCall is a call whose response is shared with the requests waiting on it.
+type coalescedCall struct {
+	done      chan struct{} // Closed once the response is buffered
+	status    int
+	header    http.Header
+	body      []byte
+	coldStart time.Duration
+	err       error
+	shared    bool // False if waiting requests must call the function themselves
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// coalesceKey returns the key of a request to fun at path shared with identical requests,
+// and whether it can be coalesced at all.
+func (g *Gateway) coalesceKey(fun *types.Function, path string, r *http.Request) (string, bool) {
+	if fun.Coalesce == nil || r.Method != http.MethodGet || r.ContentLength > 0 {
+		return "", false
+	}
+	if len(fun.Coalesce.Paths) > 0 && !slices.ContainsFunc(fun.Coalesce.Paths, func(p string) bool {
+		_, ok := matchRule(&types.PathRule{From: p}, path)
+		return ok
+	}) {
+		return "", false
+	}
+
+	vary := slices.Concat(coalesceVaryHeaders, fun.Coalesce.VaryHeaders)
+	if fun.Tenancy != nil {
+		vary = append(vary, fun.Tenancy.Header)
+	}
+	if g.config.Quotas != nil {
+		vary = append(vary, g.config.Quotas.KeyHeader, g.config.Quotas.TenantHeader)
+	}
+	var key strings.Builder
+	fmt.Fprintf(&key, "%s\x00%s?%s", fun.Name, path, r.URL.RawQuery)
+	for _, h := range vary {
+		fmt.Fprintf(&key, "\x00%s", strings.Join(r.Header.Values(h), ","))
+	}
+	return key.String(), true
+}
+
+// Do calls the function with call, unless an identical request's call is in flight, in which
+// case its response is shared once buffered. Responses larger than max bytes aren't shared:
+// the first request streams its own and the others call the function themselves.
+func (c *Coalescer) Do(r *http.Request, key string, max int64, call func() (*FunctionResponse, error)) (*FunctionResponse, error) {
+	c.mu.Lock()
+	if cc, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cc.done:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+		if !cc.shared {
+			return call()
+		}
+		if cc.err != nil {
+			return nil, cc.err
+		}
+		header := cc.header.Clone()
+		header.Set(coalescedHeader, "true")
+		return &FunctionResponse{Status: cc.status, Header: header, Body: io.NopCloser(bytes.NewReader(cc.body)), ColdStart: cc.coldStart}, nil
+	}
+	cc := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = cc
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(cc.done)
+	}()
+
+	resp, err := call()
+	if err != nil {
+		// Unless the request went away, the others would fail the same
+		cc.err, cc.shared = err, r.Context().Err() == nil
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil || int64(len(body)) > max {
+		// Streamed from what was read on
+		resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp, nil
+	}
+	if err := resp.Body.Close(); err != nil {
+		return nil, invocationError(ErrClassPolicyFailure, http.StatusInternalServerError, err)
+	}
+	cc.status, cc.header, cc.body, cc.coldStart, cc.shared = resp.Status, resp.Header, body, resp.ColdStart, true
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// prefixedBody reads a response body after the part already read, closing the original.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
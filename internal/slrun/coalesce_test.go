@@ -0,0 +1,70 @@
+package slrun
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		max       int64
+		wantCalls int32
+	}{
+		{"shared", "hello", 1024, 1},
+		{"too large to share", "hello", 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoalescer()
+			var calls atomic.Int32
+			started := make(chan struct{})
+			call := func() (*FunctionResponse, error) {
+				if calls.Add(1) == 1 {
+					// Slow enough for the others to wait on it
+					close(started)
+					time.Sleep(50 * time.Millisecond)
+				}
+				return &FunctionResponse{Status: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(tt.body))}, nil
+			}
+
+			var wg sync.WaitGroup
+			bodies := make([]string, 3)
+			for i := range bodies {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if i > 0 {
+						<-started
+					}
+					r := httptest.NewRequest("GET", "/func1/report", nil)
+					resp, err := c.Do(r, "func1\x00/report", tt.max, call)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					body, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					bodies[i] = string(body)
+				}()
+			}
+			wg.Wait()
+
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("function called %v times, want %v", calls.Load(), tt.wantCalls)
+			}
+			for i, body := range bodies {
+				if body != tt.body {
+					t.Errorf("request %v body = %q, want %q", i, body, tt.body)
+				}
+			}
+		})
+	}
+}
@@ -1,48 +1,725 @@
 package slrun
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/marcorentap/slrun/internal/types"
+	"gopkg.in/yaml.v3"
 )
 
 func validateConfig(config *types.Config) error {
+	for _, f := range config.Functions {
+		if !functionNamePattern.MatchString(f.Name) {
+			return fieldError(functionField(config, f, "name"), "invalid function name %q: use lowercase letters, digits and separators . _ -, starting and ending with a letter or digit", f.Name)
+		}
+	}
+
 	// Function names should be unique
 	for _, f := range config.Functions {
 		for _, f2 := range config.Functions {
 			if f != f2 && f.Name == f2.Name {
-				return fmt.Errorf("config has duplicate function name: %s", f.Name)
+				return fieldError(functionField(config, f2, "name"), "config has duplicate function name: %s", f.Name)
+			}
+		}
+	}
+
+	for _, f := range config.Functions {
+		sources := 0
+		for _, set := range []bool{f.BuildDir != "", f.Image != "", f.Process != nil, f.Wasm != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fieldError(functionField(config, f, "build_dir"), "function %s must have one of build_dir, image, process and wasm", f.Name)
+		}
+		if f.Image != "" && (f.Dockerfile != "" || len(f.BuildArgs) > 0 || f.Target != "") {
+			return fieldError(functionField(config, f, "image"), "function %s dockerfile, build_args and target need a build_dir, images aren't built", f.Name)
+		}
+		if f.BuildDir != "" {
+			if f.Dockerfile == "" {
+				f.Dockerfile = "Dockerfile"
+			}
+			if !filepath.IsLocal(f.Dockerfile) {
+				return fieldError(functionField(config, f, "dockerfile"), "function %s dockerfile must be a path in its build_dir: %s", f.Name, f.Dockerfile)
+			}
+			err := checkBuildDir(f)
+			if err != nil {
+				return &configFieldError{Field: functionField(config, f, "build_dir"), Err: err}
+			}
+		}
+		if f.Image != "" && f.TestCommand != "" {
+			return fieldError(functionField(config, f, "test_command"), "function %s test_command needs a build_dir, images aren't tested", f.Name)
+		}
+		if f.Wasm != "" {
+			err := validateWasm(config, f)
+			if err != nil {
+				return err
+			}
+		}
+		if f.Process != nil {
+			err := validateProcess(config, f)
+			if err != nil {
+				return err
 			}
 		}
+		if f.ImagePull == "" {
+			f.ImagePull = ImagePullMissing
+		}
+		if f.ImagePull != ImagePullMissing && f.ImagePull != ImagePullAlways {
+			return fieldError(functionField(config, f, "image_pull"), "function %s has unknown image pull: %s", f.Name, f.ImagePull)
+		}
 	}
 
 	validPolicies := []types.PolicyID{types.AlwaysHotPolicy, types.AlwaysColdPolicy, types.ColdOnIdlePolicy}
 	if !slices.Contains(validPolicies, config.Policy) {
-		return fmt.Errorf("invalid policy: %s", config.Policy)
+		return fieldError("policy", "invalid policy: %s", config.Policy)
+	}
+
+	for _, f := range config.Functions {
+		if f.Transform != "" && f.Transform != TransformFormToJSON {
+			return fieldError(functionField(config, f, "transform"), "function %s has unknown transform: %s", f.Name, f.Transform)
+		}
+	}
+
+	for _, f := range config.Functions {
+		applyHandshake(f)
+		err := applyRuntimeProfile(f)
+		if err != nil {
+			return &configFieldError{Field: functionField(config, f, "profile"), Err: err}
+		}
+		if f.ReadyPath == "" {
+			f.ReadyPath = "/"
+		}
+		if !strings.HasPrefix(f.ReadyPath, "/") {
+			return fieldError(functionField(config, f, "ready_path"), "function %s ready path must start with /: %s", f.Name, f.ReadyPath)
+		}
+		err = validateReadiness(config, f)
+		if err != nil {
+			return err
+		}
+		if f.StopTimeout < 0 {
+			return fieldError(functionField(config, f, "stop_timeout"), "function %s has negative stop timeout", f.Name)
+		}
+		if f.Timeout < 0 {
+			return fieldError(functionField(config, f, "timeout"), "function %s has negative timeout", f.Name)
+		}
+		if hook := f.PreStop; hook != nil {
+			if len(hook.Exec) == 0 && hook.Path == "" {
+				return fieldError(functionField(config, f, "pre_stop"), "function %s pre_stop needs exec or path", f.Name)
+			}
+			if hook.Path != "" && !strings.HasPrefix(hook.Path, "/") {
+				return fieldError(functionField(config, f, "pre_stop.path"), "function %s pre_stop path must start with /: %s", f.Name, hook.Path)
+			}
+			if hook.Method == "" {
+				hook.Method = "POST"
+			}
+			if hook.Timeout <= 0 {
+				hook.Timeout = 10
+			}
+		}
+	}
+
+	for _, f := range config.Functions {
+		if w := f.Warmup; w != nil {
+			if w.Path == "" {
+				w.Path = "/"
+			}
+			if w.Method == "" {
+				w.Method = "GET"
+			}
+			if w.Count <= 0 {
+				w.Count = 1
+			}
+			if w.Concurrency <= 0 {
+				w.Concurrency = 1
+			}
+			if !strings.HasPrefix(w.Path, "/") {
+				return fieldError(functionField(config, f, "warmup.path"), "function %s warmup path must start with /: %s", f.Name, w.Path)
+			}
+		}
+	}
+
+	for _, f := range config.Functions {
+		if f.ListenPort == 0 {
+			continue
+		}
+		if f.ListenPort < 1 || f.ListenPort > 65535 {
+			return fieldError(functionField(config, f, "port"), "function %s has invalid port: %d", f.Name, f.ListenPort)
+		}
+		if f.Socket {
+			return fieldError(functionField(config, f, "port"), "function %s listens on a socket, it can't have a port", f.Name)
+		}
+		if f.ListenPort == f.DebugPort {
+			return fieldError(functionField(config, f, "port"), "function %s port is its debug port: %d", f.Name, f.ListenPort)
+		}
+	}
+
+	debugPorts := make(map[int]string)
+	for _, f := range config.Functions {
+		if f.DebugPort == 0 {
+			continue
+		}
+		if f.DebugPort < 1 || f.DebugPort > 65535 {
+			return fieldError(functionField(config, f, "debug_port"), "function %s has invalid debug port: %d", f.Name, f.DebugPort)
+		}
+		if other, exists := debugPorts[f.DebugPort]; exists {
+			return fieldError(functionField(config, f, "debug_port"), "functions %s and %s have the same debug port: %d", other, f.Name, f.DebugPort)
+		}
+		debugPorts[f.DebugPort] = f.Name
+		if f.Debugger != "" && !slices.Contains(debuggers, f.Debugger) {
+			return fieldError(functionField(config, f, "debugger"), "function %s has unknown debugger: %s", f.Name, f.Debugger)
+		}
 	}
 
+	if config.Builder == "" {
+		config.Builder = BuilderBuildKit
+	}
+	if config.Builder != BuilderBuildKit && config.Builder != BuilderClassic {
+		return fieldError("builder", "invalid builder: %s", config.Builder)
+	}
+
+	if config.BuildCompression == "" {
+		config.BuildCompression = CompressionAuto
+	}
+	validCompressions := []string{CompressionAuto, CompressionNone, CompressionGzip, CompressionZstd}
+	if !slices.Contains(validCompressions, config.BuildCompression) {
+		return fieldError("build_compression", "invalid build compression: %s", config.BuildCompression)
+	}
+
+	if config.BuildParallelism == 0 {
+		config.BuildParallelism = 4
+	}
+	if config.BuildParallelism < 0 {
+		return fieldError("build_parallelism", "invalid build parallelism: %d", config.BuildParallelism)
+	}
+
+	if config.StateDir == "" {
+		config.StateDir = ".slrun"
+	}
+
+	if config.ShutdownGracePeriod == "" {
+		config.ShutdownGracePeriod = "30s"
+	}
+	if _, err := time.ParseDuration(config.ShutdownGracePeriod); err != nil {
+		return fieldError("shutdown_grace_period", "invalid shutdown grace period: %w", err)
+	}
+
+	if registry := config.Registry; registry != nil {
+		for i, mirror := range registry.Mirrors {
+			mirror = strings.TrimPrefix(mirror, "https://")
+			registry.Mirrors[i] = strings.TrimSuffix(mirror, "/")
+		}
+		if cache := registry.Cache; cache != nil {
+			if cache.Port == 0 {
+				cache.Port = 5000
+			}
+			if cache.Dir == "" {
+				cache.Dir = filepath.Join(config.StateDir, "registry-cache")
+			}
+		}
+	}
+
+	if config.UploadDir == "" {
+		config.UploadDir = filepath.Join(os.TempDir(), "slrun-uploads")
+	}
+	uploadDir, err := filepath.Abs(config.UploadDir)
+	if err != nil {
+		return err
+	}
+	config.UploadDir = uploadDir
+
+	for _, f := range config.Functions {
+		for i, p := range f.ScaleProfiles {
+			if err := validateScaleProfile(p); err != nil {
+				return fieldError(functionField(config, f, fmt.Sprintf("scale_profiles[%d]", i)), "function %s scale profile: %w", f.Name, err)
+			}
+		}
+	}
+
+	for _, f := range config.Functions {
+		if f.Tenancy == nil {
+			continue
+		}
+		if f.Tenancy.Header == "" {
+			f.Tenancy.Header = "X-Tenant"
+		}
+		if len(f.Tenancy.Tenants) == 0 {
+			return fieldError(functionField(config, f, "tenancy.tenants"), "function %s has tenancy without tenants", f.Name)
+		}
+		for name, t := range f.Tenancy.Tenants {
+			if t == nil {
+				f.Tenancy.Tenants[name] = &types.Tenant{}
+			}
+		}
+	}
+
+	if config.FunctionHost == "" {
+		config.FunctionHost = "127.0.0.1"
+	}
+	if net.ParseIP(config.FunctionHost) == nil {
+		return fieldError("function_host", "invalid function host: %s", config.FunctionHost)
+	}
+
+	err = validateFunctionPorts(config)
+	if err != nil {
+		return err
+	}
+
+	if config.Fallback != "" && !hasFunction(config, config.Fallback) {
+		return fieldError("fallback", "unknown fallback function: %s", config.Fallback)
+	}
+
+	err = validateListeners(config)
+	if err != nil {
+		return err
+	}
+
+	for i, rule := range config.Redirects {
+		if err := validatePathRule(rule, true); err != nil {
+			return &configFieldError{Field: fmt.Sprintf("redirects[%d]", i), Err: err}
+		}
+	}
+	for i, rule := range config.Rewrites {
+		if err := validatePathRule(rule, false); err != nil {
+			return &configFieldError{Field: fmt.Sprintf("rewrites[%d]", i), Err: err}
+		}
+	}
+
+	err = validateQuotas(config.Quotas)
+	if err != nil {
+		return err
+	}
+
+	err = validateUsageExport(&config.UsageExport)
+	if err != nil {
+		return err
+	}
+
+	err = validateNotifications(config.Notifications)
+	if err != nil {
+		return err
+	}
+
+	err = validateCluster(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateScaling(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateAsync(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateReplicas(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateSLOs(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateChaos(config)
+	if err != nil {
+		return err
+	}
+
+	err = validateQuarantine(config)
+	if err != nil {
+		return err
+	}
+	err = validateOPA(config)
+	if err != nil {
+		return err
+	}
+	err = validateSecrets(config)
+	if err != nil {
+		return err
+	}
+	err = validateResources(config)
+	if err != nil {
+		return err
+	}
+	err = validateBaseImages(config)
+	if err != nil {
+		return err
+	}
+	err = validateRebuilds(config)
+	if err != nil {
+		return err
+	}
+	err = validateAdmin(config)
+	if err != nil {
+		return err
+	}
+	err = validateFleet(config)
+	if err != nil {
+		return err
+	}
+	err = validatePeers(config)
+	if err != nil {
+		return err
+	}
+	err = validateDNS(config)
+	if err != nil {
+		return err
+	}
+	err = validateMDNS(config)
+	if err != nil {
+		return err
+	}
+	err = validateWebhooks(config)
+	if err != nil {
+		return err
+	}
+	err = validateFlags(config)
+	if err != nil {
+		return err
+	}
+	err = validateBulkheads(config)
+	if err != nil {
+		return err
+	}
+	err = validateRegistryAuths(config)
+	if err != nil {
+		return err
+	}
+	err = validateProxyDNS(config)
+	if err != nil {
+		return err
+	}
+	err = validateCoalesce(config)
+	if err != nil {
+		return err
+	}
+	err = validateNetwork(config)
+	if err != nil {
+		return err
+	}
+	err = validateRemoteBuild(config)
+	if err != nil {
+		return err
+	}
+	err = validateCaches(config)
+	if err != nil {
+		return err
+	}
+	err = validateBackend(config)
+	if err != nil {
+		return err
+	}
+	err = validateRetention(config)
+	if err != nil {
+		return err
+	}
+
+	if config.Capture.MaxEntries <= 0 {
+		config.Capture.MaxEntries = 100
+	}
+	if config.Capture.MaxBodyBytes <= 0 {
+		config.Capture.MaxBodyBytes = 64 * 1024
+	}
+	if config.ResponseBufferBytes <= 0 {
+		config.ResponseBufferBytes = 10 * 1024 * 1024
+	}
+
+	return nil
+}
+
+func hasFunction(config *types.Config, name string) bool {
+	return slices.ContainsFunc(config.Functions, func(f *types.Function) bool { return f.Name == name })
+}
+
+func validateListeners(config *types.Config) error {
+	addresses := make(map[string]bool)
+	for i, l := range config.Listeners {
+		field := fmt.Sprintf("listeners[%d]", i)
+		if l.Address == "" {
+			return fieldError(field+".address", "listener has no address")
+		}
+		if addresses[l.Address] {
+			return fieldError(field+".address", "config has duplicate listener address: %s", l.Address)
+		}
+		addresses[l.Address] = true
+
+		for _, name := range l.Functions {
+			if !hasFunction(config, name) && !isRelayed(config, name) {
+				return fieldError(field+".functions", "listener %s routes unknown function: %s", l.Address, name)
+			}
+		}
+
+		if (l.TLSCert == "") != (l.TLSKey == "") {
+			return fieldError(field+".tls_cert", "listener %s must set both tls_cert and tls_key", l.Address)
+		}
+
+		for _, name := range l.Middleware {
+			if _, exists := middlewares[name]; !exists {
+				return fieldError(field+".middleware", "listener %s has unknown middleware: %s", l.Address, name)
+			}
+		}
+		if slices.Contains(l.Middleware, "auth") && len(l.AuthTokens) == 0 {
+			return fieldError(field+".auth_tokens", "listener %s uses auth middleware without auth_tokens", l.Address)
+		}
+
+		if l.Fallback != "" && !hasFunction(config, l.Fallback) {
+			return fieldError(field+".fallback", "listener %s has unknown fallback function: %s", l.Address, l.Fallback)
+		}
+	}
+	return nil
+}
+
+// validateFunctionPorts checks function host ports don't collide with each other or
+// with ports slrun itself listens on, which the function port range mustn't include either.
+// Host ports may be in the range, they are reserved out of it.
+func validateFunctionPorts(config *types.Config) error {
+	claimed := make(map[int]string) // What listens on a port, by port
+	addresses := []string{config.AdminAddress}
+	for _, l := range config.Listeners {
+		addresses = append(addresses, l.Address)
+	}
+	if config.Fleet != nil && config.Fleet.Aggregate {
+		addresses = append(addresses, config.Fleet.Address)
+	}
+	for _, address := range addresses {
+		_, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		if port, err := strconv.Atoi(portStr); err == nil {
+			claimed[port] = address
+		}
+	}
+	for _, f := range config.Functions {
+		if f.DebugPort != 0 {
+			claimed[f.DebugPort] = "debug port of function " + f.Name
+		}
+	}
+
+	hostPorts := make(map[int]string)
+	for _, f := range config.Functions {
+		if f.HostPort == 0 {
+			continue
+		}
+		if f.HostPort < 1 || f.HostPort > 65535 {
+			return fieldError(functionField(config, f, "host_port"), "function %s has invalid host port: %d", f.Name, f.HostPort)
+		}
+		if f.Socket {
+			return fieldError(functionField(config, f, "host_port"), "function %s listens on a socket and can't have a host port", f.Name)
+		}
+		if other, exists := claimed[f.HostPort]; exists {
+			return fieldError(functionField(config, f, "host_port"), "host port %d of function %s collides with %s", f.HostPort, f.Name, other)
+		}
+		if other, exists := hostPorts[f.HostPort]; exists {
+			return fieldError(functionField(config, f, "host_port"), "functions %s and %s have the same host port: %d", other, f.Name, f.HostPort)
+		}
+		hostPorts[f.HostPort] = f.Name
+	}
+
+	if config.FunctionPorts == "" {
+		return nil
+	}
+	from, to, err := parsePortRange(config.FunctionPorts)
+	if err != nil {
+		return fieldError("function_ports", "%w", err)
+	}
+	for port, owner := range claimed {
+		if port >= from && port <= to {
+			return fieldError("function_ports", "function port range %s includes port %d of %s", config.FunctionPorts, port, owner)
+		}
+	}
+	return nil
+}
+
+func validateQuotas(quotas *types.Quotas) error {
+	if quotas == nil {
+		return nil
+	}
+	if quotas.KeyHeader == "" {
+		quotas.KeyHeader = "X-Api-Key"
+	}
+	if quotas.TenantHeader == "" {
+		quotas.TenantHeader = "X-Tenant"
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, k := range quotas.Keys {
+		field := fmt.Sprintf("quotas.keys[%d]", i)
+		if k.Name == "" || k.Key == "" {
+			return fieldError(field, "quota key must have a name and a key")
+		}
+		if names[k.Name] {
+			return fieldError(field+".name", "config has duplicate quota key name: %s", k.Name)
+		}
+		if keys[k.Key] {
+			return fieldError(field+".key", "quota key %s duplicates another key", k.Name)
+		}
+		names[k.Name] = true
+		keys[k.Key] = true
+	}
+
+	for name, t := range quotas.Tenants {
+		if t == nil {
+			quotas.Tenants[name] = &types.Quota{}
+		}
+	}
 	return nil
 }
 
+func validateUsageExport(export *types.UsageExport) error {
+	if export.Format == "" {
+		export.Format = "csv"
+	}
+	if export.Format != "csv" && export.Format != "json" {
+		return fieldError("usage_export.format", "invalid usage export format: %s", export.Format)
+	}
+	if export.MemoryMB <= 0 {
+		export.MemoryMB = 128
+	}
+	if export.Interval != "" {
+		if _, err := time.ParseDuration(export.Interval); err != nil {
+			return fieldError("usage_export.interval", "invalid usage export interval: %w", err)
+		}
+		if export.Dir == "" {
+			return fieldError("usage_export.dir", "usage export interval set without dir")
+		}
+	}
+	return nil
+}
+
+func validateNotifications(notifications *types.Notifications) error {
+	if notifications == nil {
+		return nil
+	}
+	if notifications.RateLimit == "" {
+		notifications.RateLimit = "10m"
+	}
+	if _, err := time.ParseDuration(notifications.RateLimit); err != nil {
+		return fieldError("notifications.rate_limit", "invalid notification rate limit: %w", err)
+	}
+
+	for i, n := range notifications.Notifiers {
+		field := fmt.Sprintf("notifications.notifiers[%d]", i)
+		switch n.Type {
+		case NotifierSlack, NotifierDiscord, NotifierWebhook:
+			if n.URL == "" {
+				return fieldError(field+".url", "%s notifier has no url", n.Type)
+			}
+		case NotifierEmail:
+			if n.SMTP == nil || n.SMTP.Address == "" || n.SMTP.From == "" || len(n.SMTP.To) == 0 {
+				return fieldError(field+".smtp", "email notifier must set smtp address, from and to")
+			}
+		default:
+			return fieldError(field+".type", "unknown notifier type: %s", n.Type)
+		}
+
+		for _, e := range n.Events {
+			if !slices.Contains(notifiableEvents, e) {
+				return fieldError(field+".events", "%s notifier has unknown event: %s", n.Type, e)
+			}
+		}
+		if _, err := parseNotificationTemplate(n.Template); err != nil {
+			return fieldError(field+".template", "%s notifier template: %w", n.Type, err)
+		}
+	}
+	return nil
+}
+
+// isYAMLConfig reports whether a config file is YAML, by its extension,
+// or by its content for files not named .json.
+func isYAMLConfig(path string, content []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	trimmed := bytes.TrimSpace(content)
+	return len(trimmed) > 0 && trimmed[0] != '{'
+}
+
+// configJSON returns the content of a config file as JSON, converting it from YAML if it is.
+// YAML configs use the same keys as JSON ones.
+func configJSON(path string, content []byte, isYAML bool) ([]byte, error) {
+	if !isYAML {
+		return content, nil
+	}
+
+	var doc any
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return converted, nil
+}
+
+// readConfigJSON returns the config file at path as JSON.
+func readConfigJSON(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return configJSON(path, content, isYAMLConfig(path, content))
+}
+
+// ReadConfigFile reads and validates the config file at path. Errors name the file,
+// and the line where they can be placed, such as for unknown fields.
 func ReadConfigFile(path string) (*types.Config, error) {
-	bytes, err := os.ReadFile(path)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	isYAML := isYAMLConfig(path, content)
+	converted, err := configJSON(path, content, isYAML)
 	if err != nil {
 		return nil, err
 	}
 	config := types.Config{ConfigFile: path}
 
-	err = json.Unmarshal(bytes, &config)
+	err = json.Unmarshal(converted, &config)
+	if err != nil {
+		return nil, decodeConfigError(path, content, isYAML, err)
+	}
+	lines := configLines(content, isYAML)
+	err = checkConfigFields(path, converted, lines)
 	if err != nil {
 		return nil, err
 	}
 
 	err = validateConfig(&config)
 	if err != nil {
-		return nil, err
+		return nil, validationConfigError(path, lines, err)
+	}
+
+	for _, f := range config.Functions {
+		if sourceDir(f) == "" {
+			continue
+		}
+		f.Metadata, err = ReadFunctionMetadata(f)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	log.Printf("Policy: %v\n", config.Policy)
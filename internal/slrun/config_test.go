@@ -0,0 +1,92 @@
+package slrun
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigFileValidationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		config string
+		want   string // Error after the config path
+	}{
+		{
+			name: "duplicate function name",
+			file: "config.json",
+			config: `{
+  "policy": "always_hot",
+  "functions": [
+    {"name": "func1", "image": "nginx"},
+    {
+      "name": "func1",
+      "image": "nginx"
+    }
+  ]
+}`,
+			want: ":6: functions[1].name: config has duplicate function name: func1",
+		},
+		{
+			name: "invalid function name",
+			file: "config.json",
+			config: `{
+  "policy": "always_hot",
+  "functions": [
+    {"name": "Func", "image": "nginx"}
+  ]
+}`,
+			want: `:4: functions[0].name: invalid function name "Func"`,
+		},
+		{
+			name: "invalid policy",
+			file: "config.json",
+			config: `{
+  "functions": [],
+  "policy": "sometimes"
+}`,
+			want: ":3: policy: invalid policy: sometimes",
+		},
+		{
+			name: "image and build dir",
+			file: "config.yaml",
+			config: `policy: always_hot
+functions:
+  - name: func1
+    image: nginx
+    build_dir: ./func1
+`,
+			want: ":5: functions[0].build_dir: function func1 must have one of build_dir, image, process and wasm",
+		},
+		{
+			name: "invalid shutdown grace period",
+			file: "config.json",
+			config: `{
+  "policy": "always_hot",
+  "functions": [],
+  "shutdown_grace_period": "30"
+}`,
+			want: `:4: shutdown_grace_period: invalid shutdown grace period`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := os.WriteFile(path, []byte(tt.config), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ReadConfigFile(path)
+			if err == nil {
+				t.Fatalf("ReadConfigFile() succeeded, want error %q", path+tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), path+tt.want) {
+				t.Errorf("ReadConfigFile() error = %q, want prefix %q", err, path+tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Function names form image names, slrun-<name>, so they must be valid in image references.
+// They are lowercase, without the @ and # of tenant and replica instances.
+var functionNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+
+// checkBuildDir checks that the function's build dir is a directory with its Dockerfile.
+func checkBuildDir(f *types.Function) error {
+	info, err := os.Stat(f.BuildDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("function %s build_dir does not exist: %s", f.Name, f.BuildDir)
+	}
+	if err != nil {
+		return fmt.Errorf("function %s build_dir: %w", f.Name, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("function %s build_dir is not a directory: %s", f.Name, f.BuildDir)
+	}
+	_, err = os.Stat(dockerfilePath(f))
+	if err != nil {
+		return fmt.Errorf("function %s build_dir has no %s: %s", f.Name, f.Dockerfile, f.BuildDir)
+	}
+	return nil
+}
+
+// configKeyPath returns the path of key in the object at path, e.g. functions[1].env.
+func configKeyPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lineAt returns the line of content at offset, from 1.
+func lineAt(content []byte, offset int64) int {
+	offset = min(offset, int64(len(content)))
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
+
+// configLines returns the line of each key and array item in a config file, by path.
+// Returns what it found before any syntax error, which decoding reports.
+func configLines(content []byte, isYAML bool) map[string]int {
+	lines := make(map[string]int)
+	if isYAML {
+		var doc yaml.Node
+		if yaml.Unmarshal(content, &doc) == nil && len(doc.Content) > 0 {
+			yamlLines(doc.Content[0], "", lines)
+		}
+		return lines
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(content))
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, exists := lines[path]; !exists {
+			lines[path] = lineAt(content, dec.InputOffset())
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				keyPath := configKeyPath(path, fmt.Sprint(key))
+				lines[keyPath] = lineAt(content, dec.InputOffset())
+				err = walk(keyPath)
+				if err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				err := walk(fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		_, err = dec.Token() // Closing delimiter
+		return err
+	}
+	walk("")
+	return lines
+}
+
+func yamlLines(node *yaml.Node, path string, lines map[string]int) {
+	if _, exists := lines[path]; !exists {
+		lines[path] = node.Line
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := configKeyPath(path, node.Content[i].Value)
+			lines[keyPath] = node.Content[i].Line
+			yamlLines(node.Content[i+1], keyPath, lines)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			yamlLines(item, fmt.Sprintf("%s[%d]", path, i), lines)
+		}
+	}
+}
+
+// configField returns the field of struct type t set by key, matched like encoding/json does.
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for _, field := range reflect.VisibleFields(t) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// unknownConfigFields returns the paths of the keys in value, decoded from JSON at path,
+// that set no field of type t. Values of the wrong type are left to decoding to report.
+func unknownConfigFields(value any, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, _ := value.(map[string]any)
+		for key, v := range obj {
+			keyPath := configKeyPath(path, key)
+			field, ok := configField(t, key)
+			if !ok {
+				unknown = append(unknown, keyPath)
+				continue
+			}
+			unknown = append(unknown, unknownConfigFields(v, field.Type, keyPath)...)
+		}
+	case reflect.Map:
+		obj, _ := value.(map[string]any)
+		for key, v := range obj {
+			unknown = append(unknown, unknownConfigFields(v, t.Elem(), configKeyPath(path, key))...)
+		}
+	case reflect.Slice:
+		items, _ := value.([]any)
+		for i, item := range items {
+			unknown = append(unknown, unknownConfigFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return unknown
+}
+
+// checkConfigFields rejects keys of the config, as JSON, that aren't config fields,
+// reporting each with its line in the config file at path.
+func checkConfigFields(path string, configJSON []byte, lines map[string]int) error {
+	var doc any
+	err := json.Unmarshal(configJSON, &doc)
+	if err != nil {
+		return nil // Reported by decoding
+	}
+
+	unknown := unknownConfigFields(doc, reflect.TypeFor[types.Config](), "")
+	sort.Slice(unknown, func(i, j int) bool {
+		if lines[unknown[i]] != lines[unknown[j]] {
+			return lines[unknown[i]] < lines[unknown[j]]
+		}
+		return unknown[i] < unknown[j]
+	})
+	var errs []error
+	for _, field := range unknown {
+		errs = append(errs, fmt.Errorf("%v:%d: unknown field %v", path, lines[field], field))
+	}
+	return errors.Join(errs...)
+}
+
+// fieldPath returns the config path of a field as encoding/json names it, e.g. functions.0.env.
+func fieldPath(field string) string {
+	var path string
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			path += "[" + part + "]"
+			continue
+		}
+		path = configKeyPath(path, part)
+	}
+	return path
+}
+
+// decodeConfigError adds the file, and the line if known, to an error decoding the config file at path.
+func decodeConfigError(path string, content []byte, isYAML bool, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case isYAML:
+		// Offsets are in the JSON it was converted to, the line is found by the field
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			field := fieldPath(typeErr.Field)
+			return fmt.Errorf("%v:%d: field %v must be %v, not %v", path, configLines(content, true)[field], field, typeErr.Type, typeErr.Value)
+		}
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%v:%d: %w", path, lineAt(content, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%v:%d: field %v must be %v, not %v", path, lineAt(content, typeErr.Offset), fieldPath(typeErr.Field), typeErr.Type, typeErr.Value)
+	}
+	return fmt.Errorf("%v: %w", path, err)
+}
+
+// configFieldError is a config validation error about one field, by its path such as functions[1].name.
+type configFieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *configFieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *configFieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns a validation error about the config field at path.
+func fieldError(path string, format string, args ...any) error {
+	return &configFieldError{Field: path, Err: fmt.Errorf(format, args...)}
+}
+
+// functionField returns the config path of key in function f, e.g. functions[1].name.
+func functionField(config *types.Config, f *types.Function, key string) string {
+	i := slices.Index(config.Functions, f)
+	return configKeyPath(fmt.Sprintf("functions[%d]", i), key)
+}
+
+// fieldLine returns the line of the config field at path, or of its closest parent in the file
+// if the field isn't, such as one validation defaulted.
+func fieldLine(lines map[string]int, path string) int {
+	for {
+		if line, exists := lines[path]; exists {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return lines[""]
+		}
+		path = path[:i]
+	}
+}
+
+// validationConfigError adds the file, and the line and field if known, to an error validating the config file at path.
+func validationConfigError(path string, lines map[string]int, err error) error {
+	var ferr *configFieldError
+	if errors.As(err, &ferr) {
+		return fmt.Errorf("%v:%d: %w", path, fieldLine(lines, ferr.Field), err)
+	}
+	return fmt.Errorf("%v: %w", path, err)
+}
@@ -0,0 +1,573 @@
+package slrun
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/marcorentap/slrun/internal/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Default containerd namespace of function containers and images
+const containerdNamespace = "slrun"
+
+func validateContainerd(config *types.Config) error {
+	backend := config.Backend
+	if backend.Namespace == "" {
+		backend.Namespace = containerdNamespace
+	}
+	if backend.Host != "" && !filepath.IsAbs(strings.TrimPrefix(backend.Host, "unix://")) {
+		return fieldError("backend.host", "containerd host %s must be a unix socket, e.g. unix:///run/containerd/containerd.sock", backend.Host)
+	}
+	if config.Chaos != nil {
+		return fieldError("chaos", "chaos testing kills containers and fails calls through Docker's API, it can't be used with containerd")
+	}
+	return nil
+}
+
+// containerdBackend runs function containers on containerd with nerdctl, containerd's Docker
+// compatible CLI, which maps their ports and joins them to networks with CNI plugins. It builds
+// images with BuildKit and pulls them into containerd too, so no Docker daemon is needed.
+type containerdBackend struct {
+	args  []string // Global nerdctl flags
+	mu    sync.Mutex
+	execs map[string]*backendExec // By ID
+}
+
+// backendExec is a command run in a container, as ContainerExecCreate and ContainerExecAttach
+// do, by backends running it themselves.
+type backendExec struct {
+	container string
+	options   container.ExecOptions
+	running   bool
+	exitCode  int
+}
+
+func newContainerdBackend(backend *types.Backend) *containerdBackend {
+	b := &containerdBackend{
+		args:  []string{"--namespace", cmp.Or(backend.Namespace, containerdNamespace)},
+		execs: make(map[string]*backendExec),
+	}
+	if backend.Host != "" {
+		b.args = append(b.args, "--address", strings.TrimPrefix(backend.Host, "unix://"))
+	}
+	if backend.CNIPath != "" {
+		b.args = append(b.args, "--cni-path", backend.CNIPath)
+	}
+	if backend.CNIConfigPath != "" {
+		b.args = append(b.args, "--cni-netconfpath", backend.CNIConfigPath)
+	}
+	return b
+}
+
+// nerdctl runs nerdctl with args, returning its output.
+func (b *containerdBackend) nerdctl(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "nerdctl", slices.Concat(b.args, args)...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nerdctl %v: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// stream runs nerdctl with args, returning its stdout and stderr multiplexed as Docker
+// multiplexes container output. Closing it kills nerdctl. exited is called with its exit
+// code once it exits.
+func (b *containerdBackend) stream(ctx context.Context, exited func(int), args ...string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return streamCommand(exec.CommandContext(ctx, "nerdctl", slices.Concat(b.args, args)...), cancel, exited)
+}
+
+// streamCommand starts cmd, returning its stdout and stderr multiplexed as Docker multiplexes
+// container output. Closing it calls cancel, which kills cmd. exited is called with its exit
+// code once it exits.
+func streamCommand(cmd *exec.Cmd, cancel context.CancelFunc, exited func(int)) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	cmd.Stdout = stdcopy.NewStdWriter(pw, stdcopy.Stdout)
+	cmd.Stderr = stdcopy.NewStdWriter(pw, stdcopy.Stderr)
+	err := cmd.Start()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		err := cmd.Wait()
+		cancel()
+		if exited != nil {
+			exited(cmd.ProcessState.ExitCode())
+		}
+		// Exiting non-zero is reported through exited
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = nil
+		}
+		pw.CloseWithError(err)
+	}()
+	return &streamCloser{Reader: pr, close: func() error {
+		cancel()
+		return pr.Close()
+	}}, nil
+}
+
+type streamCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (s *streamCloser) Close() error {
+	return s.close()
+}
+
+// writeEnvFile writes env to a file only its owner reads, for nerdctl to read rather than
+// take env variables, secrets included, on its command line. The file is removed by remove.
+func writeEnvFile(env []string) (path string, remove func(), err error) {
+	for _, e := range env {
+		if strings.ContainsAny(e, "\r\n") {
+			name, _, _ := strings.Cut(e, "=")
+			return "", nil, fmt.Errorf("env variable %v has a line break, which env files can't hold", name)
+		}
+	}
+	// Created 0600
+	f, err := os.CreateTemp("", "slrun-env-")
+	if err != nil {
+		return "", nil, err
+	}
+	remove = func() { os.Remove(f.Name()) }
+	_, err = f.WriteString(strings.Join(env, "\n") + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return f.Name(), remove, nil
+}
+
+// createArgs returns the nerdctl arguments creating a container as Docker would with
+// config and hostConfig, its env read from envFile. CNI networks have no aliases, so
+// networking's are left out.
+func createArgs(config *container.Config, hostConfig *container.HostConfig, envFile string) []string {
+	args := []string{"create"}
+	for _, name := range sortedKeys(config.Labels) {
+		args = append(args, "--label", name+"="+config.Labels[name])
+	}
+	if envFile != "" {
+		args = append(args, "--env-file", envFile)
+	}
+	for _, port := range slices.Sorted(maps.Keys(hostConfig.PortBindings)) {
+		for _, binding := range hostConfig.PortBindings[port] {
+			published := port.Port() + "/" + port.Proto()
+			if binding.HostPort != "" {
+				published = binding.HostPort + ":" + published
+			} else if binding.HostIP != "" {
+				published = ":" + published
+			}
+			if binding.HostIP != "" {
+				published = binding.HostIP + ":" + published
+			}
+			args = append(args, "--publish", published)
+		}
+	}
+	if hostConfig.NetworkMode != "" && !hostConfig.NetworkMode.IsDefault() {
+		args = append(args, "--network", string(hostConfig.NetworkMode))
+	}
+	for _, host := range hostConfig.ExtraHosts {
+		args = append(args, "--add-host", host)
+	}
+	for _, bind := range hostConfig.Binds {
+		args = append(args, "--volume", bind)
+	}
+	for _, m := range hostConfig.Mounts {
+		args = append(args, "--mount", fmt.Sprintf("type=%v,source=%v,target=%v", m.Type, m.Source, m.Target))
+	}
+	resources := hostConfig.Resources
+	if resources.NanoCPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(float64(resources.NanoCPUs)/1e9, 'f', -1, 64))
+	}
+	if resources.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(resources.Memory, 10))
+	}
+	if resources.MemorySwap > 0 {
+		args = append(args, "--memory-swap", strconv.FormatInt(resources.MemorySwap, 10))
+	}
+	if resources.PidsLimit != nil {
+		args = append(args, "--pids-limit", strconv.FormatInt(*resources.PidsLimit, 10))
+	}
+	if config.StopSignal != "" {
+		args = append(args, "--stop-signal", config.StopSignal)
+	}
+	if config.StopTimeout != nil {
+		args = append(args, "--stop-timeout", strconv.Itoa(*config.StopTimeout))
+	}
+	return append(append(args, config.Image), config.Cmd...)
+}
+
+func (b *containerdBackend) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	var envFile string
+	if len(config.Env) > 0 {
+		var remove func()
+		var err error
+		envFile, remove, err = writeEnvFile(config.Env)
+		if err != nil {
+			return container.CreateResponse{}, err
+		}
+		// The container keeps its env once created
+		defer remove()
+	}
+	args := createArgs(config, hostConfig, envFile)
+	if containerName != "" {
+		args = append([]string{"create", "--name", containerName}, args[1:]...)
+	}
+	out, err := b.nerdctl(ctx, nil, args...)
+	if err != nil {
+		return container.CreateResponse{}, err
+	}
+	return container.CreateResponse{ID: string(bytes.TrimSpace(out))}, nil
+}
+
+func (b *containerdBackend) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	_, err := b.nerdctl(ctx, nil, "start", containerID)
+	return err
+}
+
+func (b *containerdBackend) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	args := []string{"stop"}
+	if options.Signal != "" {
+		args = append(args, "--signal", options.Signal)
+	}
+	if options.Timeout != nil {
+		args = append(args, "--time", strconv.Itoa(*options.Timeout))
+	}
+	_, err := b.nerdctl(ctx, nil, append(args, containerID)...)
+	return err
+}
+
+func (b *containerdBackend) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	args := []string{"rm"}
+	if options.Force {
+		args = append(args, "--force")
+	}
+	if options.RemoveVolumes {
+		args = append(args, "--volumes")
+	}
+	_, err := b.nerdctl(ctx, nil, append(args, containerID)...)
+	return err
+}
+
+// inspect returns nerdctl's Docker compatible inspection of containers, images or networks.
+func (b *containerdBackend) inspect(ctx context.Context, object string, ids []string, v any) error {
+	args := append([]string{object, "inspect", "--mode", "dockercompat"}, ids...)
+	out, err := b.nerdctl(ctx, nil, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
+
+func (b *containerdBackend) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	var inspects []container.InspectResponse
+	err := b.inspect(ctx, "container", []string{containerID}, &inspects)
+	if err != nil {
+		return container.InspectResponse{}, err
+	}
+	if len(inspects) == 0 {
+		return container.InspectResponse{}, fmt.Errorf("no such container: %v", containerID)
+	}
+	return inspects[0], nil
+}
+
+// ContainerList lists containers matching the options' label filters.
+func (b *containerdBackend) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	args := []string{"ps", "--quiet", "--no-trunc"}
+	if options.All {
+		args = append(args, "--all")
+	}
+	for _, label := range options.Filters.Get("label") {
+		args = append(args, "--filter", "label="+label)
+	}
+	out, err := b.nerdctl(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var inspects []container.InspectResponse
+	err = b.inspect(ctx, "container", ids, &inspects)
+	if err != nil {
+		return nil, err
+	}
+	var summaries []container.Summary
+	for _, inspect := range inspects {
+		summary := container.Summary{ID: inspect.ID, Names: []string{inspect.Name}}
+		if inspect.Config != nil {
+			summary.Image = inspect.Config.Image
+			summary.Labels = inspect.Config.Labels
+		}
+		if inspect.State != nil {
+			summary.State = inspect.State.Status
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+func (b *containerdBackend) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	args := []string{"logs"}
+	if options.Follow {
+		args = append(args, "--follow")
+	}
+	if options.Tail != "" {
+		args = append(args, "--tail", options.Tail)
+	}
+	if options.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	return b.stream(ctx, nil, append(args, containerID)...)
+}
+
+func (b *containerdBackend) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	resp := container.ExecCreateResponse{ID: hex.EncodeToString(id)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.execs[resp.ID] = &backendExec{container: containerID, options: options}
+	return resp, nil
+}
+
+// execConn is the connection of an exec's hijacked response. Its output is read from the
+// response's Reader, closing it kills the exec.
+type execConn struct {
+	net.Conn
+	out io.Closer
+}
+
+func (c *execConn) Close() error {
+	return c.out.Close()
+}
+
+func (b *containerdBackend) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (dockertypes.HijackedResponse, error) {
+	b.mu.Lock()
+	e, ok := b.execs[execID]
+	if ok {
+		e.running = true
+	}
+	b.mu.Unlock()
+	if !ok {
+		return dockertypes.HijackedResponse{}, fmt.Errorf("no such exec: %v", execID)
+	}
+
+	args := []string{"exec"}
+	removeEnv := func() {}
+	if len(e.options.Env) > 0 {
+		envFile, remove, err := writeEnvFile(e.options.Env)
+		if err != nil {
+			return dockertypes.HijackedResponse{}, err
+		}
+		args, removeEnv = append(args, "--env-file", envFile), remove
+	}
+	if e.options.User != "" {
+		args = append(args, "--user", e.options.User)
+	}
+	if e.options.WorkingDir != "" {
+		args = append(args, "--workdir", e.options.WorkingDir)
+	}
+	args = append(append(args, e.container), e.options.Cmd...)
+	// Not ctx, which only bounds attaching
+	out, err := b.stream(context.Background(), func(code int) {
+		removeEnv()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		e.running, e.exitCode = false, code
+	}, args...)
+	if err != nil {
+		removeEnv()
+		return dockertypes.HijackedResponse{}, err
+	}
+	return dockertypes.HijackedResponse{Conn: &execConn{out: out}, Reader: bufio.NewReader(out)}, nil
+}
+
+func (b *containerdBackend) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.execs[execID]
+	if !ok {
+		return container.ExecInspect{}, fmt.Errorf("no such exec: %v", execID)
+	}
+	if !e.running {
+		delete(b.execs, execID)
+	}
+	return container.ExecInspect{ExecID: execID, ContainerID: e.container, Running: e.running, ExitCode: e.exitCode}, nil
+}
+
+// ImageInspect inspects the image in containerd. Its health check is left out.
+func (b *containerdBackend) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	var inspects []image.InspectResponse
+	err := b.inspect(ctx, "image", []string{imageID}, &inspects)
+	if err != nil {
+		return image.InspectResponse{}, err
+	}
+	if len(inspects) == 0 {
+		return image.InspectResponse{}, fmt.Errorf("no such image: %v", imageID)
+	}
+	// containerd runs no health checks, functions would wait on them forever
+	if inspects[0].Config != nil {
+		inspects[0].Config.Healthcheck = nil
+	}
+	return inspects[0], nil
+}
+
+// NetworkList lists the CNI networks matching the options' name and label filters. Networks
+// are named by their ID.
+func (b *containerdBackend) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	out, err := b.nerdctl(ctx, nil, "network", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Fields(string(out)) {
+		if wanted := options.Filters.Get("name"); len(wanted) == 0 || slices.Contains(wanted, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 || !options.Filters.Contains("label") {
+		var networks []network.Summary
+		for _, name := range names {
+			networks = append(networks, network.Summary{ID: name, Name: name})
+		}
+		return networks, nil
+	}
+
+	// Their labels are only inspected
+	var inspects []network.Inspect
+	err = b.inspect(ctx, "network", names, &inspects)
+	if err != nil {
+		return nil, err
+	}
+	var networks []network.Summary
+	for _, inspect := range inspects {
+		if options.Filters.MatchKVList("label", inspect.Labels) {
+			networks = append(networks, network.Summary{ID: inspect.Name, Name: inspect.Name, Labels: inspect.Labels})
+		}
+	}
+	return networks, nil
+}
+
+// NetworkCreate writes a CNI config of a bridge network with the options' labels.
+func (b *containerdBackend) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	args := []string{"network", "create"}
+	if options.Driver != "" {
+		args = append(args, "--driver", options.Driver)
+	}
+	for _, label := range sortedKeys(options.Labels) {
+		args = append(args, "--label", label+"="+options.Labels[label])
+	}
+	out, err := b.nerdctl(ctx, nil, append(args, name)...)
+	if err != nil {
+		return network.CreateResponse{}, err
+	}
+	return network.CreateResponse{ID: string(bytes.TrimSpace(out))}, nil
+}
+
+// containerdEvent is an event as nerdctl events --format '{{json .}}' writes it.
+type containerdEvent struct {
+	Topic string
+	Event string // JSON of the event
+}
+
+// containerdTask is the container task an event is about.
+type containerdTask struct {
+	ContainerID string `json:"container_id"`
+	ID          string `json:"id"` // Of the exec, if the task is one
+	ExitStatus  int    `json:"exit_status"`
+}
+
+// dockerEvent returns the Docker container event of a line of nerdctl events, if it is one:
+// start as a container's task starts, and die as it exits.
+func dockerEvent(line []byte) (events.Message, bool) {
+	var event containerdEvent
+	if json.Unmarshal(line, &event) != nil {
+		return events.Message{}, false
+	}
+	var task containerdTask
+	if json.Unmarshal([]byte(event.Event), &task) != nil || task.ContainerID == "" {
+		return events.Message{}, false
+	}
+	msg := events.Message{Type: events.ContainerEventType, Actor: events.Actor{ID: task.ContainerID}}
+	switch {
+	case event.Topic == "/tasks/start":
+		msg.Action = events.ActionStart
+	case event.Topic == "/tasks/exit" && (task.ID == "" || task.ID == task.ContainerID):
+		msg.Action = events.ActionDie
+		msg.Actor.Attributes = map[string]string{"exitCode": strconv.Itoa(task.ExitStatus)}
+	default:
+		return events.Message{}, false
+	}
+	return msg, true
+}
+
+// Events streams the start and die events of containers. containerd has no health checks,
+// and events can't be filtered, so those of every container of the namespace are sent.
+func (b *containerdBackend) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message)
+	errs := make(chan error, 1)
+	cmd := exec.CommandContext(ctx, "nerdctl", slices.Concat(b.args, []string{"events", "--format", "{{json .}}"})...)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		errs <- err
+		return msgs, errs
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			msg, ok := dockerEvent(scanner.Bytes())
+			if !ok {
+				continue
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+			}
+		}
+		err := cmd.Wait()
+		if err == nil {
+			err = errors.New("nerdctl events exited")
+		}
+		errs <- err
+	}()
+	return msgs, errs
+}
@@ -0,0 +1,156 @@
+package slrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestCreateArgs(t *testing.T) {
+	pids := int64(64)
+	timeout := 10
+	config := &container.Config{
+		Image:       "slrun-func1",
+		Env:         []string{"LOG_LEVEL=debug"},
+		Labels:      map[string]string{labelInstance: "3f2a1b9c7d4e", labelFunction: "func1"},
+		StopSignal:  "SIGINT",
+		StopTimeout: &timeout,
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: nat.PortMap{
+			"8080/tcp": {{HostIP: "127.0.0.1"}},
+			"9229/tcp": {{HostIP: "127.0.0.1", HostPort: "9229"}},
+		},
+		NetworkMode: "slrun-3f2a1b9c7d4e",
+		Binds:       []string{"/tmp/uploads/func1:/uploads:ro"},
+		Mounts:      []mount.Mount{{Type: mount.TypeVolume, Source: "slrun-3f2a1b9c7d4e-cache-func1-npm", Target: "/slrun/cache/npm"}},
+		Resources:   container.Resources{NanoCPUs: 500_000_000, Memory: 128 << 20, MemorySwap: 128 << 20, PidsLimit: &pids},
+	}
+	want := []string{
+		"create",
+		"--label", "slrun.function=func1",
+		"--label", "slrun.instance=3f2a1b9c7d4e",
+		"--env-file", "/tmp/slrun-env-1",
+		"--publish", "127.0.0.1::8080/tcp",
+		"--publish", "127.0.0.1:9229:9229/tcp",
+		"--network", "slrun-3f2a1b9c7d4e",
+		"--volume", "/tmp/uploads/func1:/uploads:ro",
+		"--mount", "type=volume,source=slrun-3f2a1b9c7d4e-cache-func1-npm,target=/slrun/cache/npm",
+		"--cpus", "0.5",
+		"--memory", "134217728",
+		"--memory-swap", "134217728",
+		"--pids-limit", "64",
+		"--stop-signal", "SIGINT",
+		"--stop-timeout", "10",
+		"slrun-func1",
+	}
+	if got := createArgs(config, hostConfig, "/tmp/slrun-env-1"); !slices.Equal(got, want) {
+		t.Errorf("createArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestDockerEvent(t *testing.T) {
+	tests := []struct {
+		line   string
+		action events.Action
+		code   string
+	}{
+		{`{"Topic":"/tasks/start","Event":"{\"container_id\":\"abc\",\"pid\":42}"}`, events.ActionStart, ""},
+		{`{"Topic":"/tasks/exit","Event":"{\"container_id\":\"abc\",\"id\":\"abc\",\"exit_status\":137}"}`, events.ActionDie, "137"},
+		// An exec exiting, not the container
+		{`{"Topic":"/tasks/exit","Event":"{\"container_id\":\"abc\",\"id\":\"exec1\",\"exit_status\":1}"}`, "", ""},
+		{`{"Topic":"/images/create","Event":"{\"name\":\"docker.io/library/alpine:latest\"}"}`, "", ""},
+		{`not json`, "", ""},
+	}
+	for _, test := range tests {
+		msg, ok := dockerEvent([]byte(test.line))
+		if ok != (test.action != "") || msg.Action != test.action {
+			t.Errorf("dockerEvent(%s) = %v, %v, want %q", test.line, msg.Action, ok, test.action)
+			continue
+		}
+		if ok && (msg.Actor.ID != "abc" || msg.Actor.Attributes["exitCode"] != test.code) {
+			t.Errorf("dockerEvent(%s) = %+v, want container abc exiting %q", test.line, msg.Actor, test.code)
+		}
+	}
+}
+
+func TestValidateContainerd(t *testing.T) {
+	config := &types.Config{Backend: &types.Backend{Engine: BackendContainerd}}
+	if err := validateBackend(config); err != nil || config.Backend.Namespace != containerdNamespace {
+		t.Errorf("validateBackend() = %v, namespace %q, want %q", err, config.Backend.Namespace, containerdNamespace)
+	}
+	config = &types.Config{Backend: &types.Backend{Engine: BackendContainerd, Host: "tcp://containerd:2375"}}
+	if err := validateBackend(config); err == nil {
+		t.Errorf("validateBackend() of a containerd tcp host = nil, want an error")
+	}
+	config = &types.Config{Backend: &types.Backend{Engine: BackendContainerd}, Chaos: &types.Chaos{}}
+	if err := validateBackend(config); err == nil {
+		t.Errorf("validateBackend() of containerd with chaos = nil, want an error")
+	}
+	if got := backendHost(&types.Backend{Engine: BackendContainerd, Host: "unix:///run/containerd/containerd.sock"}); got != "" {
+		t.Errorf("backendHost(containerd) = %q, want Docker's from its env", got)
+	}
+}
+
+func TestExtractBuildContext(t *testing.T) {
+	write := func(headers ...*tar.Header) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, h := range headers {
+			tw.WriteHeader(h)
+			if h.Typeflag == tar.TypeReg {
+				tw.Write([]byte(strings.Repeat("x", int(h.Size))))
+			}
+		}
+		tw.Close()
+		return &buf
+	}
+
+	dir := t.TempDir()
+	err := extractBuildContext(write(
+		&tar.Header{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		&tar.Header{Name: "src/index.js", Typeflag: tar.TypeReg, Mode: 0644, Size: 2},
+	), dir)
+	if err != nil {
+		t.Fatalf("extractBuildContext() = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "src", "index.js")); err != nil || string(data) != "xx" {
+		t.Errorf("extracted src/index.js = %q, %v, want %q", data, err, "xx")
+	}
+
+	for name, context := range map[string]*bytes.Buffer{
+		"outside": write(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}),
+		"symlink": write(
+			&tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+			&tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		),
+	} {
+		if err := extractBuildContext(context, t.TempDir()); err == nil {
+			t.Errorf("extractBuildContext() of a path %s = nil, want an error", name)
+		}
+	}
+}
+
+func TestImageSummaries(t *testing.T) {
+	out := []byte(`{"ID":"sha256:1a","Repository":"slrun-func1","Tag":"latest"}
+{"ID":"sha256:1a","Repository":"slrun-func1","Tag":"v2"}
+{"ID":"sha256:2b","Repository":"<none>","Tag":"<none>"}
+`)
+	got, err := imageSummaries(out)
+	if err != nil {
+		t.Fatalf("imageSummaries() = %v", err)
+	}
+	if len(got) != 2 || !slices.Equal(got[0].RepoTags, []string{"slrun-func1:latest", "slrun-func1:v2"}) || got[1].ID != "sha256:2b" || got[1].RepoTags != nil {
+		t.Errorf("imageSummaries() = %+v", got)
+	}
+}
@@ -0,0 +1,423 @@
+package slrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// Registry Docker Hub credentials are saved under in Docker configs
+const dockerHubAuthServer = "https://index.docker.io/v1/"
+
+// ImageBuild builds an image from buildContext, a tar, with nerdctl build, which needs
+// BuildKit's buildkitd. The context is extracted to a temporary dir for the build. Its output
+// is streamed as Docker streams classic builds'.
+func (b *containerdBackend) ImageBuild(ctx context.Context, buildContext io.Reader, options build.ImageBuildOptions) (build.ImageBuildResponse, error) {
+	dir, err := os.MkdirTemp("", "slrun-build-")
+	if err != nil {
+		return build.ImageBuildResponse{}, err
+	}
+	err = extractBuildContext(buildContext, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return build.ImageBuildResponse{}, fmt.Errorf("cannot extract build context: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer os.RemoveAll(dir)
+		out := &buildStreamWriter{enc: json.NewEncoder(pw)}
+		cmd := exec.CommandContext(ctx, "nerdctl", slices.Concat(b.args, buildArgsOf(dir, options))...)
+		cmd.Stdout, cmd.Stderr = out, out
+		err := cmd.Run()
+		out.flush()
+		if err != nil {
+			msg := "nerdctl build: " + err.Error()
+			out.enc.Encode(jsonmessage.JSONMessage{Error: &jsonmessage.JSONError{Message: msg}, ErrorMessage: msg})
+		}
+		pw.Close()
+	}()
+	return build.ImageBuildResponse{Body: pr}, nil
+}
+
+// buildArgsOf returns the nerdctl arguments building the context extracted to dir with options.
+func buildArgsOf(dir string, options build.ImageBuildOptions) []string {
+	dockerfile := filepath.Join(dir, filepath.FromSlash(cmp.Or(options.Dockerfile, "Dockerfile")))
+	args := []string{"build", "--progress", "plain", "--file", dockerfile}
+	for _, tag := range options.Tags {
+		args = append(args, "--tag", tag)
+	}
+	for _, name := range sortedKeys(options.BuildArgs) {
+		if value := options.BuildArgs[name]; value != nil {
+			args = append(args, "--build-arg", name+"="+*value)
+		}
+	}
+	for _, name := range sortedKeys(options.Labels) {
+		args = append(args, "--label", name+"="+options.Labels[name])
+	}
+	if options.Target != "" {
+		args = append(args, "--target", options.Target)
+	}
+	if options.NoCache {
+		args = append(args, "--no-cache")
+	}
+	return append(args, dir)
+}
+
+// buildStreamWriter writes each line of build output as a stream message.
+type buildStreamWriter struct {
+	enc  *json.Encoder
+	line []byte
+}
+
+func (w *buildStreamWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		err := w.enc.Encode(jsonmessage.JSONMessage{Stream: string(w.line[:i+1])})
+		w.line = w.line[i+1:]
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// flush writes the last line, if it has no line break.
+func (w *buildStreamWriter) flush() {
+	if len(w.line) > 0 {
+		w.enc.Encode(jsonmessage.JSONMessage{Stream: string(w.line) + "\n"})
+		w.line = nil
+	}
+}
+
+// extractBuildContext extracts the tar build context to dir. Its files are only written
+// inside dir, never through its symlinks.
+func extractBuildContext(r io.Reader, dir string) error {
+	links := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("path outside the context: %v", header.Name)
+		}
+		for parent := filepath.Dir(name); parent != "."; parent = filepath.Dir(parent) {
+			if links[parent] {
+				return fmt.Errorf("path through a symlink: %v", header.Name)
+			}
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+			if err == nil {
+				err = writeContextFile(path, header.FileInfo().Mode().Perm(), tr)
+			}
+		case tar.TypeSymlink:
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+			if err == nil {
+				err = os.Symlink(header.Linkname, path)
+			}
+			links[name] = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeContextFile(path string, mode os.FileMode, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// imageListEntry is an image as nerdctl images --format '{{json .}}' writes it.
+type imageListEntry struct {
+	ID         string
+	Repository string
+	Tag        string
+}
+
+// ImageList lists images matching the options' reference filters.
+func (b *containerdBackend) ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error) {
+	args := []string{"images", "--no-trunc", "--format", "{{json .}}"}
+	for _, ref := range options.Filters.Get("reference") {
+		args = append(args, "--filter", "reference="+ref)
+	}
+	out, err := b.nerdctl(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return imageSummaries(out)
+}
+
+// imageSummaries returns the images nerdctl images lists in out, one JSON entry per tag.
+func imageSummaries(out []byte) ([]image.Summary, error) {
+	var summaries []image.Summary
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry imageListEntry
+		err := json.Unmarshal(line, &entry)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(summaries, func(s image.Summary) bool { return s.ID == entry.ID })
+		if i < 0 {
+			summaries = append(summaries, image.Summary{ID: entry.ID})
+			i = len(summaries) - 1
+		}
+		if entry.Repository != "" && entry.Repository != "<none>" {
+			summaries[i].RepoTags = append(summaries[i].RepoTags, entry.Repository+":"+cmp.Or(entry.Tag, "latest"))
+		}
+	}
+	return summaries, nil
+}
+
+// ImageLoad loads the images of a tar archive, as docker save writes them, into containerd.
+func (b *containerdBackend) ImageLoad(ctx context.Context, input io.Reader, loadOpts ...client.ImageLoadOption) (image.LoadResponse, error) {
+	out, err := b.nerdctl(ctx, input, "load")
+	if err != nil {
+		return image.LoadResponse{}, err
+	}
+	return image.LoadResponse{Body: io.NopCloser(bytes.NewReader(out))}, nil
+}
+
+// ImageSave returns a tar archive of the images, as docker save writes them.
+func (b *containerdBackend) ImageSave(ctx context.Context, imageIDs []string, saveOpts ...client.ImageSaveOption) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, "nerdctl", slices.Concat(b.args, []string{"save"}, imageIDs)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &commandReader{Reader: stdout, cmd: cmd, stderr: &stderr, cancel: cancel}, nil
+}
+
+// commandReader reads the output of nerdctl save, failing at its end if nerdctl fails.
+type commandReader struct {
+	io.Reader
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	cancel context.CancelFunc
+	done   bool
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("nerdctl save: %w: %s", waitErr, bytes.TrimSpace(r.stderr.Bytes()))
+		}
+	}
+	return n, err
+}
+
+func (r *commandReader) Close() error {
+	r.cancel()
+	if !r.done {
+		r.done = true
+		r.cmd.Wait()
+	}
+	return nil
+}
+
+// ImagePull pulls the image into containerd with the options' credentials, written to a Docker
+// config of the pull only. Its progress isn't streamed.
+func (b *containerdBackend) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, "nerdctl", slices.Concat(b.args, []string{"pull", "--quiet", refStr})...)
+	if options.RegistryAuth != "" {
+		configDir, err := writeDockerConfig(options.RegistryAuth)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(configDir)
+		cmd.Env = append(os.Environ(), "DOCKER_CONFIG="+configDir)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("nerdctl pull: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
+// writeDockerConfig writes the encoded credentials to the Docker config of a new dir only
+// its owner reads, returning the dir.
+func writeDockerConfig(encodedAuth string) (string, error) {
+	auth, err := registry.DecodeAuthConfig(encodedAuth)
+	if err != nil {
+		return "", err
+	}
+	server := auth.ServerAddress
+	if registryHost(server) == "docker.io" {
+		server = dockerHubAuthServer
+	}
+	entry := map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))}
+	if auth.IdentityToken != "" {
+		entry = map[string]string{"identitytoken": auth.IdentityToken}
+	}
+	data, err := json.Marshal(map[string]any{"auths": map[string]any{server: entry}})
+	if err != nil {
+		return "", err
+	}
+	// Created 0700
+	dir, err := os.MkdirTemp("", "slrun-docker-config-")
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func (b *containerdBackend) ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error) {
+	args := []string{"rmi"}
+	if options.Force {
+		args = append(args, "--force")
+	}
+	_, err := b.nerdctl(ctx, nil, append(args, imageID)...)
+	return nil, err
+}
+
+func (b *containerdBackend) ImageTag(ctx context.Context, source, target string) error {
+	_, err := b.nerdctl(ctx, nil, "tag", source, target)
+	return err
+}
+
+// DistributionInspect isn't supported: nerdctl can't read a manifest from a registry without
+// pulling the image.
+func (b *containerdBackend) DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	return registry.DistributionInspect{}, fmt.Errorf("cannot inspect %v in its registry with containerd: %w", imageRef, errors.ErrUnsupported)
+}
+
+func (b *containerdBackend) ContainerKill(ctx context.Context, containerID, signal string) error {
+	_, err := b.nerdctl(ctx, nil, "kill", "--signal", cmp.Or(signal, "SIGKILL"), containerID)
+	return err
+}
+
+// ContainerWait waits for the container to stop, whatever the condition.
+func (b *containerdBackend) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	resps := make(chan container.WaitResponse, 1)
+	errs := make(chan error, 1)
+	go func() {
+		out, err := b.nerdctl(ctx, nil, "wait", containerID)
+		if err != nil {
+			errs <- err
+			return
+		}
+		code, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+		if err != nil {
+			errs <- fmt.Errorf("nerdctl wait: unexpected exit code %q", out)
+			return
+		}
+		resps <- container.WaitResponse{StatusCode: code}
+	}()
+	return resps, errs
+}
+
+func (b *containerdBackend) NetworkRemove(ctx context.Context, networkID string) error {
+	_, err := b.nerdctl(ctx, nil, "network", "rm", networkID)
+	return err
+}
+
+// VolumeList lists the volumes matching the options' label filters.
+func (b *containerdBackend) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	args := []string{"volume", "ls", "--quiet"}
+	for _, label := range options.Filters.Get("label") {
+		args = append(args, "--filter", "label="+label)
+	}
+	out, err := b.nerdctl(ctx, nil, args...)
+	if err != nil {
+		return volume.ListResponse{}, err
+	}
+	names := strings.Fields(string(out))
+	if len(names) == 0 {
+		return volume.ListResponse{}, nil
+	}
+	out, err = b.nerdctl(ctx, nil, append([]string{"volume", "inspect"}, names...)...)
+	if err != nil {
+		return volume.ListResponse{}, err
+	}
+	var volumes []*volume.Volume
+	err = json.Unmarshal(out, &volumes)
+	if err != nil {
+		return volume.ListResponse{}, err
+	}
+	return volume.ListResponse{Volumes: volumes}, nil
+}
+
+func (b *containerdBackend) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	args := []string{"volume", "rm"}
+	if force {
+		args = append(args, "--force")
+	}
+	_, err := b.nerdctl(ctx, nil, append(args, volumeID)...)
+	return err
+}
+
+// BuildCachePrune prunes BuildKit's cache, all of it with All. nerdctl can't filter what it
+// prunes, so filtered prunes aren't supported.
+func (b *containerdBackend) BuildCachePrune(ctx context.Context, opts build.CachePruneOptions) (*build.CachePruneReport, error) {
+	if opts.Filters.Len() > 0 {
+		return nil, fmt.Errorf("cannot prune filtered build cache with containerd: %w", errors.ErrUnsupported)
+	}
+	args := []string{"builder", "prune", "--force"}
+	if opts.All {
+		args = append(args, "--all")
+	}
+	_, err := b.nerdctl(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &build.CachePruneReport{}, nil
+}
@@ -0,0 +1,127 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// functionByContainer returns the function or instance running in a container, if any.
+func (r *Runtime) functionByContainer(id string) *types.Function {
+	for _, f := range r.functions {
+		if f.IsRunning && f.ContainerId == id {
+			return f
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, instance := range r.instances {
+		if instance.IsRunning && instance.ContainerId == id {
+			return instance
+		}
+	}
+	return nil
+}
+
+// refreshPort re-inspects the function's container for its host port,
+// which changes when Docker restarts the container. Returns whether it changed.
+func (r *Runtime) refreshPort(function *types.Function) bool {
+	if !function.IsRunning || function.SocketPath != "" {
+		return false
+	}
+	port, err := r.containerPort(context.Background(), function)
+	if err != nil {
+		log.Printf("Cannot inspect function %v container port: %v\n", function.Name, err)
+		return false
+	}
+	if port == function.Port {
+		return false
+	}
+	log.Printf("Function %v container port changed %v => %v\n", function.Name, function.Port, port)
+	function.Port = port
+	return true
+}
+
+// refreshPorts refreshes the ports of all running functions and instances.
+func (r *Runtime) refreshPorts() {
+	for _, f := range r.functions {
+		r.refreshPort(f)
+	}
+	r.mu.Lock()
+	var instances []*types.Function
+	for _, instance := range r.instances {
+		instances = append(instances, instance)
+	}
+	r.mu.Unlock()
+	for _, instance := range instances {
+		r.refreshPort(instance)
+	}
+}
+
+// watchContainers refreshes function ports when Docker restarts their containers,
+// by restart policy or after a daemon restart, follows their health and handles them dying, until ctx is done.
+func (r *Runtime) watchContainers(ctx context.Context) {
+	options := dockerevents.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", "container"),
+			instanceFilter(r.instance),
+			filters.Arg("event", "start"),
+			filters.Arg("event", "die"),
+			filters.Arg("event", string(dockerevents.ActionHealthStatus)),
+		),
+	}
+	for {
+		msgs, errs := r.cli.Events(ctx, options)
+	watch:
+		for {
+			select {
+			case msg := <-msgs:
+				if msg.Action == dockerevents.ActionDie {
+					if _, stopped := r.stopping.LoadAndDelete(msg.Actor.ID); stopped {
+						continue
+					}
+				}
+				f := r.functionByContainer(msg.Actor.ID)
+				if f == nil {
+					continue
+				}
+				if status, ok := healthStatus(msg); ok {
+					r.healthChanged(f, status)
+				} else if msg.Action == dockerevents.ActionDie {
+					exitCode, _ := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+					r.containerDied(ctx, f, exitCode)
+				} else if msg.Action == dockerevents.ActionStart {
+					r.refreshPort(f)
+				}
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Docker events stream failed, reconnecting: %v\n", err)
+				break watch
+			}
+		}
+
+		// The daemon may be restarting, retry until it is back
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		// Containers restarted while disconnected weren't seen
+		r.refreshPorts()
+	}
+}
+
+// isDialError reports whether err is a failure to connect, before any of a request was sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,164 @@
+package slrun
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// contextPattern is a .dockerignore pattern: a path relative to the build dir whose
+// segments are matched with path.Match, where ** matches any number of segments.
+type contextPattern struct {
+	segments  []string
+	exception bool // Starts with !, sending what earlier patterns leave out
+}
+
+// parseContextPatterns parses .dockerignore style patterns, skipping blank lines and # comments.
+func parseContextPatterns(lines []string) ([]*contextPattern, error) {
+	var patterns []*contextPattern
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := &contextPattern{}
+		if strings.HasPrefix(line, "!") {
+			p.exception = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = path.Clean("/" + filepath.ToSlash(line))[1:]
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(line, "/")
+		for _, s := range p.segments {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", line, err)
+			}
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// matches reports whether the pattern matches rel, a slash separated path, or one of its parents.
+func (p *contextPattern) matches(rel string) bool {
+	segments := strings.Split(rel, "/")
+	for i := len(segments); i > 0; i-- {
+		if matchSegments(p.segments, segments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// contextFilter selects the files of a function's build dir sent as its build context.
+type contextFilter struct {
+	always        []string          // Sent whatever the patterns, as the daemon needs them: the Dockerfile and .dockerignore
+	excludes      []*contextPattern // From .dockerignore, then the function's context_exclude
+	includes      []*contextPattern // The function's context_include, everything if empty
+	hasExceptions bool
+}
+
+// newContextFilter returns the filter of the function's build context, from its
+// build dir's .dockerignore and its context_include and context_exclude.
+func newContextFilter(function *types.Function) (*contextFilter, error) {
+	var lines []string
+	file, err := os.Open(filepath.Join(function.BuildDir, ".dockerignore"))
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	f := &contextFilter{always: []string{path.Clean(filepath.ToSlash(cmp.Or(function.Dockerfile, "Dockerfile"))), ".dockerignore"}}
+	f.excludes, err = parseContextPatterns(append(lines, function.ContextExclude...))
+	if err != nil {
+		return nil, fmt.Errorf("function %v build context: %w", function.Name, err)
+	}
+	f.includes, err = parseContextPatterns(function.ContextInclude)
+	if err != nil {
+		return nil, fmt.Errorf("function %v build context: %w", function.Name, err)
+	}
+	for _, p := range f.excludes {
+		f.hasExceptions = f.hasExceptions || p.exception
+	}
+	return f, nil
+}
+
+// excluded reports whether rel is left out by the exclude patterns, the last matching
+// pattern deciding.
+func (f *contextFilter) excluded(rel string) bool {
+	excluded := false
+	for _, p := range f.excludes {
+		if p.matches(rel) {
+			excluded = !p.exception
+		}
+	}
+	return excluded
+}
+
+// included reports whether rel is matched by an include pattern, or there are none.
+func (f *contextFilter) included(rel string) bool {
+	if len(f.includes) == 0 {
+		return true
+	}
+	for _, p := range f.includes {
+		if p.matches(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// send reports whether rel is sent in the build context. For directories, it also reports
+// whether the walk should descend into it: excluded directories are skipped unless exception
+// patterns may send files in them again, or they hold the Dockerfile.
+func (f *contextFilter) send(rel string, dir bool) (send bool, descend bool) {
+	if !dir && slices.Contains(f.always, rel) {
+		return true, false
+	}
+	excluded := f.excluded(rel)
+	send = !excluded && f.included(rel)
+	if dir {
+		descend = !excluded || f.hasExceptions || slices.ContainsFunc(f.always, func(p string) bool {
+			return strings.HasPrefix(p, rel+"/")
+		})
+	}
+	return send, descend
+}
@@ -0,0 +1,145 @@
+package slrun
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestContextPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"node_modules", "node_modules", true},
+		{"node_modules", "node_modules/left-pad/index.js", true},
+		{"node_modules", "src/node_modules", false},
+		{"**/node_modules", "src/node_modules/x.js", true},
+		{"*.log", "debug.log", true},
+		{"*.log", "logs/debug.log", false},
+		{"**/*.log", "logs/debug.log", true},
+		{"/build/", "build/out", true},
+		{"src/**/*.go", "src/a/b/c.go", true},
+		{"src/**/*.go", "src/c.go", true},
+		{"src/**/*.go", "src/c.js", false},
+	}
+
+	for _, tt := range tests {
+		patterns, err := parseContextPatterns([]string{tt.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := patterns[0].matches(tt.path); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := parseContextPatterns([]string{"[a-"}); err == nil {
+		t.Errorf("parseContextPatterns() accepted an invalid pattern")
+	}
+}
+
+func TestCreateTarContext(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Dockerfile":               "FROM alpine",
+		".dockerignore":            "# junk\n.git\nnode_modules\n!node_modules/keep\n*.log\nDockerfile\n",
+		"main.go":                  "package main",
+		"debug.log":                "",
+		"docs/README.md":           "",
+		".git/HEAD":                "",
+		"node_modules/x/index.js":  "",
+		"node_modules/keep/a.js":   "",
+		"secrets/dev.env":          "",
+		"secrets/template.env.txt": "",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		os.WriteFile(file, []byte(content), 0644)
+	}
+	symlinks := true
+	if err := os.Symlink("main.go", filepath.Join(dir, "link.go")); err != nil {
+		symlinks = false // E.g. Windows without privileges
+	}
+
+	fun := &types.Function{Name: "func1", BuildDir: dir, ContextExclude: []string{"secrets", "!secrets/*.txt", "docs"}}
+	tarCtx, err := createTarContext(fun)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	links := map[string]string{}
+	tr := tar.NewReader(tarCtx)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			links[header.Name] = header.Linkname
+		}
+		if header.Typeflag != tar.TypeDir {
+			names = append(names, header.Name)
+		}
+	}
+	slices.Sort(names)
+
+	want := []string{".dockerignore", "Dockerfile", "main.go", "node_modules/keep/a.js", "secrets/template.env.txt"}
+	if symlinks {
+		want = append(want, "link.go")
+		slices.Sort(want)
+		if links["link.go"] != "main.go" {
+			t.Errorf("link.go links to %q, want main.go", links["link.go"])
+		}
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("createTarContext() files = %q, want %q", names, want)
+	}
+
+	fun.ContextInclude = []string{"*.go"}
+	tarCtx, err = createTarContext(fun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = nil
+	tr = tar.NewReader(tarCtx)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		if header.Typeflag != tar.TypeDir {
+			names = append(names, header.Name)
+		}
+	}
+	if slices.Contains(names, "node_modules/keep/a.js") || !slices.Contains(names, "main.go") || !slices.Contains(names, "Dockerfile") {
+		t.Errorf("createTarContext() with context_include = %q", names)
+	}
+
+	// A Dockerfile elsewhere is sent even from an excluded dir
+	os.WriteFile(filepath.Join(dir, "docs/Dockerfile.prod"), []byte("FROM alpine"), 0644)
+	fun.ContextInclude = nil
+	fun.Dockerfile = "docs/Dockerfile.prod"
+	tarCtx, err = createTarContext(fun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = nil
+	tr = tar.NewReader(tarCtx)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		if header.Typeflag != tar.TypeDir {
+			names = append(names, header.Name)
+		}
+	}
+	if !slices.Contains(names, "docs/Dockerfile.prod") || slices.Contains(names, "docs/README.md") {
+		t.Errorf("createTarContext() with dockerfile docs/Dockerfile.prod = %q", names)
+	}
+}
@@ -0,0 +1,226 @@
+package slrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/policy"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// A function failing this many times within crashLoopWindow is crash looping
+const (
+	crashLoopFailures = 3
+	crashLoopWindow   = 5 * time.Minute
+)
+
+// A crash looping function isn't started for a back-off, doubled each time it loops again
+// within crashLoopWindow of the last back-off ending
+const (
+	crashLoopBackoff    = 10 * time.Second
+	crashLoopMaxBackoff = 5 * time.Minute
+)
+
+// Exit codes kept of a function's containers that died
+const recentExitCodes = 5
+
+// functionFailures is the failure history of a function or instance.
+type functionFailures struct {
+	recent     []time.Time   // Failures within crashLoopWindow
+	restarts   int           // Containers started after one died or failed to start
+	restarting bool          // Failed since its container last started
+	exitCodes  []int         // Of its containers that died, latest last
+	reason     string        // Of its latest failure
+	backoff    time.Duration // Of its latest crash loop
+	retryAt    time.Time     // It isn't started until then
+}
+
+// startFailures tracks failures of functions to start or stay up, to detect crash loops.
+type startFailures struct {
+	mu       sync.Mutex
+	failures map[string]*functionFailures
+}
+
+// get returns the failure history of function. Must hold s.mu.
+func (s *startFailures) get(function string) *functionFailures {
+	if s.failures == nil {
+		s.failures = make(map[string]*functionFailures)
+	}
+	f, exists := s.failures[function]
+	if !exists {
+		f = &functionFailures{}
+		s.failures[function] = f
+	}
+	return f
+}
+
+// add records a failure of function and returns whether it is crash looping, and its back-off.
+// The failures are then forgotten, so a crash loop is reported once per crashLoopFailures.
+func (s *startFailures) add(function string, reason string) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.get(function)
+	f.reason = reason
+	f.restarting = true
+
+	now := time.Now()
+	var recent []time.Time
+	for _, t := range f.recent {
+		if now.Sub(t) < crashLoopWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) < crashLoopFailures {
+		f.recent = recent
+		return false, 0
+	}
+	f.recent = nil
+	if f.retryAt.IsZero() || now.Sub(f.retryAt) > crashLoopWindow {
+		f.backoff = crashLoopBackoff
+	} else {
+		f.backoff = min(f.backoff*2, crashLoopMaxBackoff)
+	}
+	f.retryAt = now.Add(f.backoff)
+	return true, f.backoff
+}
+
+// exited records the exit code of a container of function that died.
+func (s *startFailures) exited(function string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.get(function)
+	f.exitCodes = append(f.exitCodes, code)
+	if len(f.exitCodes) > recentExitCodes {
+		f.exitCodes = f.exitCodes[1:]
+	}
+}
+
+// started records a container of function starting, a restart if it had failed.
+func (s *startFailures) started(function string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.get(function)
+	if f.restarting {
+		f.restarts++
+		f.restarting = false
+	}
+}
+
+// backingOff returns when function may be started again and why, if it is crash looping.
+func (s *startFailures) backingOff(function string) (time.Time, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, exists := s.failures[function]
+	if !exists || time.Now().After(f.retryAt) {
+		return time.Time{}, "", false
+	}
+	return f.retryAt, f.reason, true
+}
+
+// crashLoopError returns an error if function is crash looping and mustn't be started yet.
+func (r *Runtime) crashLoopError(function *types.Function) error {
+	retryAt, reason, looping := r.startFailures.backingOff(function.Name)
+	if !looping {
+		return nil
+	}
+	return fmt.Errorf("function %v is crash looping, retrying in %v: %v", function.Name, time.Until(retryAt).Round(time.Second), reason)
+}
+
+// functionStartFailed publishes a start failure of function, and a crash loop if it keeps failing.
+func (r *Runtime) functionStartFailed(function *types.Function, err error) {
+	r.events.Publish(Event{
+		Type:     EventStartFailed,
+		Function: function.Name,
+		Data:     map[string]any{"error": err.Error()},
+	})
+	r.functionFailed(function, err.Error())
+}
+
+// functionFailed records a failure of function, and publishes a crash loop if it keeps failing.
+func (r *Runtime) functionFailed(function *types.Function, reason string) {
+	looping, backoff := r.startFailures.add(function.Name, reason)
+	if !looping {
+		return
+	}
+	log.Printf("Function %v is crash looping, backing off for %v: %v\n", function.Name, backoff, reason)
+	r.events.Publish(Event{
+		Type:     EventCrashLoop,
+		Function: function.Name,
+		Data: map[string]any{
+			"error":    reason,
+			"failures": crashLoopFailures,
+			"window":   crashLoopWindow.String(),
+			"backoff":  backoff.String(),
+		},
+	})
+}
+
+// containerDied handles the function's container exiting while slrun wasn't stopping it.
+// The always_hot policy's functions are restarted, after their back-off if crash looping,
+// others are started on their next call.
+func (r *Runtime) containerDied(ctx context.Context, function *types.Function, exitCode int) {
+	log.Printf("Function %v container %v exited with code %v\n", function.Name, function.ContainerId, exitCode)
+	r.events.Publish(Event{
+		Type:     EventExited,
+		Function: function.Name,
+		Data:     map[string]any{"container_id": function.ContainerId, "exit_code": exitCode},
+	})
+	r.containerStopped(function)
+	r.startFailures.exited(function.Name, exitCode)
+	r.functionFailed(function, fmt.Sprintf("container exited with code %d", exitCode))
+
+	if _, alwaysHot := r.policy.(*policy.AlwaysHot); alwaysHot {
+		go r.restartDied(ctx, function)
+	}
+}
+
+// restartDied starts the function again once it may, until it starts or ctx is done.
+func (r *Runtime) restartDied(ctx context.Context, function *types.Function) {
+	for {
+		wait := time.Second
+		if retryAt, _, looping := r.startFailures.backingOff(function.Name); looping {
+			wait = time.Until(retryAt)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if function.IsRunning || !function.IsEnabled {
+			return
+		}
+
+		err := r.startFunction(function)
+		if err == nil {
+			log.Printf("Restarted function %v\n", function.Name)
+			return
+		}
+		r.functionStartFailed(function, err)
+		log.Printf("Cannot restart function %v: %v\n", function.Name, err)
+	}
+}
+
+// setFailureState adds the function's restarts and recent exit codes to its state,
+// and marks it crash looping while it backs off.
+func (r *Runtime) setFailureState(state *FunctionState) {
+	r.startFailures.mu.Lock()
+	defer r.startFailures.mu.Unlock()
+	f, exists := r.startFailures.failures[state.Name]
+	if !exists {
+		return
+	}
+	state.Restarts = f.restarts
+	state.ExitCodes = slices.Clone(f.exitCodes)
+	if state.State == StateStopped && time.Now().Before(f.retryAt) {
+		state.State = StateCrashLoop
+		state.Reason = f.reason
+		retryAt := f.retryAt
+		state.RetryAt = &retryAt
+	}
+}
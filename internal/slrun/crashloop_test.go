@@ -0,0 +1,53 @@
+package slrun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashLoopBackoff(t *testing.T) {
+	var s startFailures
+	loop := func() (bool, time.Duration) {
+		for range crashLoopFailures - 1 {
+			if looping, _ := s.add("func1", "exited"); looping {
+				t.Fatalf("add() under %v failures = looping", crashLoopFailures)
+			}
+		}
+		return s.add("func1", "exited")
+	}
+	// The back-off ends now, as if the function was started again and failed once more
+	endBackoff := func(ago time.Duration) {
+		s.failures["func1"].retryAt = time.Now().Add(-ago)
+	}
+
+	looping, backoff := loop()
+	if !looping || backoff != crashLoopBackoff {
+		t.Fatalf("add() = %v %v, want looping for %v", looping, backoff, crashLoopBackoff)
+	}
+	retryAt, reason, backingOff := s.backingOff("func1")
+	if !backingOff || reason != "exited" || time.Until(retryAt) > crashLoopBackoff || time.Until(retryAt) < crashLoopBackoff-time.Second {
+		t.Errorf("backingOff() = %v %q %v, want retrying in %v", retryAt, reason, backingOff, crashLoopBackoff)
+	}
+
+	// Looping again soon after a back-off doubles it, up to the max
+	want := crashLoopBackoff
+	for range 8 {
+		endBackoff(time.Second)
+		if _, _, backingOff := s.backingOff("func1"); backingOff {
+			t.Fatalf("backingOff() after the back-off = true")
+		}
+		want = min(want*2, crashLoopMaxBackoff)
+		if _, backoff := loop(); backoff != want {
+			t.Errorf("add() looping again = %v, want %v", backoff, want)
+		}
+	}
+	if want != crashLoopMaxBackoff {
+		t.Errorf("back-off after 8 loops = %v, want the max %v", want, crashLoopMaxBackoff)
+	}
+
+	// Looping long after the last back-off starts over
+	endBackoff(crashLoopWindow + time.Second)
+	if _, backoff := loop(); backoff != crashLoopBackoff {
+		t.Errorf("add() looping after the window = %v, want %v", backoff, crashLoopBackoff)
+	}
+}
@@ -0,0 +1,102 @@
+package slrun
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// pidFile returns where the running daemon of a state dir keeps its process ID.
+func pidFile(stateDir string) string {
+	return filepath.Join(stateDir, "slrun.pid")
+}
+
+// DaemonLogFile returns where a detached daemon of the state dir writes its output.
+func DaemonLogFile(stateDir string) string {
+	return filepath.Join(stateDir, "slrun.log")
+}
+
+// processAlive reports whether the process with pid is running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// DaemonPID returns the process ID of the daemon running with the state dir, or 0 if none is.
+func DaemonPID(stateDir string) (int, error) {
+	content, err := os.ReadFile(pidFile(stateDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %v: %w", pidFile(stateDir), err)
+	}
+	if !processAlive(pid) {
+		return 0, nil // Left by a daemon that didn't stop cleanly
+	}
+	return pid, nil
+}
+
+// writePIDFile records this process as the daemon of the state dir, failing if another one runs.
+func writePIDFile(stateDir string) error {
+	pid, err := DaemonPID(stateDir)
+	if err != nil {
+		return err
+	}
+	if pid != 0 && pid != os.Getpid() {
+		return fmt.Errorf("slrun is already running with state dir %v (pid %v)", stateDir, pid)
+	}
+	err = os.MkdirAll(stateDir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pidFile(stateDir), []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+func removePIDFile(stateDir string) {
+	os.Remove(pidFile(stateDir))
+}
+
+// StopDaemon interrupts the daemon running with the config's state dir and waits up to
+// timeout for it to shut down.
+func StopDaemon(config *types.Config, timeout time.Duration) error {
+	pid, err := DaemonPID(config.StateDir)
+	if err != nil {
+		return err
+	}
+	if pid == 0 {
+		return fmt.Errorf("slrun isn't running with state dir %v", config.StateDir)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	err = p.Signal(syscall.SIGTERM)
+	if err != nil {
+		return fmt.Errorf("cannot stop slrun (pid %v): %w", pid, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("slrun (pid %v) didn't stop within %v", pid, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
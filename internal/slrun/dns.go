@@ -0,0 +1,179 @@
+package slrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/miekg/dns"
+)
+
+// Seconds resolvers may cache answers, short since functions come and go with the config
+const dnsTTL = 5
+
+func validateDNS(config *types.Config) error {
+	d := config.DNS
+	if d == nil {
+		return nil
+	}
+	if d.Address == "" {
+		d.Address = "127.0.0.1:1053"
+	}
+	if _, _, err := net.SplitHostPort(d.Address); err != nil {
+		return fieldError("dns.address", "invalid dns address: %w", err)
+	}
+	if d.Domain == "" {
+		d.Domain = "slrun.local"
+	}
+	d.Domain = strings.ToLower(strings.Trim(d.Domain, "."))
+	if _, ok := dns.IsDomainName(d.Domain); !ok {
+		return fieldError("dns.domain", "invalid dns domain: %s", d.Domain)
+	}
+	if d.IP == "" {
+		d.IP = "127.0.0.1"
+	}
+	if net.ParseIP(d.IP) == nil {
+		return fieldError("dns.ip", "invalid dns ip: %s", d.IP)
+	}
+	return nil
+}
+
+// hostFunction returns the function named by a request's host, <function>.<domain>, if it
+// is under the DNS domain.
+func hostFunction(config *types.DNS, host string) (string, bool) {
+	if config == nil {
+		return "", false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	name, ok := strings.CutSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), "."+config.Domain)
+	if !ok || name == "" || strings.Contains(name, ".") {
+		return "", false
+	}
+	return name, true
+}
+
+// DNSServer resolves <function>.<domain> names to the gateway, so functions can be called
+// by hostname during development.
+type DNSServer struct {
+	config  *types.DNS
+	gateway *Gateway
+	ip      net.IP
+	udp     *dns.Server
+	tcp     *dns.Server
+}
+
+func NewDNSServer(config *types.DNS, gateway *Gateway) *DNSServer {
+	if config == nil {
+		return nil
+	}
+	return &DNSServer{config: config, gateway: gateway, ip: net.ParseIP(config.IP)}
+}
+
+// Start serves DNS over UDP and TCP on the configured address.
+func (s *DNSServer) Start() error {
+	if s == nil {
+		return nil
+	}
+	mux := dns.NewServeMux()
+	mux.HandleFunc(dns.Fqdn(s.config.Domain), s.handle)
+
+	pc, err := net.ListenPacket("udp", s.config.Address)
+	if err != nil {
+		return fmt.Errorf("cannot listen for dns: %w", err)
+	}
+	l, err := net.Listen("tcp", s.config.Address)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("cannot listen for dns: %w", err)
+	}
+	s.udp = &dns.Server{PacketConn: pc, Handler: mux}
+	s.tcp = &dns.Server{Listener: l, Handler: mux}
+	for _, server := range []*dns.Server{s.udp, s.tcp} {
+		go func() {
+			if err := server.ActivateAndServe(); err != nil {
+				log.Printf("DNS server failed: %v\n", err)
+			}
+		}()
+	}
+	fmt.Printf("DNS listening on %v for *.%v\n", s.config.Address, s.config.Domain)
+	return nil
+}
+
+func (s *DNSServer) Shutdown(ctx context.Context) error {
+	if s == nil || s.udp == nil {
+		return nil
+	}
+	err := s.udp.ShutdownContext(ctx)
+	if err2 := s.tcp.ShutdownContext(ctx); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// resolves reports whether name is the domain itself or one of its functions.
+func (s *DNSServer) resolves(name string) bool {
+	if name == s.config.Domain {
+		return true
+	}
+	function, ok := hostFunction(s.config, name)
+	if !ok {
+		return false
+	}
+	return s.gateway.runtime.FunctionByName(function) != nil || s.gateway.peers.route(function) != nil
+}
+
+func (s *DNSServer) handle(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+
+	for _, q := range req.Question {
+		if !s.resolves(strings.ToLower(strings.TrimSuffix(q.Name, "."))) {
+			m.Rcode = dns.RcodeNameError
+			continue
+		}
+
+		// Names of the other address family exist but have no records
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: dnsTTL}
+		ip4 := s.ip.To4()
+		switch {
+		case q.Qtype == dns.TypeA && ip4 != nil:
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip4})
+		case q.Qtype == dns.TypeAAAA && ip4 == nil:
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: s.ip})
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// hostsEntries returns /etc/hosts lines for the domain and its functions, for systems
+// that can't be pointed at the DNS server.
+func (s *DNSServer) hostsEntries() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v\n", s.config.IP, s.config.Domain)
+	for _, f := range s.gateway.runtime.functions {
+		fmt.Fprintf(&b, "%v %v.%v\n", s.config.IP, f.Name, s.config.Domain)
+	}
+	for _, p := range s.gateway.config.Peers {
+		for _, name := range p.Functions {
+			fmt.Fprintf(&b, "%v %v.%v\n", s.config.IP, name, s.config.Domain)
+		}
+	}
+	return b.String()
+}
+
+// hostsHandler serves the hosts file entries of the DNS names.
+func (s *DNSServer) hostsHandler(w http.ResponseWriter, r *http.Request) {
+	if s == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("dns is disabled"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, s.hostsEntries())
+}
@@ -0,0 +1,38 @@
+package slrun
+
+import (
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestHostFunction(t *testing.T) {
+	config := &types.DNS{Domain: "slrun.local"}
+
+	tests := []struct {
+		host string
+		want string // Empty if the host names no function
+	}{
+		{"func1.slrun.local", "func1"},
+		{"func1.slrun.local:1337", "func1"},
+		{"Func1.SLRUN.local.", "func1"},
+		{"slrun.local", ""},
+		{"a.func1.slrun.local", ""},
+		{"func1.example.com", ""},
+		{"localhost:1337", ""},
+		{"[::1]:1337", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, ok := hostFunction(config, tt.host)
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("hostFunction(%q) = %q, %v, want %q", tt.host, got, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := hostFunction(nil, "func1.slrun.local"); ok {
+		t.Errorf("hostFunction() routed by host with dns disabled")
+	}
+}
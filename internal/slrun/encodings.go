@@ -0,0 +1,189 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Encoding encodes admin API responses in a media type, negotiated through the Accept header.
+type Encoding struct {
+	MediaTypes []string // The first is the response's Content-Type
+	Encode     func(w io.Writer, v any) error
+}
+
+// Admin API response encodings by name
+var encodings = map[string]*Encoding{
+	"json":    {MediaTypes: []string{"application/json"}, Encode: encodeJSON},
+	"msgpack": {MediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, Encode: encodeMsgpack},
+}
+
+func encodeJSON(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// negotiateEncoding returns the encoding of the response to r, the one of highest quality in
+// its Accept header, JSON if it accepts none but JSON or has none.
+func negotiateEncoding(r *http.Request) *Encoding {
+	best, bestQ := encodings["json"], 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		for _, e := range encodings {
+			if slices.Contains(e.MediaTypes, mediaType) {
+				best, bestQ = e, q
+			}
+		}
+	}
+	return best
+}
+
+// writeEncoded writes v in the encoding the request accepts, for endpoints tooling polls frequently.
+func writeEncoded(w http.ResponseWriter, r *http.Request, status int, v any) {
+	e := negotiateEncoding(r)
+	w.Header().Set("Content-Type", e.MediaTypes[0])
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	e.Encode(w, v)
+}
+
+// encodeMsgpack writes v as MessagePack. v is encoded as its JSON would be, with the same
+// field names and omitted fields, numbers being encoded as integers when they are whole.
+func encodeMsgpack(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := appendMsgpack(&buf, value); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// appendMsgpack appends v, as decoded from JSON, to buf. Map keys are sorted, as in JSON.
+func appendMsgpack(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			appendMsgpackInt(buf, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	case string:
+		appendMsgpackHeader(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []any:
+		appendMsgpackHeader(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := appendMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		appendMsgpackHeader(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			appendMsgpack(buf, k)
+			if err := appendMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+// appendMsgpackHeader appends the header of a string, array or map of n elements: its fix
+// format up to fixMax, else the 8 bit (if the type has one), 16 or 32 bit format.
+func appendMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{f8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(f16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(f32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+// appendMsgpackInt appends n in the smallest integer format holding it.
+func appendMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		buf.WriteByte(byte(n))
+	case n >= -32 && n < 0:
+		buf.WriteByte(byte(int8(n)))
+	case n > 0 && n <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(n)})
+	case n > 0 && n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n > 0 && n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	case n > 0:
+		buf.WriteByte(0xcf)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(n)))
+	case n >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(int8(n))})
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(int16(n))))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(int32(n))))
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(n)))
+	}
+}
@@ -0,0 +1,55 @@
+package slrun
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEncodeMsgpack(t *testing.T) {
+	tests := []struct {
+		value any
+		want  []byte
+	}{
+		{map[string]any{"b": []any{true, nil, "x"}, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x93, 0xc3, 0xc0, 0xa1, 'x'}},
+		{-5, []byte{0xfb}},
+		{300, []byte{0xcd, 0x01, 0x2c}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{struct {
+			Name  string `json:"name"`
+			Empty string `json:"empty,omitempty"`
+		}{Name: "func1"}, []byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa5, 'f', 'u', 'n', 'c', '1'}},
+		{string(make([]byte, 40)), append([]byte{0xd9, 40}, make([]byte, 40)...)},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := encodeMsgpack(&buf, tt.value); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), tt.want) {
+			t.Errorf("encodeMsgpack(%v) = % x, want % x", tt.value, buf.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "json"},
+		{"*/*", "json"},
+		{"application/msgpack", "msgpack"},
+		{"application/json, application/x-msgpack", "json"},
+		{"application/json;q=0.5, application/x-msgpack", "msgpack"},
+		{"text/html", "json"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/admin/status", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := negotiateEncoding(r); got != encodings[tt.want] {
+			t.Errorf("negotiateEncoding(%q) = %v, want %v", tt.accept, got.MediaTypes[0], tt.want)
+		}
+	}
+}
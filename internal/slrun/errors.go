@@ -0,0 +1,80 @@
+package slrun
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// Invocation error classes reported to clients
+const (
+	ErrClassNotFound      = "function_not_found"
+	ErrClassStartFailed   = "function_start_failed"
+	ErrClassCrashLoop     = "function_crash_loop"
+	ErrClassUnreachable   = "function_unreachable"
+	ErrClassBadResponse   = "function_bad_response"
+	ErrClassPolicyFailure = "policy_failure"
+	ErrClassPolicyDenied  = "policy_denied"
+	ErrClassQuotaExceeded = "quota_exceeded"
+	ErrClassNoQuota       = "quota_unauthorized"
+	ErrClassDisabled      = "function_disabled"
+	ErrClassQuarantined   = "function_quarantined"
+	ErrClassBadRequest    = "bad_request"
+	ErrClassTooLarge      = "payload_too_large"
+	ErrClassQueueFull     = "async_queue_full"
+	ErrClassDraining      = "gateway_draining"
+	ErrClassUnverified    = "webhook_unverified"
+	ErrClassTimeout       = "function_timeout"
+	ErrClassSaturated     = "function_saturated"
+)
+
+// InvocationError is an error invoking a function, classed for reporting to clients.
+type InvocationError struct {
+	Class  string
+	Status int // HTTP status returned to the client
+	Err    error
+}
+
+func (e *InvocationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvocationError) Unwrap() error {
+	return e.Err
+}
+
+func invocationError(class string, status int, err error) *InvocationError {
+	return &InvocationError{Class: class, Status: status, Err: err}
+}
+
+// bodyError classes an error reading a request body, which may be over its size limit.
+func bodyError(err error, class string, status int) *InvocationError {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return invocationError(ErrClassTooLarge, http.StatusRequestEntityTooLarge, err)
+	}
+	return invocationError(class, status, err)
+}
+
+// invocationErrorBody is the JSON body returned to clients when an invocation fails.
+type invocationErrorBody struct {
+	Error     string   `json:"error"`
+	Class     string   `json:"class"`
+	RequestID string   `json:"request_id"`
+	Function  string   `json:"function"`
+	Logs      []string `json:"logs,omitempty"` // Tail of the function's logs, dev mode only
+}
+
+// requestIDHeader carries the request correlation ID to functions and back to clients.
+const requestIDHeader = "X-Request-Id"
+
+// requestID returns the client supplied request ID, or a new one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,76 @@
+package slrun
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventUploadProgress = "upload.progress"
+	EventUploadComplete = "upload.complete"
+	EventBuilt          = "build.succeeded"
+	EventBuildFailed    = "build.failed"
+	EventBuildCancelled = "build.cancelled" // Superseded by a newer build, or cancelled through the admin API
+	EventTestsFailed    = "tests.failed"
+	EventStartFailed    = "function.start_failed"
+	EventCrashLoop      = "function.crash_loop" // Repeated start failures
+	EventWarmedUp       = "function.warmed_up"
+	EventExited         = "function.exited"      // Container exited while slrun wasn't stopping it
+	EventUnhealthy      = "function.unhealthy"   // Docker health check failing, the container is replaced
+	EventQuarantined    = "function.quarantined" // Disabled for violating rules, until released
+	EventSLOBurn        = "slo.burn_rate"        // An SLO's error budget is burning too fast
+	EventRedeployed     = "function.redeployed"  // Rebuilt or reconfigured while running
+	EventPolicyDenied   = "policy.denied"        // An OPA policy or the base image rules denied a deploy or invocation
+	EventFlagChanged    = "flag.changed"         // A feature flag was set or reset through the admin API
+)
+
+// Event is something that happened in the runtime, streamed to admin API clients.
+type Event struct {
+	Time      time.Time      `json:"time"`
+	Type      string         `json:"type"`
+	Function  string         `json:"function,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// Events fans out published events to subscribers.
+// Slow subscribers miss events rather than block publishers.
+type Events struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func NewEvents() *Events {
+	return &Events{subs: make(map[chan Event]struct{})}
+}
+
+func (e *Events) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving published events until passed to Unsubscribe.
+func (e *Events) Subscribe() chan Event {
+	ch := make(chan Event, 64)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subs[ch] = struct{}{}
+	return ch
+}
+
+func (e *Events) Unsubscribe(ch chan Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subs, ch)
+}
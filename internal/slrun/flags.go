@@ -0,0 +1,154 @@
+package slrun
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Header carrying a function's feature flags on each request to it
+const flagsHeader = "X-Slrun-Flags"
+
+var validFlagName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+func validateFlags(config *types.Config) error {
+	for _, f := range config.Functions {
+		for name := range f.Flags {
+			if !validFlagName.MatchString(name) {
+				return fieldError("functions."+f.Name+".flags", "invalid flag name: %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// FeatureFlags are the feature flags of functions: each function's flags from the config,
+// overridden through the admin API. Overrides are kept across restarts, and take effect on
+// the next request without restarting functions.
+type FeatureFlags struct {
+	file   string
+	events *Events
+
+	mu        sync.Mutex
+	overrides map[string]map[string]bool // Flag values by function, then flag
+}
+
+func flagsFile(stateDir string) string {
+	return filepath.Join(stateDir, "flags.json")
+}
+
+// NewFeatureFlags returns the feature flags with the overrides set before in stateDir.
+func NewFeatureFlags(stateDir string, events *Events) (*FeatureFlags, error) {
+	f := &FeatureFlags{
+		file:      flagsFile(stateDir),
+		events:    events,
+		overrides: make(map[string]map[string]bool),
+	}
+
+	bytes, err := os.ReadFile(f.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &f.overrides)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", f.file, err)
+	}
+	return f, nil
+}
+
+// save writes the overrides to the flags file. Must hold f.mu.
+func (f *FeatureFlags) save() error {
+	bytes, err := json.MarshalIndent(f.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(f.file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.file, bytes, 0644)
+}
+
+// Flags returns the function's flags, those of the function it's an instance of for
+// tenant and replica instances.
+func (f *FeatureFlags) Flags(function *types.Function) map[string]bool {
+	name := cmp.Or(function.Base, function.Name)
+	flags := make(map[string]bool, len(function.Flags))
+	for flag, enabled := range function.Flags {
+		flags[flag] = enabled
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for flag, enabled := range f.overrides[name] {
+		flags[flag] = enabled
+	}
+	return flags
+}
+
+// Set overrides the function's flag.
+func (f *FeatureFlags) Set(function string, flag string, enabled bool) error {
+	if !validFlagName.MatchString(flag) {
+		return fmt.Errorf("invalid flag name: %v", flag)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.overrides[function] == nil {
+		f.overrides[function] = make(map[string]bool)
+	}
+	f.overrides[function][flag] = enabled
+	f.events.Publish(Event{Type: EventFlagChanged, Function: function, Data: map[string]any{"flag": flag, "enabled": enabled}})
+	return f.save()
+}
+
+// Reset removes the override of the function's flag, back to its value in the config, if any.
+func (f *FeatureFlags) Reset(function string, flag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.overrides[function][flag]; !ok {
+		return nil
+	}
+	delete(f.overrides[function], flag)
+	if len(f.overrides[function]) == 0 {
+		delete(f.overrides, function)
+	}
+	f.events.Publish(Event{Type: EventFlagChanged, Function: function, Data: map[string]any{"flag": flag, "reset": true}})
+	return f.save()
+}
+
+// flagsValue returns flags as the flags header's value, e.g. "beta-ui=true,new-pricing=false".
+func flagsValue(flags map[string]bool) string {
+	var pairs []string
+	for _, flag := range sortedKeys(flags) {
+		pairs = append(pairs, flag+"="+strconv.FormatBool(flags[flag]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// flagsEnv returns the container env of flags: SLRUN_FLAGS, in the header's format,
+// and SLRUN_FLAG_<NAME> for each flag, e.g. SLRUN_FLAG_BETA_UI=true.
+func flagsEnv(flags map[string]bool) []string {
+	if len(flags) == 0 {
+		return nil
+	}
+	env := []string{"SLRUN_FLAGS=" + flagsValue(flags)}
+	for _, flag := range sortedKeys(flags) {
+		name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flag))
+		env = append(env, "SLRUN_FLAG_"+name+"="+strconv.FormatBool(flags[flag]))
+	}
+	return env
+}
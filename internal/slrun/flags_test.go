@@ -0,0 +1,52 @@
+package slrun
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	dir := t.TempDir()
+	fun := &types.Function{Name: "func1", Flags: map[string]bool{"beta-ui": false, "new-pricing": true}}
+	replica := &types.Function{Name: "func1#2", Base: "func1", Flags: fun.Flags}
+
+	flags, err := NewFeatureFlags(dir, NewEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Set("func1", "beta-ui", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Set("func1", "Bad Name", true); err == nil {
+		t.Errorf("Set() accepted an invalid flag name")
+	}
+
+	// Overrides apply to instances and are kept across restarts
+	flags, err = NewFeatureFlags(dir, NewEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := flagsValue(flags.Flags(replica)), "beta-ui=true,new-pricing=true"; got != want {
+		t.Errorf("Flags() = %q, want %q", got, want)
+	}
+
+	if err := flags.Reset("func1", "beta-ui"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := flagsValue(flags.Flags(fun)), "beta-ui=false,new-pricing=true"; got != want {
+		t.Errorf("Flags() after Reset() = %q, want %q", got, want)
+	}
+}
+
+func TestFlagsEnv(t *testing.T) {
+	got := flagsEnv(map[string]bool{"beta-ui": true, "v2.api": false})
+	want := []string{"SLRUN_FLAGS=beta-ui=true,v2.api=false", "SLRUN_FLAG_BETA_UI=true", "SLRUN_FLAG_V2_API=false"}
+	if !slices.Equal(got, want) {
+		t.Errorf("flagsEnv() = %q, want %q", got, want)
+	}
+	if env := flagsEnv(nil); env != nil {
+		t.Errorf("flagsEnv(nil) = %q, want none", env)
+	}
+}
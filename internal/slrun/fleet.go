@@ -0,0 +1,418 @@
+package slrun
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Instances missing this many reports in a row are shown as stale
+const fleetStaleReports = 3
+
+func validateFleet(config *types.Config) error {
+	fleet := config.Fleet
+	if fleet == nil {
+		return nil
+	}
+	if fleet.Report == "" && !fleet.Aggregate {
+		return fieldError("fleet", "fleet needs a report address or aggregate")
+	}
+	if fleet.Aggregate && fleet.Address == "" {
+		return fieldError("fleet.address", "fleet aggregate needs an address to receive reports on")
+	}
+	if fleet.Aggregate && config.AdminAddress == "" {
+		return fieldError("fleet.aggregate", "fleet aggregate needs an admin_address to show the fleet on")
+	}
+	// Reports come from other hosts
+	if fleet.Aggregate && fleet.Secret == "" {
+		return fieldError("fleet.secret", "fleet aggregate needs a secret to authenticate reports")
+	}
+	if fleet.Interval == "" {
+		fleet.Interval = "30s"
+	}
+	interval, err := time.ParseDuration(fleet.Interval)
+	if err != nil {
+		return fieldError("fleet.interval", "invalid fleet interval: %w", err)
+	}
+	if interval <= 0 {
+		return fieldError("fleet.interval", "fleet interval must be positive")
+	}
+	if fleet.Name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("cannot name fleet instance after hostname: %w", err)
+		}
+		fleet.Name = hostname
+	}
+	return nil
+}
+
+// FleetFunction is the state and usage of a function, or instance, of a fleet member.
+type FleetFunction struct {
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	Health      string  `json:"health,omitempty"`
+	Restarts    int     `json:"restarts,omitempty"`
+	Replicas    int     `json:"replicas,omitempty"`
+	Invocations int     `json:"invocations"`
+	ColdStarts  int     `json:"cold_starts"`
+	Seconds     float64 `json:"seconds"` // Spent invoking it
+}
+
+// FleetReport is pushed by a fleet member to the aggregator: the functions that changed
+// since the member's previous report, or all of them in a full report.
+type FleetReport struct {
+	Instance  string           `json:"instance"`
+	Version   string           `json:"version"`
+	Gateway   []string         `json:"gateway"`
+	Interval  string           `json:"interval"` // Between the member's reports
+	Seq       int64            `json:"seq"`
+	BaseSeq   int64            `json:"base_seq"` // Report whose functions the changes apply to, unless full
+	Full      bool             `json:"full"`
+	Functions []*FleetFunction `json:"functions"` // Changed or added, or all if full
+	Removed   []string         `json:"removed,omitempty"`
+}
+
+// FleetInstance is a fleet member as the aggregator last heard from it.
+type FleetInstance struct {
+	Name      string           `json:"name"`
+	Version   string           `json:"version"`
+	Gateway   []string         `json:"gateway"`
+	Address   string           `json:"address"` // Reported from
+	LastSeen  time.Time        `json:"last_seen"`
+	Stale     bool             `json:"stale"` // Missed its last reports
+	Functions []*FleetFunction `json:"functions"`
+}
+
+// errFleetResync asks a member for a full report, as its changes apply to a report the aggregator doesn't have.
+var errFleetResync = errors.New("report doesn't follow the last one received, send a full report")
+
+// fleetFunctions returns the functions of status with their usage, by name.
+func fleetFunctions(status *Status, usage *UsageReport) map[string]FleetFunction {
+	functions := make(map[string]FleetFunction)
+	for _, s := range status.Functions {
+		functions[s.Name] = FleetFunction{
+			Name:     s.Name,
+			State:    s.State,
+			Health:   s.Health,
+			Restarts: s.Restarts,
+			Replicas: s.Replicas,
+		}
+	}
+	for _, rec := range usage.Records {
+		f, exists := functions[rec.Function]
+		if !exists {
+			continue
+		}
+		f.Invocations += rec.Invocations
+		f.ColdStarts += rec.ColdStarts
+		f.Seconds += rec.Seconds
+		functions[rec.Function] = f
+	}
+	return functions
+}
+
+// FleetReporter pushes this instance's function states and usage to the fleet aggregator.
+// After a first full report, only what changed is sent.
+type FleetReporter struct {
+	config   *types.Fleet
+	interval time.Duration
+	status   func() *Status
+	usage    func() *UsageReport
+	client   *http.Client
+
+	seq  int64
+	sent map[string]FleetFunction // As the aggregator has them, nil until it has a full report
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewFleetReporter returns the reporter of config, or nil if it reports to no aggregator.
+func NewFleetReporter(config *types.Fleet, status func() *Status, usage func() *UsageReport) *FleetReporter {
+	if config == nil || config.Report == "" {
+		return nil
+	}
+	interval, _ := time.ParseDuration(config.Interval)
+	return &FleetReporter{
+		config:   config,
+		interval: interval,
+		status:   status,
+		usage:    usage,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (fr *FleetReporter) Start() {
+	if fr == nil {
+		return
+	}
+	fr.stop = make(chan struct{})
+	fr.wg.Add(1)
+	go func() {
+		defer fr.wg.Done()
+		ticker := time.NewTicker(fr.interval)
+		defer ticker.Stop()
+		for {
+			fr.report()
+			select {
+			case <-ticker.C:
+			case <-fr.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (fr *FleetReporter) Stop() {
+	if fr == nil || fr.stop == nil {
+		return
+	}
+	close(fr.stop)
+	fr.wg.Wait()
+}
+
+// report pushes the changes since the last report, or a full report if the aggregator needs one.
+func (fr *FleetReporter) report() {
+	status := fr.status()
+	current := fleetFunctions(status, fr.usage())
+	report := &FleetReport{
+		Instance: fr.config.Name,
+		Version:  status.Version,
+		Gateway:  status.Gateway,
+		Interval: fr.config.Interval,
+		Seq:      fr.seq + 1,
+		BaseSeq:  fr.seq,
+		Full:     fr.sent == nil,
+	}
+	for _, name := range slices.Sorted(maps.Keys(current)) {
+		f := current[name]
+		if prev, exists := fr.sent[name]; report.Full || !exists || prev != f {
+			report.Functions = append(report.Functions, &f)
+		}
+	}
+	for name := range fr.sent {
+		if _, exists := current[name]; !exists {
+			report.Removed = append(report.Removed, name)
+		}
+	}
+	sort.Strings(report.Removed)
+
+	err := fr.send(report)
+	if errors.Is(err, errFleetResync) {
+		fr.sent = nil
+		log.Printf("Fleet aggregator %v asked for a full report\n", fr.config.Report)
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot report to fleet aggregator %v: %v\n", fr.config.Report, err)
+		return
+	}
+	fr.seq = report.Seq
+	fr.sent = current
+}
+
+func (fr *FleetReporter) send(report *FleetReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+fr.config.Report+"/admin/fleet/reports", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if fr.config.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+fr.config.Secret)
+	}
+
+	resp, err := fr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return errFleetResync
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("aggregator answered %v", resp.Status)
+	}
+	return nil
+}
+
+type fleetMember struct {
+	FleetInstance
+	seq       int64
+	interval  time.Duration
+	functions map[string]*FleetFunction
+}
+
+// FleetAggregator keeps the latest state of each fleet member from their reports.
+type FleetAggregator struct {
+	secret string
+
+	mu      sync.Mutex
+	members map[string]*fleetMember
+}
+
+// NewFleetAggregator returns the aggregator of config, or nil if this instance doesn't aggregate.
+func NewFleetAggregator(config *types.Fleet) *FleetAggregator {
+	if config == nil || !config.Aggregate {
+		return nil
+	}
+	return &FleetAggregator{secret: config.Secret, members: make(map[string]*fleetMember)}
+}
+
+// Authorized reports whether a report request carries the fleet's secret.
+func (fa *FleetAggregator) Authorized(r *http.Request) bool {
+	if fa.secret == "" {
+		return true
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(fa.secret)) == 1
+}
+
+// Apply applies a member's report, returning errFleetResync if its changes can't be applied.
+func (fa *FleetAggregator) Apply(report *FleetReport, address string) error {
+	if report.Instance == "" {
+		return fmt.Errorf("report has no instance")
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	member, exists := fa.members[report.Instance]
+	if !report.Full && (!exists || member.seq != report.BaseSeq) {
+		return errFleetResync
+	}
+	if !exists || report.Full {
+		member = &fleetMember{functions: make(map[string]*FleetFunction)}
+		fa.members[report.Instance] = member
+	}
+	for _, f := range report.Functions {
+		member.functions[f.Name] = f
+	}
+	for _, name := range report.Removed {
+		delete(member.functions, name)
+	}
+	member.seq = report.Seq
+	member.interval, _ = time.ParseDuration(report.Interval)
+	member.Name = report.Instance
+	member.Version = report.Version
+	member.Gateway = report.Gateway
+	member.Address = address
+	member.LastSeen = time.Now()
+	return nil
+}
+
+// Instances returns the fleet members by name.
+func (fa *FleetAggregator) Instances() []*FleetInstance {
+	instances := []*FleetInstance{}
+	if fa == nil {
+		return instances
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(fa.members)) {
+		member := fa.members[name]
+		instance := member.FleetInstance
+		instance.Stale = member.interval > 0 && time.Since(member.LastSeen) > fleetStaleReports*member.interval
+		instance.Functions = []*FleetFunction{}
+		for _, fname := range slices.Sorted(maps.Keys(member.functions)) {
+			f := *member.functions[fname]
+			instance.Functions = append(instance.Functions, &f)
+		}
+		instances = append(instances, &instance)
+	}
+	return instances
+}
+
+// fleetDashboardTemplate shows every fleet member's functions in one page, refreshed every 10s.
+var fleetDashboardTemplate = template.Must(template.New("fleet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>slrun fleet</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #1e1e1e; color: #ddd; }
+header { background: #333; color: #fff; padding: 16px 24px; }
+main { padding: 16px 24px; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { text-align: left; padding: 4px 12px; border-bottom: 1px solid #444; }
+.meta { color: #999; }
+.stale { color: #e5a50a; }
+.running { color: #57e389; }
+.crash_loop_backoff, .quarantined, .unhealthy { color: #ff7b72; }
+</style>
+</head>
+<body>
+<header><h2>slrun fleet: {{len .}} instances</h2></header>
+<main>
+{{range .}}
+<h3>{{.Name}}{{if .Stale}} <span class="stale">(stale)</span>{{end}}</h3>
+<p class="meta">slrun {{.Version}} at {{.Address}}, last report {{.LastSeen.Format "2006-01-02 15:04:05"}}</p>
+<table>
+<tr><th>Function</th><th>State</th><th>Health</th><th>Restarts</th><th>Invocations</th><th>Cold starts</th><th>Seconds</th></tr>
+{{range .Functions}}<tr><td>{{.Name}}</td><td class="{{.State}}">{{.State}}</td><td class="{{.Health}}">{{.Health}}</td><td>{{.Restarts}}</td><td>{{.Invocations}}</td><td>{{.ColdStarts}}</td><td>{{printf "%.1f" .Seconds}}</td></tr>
+{{end}}</table>
+{{else}}
+<p class="meta">No instance has reported yet.</p>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+// reportFleet receives a fleet member's report.
+func (a *Admin) reportFleet(w http.ResponseWriter, r *http.Request) {
+	if a.fleet == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("this instance doesn't aggregate a fleet"))
+		return
+	}
+	if !a.fleet.Authorized(r) {
+		writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("invalid fleet secret"))
+		return
+	}
+	var report FleetReport
+	err := json.NewDecoder(r.Body).Decode(&report)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = a.fleet.Apply(&report, r.RemoteAddr)
+	if errors.Is(err, errFleetResync) {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) getFleet(w http.ResponseWriter, r *http.Request) {
+	writeEncoded(w, r, http.StatusOK, a.fleet.Instances())
+}
+
+func (a *Admin) fleetDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := fleetDashboardTemplate.Execute(w, a.fleet.Instances())
+	if err != nil {
+		log.Printf("Cannot render fleet dashboard: %v\n", err)
+	}
+}
@@ -0,0 +1,27 @@
+package slrun
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestValidateFleet(t *testing.T) {
+	tests := []struct {
+		config *types.Config
+		err    string
+	}{
+		{&types.Config{AdminAddress: "127.0.0.1:9090", Fleet: &types.Fleet{Aggregate: true, Address: ":9091", Secret: "fleet-secret"}}, ""},
+		{&types.Config{AdminAddress: "127.0.0.1:9090", Fleet: &types.Fleet{Aggregate: true, Secret: "fleet-secret"}}, "needs an address"},
+		{&types.Config{AdminAddress: "127.0.0.1:9090", Fleet: &types.Fleet{Aggregate: true, Address: ":9091"}}, "needs a secret"},
+		{&types.Config{Fleet: &types.Fleet{Report: "lab-server:9091"}}, ""},
+		{&types.Config{Fleet: &types.Fleet{}}, "report address or aggregate"},
+	}
+	for _, test := range tests {
+		err := validateFleet(test.config)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("validateFleet(%+v) = %v, want %q", test.config.Fleet, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Gateway serves function invocations on one or more listeners.
+type Gateway struct {
+	runtime   *Runtime
+	config    *types.Config
+	listeners []*types.Listener
+	servers   []*http.Server // One per listener
+	captures  *Captures
+	quotas    *Quotas
+	billing   *Billing
+	async     *AsyncQueue // Runs invocations asked to respond async, nil if disabled
+	slos      *SLOs
+	history   *InvocationHistory
+	peers     *Peers     // Relays invocations of functions run by peers, nil without peers
+	dns       *DNSServer // Resolves function hostnames to the gateway, nil if disabled
+	metrics   *Metrics
+	coalescer *Coalescer
+
+	draining atomic.Bool  // Invocations are refused while set
+	inFlight atomic.Int64 // Invocations being served
+}
+
+func NewGateway(runtime *Runtime, config *types.Config, listeners []*types.Listener, billing *Billing, metrics *Metrics) (*Gateway, error) {
+	g := &Gateway{
+		runtime:   runtime,
+		config:    config,
+		listeners: listeners,
+		captures:  NewCaptures(config.Capture),
+		quotas:    NewQuotas(config.Quotas),
+		billing:   billing,
+		slos:      NewSLOs(config.Functions, runtime.events),
+		history:   NewInvocationHistory(),
+		peers:     NewPeers(config.Peers),
+		metrics:   metrics,
+		coalescer: NewCoalescer(),
+	}
+	if config.Async != nil {
+		g.async = NewAsyncQueue(g, config.Async)
+	}
+	g.dns = NewDNSServer(config.DNS, g)
+
+	for _, l := range listeners {
+		mux := http.NewServeMux()
+		route := g.routeHandler(l)
+		mux.Handle("/", route)
+		if len(config.Webhooks) > 0 {
+			mux.HandleFunc("POST "+webhooksPrefix+"{name}", g.webhookHandler)
+		}
+		if config.Dev {
+			mux.HandleFunc("POST /_slrun/functions/{name}/restart", g.restartHandler)
+		}
+		if g.async != nil {
+			mux.HandleFunc("GET /_slrun/invocations/{id}", g.async.invocationHandler)
+		}
+
+		var handler http.Handler = mux
+
+		// Wrap in reverse so the first middleware listed sees the request first
+		for i := len(l.Middleware) - 1; i >= 0; i-- {
+			mw, err := newMiddleware(l.Middleware[i], l)
+			if err != nil {
+				return nil, err
+			}
+			handler = mw(handler)
+		}
+		handler = withArrival(handler)
+
+		g.servers = append(g.servers, &http.Server{
+			Addr:    l.Address,
+			Handler: handler,
+		})
+	}
+
+	return g, nil
+}
+
+// Requests under this path prefix are routed by their second segment, unless a function has its name
+const functionsPrefix = "functions"
+
+// routeHandler returns the routing table of a listener.
+// Requests are routed by their first path segment: /funcName/other/parts,
+// or by the second under the functions prefix: /functions/funcName/other/parts,
+// or, with DNS enabled, by their host: funcName.slrun.local/other/parts
+func (g *Gateway) routeHandler(l *types.Listener) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Correlate the request across gateway, function and client
+		r.Header.Set(requestIDHeader, requestID(r))
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+
+		g.inFlight.Add(1)
+		defer g.inFlight.Add(-1)
+		if g.refuseDraining(w, r) {
+			return
+		}
+
+		// Function header policies are added once routed
+		hw := newHeaderPolicyWriter(w, g.config.ResponseHeaders)
+		w = hw
+
+		if rule, to, ok := applyRules(g.config.Redirects, r.URL.Path); ok {
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, to, rule.Status)
+			return
+		}
+		if _, to, ok := applyRules(g.config.Rewrites, r.URL.Path); ok {
+			r.URL.Path = to
+			r.URL.RawPath = ""
+		}
+
+		funcName, byHost := hostFunction(g.config.DNS, r.Host)
+		path := r.URL.Path
+		if !byHost {
+			parts := strings.Split(r.URL.Path, "/")
+
+			if len(parts) < 2 {
+				return
+			}
+
+			funcName = parts[1]
+			prefix := "/" + funcName
+			if funcName == functionsPrefix && len(parts) > 2 && g.runtime.FunctionByName(functionsPrefix) == nil {
+				funcName = parts[2]
+				prefix = "/" + functionsPrefix + "/" + funcName
+			}
+			path, _ = strings.CutPrefix(r.URL.Path, prefix)
+		}
+
+		routed := (len(l.Functions) == 0 || slices.Contains(l.Functions, funcName)) && !g.webhookOnly(funcName)
+		if peer := g.peers.route(funcName); routed && peer != nil {
+			g.relay(w, r, peer, funcName, path)
+			return
+		}
+		if !routed || g.runtime.FunctionByName(funcName) == nil {
+			fallback := g.fallback(l)
+			if fallback == "" {
+				log.Printf("Unknown function requested %v\n", funcName)
+				err := invocationError(ErrClassNotFound, http.StatusNotFound, fmt.Errorf("function %v not found", funcName))
+				g.writeError(w, r, funcName, err)
+				return
+			}
+
+			// The fallback function sees the original path
+			funcName, path = fallback, r.URL.Path
+		}
+		g.invoke(hw, r, g.runtime.FunctionByName(funcName), path)
+	})
+}
+
+// refuseDraining refuses the request while the gateway is draining, reporting whether it did.
+func (g *Gateway) refuseDraining(w http.ResponseWriter, r *http.Request) bool {
+	if !g.draining.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	err := invocationError(ErrClassDraining, http.StatusServiceUnavailable, fmt.Errorf("gateway is draining"))
+	g.writeError(w, r, "", err)
+	return true
+}
+
+// webhookOnly returns whether the function is only invoked by its webhooks, so never routed to.
+func (g *Gateway) webhookOnly(funcName string) bool {
+	fun := g.runtime.FunctionByName(funcName)
+	return fun != nil && fun.WebhookOnly
+}
+
+// invoke calls the function at path with the request, once its invoke policy and quotas admit
+// it, and writes its response to hw.
+func (g *Gateway) invoke(hw *headerPolicyWriter, r *http.Request, fun *types.Function, path string) {
+	var w http.ResponseWriter = hw
+	funcName := fun.Name
+	hw.addPolicy(fun.ResponseHeaders)
+
+	err := g.runtime.checkInvoke(fun, path, r)
+	if err != nil {
+		g.writeError(w, r, funcName, err)
+		return
+	}
+
+	release, err := g.quotas.Acquire(r)
+	if err != nil {
+		g.writeError(w, r, funcName, quotaError(err))
+		return
+	}
+	defer release()
+
+	g.captures.Wrap(funcName, w, r, func(w http.ResponseWriter, r *http.Request) {
+		// Bodies are streamed to the function, not buffered
+		err := limitUpload(w, r, fun)
+		if err != nil {
+			g.writeError(w, r, funcName, err)
+			return
+		}
+		if r.Body != http.NoBody {
+			r.Body = newProgressReader(r.Body, g.runtime.events, funcName, r.Header.Get(requestIDHeader), r.ContentLength)
+		}
+
+		if g.async != nil && wantsAsync(r) {
+			inv, err := g.async.Enqueue(fun, path, r)
+			if err != nil {
+				g.writeError(w, r, funcName, err)
+				return
+			}
+			w.Header().Set("Location", "/_slrun/invocations/"+inv.ID)
+			writeJSON(w, http.StatusAccepted, inv)
+			return
+		}
+
+		// Remote functions are transformed on the node running them
+		if fun.Transform == TransformFormToJSON && !fun.Remote {
+			cleanup, err := transformFormToJSON(r, g.config.UploadDir, funcName, fun.MaxUploadBytes)
+			defer cleanup()
+			if err != nil {
+				g.writeError(w, r, funcName, bodyError(err, ErrClassBadRequest, http.StatusBadRequest))
+				return
+			}
+		}
+
+		// Usage lasts until the response has streamed to the client
+		start := time.Now()
+		resp, err := g.callFunction(fun, path, r)
+		defer func() {
+			timing := InvocationTiming{Execution: time.Since(start)}
+			if resp != nil {
+				timing.ColdStart = resp.ColdStart
+				timing.Execution -= resp.ColdStart
+			}
+			g.recordInvocation(funcName, r, resp, err, timing)
+		}()
+		if err != nil {
+			g.writeError(w, r, funcName, err)
+			return
+		}
+
+		if g.config.Dev && resp.Status >= 500 && wantsHTML(r) {
+			var body []byte
+			body, err = resp.ReadAll(g.config.ResponseBufferBytes)
+			if err != nil {
+				g.writeError(w, r, funcName, err)
+				return
+			}
+			g.writeOverlay(w, r, funcName, resp.Status, string(body))
+			return
+		}
+
+		if fun.BufferResponse {
+			err = resp.WriteBuffered(w, g.config.ResponseBufferBytes)
+			if err != nil {
+				g.writeError(w, r, funcName, err)
+			}
+			return
+		}
+		if werr := resp.Write(w); werr != nil {
+			log.Printf("Cannot stream function %v response: %v\n", funcName, werr)
+		}
+	})
+
+	log.Printf("Function %v called\n", funcName)
+}
+
+// callFunction calls a function, sharing the call of an identical request in flight if it coalesces requests.
+func (g *Gateway) callFunction(fun *types.Function, path string, r *http.Request) (*FunctionResponse, error) {
+	key, ok := g.coalesceKey(fun, path, r)
+	if !ok {
+		return g.runtime.CallFunctionByName(fun.Name, path, r)
+	}
+	return g.coalescer.Do(r, key, fun.Coalesce.MaxBodyBytes, func() (*FunctionResponse, error) {
+		return g.runtime.CallFunctionByName(fun.Name, path, r)
+	})
+}
+
+// limitUpload limits the request body to the function's max_upload_bytes, failing at once
+// if the request says it is larger.
+func limitUpload(w http.ResponseWriter, r *http.Request, fun *types.Function) error {
+	if fun.MaxUploadBytes <= 0 {
+		return nil
+	}
+	if r.ContentLength > fun.MaxUploadBytes {
+		err := fmt.Errorf("request body of %v bytes exceeds limit of %v bytes", r.ContentLength, fun.MaxUploadBytes)
+		return invocationError(ErrClassTooLarge, http.StatusRequestEntityTooLarge, err)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, fun.MaxUploadBytes)
+	return nil
+}
+
+// checkInvoke returns an invocation error if a policy denies the request to the function at path,
+// publishing the denial.
+func (r *Runtime) checkInvoke(fun *types.Function, path string, req *http.Request) error {
+	err := r.opa.CheckInvoke(fun, path, req)
+	if errors.Is(err, ErrPolicyDenied) {
+		r.events.Publish(Event{
+			Type:      EventPolicyDenied,
+			Function:  fun.Name,
+			RequestID: req.Header.Get(requestIDHeader),
+			Data:      map[string]any{"error": err.Error(), "path": path},
+		})
+	}
+	return err
+}
+
+// recordInvocation records a finished invocation's usage, SLO compliance, timing and metrics.
+// resp is nil if err failed it before the function responded.
+func (g *Gateway) recordInvocation(funcName string, r *http.Request, resp *FunctionResponse, err error, timing InvocationTiming) {
+	status := http.StatusInternalServerError
+	var ierr *InvocationError
+	if errors.As(err, &ierr) {
+		status = ierr.Status
+	} else if err == nil {
+		status = resp.Status
+	}
+
+	g.billing.Record(funcName, g.quotas.KeyName(r), timing)
+	g.slos.Record(funcName, timing.Total(), status >= 500)
+	g.history.Record(funcName, r.Header.Get(requestIDHeader), status, timing)
+	g.metrics.RecordInvocation(funcName, status, timing)
+}
+
+// writeError writes a failed invocation as a JSON error body.
+// In dev mode the body includes the tail of the function's logs,
+// and browsers get the error overlay instead.
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, funcName string, err error) {
+	var ierr *InvocationError
+	if !errors.As(err, &ierr) {
+		ierr = invocationError(ErrClassPolicyFailure, http.StatusInternalServerError, err)
+	}
+	log.Printf("Request %v to function %v failed: %v\n", r.Header.Get(requestIDHeader), funcName, ierr)
+
+	if g.config.Dev && wantsHTML(r) {
+		g.writeOverlay(w, r, funcName, ierr.Status, ierr.Error())
+		return
+	}
+
+	body := invocationErrorBody{
+		Error:     ierr.Error(),
+		Class:     ierr.Class,
+		RequestID: r.Header.Get(requestIDHeader),
+		Function:  funcName,
+	}
+
+	fun := g.runtime.FunctionByName(funcName)
+	if g.config.Dev && fun != nil {
+		logs, err := g.runtime.FunctionLogs(fun, 50)
+		if err != nil {
+			log.Printf("Cannot read function %v logs: %v\n", funcName, err)
+		}
+		body.Logs = logs
+	}
+
+	writeJSON(w, ierr.Status, body)
+}
+
+// Drain refuses new invocations and waits for those in flight to finish, or ctx to be done.
+func (g *Gateway) Drain(ctx context.Context) error {
+	g.draining.Store(true)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for g.inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Undrain accepts invocations again.
+func (g *Gateway) Undrain() {
+	g.draining.Store(false)
+}
+
+// InFlight returns the number of invocations being served.
+func (g *Gateway) InFlight() int64 {
+	return g.inFlight.Load()
+}
+
+// fallback returns the function receiving route misses on l, if any.
+func (g *Gateway) fallback(l *types.Listener) string {
+	if l.Fallback != "" {
+		return l.Fallback
+	}
+	return g.config.Fallback
+}
+
+// Start starts serving on all listeners.
+func (g *Gateway) Start() {
+	if g.async != nil {
+		g.async.Start()
+	}
+	g.slos.Start()
+	for i, server := range g.servers {
+		l := g.listeners[i]
+		tls := l.TLSCert != "" && l.TLSKey != ""
+		go func() {
+			var err error
+			if tls {
+				err = server.ListenAndServeTLS(l.TLSCert, l.TLSKey)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server failed: %v", err)
+			}
+		}()
+
+		scheme := "HTTP"
+		if tls {
+			scheme = "HTTPS"
+		}
+		fmt.Printf("%v server listening on %v\n", scheme, server.Addr)
+	}
+}
+
+// Shutdown gracefully stops all listeners.
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, server := range g.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("listener %v: %w", server.Addr, err))
+		}
+	}
+	if g.async != nil {
+		g.async.Stop()
+	}
+	g.slos.Stop()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package slrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Response headers expected to differ between any two responses
+var volatileHeaders = []string{"Date", "Content-Length", "Server-Timing", requestIDHeader, clusterColdStartHeader}
+
+// GoldenOptions says which response differences DiffFunctions ignores.
+type GoldenOptions struct {
+	IgnoreHeaders []string // Besides volatileHeaders
+	IgnoreFields  []string // JSON object keys ignored at any depth
+}
+
+// ResponseDiff is a request whose responses differed, and how.
+type ResponseDiff struct {
+	Request *ReplayRequest
+	Diffs   []string
+}
+
+// goldenResponse is a response normalized for comparison.
+type goldenResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// DiffFunctions sends each request to the functions at urlA and urlB, one after the
+// other, and returns the requests whose responses differ in status, headers or
+// normalized body. JSON bodies are compared regardless of formatting and key order.
+func DiffFunctions(ctx context.Context, requests []*ReplayRequest, urlA, urlB string, opts GoldenOptions) ([]*ResponseDiff, error) {
+	client := &http.Client{
+		Timeout: time.Minute,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	ignored := slices.Concat(volatileHeaders, opts.IgnoreHeaders)
+	for i, name := range ignored {
+		ignored[i] = http.CanonicalHeaderKey(name)
+	}
+
+	diffs := []*ResponseDiff{}
+	for _, req := range requests {
+		a, err := goldenFetch(ctx, client, urlA, req, ignored, opts.IgnoreFields)
+		if err != nil {
+			return diffs, err
+		}
+		b, err := goldenFetch(ctx, client, urlB, req, ignored, opts.IgnoreFields)
+		if err != nil {
+			return diffs, err
+		}
+		if d := diffResponses(a, b); len(d) > 0 {
+			diffs = append(diffs, &ResponseDiff{Request: req, Diffs: d})
+		}
+	}
+	return diffs, nil
+}
+
+func goldenFetch(ctx context.Context, client *http.Client, functionURL string, req *ReplayRequest, ignoreHeaders, ignoreFields []string) (*goldenResponse, error) {
+	resp, err := SendReplay(ctx, client, functionURL, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	header := resp.Header.Clone()
+	for _, name := range ignoreHeaders {
+		header.Del(name)
+	}
+	return &goldenResponse{status: resp.StatusCode, header: header, body: normalizeBody(body, ignoreFields)}, nil
+}
+
+// normalizeBody returns a JSON body re-encoded without ignored fields, with sorted keys
+// and no insignificant whitespace, and any other body with surrounding whitespace trimmed.
+func normalizeBody(body []byte, ignoreFields []string) []byte {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil || dec.More() {
+		return bytes.TrimSpace(body)
+	}
+	normalized, err := json.Marshal(dropFields(v, ignoreFields))
+	if err != nil {
+		return bytes.TrimSpace(body)
+	}
+	return normalized
+}
+
+func dropFields(v any, fields []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if slices.Contains(fields, key) {
+				delete(v, key)
+			} else {
+				v[key] = dropFields(val, fields)
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = dropFields(val, fields)
+		}
+	}
+	return v
+}
+
+// diffResponses describes how b differs from a, nil if it doesn't.
+func diffResponses(a, b *goldenResponse) []string {
+	var diffs []string
+	if a.status != b.status {
+		diffs = append(diffs, fmt.Sprintf("status %v != %v", a.status, b.status))
+	}
+
+	names := sortedKeys(a.header)
+	for _, name := range sortedKeys(b.header) {
+		if _, ok := a.header[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if !slices.Equal(a.header[name], b.header[name]) {
+			diffs = append(diffs, fmt.Sprintf("header %v %q != %q", name, a.header[name], b.header[name]))
+		}
+	}
+
+	if !bytes.Equal(a.body, b.body) {
+		i := 0
+		for i < len(a.body) && i < len(b.body) && a.body[i] == b.body[i] {
+			i++
+		}
+		diffs = append(diffs, fmt.Sprintf("body differs at byte %v: %q != %q", i, excerpt(a.body, i), excerpt(b.body, i)))
+	}
+	return diffs
+}
+
+// excerpt returns up to 40 bytes of b from offset i.
+func excerpt(b []byte, i int) string {
+	return string(b[i:min(len(b), i+40)])
+}
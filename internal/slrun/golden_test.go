@@ -0,0 +1,45 @@
+package slrun
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"json key order", `{"b": 1, "a": [1, 2]}`, `{"a":[1,2],"b":1}`},
+		{"json ignored fields", `{"id": 1, "at": "now", "items": [{"at": "then"}]}`, `{"id":1,"items":[{}]}`},
+		{"json numbers kept", `{"n": 10000000000000001}`, `{"n":10000000000000001}`},
+		{"text", "  hello\n", "hello"},
+		{"trailing data", `{"a": 1} x`, `{"a": 1} x`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(normalizeBody([]byte(tt.body), []string{"at"})); got != tt.want {
+				t.Errorf("normalizeBody(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffResponses(t *testing.T) {
+	a := &goldenResponse{status: 200, header: http.Header{"Content-Type": {"application/json"}}, body: []byte(`{"a":1}`)}
+
+	if d := diffResponses(a, a); d != nil {
+		t.Errorf("diffResponses() of equal responses = %v", d)
+	}
+
+	b := &goldenResponse{status: 500, header: http.Header{"X-Extra": {"1"}}, body: []byte(`{"a":2}`)}
+	d := strings.Join(diffResponses(a, b), "\n")
+	for _, want := range []string{"status 200 != 500", "header Content-Type", "header X-Extra", "body differs at byte 5"} {
+		if !strings.Contains(d, want) {
+			t.Errorf("diffResponses() = %q, missing %q", d, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package slrun
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// gossipMeta is what a node gossips about itself, so others can place functions on it.
+type gossipMeta struct {
+	Address string            `json:"address"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// gossipDelegate gossips this node's meta. Nodes exchange no other state.
+type gossipDelegate struct {
+	meta []byte
+}
+
+func (d *gossipDelegate) NodeMeta(limit int) []byte                  { return d.meta }
+func (d *gossipDelegate) NotifyMsg([]byte)                           {}
+func (d *gossipDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *gossipDelegate) LocalState(join bool) []byte                { return nil }
+func (d *gossipDelegate) MergeRemoteState(buf []byte, join bool)     {}
+
+// gossipEvents places functions on nodes as they join, and stops placing them on failed or departed ones.
+type gossipEvents struct {
+	cluster *Cluster
+}
+
+func (e *gossipEvents) NotifyJoin(n *memberlist.Node) {
+	node, ok := e.node(n)
+	if !ok {
+		return
+	}
+	e.cluster.addNode(node)
+	log.Printf("Cluster node %v joined at %v\n", node.Name, node.Address)
+}
+
+func (e *gossipEvents) NotifyLeave(n *memberlist.Node) {
+	if n.Name == e.cluster.self.Name {
+		return
+	}
+	e.cluster.removeNode(n.Name)
+	log.Printf("Cluster node %v left or failed, no longer routing to it\n", n.Name)
+}
+
+func (e *gossipEvents) NotifyUpdate(n *memberlist.Node) {
+	node, ok := e.node(n)
+	if !ok {
+		return
+	}
+	e.cluster.removeNode(node.Name)
+	e.cluster.addNode(node)
+}
+
+// node returns the node gossiped by another member.
+func (e *gossipEvents) node(n *memberlist.Node) (*types.Node, bool) {
+	if n.Name == e.cluster.self.Name {
+		return nil, false
+	}
+	var meta gossipMeta
+	err := json.Unmarshal(n.Meta, &meta)
+	if err != nil || meta.Address == "" {
+		log.Printf("Ignoring cluster node %v with invalid meta: %v\n", n.Name, err)
+		return nil, false
+	}
+	return &types.Node{Name: n.Name, Address: meta.Address, Labels: meta.Labels}, true
+}
+
+// startGossip joins the cluster's gossip. Nodes not reachable to join through
+// are left to join this node themselves.
+func (c *Cluster) startGossip() error {
+	gossip := c.config.Gossip
+	meta, err := json.Marshal(gossipMeta{Address: c.self.Address, Labels: c.self.Labels})
+	if err != nil {
+		return err
+	}
+	if len(meta) > memberlist.MetaMaxSize {
+		return fmt.Errorf("cluster node %v labels are too large to gossip, %v bytes", c.self.Name, len(meta))
+	}
+
+	conf := memberlist.DefaultLANConfig()
+	conf.Name = c.self.Name
+	conf.BindAddr, conf.BindPort, err = splitGossipAddress(gossip.Address)
+	if err != nil {
+		return err
+	}
+	if gossip.Advertise != "" {
+		conf.AdvertiseAddr, conf.AdvertisePort, err = splitGossipAddress(gossip.Advertise)
+		if err != nil {
+			return err
+		}
+	} else {
+		conf.AdvertisePort = conf.BindPort
+	}
+	if c.config.Secret != "" {
+		// Gossip is encrypted with a key derived from the cluster secret
+		key := sha256.Sum256([]byte(c.config.Secret))
+		conf.SecretKey = key[:]
+	}
+	conf.Delegate = &gossipDelegate{meta: meta}
+	conf.Events = &gossipEvents{cluster: c}
+	conf.LogOutput = io.Discard
+
+	c.members, err = memberlist.Create(conf)
+	if err != nil {
+		return fmt.Errorf("cannot start cluster gossip: %w", err)
+	}
+	fmt.Printf("Cluster gossip listening on %v\n", gossip.Address)
+
+	if len(gossip.Join) > 0 {
+		joined, err := c.members.Join(gossip.Join)
+		if err != nil {
+			log.Printf("Cannot join some cluster nodes: %v\n", err)
+		}
+		fmt.Printf("Joined %v cluster nodes\n", joined)
+	}
+	return nil
+}
+
+// stopGossip tells other nodes this one is leaving, so they stop routing to it at once.
+func (c *Cluster) stopGossip() {
+	err := c.members.Leave(5 * time.Second)
+	if err != nil {
+		log.Printf("Cannot leave cluster gossip: %v\n", err)
+	}
+	c.members.Shutdown()
+}
+
+func splitGossipAddress(address string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid gossip address %v: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid gossip address %v: %w", address, err)
+	}
+	return host, port, nil
+}
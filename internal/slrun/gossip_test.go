@@ -0,0 +1,51 @@
+package slrun
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestGossipMembership(t *testing.T) {
+	self := &types.Node{Name: "a", Address: "10.0.0.1:7946"}
+	c := NewCluster(&types.Cluster{Node: "a", Nodes: []*types.Node{self}, Gossip: &types.Gossip{}})
+	c.Place([]*types.Function{
+		{Name: "func1"},
+		{Name: "gpu", Requires: []string{"gpu"}},
+	})
+	events := &gossipEvents{cluster: c}
+	member := func(name string, meta gossipMeta) *memberlist.Node {
+		bytes, _ := json.Marshal(meta)
+		return &memberlist.Node{Name: name, Meta: bytes}
+	}
+	check := func(when string, want map[string][]string) {
+		t.Helper()
+		for function, nodes := range want {
+			if got := c.Nodes(function); !slices.Equal(got, nodes) {
+				t.Errorf("%v: Nodes(%v) = %v, want %v", when, function, got, nodes)
+			}
+		}
+	}
+
+	// Joining nodes are placed on if they can run a function, invalid and own meta ignored
+	events.NotifyJoin(member("b", gossipMeta{Address: "10.0.0.2:7946"}))
+	events.NotifyJoin(member("c", gossipMeta{Address: "10.0.0.3:7946", Labels: map[string]string{"gpu": "a100"}}))
+	events.NotifyJoin(member("d", gossipMeta{}))
+	events.NotifyJoin(member("a", gossipMeta{Address: "10.0.0.1:7946"}))
+	check("joined", map[string][]string{"func1": {"b", "c"}, "gpu": {"c"}})
+
+	// Updated nodes are placed on as their labels now allow
+	events.NotifyUpdate(member("b", gossipMeta{Address: "10.0.0.2:7946", Labels: map[string]string{"gpu": "t4"}}))
+	check("updated", map[string][]string{"func1": {"c", "b"}, "gpu": {"c", "b"}})
+
+	// Nodes that left or failed aren't routed to
+	events.NotifyLeave(member("c", gossipMeta{}))
+	events.NotifyLeave(member("a", gossipMeta{}))
+	check("left", map[string][]string{"func1": {"b"}, "gpu": {"b"}})
+	if n := c.pick("gpu", false); n == nil || n.Name != "b" || n.Address != "10.0.0.2:7946" {
+		t.Errorf("pick(gpu) = %+v, want node b at its gossiped address", n)
+	}
+}
@@ -0,0 +1,71 @@
+package slrun
+
+import (
+	"cmp"
+	"net/http"
+	"strconv"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Version of the slrun function contract, passed to functions as SLRUN_PROTOCOL
+const handshakeProtocol = 1
+
+// Health path of functions following the contract
+const handshakeHealthPath = "/healthz"
+
+// applyHandshake fills the unset settings of a function following the contract.
+// It is applied before the runtime profile, so the contract's settings win over the profile's.
+func applyHandshake(f *types.Function) {
+	if !f.Handshake {
+		return
+	}
+	if f.ReadyPath == "" {
+		f.ReadyPath = handshakeHealthPath
+	}
+	if f.StopSignal == "" {
+		f.StopSignal = "SIGTERM"
+	}
+	if f.StopTimeout == 0 {
+		f.StopTimeout = 10
+	}
+}
+
+// contractEnv returns the environment telling a function how slrun runs it.
+// Every function gets it, those following the contract rely on it.
+func contractEnv(function *types.Function) []string {
+	env := []string{
+		"SLRUN_PROTOCOL=" + strconv.Itoa(handshakeProtocol),
+		"SLRUN_FUNCTION=" + cmp.Or(function.Base, function.Name),
+		"SLRUN_READY_PATH=" + function.ReadyPath,
+		"SLRUN_STOP_SIGNAL=" + cmp.Or(function.StopSignal, "SIGTERM"),
+		"SLRUN_STOP_TIMEOUT=" + strconv.Itoa(function.StopTimeout),
+	}
+	if !function.Socket {
+		env = append(env, "SLRUN_PORT="+strconv.Itoa(function.ContainerPort))
+	}
+	return env
+}
+
+// probeReady sends a readiness probe to the function. Functions with a readiness probe are
+// ready once it succeeds, those following the contract once their health path answers GET
+// with a 2xx status, others once they answer HEAD at all.
+func (r *Runtime) probeReady(function *types.Function) (bool, error) {
+	if function.Readiness != nil {
+		return r.probeReadiness(function)
+	}
+	method := http.MethodHead
+	if function.Handshake {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, r.functionURL(function, function.ReadyPath), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := r.functionClient(function).Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return !function.Handshake || (resp.StatusCode >= 200 && resp.StatusCode < 300), nil
+}
@@ -0,0 +1,63 @@
+package slrun
+
+import (
+	"net/http"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// headerPolicyWriter applies header policies to a response just before its headers are written.
+type headerPolicyWriter struct {
+	http.ResponseWriter
+	policies    []*types.HeaderPolicy
+	wroteHeader bool
+}
+
+func (w *headerPolicyWriter) apply() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.ResponseWriter.Header()
+	for _, p := range w.policies {
+		for _, name := range p.Remove {
+			h.Del(name)
+		}
+		for name, value := range p.Defaults {
+			if h.Get(name) == "" {
+				h.Set(name, value)
+			}
+		}
+		for name, value := range p.Set {
+			h.Set(name, value)
+		}
+	}
+}
+
+func (w *headerPolicyWriter) WriteHeader(status int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerPolicyWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerPolicyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func newHeaderPolicyWriter(w http.ResponseWriter, global *types.HeaderPolicy) *headerPolicyWriter {
+	hw := &headerPolicyWriter{ResponseWriter: w}
+	hw.addPolicy(global)
+	return hw
+}
+
+// addPolicy adds a policy applied after those already added.
+func (w *headerPolicyWriter) addPolicy(p *types.HeaderPolicy) {
+	if p != nil {
+		w.policies = append(w.policies, p)
+	}
+}
@@ -0,0 +1,75 @@
+package slrun
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestHeaderPolicyWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   *types.HeaderPolicy
+		function *types.HeaderPolicy
+		response map[string]string // Headers set by the function
+		want     map[string]string // "" for headers that must be absent
+	}{
+		{
+			name:     "no policies",
+			response: map[string]string{"Server": "func"},
+			want:     map[string]string{"Server": "func"},
+		},
+		{
+			name:     "set replaces",
+			global:   &types.HeaderPolicy{Set: map[string]string{"X-Frame-Options": "DENY"}},
+			response: map[string]string{"X-Frame-Options": "SAMEORIGIN"},
+			want:     map[string]string{"X-Frame-Options": "DENY"},
+		},
+		{
+			name:     "defaults keep function value",
+			global:   &types.HeaderPolicy{Defaults: map[string]string{"Cache-Control": "no-store", "X-Default": "yes"}},
+			response: map[string]string{"Cache-Control": "max-age=60"},
+			want:     map[string]string{"Cache-Control": "max-age=60", "X-Default": "yes"},
+		},
+		{
+			name:     "remove",
+			global:   &types.HeaderPolicy{Remove: []string{"Server", "X-Powered-By"}},
+			response: map[string]string{"Server": "func", "X-Powered-By": "php", "X-Kept": "1"},
+			want:     map[string]string{"Server": "", "X-Powered-By": "", "X-Kept": "1"},
+		},
+		{
+			name:     "function policy applies after global",
+			global:   &types.HeaderPolicy{Set: map[string]string{"Cache-Control": "no-store"}},
+			function: &types.HeaderPolicy{Set: map[string]string{"Cache-Control": "public"}},
+			want:     map[string]string{"Cache-Control": "public"},
+		},
+		{
+			name:     "function removes global default",
+			global:   &types.HeaderPolicy{Defaults: map[string]string{"X-Global": "1"}},
+			function: &types.HeaderPolicy{Remove: []string{"X-Global"}},
+			want:     map[string]string{"X-Global": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w := newHeaderPolicyWriter(rec, tt.global)
+			w.addPolicy(tt.function)
+
+			for name, value := range tt.response {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("ok"))
+
+			for name, want := range tt.want {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("header %v = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
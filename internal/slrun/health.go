@@ -0,0 +1,81 @@
+package slrun
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Docker's default interval between health checks
+const defaultHealthInterval = 30 * time.Second
+
+// imageHealth returns the health state of a new container of the image, starting if the
+// image has a HEALTHCHECK, and how long the container may take to first report healthy.
+func imageHealth(inspect image.InspectResponse) (string, time.Duration) {
+	if inspect.Config == nil || inspect.Config.Healthcheck == nil {
+		return "", 0
+	}
+	check := inspect.Config.Healthcheck
+	if len(check.Test) == 0 || check.Test[0] == "NONE" {
+		return "", 0
+	}
+	interval := check.Interval
+	if interval == 0 {
+		interval = defaultHealthInterval
+	}
+	// Checks in the start period are at the start interval, the first after it at the interval
+	return string(container.Starting), check.StartPeriod + interval
+}
+
+// healthStatus returns the health state a Docker health_status event reports, if it is one.
+func healthStatus(msg dockerevents.Message) (string, bool) {
+	action := string(msg.Action)
+	if !strings.HasPrefix(action, string(dockerevents.ActionHealthStatus)+":") {
+		return "", false
+	}
+	status := strings.TrimSpace(strings.TrimPrefix(action, string(dockerevents.ActionHealthStatus)+":"))
+	if status != string(container.Healthy) && status != string(container.Unhealthy) {
+		return "", false
+	}
+	return status, true
+}
+
+// healthChanged records the health state Docker reports for the function's container.
+// An unhealthy container is replaced, as Docker keeps it running.
+func (r *Runtime) healthChanged(function *types.Function, status string) {
+	if function.Health == status {
+		return
+	}
+	log.Printf("Function %v container is %v\n", function.Name, status)
+	function.Health = status
+	if status != string(container.Unhealthy) {
+		return
+	}
+
+	r.events.Publish(Event{
+		Type:     EventUnhealthy,
+		Function: function.Name,
+		Data:     map[string]any{"container_id": function.ContainerId},
+	})
+	go func() {
+		err := r.RestartFunction(function)
+		if err != nil {
+			r.functionStartFailed(function, fmt.Errorf("cannot replace unhealthy container: %w", err))
+			log.Printf("Cannot restart unhealthy function %v: %v\n", function.Name, err)
+		}
+	}()
+}
+
+// healthy returns an error unless the function's container is healthy, or has no health check.
+func healthy(function *types.Function) error {
+	if function.Health == "" || function.Health == string(container.Healthy) {
+		return nil
+	}
+	return fmt.Errorf("container is %v", function.Health)
+}
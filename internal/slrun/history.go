@@ -0,0 +1,165 @@
+package slrun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Deployment results
+const (
+	DeploySucceeded = "succeeded"
+	DeployFailed    = "failed"
+)
+
+// Deployment is a build and deploy of a function, kept in its history.
+type Deployment struct {
+	ID     int            `json:"id"`
+	Time   time.Time      `json:"time"`
+	Image  string         `json:"image,omitempty"` // Image ID
+	Result string         `json:"result"`
+	Error  string         `json:"error,omitempty"`
+	Config map[string]any `json:"config"` // The function's config when deployed
+}
+
+// Guards history files
+var historyMu sync.Mutex
+
+func historyFile(stateDir string, function string) string {
+	return filepath.Join(stateDir, "history", function+".json")
+}
+
+// ReadHistory returns a function's deployments, oldest first.
+func ReadHistory(stateDir string, function string) ([]*Deployment, error) {
+	bytes, err := os.ReadFile(historyFile(stateDir, function))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*Deployment
+	err = json.Unmarshal(bytes, &history)
+	return history, err
+}
+
+// RecordDeployment appends a deployment of function to its history.
+// deployErr is the error deploying it, nil if it succeeded.
+func RecordDeployment(stateDir string, function *types.Function, imageID string, deployErr error) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	history, err := ReadHistory(stateDir, function.Name)
+	if err != nil {
+		return err
+	}
+
+	// Snapshot the config as written in the config file
+	bytes, err := json.Marshal(function)
+	if err != nil {
+		return err
+	}
+	var config map[string]any
+	err = json.Unmarshal(bytes, &config)
+	if err != nil {
+		return err
+	}
+
+	// IDs of deployments pruned by retention aren't reused
+	id := 1
+	if len(history) > 0 {
+		id = history[len(history)-1].ID + 1
+	}
+	d := &Deployment{
+		ID:     id,
+		Time:   time.Now(),
+		Image:  imageID,
+		Result: DeploySucceeded,
+		Config: config,
+	}
+	if deployErr != nil {
+		d.Result = DeployFailed
+		d.Error = deployErr.Error()
+	}
+	return writeHistory(stateDir, function.Name, append(history, d))
+}
+
+func writeHistory(stateDir string, function string, history []*Deployment) error {
+	file := historyFile(stateDir, function)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, bytes, 0644)
+}
+
+// flatten flattens nested config values into dotted paths, e.g. env.LOG_LEVEL.
+func flatten(prefix string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			flatten(path, child, out)
+		}
+	case []any:
+		for i, child := range v {
+			flatten(fmt.Sprintf("%v[%d]", prefix, i), child, out)
+		}
+	default:
+		bytes, _ := json.Marshal(v)
+		out[prefix] = string(bytes)
+	}
+}
+
+// DiffDeployments returns the config and image differences from deployment a to b,
+// one line per changed field, prefixed with - for removed and + for added values.
+func DiffDeployments(a *Deployment, b *Deployment) []string {
+	before := make(map[string]string)
+	after := make(map[string]string)
+	flatten("", a.Config, before)
+	flatten("", b.Config, after)
+	before["image"] = a.Image
+	after["image"] = b.Image
+
+	var paths []string
+	for path := range before {
+		paths = append(paths, path)
+	}
+	for path := range after {
+		if _, exists := before[path]; !exists {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	var diff []string
+	for _, path := range paths {
+		old, hadOld := before[path]
+		cur, hasCur := after[path]
+		if hadOld && hasCur && old == cur {
+			continue
+		}
+		if hadOld {
+			diff = append(diff, fmt.Sprintf("- %v: %v", path, old))
+		}
+		if hasCur {
+			diff = append(diff, fmt.Sprintf("+ %v: %v", path, cur))
+		}
+	}
+	return diff
+}
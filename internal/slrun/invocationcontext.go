@@ -0,0 +1,100 @@
+package slrun
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Headers telling functions about the invocation, replacing any the client sent
+const (
+	functionNameHeader = "X-Slrun-Function"    // Function invoked
+	versionHeader      = "X-Slrun-Version"     // Short ID of the image its container runs
+	coldStartHeader    = "X-Slrun-Cold-Start"  // Whether the call started its container, true or false
+	deadlineHeader     = "X-Slrun-Deadline-Ms" // Milliseconds left before the call times out, if it has a deadline
+)
+
+type arrivalKey struct{}
+
+// withArrival records when requests arrived at the gateway, before any middleware, in their context.
+func withArrival(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), arrivalKey{}, time.Now())))
+	})
+}
+
+// arrival returns when r arrived at the gateway, now if it didn't come through it.
+func arrival(r *http.Request) time.Time {
+	if t, ok := r.Context().Value(arrivalKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// callerDeadline returns the deadline r's caller gave in the deadline header, counted from
+// when r arrived at the gateway, or false if it gave none.
+func callerDeadline(r *http.Request) (time.Time, bool) {
+	ms, err := strconv.ParseInt(r.Header.Get(deadlineHeader), 10, 64)
+	if err != nil || ms < 0 {
+		return time.Time{}, false
+	}
+	return arrival(r).Add(time.Duration(ms) * time.Millisecond), true
+}
+
+// invocationDeadline returns when a call of function made by r times out: the function's
+// timeout after r arrived at the gateway, or its caller's deadline if sooner. False if the
+// function has no timeout and the caller no deadline.
+func invocationDeadline(function *types.Function, r *http.Request) (time.Time, bool) {
+	deadline, ok := callerDeadline(r)
+	if function == nil || function.Timeout <= 0 {
+		return deadline, ok
+	}
+	timeout := arrival(r).Add(time.Duration(function.Timeout) * time.Second)
+	if !ok || timeout.Before(deadline) {
+		return timeout, true
+	}
+	return deadline, true
+}
+
+// forwardDeadline sets the deadline header of a call of function made by r forwarded to
+// another slrun node, function nil if its timeout is unknown here, to the time left.
+func forwardDeadline(h http.Header, function *types.Function, r *http.Request) {
+	h.Del(deadlineHeader)
+	if deadline, ok := invocationDeadline(function, r); ok {
+		h.Set(deadlineHeader, remainingMs(deadline))
+	}
+}
+
+// deadlineError is the error of a call of function past its deadline.
+func deadlineError(function *types.Function) error {
+	err := fmt.Errorf("function %v didn't answer before the call's deadline", function.Name)
+	return invocationError(ErrClassTimeout, http.StatusGatewayTimeout, err)
+}
+
+func remainingMs(deadline time.Time) string {
+	return strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 0), 10)
+}
+
+// imageVersion returns the short ID of an image, e.g. 0123456789ab.
+func imageVersion(inspect image.InspectResponse) string {
+	id := strings.TrimPrefix(inspect.ID, "sha256:")
+	return id[:min(len(id), 12)]
+}
+
+// setContextHeaders sets the invocation context headers of a call of function.
+func setContextHeaders(h http.Header, function *types.Function, cold bool, deadline time.Time, hasDeadline bool) {
+	h.Set(functionNameHeader, cmp.Or(function.Base, function.Name))
+	h.Set(versionHeader, function.Version)
+	h.Set(coldStartHeader, strconv.FormatBool(cold))
+	h.Del(deadlineHeader)
+	if hasDeadline {
+		h.Set(deadlineHeader, remainingMs(deadline))
+	}
+}
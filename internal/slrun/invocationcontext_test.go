@@ -0,0 +1,126 @@
+package slrun
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestSetContextHeaders(t *testing.T) {
+	replica := &types.Function{Name: "func1#2", Base: "func1", Version: "0123456789ab"}
+
+	h := http.Header{deadlineHeader: {"999999"}, coldStartHeader: {"spoofed"}}
+	setContextHeaders(h, replica, true, time.Time{}, false)
+	if got := h.Get(functionNameHeader); got != "func1" {
+		t.Errorf("%v = %q, want func1", functionNameHeader, got)
+	}
+	if got := h.Get(versionHeader); got != "0123456789ab" {
+		t.Errorf("%v = %q, want 0123456789ab", versionHeader, got)
+	}
+	if got := h.Get(coldStartHeader); got != "true" {
+		t.Errorf("%v = %q, want true", coldStartHeader, got)
+	}
+	if got := h.Get(deadlineHeader); got != "" {
+		t.Errorf("%v = %q without a timeout, want none", deadlineHeader, got)
+	}
+
+	setContextHeaders(h, replica, false, time.Now().Add(2*time.Second), true)
+	ms, err := strconv.Atoi(h.Get(deadlineHeader))
+	if err != nil || ms <= 1000 || ms > 2000 {
+		t.Errorf("%v = %q, want about 2000", deadlineHeader, h.Get(deadlineHeader))
+	}
+	setContextHeaders(h, replica, false, time.Now().Add(-time.Second), true)
+	if got := h.Get(deadlineHeader); got != "0" {
+		t.Errorf("%v = %q past the deadline, want 0", deadlineHeader, got)
+	}
+}
+
+func TestInvocationDeadline(t *testing.T) {
+	var got time.Time
+	var ok bool
+	fun := &types.Function{Name: "func1", Timeout: 5}
+	handler := withArrival(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		got, ok = invocationDeadline(fun, r)
+	}))
+
+	before := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/func1", nil))
+	if !ok || got.Before(before.Add(5*time.Second)) || got.After(before.Add(5*time.Second+10*time.Millisecond)) {
+		t.Errorf("invocationDeadline() = %v, %v, want 5s after arrival at %v", got, ok, before)
+	}
+
+	if _, ok := invocationDeadline(&types.Function{Name: "func2"}, httptest.NewRequest("GET", "/func2", nil)); ok {
+		t.Errorf("invocationDeadline() of a function without timeout has a deadline")
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	inspect := image.InspectResponse{ID: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+	if got := imageVersion(inspect); got != "0123456789ab" {
+		t.Errorf("imageVersion() = %q, want 0123456789ab", got)
+	}
+}
+
+func TestCallerDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		timeout int
+		wantMs  int64 // -1 for no deadline
+	}{
+		{"none", "", 0, -1},
+		{"caller only", "1500", 0, 1500},
+		{"timeout only", "", 3, 3000},
+		{"caller sooner", "1500", 3, 1500},
+		{"timeout sooner", "5000", 3, 3000},
+		{"invalid", "soon", 0, -1},
+		{"negative", "-5", 0, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadline time.Time
+			var ok bool
+			var arrived time.Time
+			handler := withArrival(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				arrived = arrival(r)
+				deadline, ok = invocationDeadline(&types.Function{Name: "func1", Timeout: tt.timeout}, r)
+			}))
+			r := httptest.NewRequest("GET", "/func1", nil)
+			if tt.header != "" {
+				r.Header.Set(deadlineHeader, tt.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if ok != (tt.wantMs >= 0) {
+				t.Fatalf("invocationDeadline() has deadline %v, want %v", ok, tt.wantMs >= 0)
+			}
+			if ok && deadline.Sub(arrived).Milliseconds() != tt.wantMs {
+				t.Errorf("invocationDeadline() = arrival + %v, want %vms", deadline.Sub(arrived), tt.wantMs)
+			}
+		})
+	}
+}
+
+func TestForwardDeadline(t *testing.T) {
+	r := httptest.NewRequest("GET", "/func1", nil)
+	r.Header.Set(deadlineHeader, "2000")
+	h := r.Header.Clone()
+	forwardDeadline(h, nil, r)
+	ms, err := strconv.Atoi(h.Get(deadlineHeader))
+	if err != nil || ms > 2000 || ms < 1900 {
+		t.Errorf("forwardDeadline() set %q, want about 2000", h.Get(deadlineHeader))
+	}
+
+	h = http.Header{}
+	forwardDeadline(h, nil, httptest.NewRequest("GET", "/func1", nil))
+	if got := h.Get(deadlineHeader); got != "" {
+		t.Errorf("forwardDeadline() without deadline set %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package slrun
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Invocations kept in the history of each function
+const invocationHistorySize = 1000
+
+// InvocationTiming breaks an invocation's latency down by where it was spent.
+type InvocationTiming struct {
+	Queue     time.Duration // Waiting in the async queue
+	ColdStart time.Duration // Starting the function's container, zero if it was running
+	Execution time.Duration // The function serving it, until its response was sent
+}
+
+func (t InvocationTiming) Total() time.Duration {
+	return t.Queue + t.ColdStart + t.Execution
+}
+
+// InvocationRecord is an invocation in a function's history.
+type InvocationRecord struct {
+	RequestID   string    `json:"request_id"`
+	Function    string    `json:"function"`
+	Time        time.Time `json:"time"`
+	Status      int       `json:"status"` // Sent to the client, including gateway errors
+	Cold        bool      `json:"cold"`
+	QueueMs     float64   `json:"queue_ms"`
+	ColdStartMs float64   `json:"cold_start_ms"`
+	ExecutionMs float64   `json:"execution_ms"`
+	TotalMs     float64   `json:"total_ms"`
+}
+
+// LatencyBreakdown summarises a function's recent invocations, to weigh what cold starts cost.
+type LatencyBreakdown struct {
+	Function        string  `json:"function"`
+	Invocations     int     `json:"invocations"`
+	ColdStarts      int     `json:"cold_starts"`
+	P50Ms           float64 `json:"p50_ms"`
+	P99Ms           float64 `json:"p99_ms"`
+	WarmP99Ms       float64 `json:"warm_p99_ms"`        // p99 of invocations without a cold start
+	ColdStartP99Ms  float64 `json:"cold_start_p99_ms"`  // What cold starts add to the p99
+	MeanQueueMs     float64 `json:"mean_queue_ms"`      // Of all invocations
+	MeanColdStartMs float64 `json:"mean_cold_start_ms"` // Of invocations with a cold start
+	MeanExecutionMs float64 `json:"mean_execution_ms"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// percentile returns the pth percentile of sorted values, zero if there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// InvocationHistory keeps the latest invocations of each function with their timing.
+type InvocationHistory struct {
+	mu          sync.Mutex
+	invocations map[string][]*InvocationRecord // By function, oldest first
+}
+
+func NewInvocationHistory() *InvocationHistory {
+	return &InvocationHistory{invocations: make(map[string][]*InvocationRecord)}
+}
+
+func (h *InvocationHistory) Record(function string, requestID string, status int, timing InvocationTiming) {
+	rec := &InvocationRecord{
+		RequestID:   requestID,
+		Function:    function,
+		Time:        time.Now(),
+		Status:      status,
+		Cold:        timing.ColdStart > 0,
+		QueueMs:     milliseconds(timing.Queue),
+		ColdStartMs: milliseconds(timing.ColdStart),
+		ExecutionMs: milliseconds(timing.Execution),
+		TotalMs:     milliseconds(timing.Total()),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := append(h.invocations[function], rec)
+	if len(records) > invocationHistorySize {
+		records = records[len(records)-invocationHistorySize:]
+	}
+	h.invocations[function] = records
+}
+
+// Prune forgets invocations before t.
+func (h *InvocationHistory) Prune(t time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pruned := 0
+	for function, records := range h.invocations {
+		i := slices.IndexFunc(records, func(rec *InvocationRecord) bool { return !rec.Time.Before(t) })
+		if i < 0 {
+			i = len(records)
+		}
+		pruned += i
+		if i == len(records) {
+			delete(h.invocations, function)
+		} else {
+			h.invocations[function] = records[i:]
+		}
+	}
+	return pruned
+}
+
+// Recent returns copies of a function's latest invocations, newest first, at most limit if positive.
+func (h *InvocationHistory) Recent(function string, limit int) []*InvocationRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := []*InvocationRecord{}
+	history := h.invocations[function]
+	for i := len(history) - 1; i >= 0 && (limit <= 0 || len(records) < limit); i-- {
+		rec := *history[i]
+		records = append(records, &rec)
+	}
+	return records
+}
+
+// Breakdown summarises a function's latest invocations.
+func (h *InvocationHistory) Breakdown(function string) *LatencyBreakdown {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b := &LatencyBreakdown{Function: function}
+	var totals, warm []float64
+	var queue, coldStart, execution float64
+	for _, rec := range h.invocations[function] {
+		b.Invocations++
+		totals = append(totals, rec.TotalMs)
+		if rec.Cold {
+			b.ColdStarts++
+			coldStart += rec.ColdStartMs
+		} else {
+			warm = append(warm, rec.TotalMs)
+		}
+		queue += rec.QueueMs
+		execution += rec.ExecutionMs
+	}
+	if b.Invocations == 0 {
+		return b
+	}
+
+	slices.Sort(totals)
+	slices.Sort(warm)
+	b.P50Ms = percentile(totals, 0.5)
+	b.P99Ms = percentile(totals, 0.99)
+	b.WarmP99Ms = percentile(warm, 0.99)
+	b.ColdStartP99Ms = max(b.P99Ms-b.WarmP99Ms, 0)
+	b.MeanQueueMs = queue / float64(b.Invocations)
+	b.MeanExecutionMs = execution / float64(b.Invocations)
+	if b.ColdStarts > 0 {
+		b.MeanColdStartMs = coldStart / float64(b.ColdStarts)
+	}
+	return b
+}
@@ -0,0 +1,27 @@
+package slrun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvocationTimingTotal(t *testing.T) {
+	tests := []struct {
+		name   string
+		timing InvocationTiming
+		want   time.Duration
+	}{
+		{"zero", InvocationTiming{}, 0},
+		{"hot", InvocationTiming{Execution: 20 * time.Millisecond}, 20 * time.Millisecond},
+		{"cold", InvocationTiming{ColdStart: time.Second, Execution: 20 * time.Millisecond}, 1020 * time.Millisecond},
+		{"queued cold", InvocationTiming{Queue: 5 * time.Millisecond, ColdStart: time.Second, Execution: 20 * time.Millisecond}, 1025 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.timing.Total(); got != tt.want {
+				t.Errorf("Total() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package slrun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Labels of the containers slrun creates, so it finds its own rather than any running the same image
+const (
+	labelFunction = "slrun.function" // Function or instance name, e.g. func1@acme or func1#2
+	labelInstance = "slrun.instance" // ID of the slrun instance that created it
+)
+
+// instanceID returns the ID of the slrun instance with the state dir. It is the same across
+// restarts, so slrun finds the containers of its previous runs, and differs between instances
+// run side by side with their own state dirs.
+func instanceID(stateDir string) string {
+	dir, err := filepath.Abs(stateDir)
+	if err != nil {
+		dir = stateDir
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:6])
+}
+
+// containerLabels returns the labels of a container of the function created by the instance.
+func containerLabels(instance string, function *types.Function) map[string]string {
+	return map[string]string{labelFunction: function.Name, labelInstance: instance}
+}
+
+// instanceFilter returns the filter of the containers, or their events, created by the instance.
+func instanceFilter(instance string) filters.KeyValuePair {
+	return filters.Arg("label", labelInstance+"="+instance)
+}
@@ -0,0 +1,21 @@
+package slrun
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestInstanceID(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	id := instanceID(".slrun")
+	if len(id) != 12 {
+		t.Errorf("instanceID() = %q, want 12 hex digits", id)
+	}
+	if other := instanceID(filepath.Join(dir, ".slrun")); other != id {
+		t.Errorf("instanceID() of the absolute state dir = %q, want %q", other, id)
+	}
+	if other := instanceID("other"); other == id {
+		t.Errorf("instanceID() of another state dir = %q, want a different one", other)
+	}
+}
@@ -0,0 +1,238 @@
+package slrun
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/miekg/dns"
+)
+
+// Seconds LAN devices may cache advertised records
+const mdnsTTL = 120
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+func validateMDNS(config *types.Config) error {
+	m := config.MDNS
+	if m == nil {
+		return nil
+	}
+	if m.Name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fieldError("mdns.name", "cannot default mdns name to the hostname: %w", err)
+		}
+		m.Name, _, _ = strings.Cut(hostname, ".")
+	}
+	if strings.Contains(m.Name, ".") {
+		return fieldError("mdns.name", "mdns name must not contain dots: %s", m.Name)
+	}
+	return nil
+}
+
+// MDNS advertises the gateway and each function as services on the LAN with multicast DNS,
+// so other devices can discover and call them.
+type MDNS struct {
+	config   *types.MDNS
+	gateway  *Gateway
+	listener *types.Listener // Advertised gateway listener
+	conn     *net.UDPConn
+}
+
+func NewMDNS(config *types.MDNS, gateway *Gateway) *MDNS {
+	if config == nil {
+		return nil
+	}
+	return &MDNS{config: config, gateway: gateway, listener: gateway.listeners[0]}
+}
+
+// service returns the DNS-SD service type of the advertised listener.
+func (m *MDNS) service() string {
+	if m.listener.TLSCert != "" && m.listener.TLSKey != "" {
+		return "_https._tcp.local."
+	}
+	return "_http._tcp.local."
+}
+
+// host returns the advertised host name, <name>.local.
+func (m *MDNS) host() string {
+	return m.config.Name + ".local."
+}
+
+// instance returns the service instance name of a function, or of the gateway if empty.
+func (m *MDNS) instance(function string) string {
+	name := "slrun on " + m.config.Name
+	if function != "" {
+		name = function + " on " + m.config.Name
+	}
+	return name + "." + m.service()
+}
+
+// records returns the advertised records, with ttl, of the gateway and the functions its
+// listener routes.
+func (m *MDNS) records(ttl uint32) []dns.RR {
+	_, portStr, _ := net.SplitHostPort(m.listener.Address)
+	port, _ := strconv.Atoi(portStr)
+
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+	service := func(function string, path string) []dns.RR {
+		instance := m.instance(function)
+		txt := []string{"path=" + path}
+		if function != "" {
+			txt = append(txt, "function="+function)
+		}
+		return []dns.RR{
+			&dns.PTR{Hdr: hdr(m.service(), dns.TypePTR), Ptr: instance},
+			&dns.SRV{Hdr: hdr(instance, dns.TypeSRV), Port: uint16(port), Target: m.host()},
+			&dns.TXT{Hdr: hdr(instance, dns.TypeTXT), Txt: txt},
+		}
+	}
+
+	rrs := service("", "/")
+	for _, f := range m.gateway.runtime.functions {
+		if f.IsEnabled && (len(m.listener.Functions) == 0 || slices.Contains(m.listener.Functions, f.Name)) {
+			rrs = append(rrs, service(f.Name, "/"+f.Name+"/")...)
+		}
+	}
+	for _, ip := range lanIPs() {
+		if ip4 := ip.To4(); ip4 != nil {
+			rrs = append(rrs, &dns.A{Hdr: hdr(m.host(), dns.TypeA), A: ip4})
+		} else {
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr(m.host(), dns.TypeAAAA), AAAA: ip})
+		}
+	}
+	return rrs
+}
+
+// lanIPs returns the host's addresses other devices may reach it at.
+func lanIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && ipnet.IP.IsGlobalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
+
+// answer returns the response to a query, nil if it asks for nothing advertised.
+// Answers to PTR queries carry the instances' SRV, TXT and address records as extras.
+func (m *MDNS) answer(query *dns.Msg) *dns.Msg {
+	rrs := m.records(mdnsTTL)
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+
+	for _, q := range query.Question {
+		for _, rr := range rrs {
+			h := rr.Header()
+			if strings.EqualFold(h.Name, q.Name) && (q.Qtype == dns.TypeANY || q.Qtype == h.Rrtype) {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+	}
+	if len(resp.Answer) == 0 {
+		return nil
+	}
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			for _, extra := range rrs {
+				name := extra.Header().Name
+				if name == ptr.Ptr || (name == m.host() && !slices.Contains(resp.Extra, extra)) {
+					resp.Extra = append(resp.Extra, extra)
+				}
+			}
+		}
+	}
+	return resp
+}
+
+// send multicasts a message to the group.
+func (m *MDNS) send(msg *dns.Msg, dst *net.UDPAddr) {
+	out, err := msg.Pack()
+	if err != nil {
+		log.Printf("Cannot pack mDNS message: %v\n", err)
+		return
+	}
+	_, err = m.conn.WriteToUDP(out, dst)
+	if err != nil {
+		log.Printf("Cannot send mDNS message: %v\n", err)
+	}
+}
+
+// announce multicasts all records, with ttl, or zero to say goodbye.
+func (m *MDNS) announce(ttl uint32) {
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = m.records(ttl)
+	m.send(msg, mdnsGroup)
+}
+
+// Start joins the mDNS group, announces the services and answers queries for them.
+func (m *MDNS) Start() error {
+	if m == nil {
+		return nil
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("cannot join mDNS group: %w", err)
+	}
+	m.conn = conn
+
+	if host, _, _ := net.SplitHostPort(m.listener.Address); host != "" && net.ParseIP(host) != nil && net.ParseIP(host).IsLoopback() {
+		log.Printf("mDNS advertises gateway listener %v, which other devices can't reach\n", m.listener.Address)
+	}
+	m.announce(mdnsTTL)
+
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return // Closed
+			}
+			var query dns.Msg
+			if query.Unpack(buf[:n]) != nil || query.Response {
+				continue
+			}
+			resp := m.answer(&query)
+			if resp == nil {
+				continue
+			}
+
+			// Legacy resolvers querying from another port get a unicast reply echoing the query
+			dst := mdnsGroup
+			if src.Port != mdnsGroup.Port {
+				dst = src
+				resp.Id = query.Id
+				resp.Question = query.Question
+			}
+			m.send(resp, dst)
+		}
+	}()
+	fmt.Printf("mDNS advertising %v as %v\n", m.service(), m.instance(""))
+	return nil
+}
+
+// Stop says goodbye, so devices drop the services at once, and leaves the group.
+func (m *MDNS) Stop() {
+	if m == nil || m.conn == nil {
+		return
+	}
+	m.announce(0)
+	m.conn.Close()
+}
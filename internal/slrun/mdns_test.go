@@ -0,0 +1,66 @@
+package slrun
+
+import (
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/miekg/dns"
+)
+
+func TestMDNSAnswer(t *testing.T) {
+	gateway := &Gateway{
+		runtime: &Runtime{functions: []*types.Function{
+			{Name: "func1", IsEnabled: true},
+			{Name: "func2"},
+		}},
+		listeners: []*types.Listener{{Address: "0.0.0.0:1337"}},
+	}
+	m := NewMDNS(&types.MDNS{Name: "ada"}, gateway)
+
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		wantAns  int // Answers, 0 if no response
+		wantSRV  bool
+		wantPath string // Of the TXT answer, if any
+	}{
+		{name: "browse", qname: "_http._tcp.local.", qtype: dns.TypePTR, wantAns: 2},
+		{name: "function srv", qname: "func1 on ada._http._tcp.local.", qtype: dns.TypeSRV, wantAns: 1, wantSRV: true},
+		{name: "function txt", qname: "func1 on ada._http._tcp.local.", qtype: dns.TypeTXT, wantAns: 1, wantPath: "path=/func1/"},
+		{name: "case insensitive", qname: "SLRUN ON ADA._http._tcp.local.", qtype: dns.TypeTXT, wantAns: 1, wantPath: "path=/"},
+		{name: "disabled function", qname: "func2 on ada._http._tcp.local.", qtype: dns.TypeANY},
+		{name: "other service", qname: "_ipp._tcp.local.", qtype: dns.TypePTR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, tt.qtype)
+			resp := m.answer(query)
+			if tt.wantAns == 0 {
+				if resp != nil {
+					t.Fatalf("answer() = %v, want no response", resp)
+				}
+				return
+			}
+			if resp == nil || len(resp.Answer) != tt.wantAns {
+				t.Fatalf("answer() = %v, want %v answers", resp, tt.wantAns)
+			}
+			switch rr := resp.Answer[0].(type) {
+			case *dns.SRV:
+				if !tt.wantSRV || rr.Port != 1337 || rr.Target != "ada.local." {
+					t.Errorf("SRV = %v, want port 1337 on ada.local.", rr)
+				}
+			case *dns.TXT:
+				if rr.Txt[0] != tt.wantPath {
+					t.Errorf("TXT = %v, want %v", rr.Txt, tt.wantPath)
+				}
+			case *dns.PTR:
+				if len(resp.Extra) < 4 {
+					t.Errorf("PTR answer has %v extras, want SRV and TXT of each instance", len(resp.Extra))
+				}
+			}
+		})
+	}
+}
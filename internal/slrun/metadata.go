@@ -0,0 +1,74 @@
+package slrun
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata files looked up in a function's build dir, in order
+var metadataFiles = []string{"slrun.yaml", "slrun.yml"}
+var readmeFiles = []string{"README.md", "README", "README.txt"}
+
+// ReadFunctionMetadata reads a function's metadata from slrun.yaml in its build dir.
+// Without one, the description is taken from the first paragraph of its README.
+// Returns nil if there is neither.
+func ReadFunctionMetadata(function *types.Function) (*types.Metadata, error) {
+	for _, name := range metadataFiles {
+		bytes, err := os.ReadFile(filepath.Join(sourceDir(function), name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var meta types.Metadata
+		err = yaml.Unmarshal(bytes, &meta)
+		if err != nil {
+			return nil, fmt.Errorf("function %v %v: %w", function.Name, name, err)
+		}
+		return &meta, nil
+	}
+
+	for _, name := range readmeFiles {
+		f, err := os.Open(filepath.Join(sourceDir(function), name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		description, err := readmeDescription(f)
+		if err != nil {
+			return nil, err
+		}
+		return &types.Metadata{Description: description}, nil
+	}
+
+	return nil, nil
+}
+
+// readmeDescription returns the first paragraph of a README, skipping headings.
+func readmeDescription(f *os.File) (string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		} else if len(lines) > 0 {
+			break
+		}
+	}
+	return strings.Join(lines, " "), scanner.Err()
+}
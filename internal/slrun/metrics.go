@@ -0,0 +1,246 @@
+package slrun
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bucket upper bounds, in seconds, of invocation and build duration histograms
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	buildBuckets   = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+)
+
+// histogram counts observations in buckets, as a Prometheus histogram.
+type histogram struct {
+	bounds []float64
+	counts []int64 // Observations in each bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+// write writes the histogram's series named name, labels being its other labels, e.g. function="f".
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%v_bucket{%v,le=%q} %v\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%v_bucket{%v,le=\"+Inf\"} %v\n", name, labels, h.count)
+	fmt.Fprintf(w, "%v_sum{%v} %v\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%v_count{%v} %v\n", name, labels, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label returns a Prometheus label pair.
+func label(name string, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// invocationKey identifies an invocation counter.
+type invocationKey struct {
+	function string
+	status   int
+}
+
+// buildKey identifies a build duration histogram.
+type buildKey struct {
+	function string
+	result   string // success or failure
+}
+
+// Metrics collects invocation and build metrics, served in the Prometheus text format.
+// Function states, replicas and restarts are read from the runtime when scraped.
+type Metrics struct {
+	events *Events
+	sub    chan Event
+	done   chan struct{}
+
+	mu          sync.Mutex
+	invocations map[invocationKey]int64
+	latencies   map[string]*histogram // Total invocation latency by function
+	coldStarts  map[string]int64
+	builds      map[buildKey]*histogram
+}
+
+func NewMetrics(events *Events) *Metrics {
+	return &Metrics{
+		events:      events,
+		invocations: make(map[invocationKey]int64),
+		latencies:   make(map[string]*histogram),
+		coldStarts:  make(map[string]int64),
+		builds:      make(map[buildKey]*histogram),
+	}
+}
+
+// Start records builds as their events are published.
+func (m *Metrics) Start() {
+	m.sub = m.events.Subscribe()
+	m.done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case event := <-m.sub:
+				m.recordBuild(event)
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Metrics) Stop() {
+	m.events.Unsubscribe(m.sub)
+	close(m.done)
+}
+
+// recordBuild records the duration of a build event, which carries build_seconds.
+func (m *Metrics) recordBuild(event Event) {
+	seconds, ok := event.Data["build_seconds"].(float64)
+	if !ok {
+		return
+	}
+	key := buildKey{function: event.Function, result: "failure"}
+	if event.Type == EventBuilt {
+		key.result = "success"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, exists := m.builds[key]
+	if !exists {
+		h = newHistogram(buildBuckets)
+		m.builds[key] = h
+	}
+	h.observe(seconds)
+}
+
+// RecordInvocation records a finished invocation of function with the status sent to the client.
+func (m *Metrics) RecordInvocation(function string, status int, timing InvocationTiming) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invocations[invocationKey{function, status}]++
+	h, exists := m.latencies[function]
+	if !exists {
+		h = newHistogram(latencyBuckets)
+		m.latencies[function] = h
+	}
+	h.observe(timing.Total().Seconds())
+	if timing.ColdStart > 0 {
+		m.coldStarts[function]++
+	}
+}
+
+// metricHeader writes the help and type of a metric family.
+func metricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+}
+
+// write writes the recorded metrics, sorted so scrapes are stable.
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metricHeader(w, "slrun_invocations_total", "counter", "Invocations by function and status code sent to the client.")
+	keys := make([]invocationKey, 0, len(m.invocations))
+	for key := range m.invocations {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b invocationKey) int {
+		if c := strings.Compare(a.function, b.function); c != 0 {
+			return c
+		}
+		return a.status - b.status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "slrun_invocations_total{%v,%v} %v\n", label("function", key.function), label("status", strconv.Itoa(key.status)), m.invocations[key])
+	}
+
+	metricHeader(w, "slrun_invocation_duration_seconds", "histogram", "Invocation latency by function, including queueing and cold starts.")
+	for _, function := range sortedKeys(m.latencies) {
+		m.latencies[function].write(w, "slrun_invocation_duration_seconds", label("function", function))
+	}
+
+	metricHeader(w, "slrun_cold_starts_total", "counter", "Invocations that started their function's container.")
+	for _, function := range sortedKeys(m.coldStarts) {
+		fmt.Fprintf(w, "slrun_cold_starts_total{%v} %v\n", label("function", function), m.coldStarts[function])
+	}
+
+	metricHeader(w, "slrun_build_duration_seconds", "histogram", "Image build duration by function and result.")
+	builds := make([]buildKey, 0, len(m.builds))
+	for key := range m.builds {
+		builds = append(builds, key)
+	}
+	slices.SortFunc(builds, func(a, b buildKey) int {
+		if c := strings.Compare(a.function, b.function); c != 0 {
+			return c
+		}
+		return strings.Compare(a.result, b.result)
+	})
+	for _, key := range builds {
+		m.builds[key].write(w, "slrun_build_duration_seconds", label("function", key.function)+","+label("result", key.result))
+	}
+}
+
+// writeStatusMetrics writes metrics of the functions' current states.
+func writeStatusMetrics(w io.Writer, status *Status) {
+	metricHeader(w, "slrun_function_up", "gauge", "Whether the function or instance is running.")
+	for _, state := range status.Functions {
+		up := 0
+		if state.State == StateRunning {
+			up = 1
+		}
+		fmt.Fprintf(w, "slrun_function_up{%v} %v\n", label("function", state.Name), up)
+	}
+
+	metricHeader(w, "slrun_function_replicas", "gauge", "Running replicas of the function.")
+	for _, state := range status.Functions {
+		replicas := state.Replicas
+		if replicas == 0 && state.State == StateRunning {
+			replicas = 1
+		}
+		fmt.Fprintf(w, "slrun_function_replicas{%v} %v\n", label("function", state.Name), replicas)
+	}
+
+	metricHeader(w, "slrun_container_restarts_total", "counter", "Containers started after one died or failed to start.")
+	for _, state := range status.Functions {
+		fmt.Fprintf(w, "slrun_container_restarts_total{%v} %v\n", label("function", state.Name), state.Restarts)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// getMetrics serves the metrics for Prometheus to scrape.
+func (a *Admin) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.gateway.metrics.write(w)
+	writeStatusMetrics(w, buildStatus(a.runtime, a.gateway))
+}
@@ -0,0 +1,67 @@
+package slrun
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := NewMetrics(NewEvents())
+	m.RecordInvocation("func1", 200, InvocationTiming{ColdStart: time.Second, Execution: 20 * time.Millisecond})
+	m.RecordInvocation("func1", 200, InvocationTiming{Execution: 3 * time.Millisecond})
+	m.RecordInvocation("func1", 502, InvocationTiming{Execution: 40 * time.Millisecond})
+	m.recordBuild(Event{Type: EventBuilt, Function: "func1", Data: map[string]any{"build_seconds": 12.5}})
+	m.recordBuild(Event{Type: EventBuildFailed, Function: "func1", Data: map[string]any{"error": "boom", "build_seconds": 2.0}})
+	m.recordBuild(Event{Type: EventExited, Function: "func1"})
+
+	var b strings.Builder
+	m.write(&b)
+	out := b.String()
+
+	want := []string{
+		`slrun_invocations_total{function="func1",status="200"} 2`,
+		`slrun_invocations_total{function="func1",status="502"} 1`,
+		`slrun_invocation_duration_seconds_bucket{function="func1",le="0.005"} 1`,
+		`slrun_invocation_duration_seconds_bucket{function="func1",le="0.05"} 2`,
+		`slrun_invocation_duration_seconds_bucket{function="func1",le="2.5"} 3`,
+		`slrun_invocation_duration_seconds_bucket{function="func1",le="+Inf"} 3`,
+		`slrun_invocation_duration_seconds_count{function="func1"} 3`,
+		`slrun_cold_starts_total{function="func1"} 1`,
+		`slrun_build_duration_seconds_bucket{function="func1",result="success",le="10"} 0`,
+		`slrun_build_duration_seconds_bucket{function="func1",result="success",le="30"} 1`,
+		`slrun_build_duration_seconds_sum{function="func1",result="failure"} 2`,
+		`slrun_build_duration_seconds_count{function="func1",result="failure"} 1`,
+	}
+	for _, line := range want {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics missing %q in:\n%v", line, out)
+		}
+	}
+}
+
+func TestWriteStatusMetrics(t *testing.T) {
+	status := &Status{Functions: []*FunctionState{
+		{Name: "func1", State: StateRunning, Restarts: 2},
+		{Name: "func2", State: StateRunning, Replicas: 3},
+		{Name: "func3", State: StateStopped},
+	}}
+
+	var b strings.Builder
+	writeStatusMetrics(&b, status)
+	out := b.String()
+
+	want := []string{
+		`slrun_function_up{function="func1"} 1`,
+		`slrun_function_up{function="func3"} 0`,
+		`slrun_function_replicas{function="func1"} 1`,
+		`slrun_function_replicas{function="func2"} 3`,
+		`slrun_function_replicas{function="func3"} 0`,
+		`slrun_container_restarts_total{function="func1"} 2`,
+	}
+	for _, line := range want {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics missing %q in:\n%v", line, out)
+		}
+	}
+}
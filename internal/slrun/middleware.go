@@ -0,0 +1,79 @@
+package slrun
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+type middleware func(http.Handler) http.Handler
+
+// middlewares maps listener middleware names to their constructors.
+var middlewares = map[string]func(l *types.Listener) middleware{
+	"logging": loggingMiddleware,
+	"auth":    authMiddleware,
+}
+
+func newMiddleware(name string, l *types.Listener) (middleware, error) {
+	newMw, exists := middlewares[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown middleware: %s", name)
+	}
+	return newMw(l), nil
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// loggingMiddleware logs every request with its status and duration.
+func loggingMiddleware(l *types.Listener) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			log.Printf("%v %v %v %v %v ms\n", l.Address, r.Method, r.URL.Path, rec.status, time.Since(start).Milliseconds())
+		})
+	}
+}
+
+// authMiddleware rejects requests without a valid bearer token.
+func authMiddleware(l *types.Listener) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || !validToken(token, l.AuthTokens) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func validToken(token string, tokens []string) bool {
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
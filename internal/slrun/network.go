@@ -0,0 +1,108 @@
+package slrun
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"net"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Host name containers call the gateway on, mapped to the host by Docker
+const gatewayHostname = "host.docker.internal"
+
+func validateNetwork(config *types.Config) error {
+	if config.Network == nil {
+		config.Network = &types.FunctionNetwork{}
+	}
+	if config.Network.Name == "" {
+		config.Network.Name = "slrun-" + instanceID(config.StateDir)
+	}
+	return nil
+}
+
+// functionNetwork returns the name of the network function containers join, empty if disabled.
+func functionNetwork(config *types.FunctionNetwork) string {
+	if config == nil || config.Disabled {
+		return ""
+	}
+	return config.Name
+}
+
+// ensureNetwork creates the bridge network function containers join, unless it exists.
+func (r *Runtime) ensureNetwork(ctx context.Context) error {
+	if r.network == "" {
+		return nil
+	}
+	networks, err := r.cli.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(filters.Arg("name", r.network))})
+	if err != nil {
+		return err
+	}
+	for _, n := range networks {
+		// The filter matches names containing it
+		if n.Name == r.network {
+			return nil
+		}
+	}
+	_, err = r.cli.NetworkCreate(ctx, r.network, network.CreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{labelInstance: r.instance},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Created network %v\n", r.network)
+	return nil
+}
+
+// networkAliases returns the names other functions call the function's container by on the network:
+// its name and slrun-<name>. Replicas share their function's, so calls are spread across them. Tenant
+// instances have none, as their tenant's data isn't the function's.
+func networkAliases(function *types.Function) []string {
+	if function.Tenant != "" {
+		return nil
+	}
+	name := cmp.Or(function.Base, function.Name)
+	return []string{name, "slrun-" + name}
+}
+
+// networkingConfig returns the networking config of the function's containers, joining the network.
+func (r *Runtime) networkingConfig(function *types.Function) *network.NetworkingConfig {
+	if r.network == "" {
+		return &network.NetworkingConfig{}
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			r.network: {Aliases: networkAliases(function)},
+		},
+	}
+}
+
+// gatewayURL returns the gateway's URL from function containers, reaching the host at hostname,
+// passed as SLRUN_GATEWAY for functions to call each other under /functions/. It is that of the
+// first listener routing every function, plain HTTP ones first as certificates rarely name the host.
+// Empty if no listener routes every function.
+func gatewayURL(listeners []*types.Listener, hostname string) string {
+	tls := func(l *types.Listener) bool { return l.TLSCert != "" && l.TLSKey != "" }
+	var best *types.Listener
+	for _, l := range listeners {
+		if len(l.Functions) == 0 && (best == nil || tls(best) && !tls(l)) {
+			best = l
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(best.Address)
+	if err != nil {
+		return ""
+	}
+	scheme := "http"
+	if tls(best) {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(hostname, port)
+}
@@ -0,0 +1,56 @@
+package slrun
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestNetworkAliases(t *testing.T) {
+	tests := []struct {
+		function *types.Function
+		want     []string
+	}{
+		{&types.Function{Name: "auth"}, []string{"auth", "slrun-auth"}},
+		{&types.Function{Name: "auth-2", Base: "auth"}, []string{"auth", "slrun-auth"}},
+		{&types.Function{Name: "auth-acme", Tenant: "acme"}, nil},
+	}
+	for _, test := range tests {
+		if got := networkAliases(test.function); !slices.Equal(got, test.want) {
+			t.Errorf("networkAliases(%v) = %v, want %v", test.function.Name, got, test.want)
+		}
+	}
+}
+
+func TestFunctionNetwork(t *testing.T) {
+	config := &types.Config{StateDir: ".slrun"}
+	if err := validateNetwork(config); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := functionNetwork(config.Network), "slrun-"+instanceID(".slrun"); got != want {
+		t.Errorf("functionNetwork() = %q, want %q", got, want)
+	}
+	config.Network.Disabled = true
+	if got := functionNetwork(config.Network); got != "" {
+		t.Errorf("functionNetwork() of a disabled network = %q, want none", got)
+	}
+}
+
+func TestGatewayURL(t *testing.T) {
+	tests := []struct {
+		listeners []*types.Listener
+		want      string
+	}{
+		{[]*types.Listener{{Address: "0.0.0.0:8080"}}, "http://host.docker.internal:8080"},
+		{[]*types.Listener{{Address: ":8443", TLSCert: "cert.pem", TLSKey: "key.pem"}}, "https://host.docker.internal:8443"},
+		{[]*types.Listener{{Address: ":8443", TLSCert: "cert.pem", TLSKey: "key.pem"}, {Address: ":8080"}}, "http://host.docker.internal:8080"},
+		{[]*types.Listener{{Address: ":9000", Functions: []string{"auth"}}, {Address: ":8080"}}, "http://host.docker.internal:8080"},
+		{[]*types.Listener{{Address: ":9000", Functions: []string{"auth"}}}, ""},
+	}
+	for _, test := range tests {
+		if got := gatewayURL(test.listeners, gatewayHostname); got != test.want {
+			t.Errorf("gatewayURL(%v) = %q, want %q", test.listeners, got, test.want)
+		}
+	}
+}
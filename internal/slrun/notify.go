@@ -0,0 +1,215 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Notifier types
+const (
+	NotifierSlack   = "slack"
+	NotifierDiscord = "discord"
+	NotifierWebhook = "webhook"
+	NotifierEmail   = "email"
+)
+
+// Failure events, notified by notifiers that don't list their events
+var failureEvents = []string{EventBuildFailed, EventTestsFailed, EventCrashLoop, EventSLOBurn, EventQuarantined}
+
+// Event types notifiers may list
+var notifiableEvents = append(slices.Clone(failureEvents), EventStartFailed, EventExited, EventUnhealthy, EventRedeployed, EventPolicyDenied)
+
+const defaultNotificationTemplate = `slrun: {{.Type}}{{with .Function}} {{.}}{{end}}{{with .Data.error}}: {{.}}{{end}}` +
+	`{{with .Suppressed}} ({{.}} similar suppressed){{end}}`
+
+// notification is the data notification templates are executed on.
+type notification struct {
+	Event
+	Suppressed int // Notifications of the same event type and function dropped by the rate limit
+}
+
+type notifier struct {
+	config   *types.Notifier
+	template *template.Template
+}
+
+type rateKey struct {
+	eventType string
+	function  string
+}
+
+// Notifiers send notifications of failure events, at most one per event type
+// and function per rate limit interval.
+type Notifiers struct {
+	notifiers  []*notifier
+	rateLimit  time.Duration
+	events     *Events
+	client     *http.Client
+	last       map[rateKey]time.Time
+	suppressed map[rateKey]int
+	sub        chan Event
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func parseNotificationTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultNotificationTemplate
+	}
+	return template.New("notification").Option("missingkey=zero").Parse(text)
+}
+
+func NewNotifiers(config *types.Notifications, events *Events) (*Notifiers, error) {
+	rateLimit, err := time.ParseDuration(config.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Notifiers{
+		rateLimit:  rateLimit,
+		events:     events,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		last:       make(map[rateKey]time.Time),
+		suppressed: make(map[rateKey]int),
+		stop:       make(chan struct{}),
+	}
+	for _, c := range config.Notifiers {
+		tmpl, err := parseNotificationTemplate(c.Template)
+		if err != nil {
+			return nil, err
+		}
+		n.notifiers = append(n.notifiers, &notifier{config: c, template: tmpl})
+	}
+	return n, nil
+}
+
+// Start notifies of events published until Stop.
+func (n *Notifiers) Start() {
+	n.sub = n.events.Subscribe()
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			select {
+			case event := <-n.sub:
+				n.notify(event)
+			case <-n.stop:
+				n.events.Unsubscribe(n.sub)
+				// Send what was published before stopping, e.g. the failed build stopping slrun
+				for {
+					select {
+					case event := <-n.sub:
+						n.notify(event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop sends pending notifications and stops.
+func (n *Notifiers) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+}
+
+func (n *Notifiers) notify(event Event) {
+	if !slices.Contains(notifiableEvents, event.Type) {
+		return
+	}
+
+	key := rateKey{event.Type, event.Function}
+	if time.Since(n.last[key]) < n.rateLimit {
+		n.suppressed[key]++
+		return
+	}
+	n.last[key] = time.Now()
+	data := notification{Event: event, Suppressed: n.suppressed[key]}
+	delete(n.suppressed, key)
+
+	for _, nt := range n.notifiers {
+		events := nt.config.Events
+		if len(events) == 0 {
+			events = failureEvents
+		}
+		if !slices.Contains(events, event.Type) {
+			continue
+		}
+
+		var msg bytes.Buffer
+		err := nt.template.Execute(&msg, data)
+		if err != nil {
+			log.Printf("Cannot execute %v notification template: %v\n", nt.config.Type, err)
+			continue
+		}
+
+		err = n.send(nt.config, event, msg.String())
+		if err != nil {
+			log.Printf("Cannot send %v notification of %v: %v\n", nt.config.Type, event.Type, err)
+		}
+	}
+}
+
+func (n *Notifiers) send(config *types.Notifier, event Event, msg string) error {
+	switch config.Type {
+	case NotifierSlack:
+		return n.post(config.URL, map[string]any{"text": msg})
+	case NotifierDiscord:
+		return n.post(config.URL, map[string]any{"content": msg})
+	case NotifierWebhook:
+		return n.post(config.URL, map[string]any{"message": msg, "event": event})
+	case NotifierEmail:
+		return sendEmail(config.SMTP, event, msg)
+	}
+	return fmt.Errorf("unknown notifier type: %v", config.Type)
+}
+
+func (n *Notifiers) post(url string, body any) error {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", strings.NewReader(string(bytes)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(config *types.SMTP, event Event, msg string) error {
+	subject := "slrun: " + event.Type
+	if event.Function != "" {
+		subject += " " + event.Function
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %v\r\n", config.From)
+	fmt.Fprintf(&b, "To: %v\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %v\r\n", subject)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%v\r\n", msg)
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		host, _, _ := strings.Cut(config.Address, ":")
+		auth = smtp.PlainAuth("", config.Username, config.Password, host)
+	}
+	return smtp.SendMail(config.Address, auth, config.From, config.To, []byte(b.String()))
+}
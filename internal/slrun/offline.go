@@ -0,0 +1,138 @@
+package slrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// ConnectDocker connects to the backend's container engine, Docker as its env says if nil.
+func ConnectDocker(backend *types.Backend) error {
+	dockerCtx = context.Background()
+	if backend != nil && backend.Engine == BackendContainerd {
+		// No Docker daemon, nerdctl builds and stores images in containerd
+		dockerCli = newContainerdBackend(backend)
+		return nil
+	}
+	cli, err := newBackendClient(backend)
+	if err != nil {
+		return err
+	}
+	dockerCli = cli
+	return nil
+}
+
+// RequiredImages returns the base images the functions build from, and the prebuilt images
+// they run, with the functions using each.
+func RequiredImages(functions []*types.Function) (map[string][]string, error) {
+	images := make(map[string][]string)
+	for _, f := range functions {
+		if f.Process != nil {
+			continue
+		}
+		if f.Image != "" {
+			images[f.Image] = append(images[f.Image], f.Name)
+			continue
+		}
+		bases, err := baseImages(dockerfilePath(f))
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range bases {
+			if !slices.Contains(images[img], f.Name) {
+				images[img] = append(images[img], f.Name)
+			}
+		}
+	}
+	return images, nil
+}
+
+// MissingImagesError lists base images that offline mode needs but aren't available locally.
+type MissingImagesError struct {
+	Images map[string][]string // Functions by missing image
+}
+
+func (e *MissingImagesError) Error() string {
+	var b strings.Builder
+	b.WriteString("offline mode: base images missing locally:\n")
+	for _, img := range slices.Sorted(maps.Keys(e.Images)) {
+		fmt.Fprintf(&b, "  %v (used by %v)\n", img, strings.Join(e.Images[img], ", "))
+	}
+	b.WriteString("On a connected machine, run `slrun images export -o images.tar` with this config, " +
+		"then `slrun images import images.tar` here")
+	return b.String()
+}
+
+// checkOfflineImages fails with a MissingImagesError unless every base image is available locally,
+// since offline builds can't pull them.
+func checkOfflineImages(functions []*types.Function) error {
+	images, err := RequiredImages(functions)
+	if err != nil {
+		return err
+	}
+	missing := make(map[string][]string)
+	for img, users := range images {
+		if !imageExists(img) {
+			missing[img] = users
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingImagesError{Images: missing}
+	}
+	return nil
+}
+
+// ExportImages writes the functions' base images to w as a docker save archive,
+// pulling those missing locally unless offline.
+func ExportImages(functions []*types.Function, offline bool, w io.Writer) ([]string, error) {
+	images, err := RequiredImages(functions)
+	if err != nil {
+		return nil, err
+	}
+	refs := slices.Sorted(maps.Keys(images))
+
+	if offline {
+		err = checkOfflineImages(functions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, ref := range refs {
+		if imageExists(ref) {
+			continue
+		}
+		fmt.Printf("Pulling %v\n", ref)
+		err := pullImage(ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot pull %v: %w", ref, err)
+		}
+	}
+
+	out, err := dockerCli.ImageSave(dockerCtx, refs)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	_, err = io.Copy(w, out)
+	return refs, err
+}
+
+// ImportImages loads images from a docker save archive, e.g. written by ExportImages.
+func ImportImages(r io.Reader) error {
+	resp, err := dockerCli.ImageLoad(dockerCtx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return jsonmessage.DisplayJSONMessagesStream(resp.Body, io.Discard, 0, false, nil)
+}
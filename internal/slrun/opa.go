@@ -0,0 +1,193 @@
+package slrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// Rules of the Rego policies denying deploys and invocations, sets of messages saying why
+const (
+	opaDeployQuery = "data.slrun.deploy.deny"
+	opaInvokeQuery = "data.slrun.invoke.deny"
+)
+
+// ErrPolicyDenied is returned by deploys an OPA policy denies.
+var ErrPolicyDenied = errors.New("denied by policy")
+
+func validateOPA(config *types.Config) error {
+	opa := config.OPA
+	if opa == nil {
+		return nil
+	}
+	if len(opa.Files) == 0 {
+		return fieldError("opa.files", "opa has no policy files")
+	}
+	for _, file := range opa.Files {
+		_, err := os.Stat(file)
+		if err != nil {
+			return fieldError("opa.files", "opa policy file: %w", err)
+		}
+	}
+	return nil
+}
+
+// OPAPolicies evaluates Rego policies guarding deploys and invocations with an embedded OPA.
+// Policies deny by adding messages to deny in package slrun.deploy, with the image, source and
+// config of a function deploying as input, or in package slrun.invoke, with the request as input.
+type OPAPolicies struct {
+	deploy rego.PreparedEvalQuery
+	invoke rego.PreparedEvalQuery
+}
+
+// NewOPAPolicies compiles the policies of config, or returns nil if config is nil.
+func NewOPAPolicies(config *types.OPA) (*OPAPolicies, error) {
+	if config == nil {
+		return nil, nil
+	}
+	ctx := context.Background()
+	prepare := func(query string) (rego.PreparedEvalQuery, error) {
+		return rego.New(rego.Query(query), rego.Load(config.Files, nil)).PrepareForEval(ctx)
+	}
+
+	deploy, err := prepare(opaDeployQuery)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load opa policies: %w", err)
+	}
+	invoke, err := prepare(opaInvokeQuery)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load opa policies: %w", err)
+	}
+	log.Printf("Loaded OPA policies from %v\n", config.Files)
+	return &OPAPolicies{deploy: deploy, invoke: invoke}, nil
+}
+
+// deny evaluates a deny rule on input, returning its messages, sorted. A rule no policy defines denies nothing.
+func deny(query rego.PreparedEvalQuery, input map[string]any) ([]string, error) {
+	results, err := query.Eval(context.Background(), rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, result := range results {
+		for _, expr := range result.Expressions {
+			values, _ := expr.Value.([]any)
+			for _, v := range values {
+				if msg, ok := v.(string); ok {
+					messages = append(messages, msg)
+				} else {
+					messages = append(messages, fmt.Sprint(v))
+				}
+			}
+		}
+	}
+	sort.Strings(messages)
+	return messages, nil
+}
+
+// deniedError returns an error listing why a policy denied something, or nil if it didn't.
+func deniedError(messages []string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, msg := range messages {
+		errs = append(errs, errors.New(msg))
+	}
+	return fmt.Errorf("%w: %w", ErrPolicyDenied, errors.Join(errs...))
+}
+
+// asInput converts v to the JSON values policies see.
+func asInput(v any) any {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var input any
+	json.Unmarshal(bytes, &input)
+	return input
+}
+
+// CheckDeploy returns an error if a policy denies deploying ref as the function's image.
+// ref is the candidate of a built image, which policies see under the function's image name.
+func (p *OPAPolicies) CheckDeploy(function *types.Function, ref string) error {
+	if p == nil {
+		return nil
+	}
+	input := map[string]any{"function": asInput(function)}
+
+	inspect, err := dockerCli.ImageInspect(dockerCtx, ref)
+	if err != nil {
+		return err
+	}
+	img := map[string]any{"name": functionImageName(function), "id": inspect.ID}
+	if config := inspect.Config; config != nil {
+		var ports []string
+		for port := range config.ExposedPorts {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		img["user"] = config.User
+		img["env"] = config.Env
+		img["labels"] = config.Labels
+		img["entrypoint"] = config.Entrypoint
+		img["cmd"] = config.Cmd
+		img["exposed_ports"] = ports
+		img["healthcheck"] = config.Healthcheck != nil
+	}
+	input["image"] = asInput(img)
+
+	if function.Image == "" {
+		source := map[string]any{"build_dir": function.BuildDir}
+		dockerfile, err := os.ReadFile(dockerfilePath(function))
+		if err == nil {
+			source["dockerfile"] = string(dockerfile)
+		}
+		input["source"] = source
+	}
+
+	messages, err := deny(p.deploy, input)
+	if err != nil {
+		return fmt.Errorf("cannot evaluate deploy policy: %w", err)
+	}
+	return deniedError(messages)
+}
+
+// CheckInvoke returns an invocation error if a policy denies the request to the function at path.
+func (p *OPAPolicies) CheckInvoke(function *types.Function, path string, r *http.Request) error {
+	if p == nil {
+		return nil
+	}
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	input := map[string]any{
+		"function":  function.Name,
+		"method":    r.Method,
+		"path":      path,
+		"host":      r.Host,
+		"headers":   asInput(r.Header),
+		"query":     asInput(r.URL.Query()),
+		"remote_ip": remoteIP,
+	}
+
+	messages, err := deny(p.invoke, input)
+	if err != nil {
+		return invocationError(ErrClassPolicyFailure, http.StatusInternalServerError, fmt.Errorf("cannot evaluate invoke policy: %w", err))
+	}
+	err = deniedError(messages)
+	if err != nil {
+		return invocationError(ErrClassPolicyDenied, http.StatusForbidden, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package slrun
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+const testInvokePolicy = `package slrun.invoke
+
+deny contains "admin paths need a role" if {
+	input.path == "/admin"
+	not input.headers["X-Role"]
+}
+
+deny contains "deletes are read-only" if {
+	input.method == "DELETE"
+}
+`
+
+func TestOPAInvokePolicy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "invoke.rego")
+	if err := os.WriteFile(file, []byte(testInvokePolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := NewOPAPolicies(&types.OPA{Files: []string{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	function := &types.Function{Name: "func1"}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		role   string
+		denied bool
+	}{
+		{name: "allowed", method: "GET", path: "/users"},
+		{name: "admin with role", method: "GET", path: "/admin", role: "ops"},
+		{name: "admin without role", method: "GET", path: "/admin", denied: true},
+		{name: "delete", method: "DELETE", path: "/users", denied: true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/func1"+test.path, nil)
+		if test.role != "" {
+			r.Header.Set("X-Role", test.role)
+		}
+		err := policies.CheckInvoke(function, test.path, r)
+		var ierr *InvocationError
+		if test.denied && (!errors.As(err, &ierr) || ierr.Class != ErrClassPolicyDenied || ierr.Status != 403) {
+			t.Errorf("%v: CheckInvoke() = %v, want %v", test.name, err, ErrClassPolicyDenied)
+		}
+		if !test.denied && err != nil {
+			t.Errorf("%v: CheckInvoke() = %v, want allowed", test.name, err)
+		}
+	}
+}
+
+func TestDeniedError(t *testing.T) {
+	if err := deniedError(nil); err != nil {
+		t.Errorf("deniedError(nil) = %v, want nil", err)
+	}
+	err := deniedError([]string{"a", "b"})
+	if !errors.Is(err, ErrPolicyDenied) || err.Error() != "denied by policy: a\nb" {
+		t.Errorf("deniedError() = %q, want both messages", err)
+	}
+
+	// Without policies, everything is allowed
+	var policies *OPAPolicies
+	if err := policies.CheckInvoke(&types.Function{Name: "func1"}, "/", httptest.NewRequest("GET", "/func1", nil)); err != nil {
+		t.Errorf("CheckInvoke() without policies = %v", err)
+	}
+}
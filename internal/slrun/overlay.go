@@ -0,0 +1,100 @@
+package slrun
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// overlayTemplate is the dev mode error page shown to browsers when a function fails.
+var overlayTemplate = template.Must(template.New("overlay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Function}} failed</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #1e1e1e; color: #ddd; }
+header { background: #b3261e; color: #fff; padding: 16px 24px; }
+main { padding: 16px 24px; }
+pre { background: #111; padding: 12px; overflow-x: auto; white-space: pre-wrap; }
+button { padding: 8px 16px; font-size: 14px; cursor: pointer; }
+.meta { color: #999; }
+</style>
+</head>
+<body>
+<header><h2>Function {{.Function}} failed with status {{.Status}}</h2></header>
+<main>
+<p class="meta">Request ID {{.RequestID}}</p>
+<h3>Error</h3>
+<pre>{{.Error}}</pre>
+<h3>Container logs</h3>
+<pre>{{range .Logs}}{{.}}
+{{else}}No logs available{{end}}</pre>
+<form method="post" action="/_slrun/functions/{{.Function}}/restart">
+<button type="submit">Restart function</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+type overlayData struct {
+	Function  string
+	Status    int
+	RequestID string
+	Error     string
+	Logs      []string
+}
+
+// wantsHTML reports whether the client is a browser expecting an HTML page.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// writeOverlay writes the dev mode error page for a failed function.
+func (g *Gateway) writeOverlay(w http.ResponseWriter, r *http.Request, funcName string, status int, errMsg string) {
+	data := overlayData{
+		Function:  funcName,
+		Status:    status,
+		RequestID: r.Header.Get(requestIDHeader),
+		Error:     errMsg,
+	}
+
+	if fun := g.runtime.FunctionByName(funcName); fun != nil {
+		logs, err := g.runtime.FunctionLogs(fun, 200)
+		if err != nil {
+			log.Printf("Cannot read function %v logs: %v\n", funcName, err)
+		}
+		data.Logs = logs
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := overlayTemplate.Execute(w, data)
+	if err != nil {
+		log.Printf("Cannot render error overlay: %v\n", err)
+	}
+}
+
+// restartHandler restarts a function from the error overlay, then sends the browser back.
+func (g *Gateway) restartHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	fun := g.runtime.FunctionByName(name)
+	if fun == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := g.runtime.RestartFunction(fun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	back := r.Referer()
+	if back == "" {
+		back = "/" + name
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
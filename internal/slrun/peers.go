@@ -0,0 +1,262 @@
+package slrun
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Carries the relay token on invocations relayed between peers
+const relayTokenHeader = "X-Slrun-Relay-Token"
+
+func validatePeers(config *types.Config) error {
+	names := make(map[string]bool)
+	relayed := make(map[string]string) // Peer of each relayed function
+	for i, p := range config.Peers {
+		field := fmt.Sprintf("peers[%d]", i)
+		if p.Name == "" {
+			return fieldError(field+".name", "peer must have a name")
+		}
+		if names[p.Name] {
+			return fieldError(field+".name", "config has duplicate peer name: %s", p.Name)
+		}
+		names[p.Name] = true
+
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fieldError(field+".url", "peer %s url must be an http or https url: %s", p.Name, p.URL)
+		}
+		p.URL = strings.TrimSuffix(p.URL, "/")
+		if p.Token == "" {
+			return fieldError(field+".token", "peer %s has no token", p.Name)
+		}
+		if len(p.Functions) == 0 {
+			return fieldError(field+".functions", "peer %s has no functions", p.Name)
+		}
+		for _, name := range p.Functions {
+			if !functionNamePattern.MatchString(name) {
+				return fieldError(field+".functions", "peer %s has invalid function name %q", p.Name, name)
+			}
+			if other, exists := relayed[name]; exists {
+				return fieldError(field+".functions", "function %s is relayed to both peers %s and %s", name, other, p.Name)
+			}
+			relayed[name] = p.Name
+		}
+	}
+	for _, f := range config.Functions {
+		if peer, exists := relayed[f.Name]; exists {
+			return fieldError(functionField(config, f, "name"), "function %s is defined here and relayed to peer %s", f.Name, peer)
+		}
+	}
+
+	relay := config.Relay
+	if relay == nil {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(relay.Address); err != nil {
+		return fieldError("relay.address", "invalid relay address: %w", err)
+	}
+	if relay.Token == "" {
+		return fieldError("relay.token", "relay has no token")
+	}
+	for _, name := range relay.Functions {
+		if !hasFunction(config, name) {
+			return fieldError("relay.functions", "relay has unknown function: %s", name)
+		}
+	}
+	return nil
+}
+
+// isRelayed reports whether invocations of the function are relayed to a peer.
+func isRelayed(config *types.Config, name string) bool {
+	return slices.ContainsFunc(config.Peers, func(p *types.Peer) bool { return slices.Contains(p.Functions, name) })
+}
+
+// Peers relays invocations of functions run by other slrun instances.
+type Peers struct {
+	functions  map[string]*types.Peer // Peer running each relayed function
+	httpClient *http.Client
+}
+
+// NewPeers returns the peers of config, or nil if it has none.
+func NewPeers(config []*types.Peer) *Peers {
+	if len(config) == 0 {
+		return nil
+	}
+	p := &Peers{functions: make(map[string]*types.Peer), httpClient: newProxyClient()}
+	for _, peer := range config {
+		for _, name := range peer.Functions {
+			p.functions[name] = peer
+		}
+	}
+	return p
+}
+
+// route returns the peer running function, nil if it isn't relayed.
+func (p *Peers) route(function string) *types.Peer {
+	if p == nil {
+		return nil
+	}
+	return p.functions[function]
+}
+
+// Forward invokes function on peer.
+func (p *Peers) Forward(peer *types.Peer, function string, path string, prevReq *http.Request) (*FunctionResponse, error) {
+	url := peer.URL + clusterInvokePrefix + function + path
+	if prevReq.URL.RawQuery != "" {
+		url += "?" + prevReq.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(prevReq.Context(), prevReq.Method, url, prevReq.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = prevReq.Header.Clone()
+	removeHopHeaders(req.Header)
+	req.Header.Set(relayTokenHeader, peer.Token)
+	forwardDeadline(req.Header, nil, prevReq)
+	req.ContentLength = prevReq.ContentLength
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error relaying function %v to peer %v: %v\n", function, peer.Name, err)
+		return nil, bodyError(err, ErrClassUnreachable, http.StatusBadGateway)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get(clusterErrorHeader) == "" {
+		resp.Body.Close()
+		err := fmt.Errorf("peer %v rejected the relay token", peer.Name)
+		return nil, invocationError(ErrClassUnreachable, http.StatusBadGateway, err)
+	}
+	return forwardedResponse(resp, "peer "+peer.Name)
+}
+
+// Relay serves invocations of this instance's functions relayed by its peers.
+type Relay struct {
+	config *types.Relay
+	server *http.Server
+}
+
+// NewRelay returns the relay of config, or nil if config is nil.
+func NewRelay(config *types.Relay) *Relay {
+	if config == nil {
+		return nil
+	}
+	return &Relay{config: config}
+}
+
+// Start serves relayed invocations with the gateway's runtime, quotas and upload dir.
+func (rl *Relay) Start(gateway *Gateway) {
+	if rl == nil {
+		return
+	}
+	rl.server = &http.Server{
+		Addr:    rl.config.Address,
+		Handler: rl.invokeHandler(gateway),
+	}
+	go func() {
+		if err := rl.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Relay server failed: %v", err)
+		}
+	}()
+	fmt.Printf("Relay listening on %v\n", rl.config.Address)
+}
+
+func (rl *Relay) Shutdown(ctx context.Context) error {
+	if rl == nil || rl.server == nil {
+		return nil
+	}
+	return rl.server.Shutdown(ctx)
+}
+
+// invokeHandler invokes functions on this instance for peers presenting the relay token.
+// Invocations are subject to this instance's invoke policies, quotas and upload limits,
+// like those of its gateway.
+func (rl *Relay) invokeHandler(gateway *Gateway) http.Handler {
+	runtime := gateway.runtime
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(relayTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(rl.config.Token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("invalid relay token"))
+			return
+		}
+		r.Header.Del(relayTokenHeader)
+		if r.Header.Get(requestIDHeader) == "" {
+			r.Header.Set(requestIDHeader, requestID(r))
+		}
+
+		rest, ok := strings.CutPrefix(r.URL.Path, clusterInvokePrefix)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("not a function invocation"))
+			return
+		}
+		funcName, path, _ := strings.Cut(rest, "/")
+		path = "/" + path
+
+		fun := runtime.FunctionByName(funcName)
+		allowed := len(rl.config.Functions) == 0 || slices.Contains(rl.config.Functions, funcName)
+		if fun == nil || !allowed {
+			err := fmt.Errorf("function %v isn't relayed by this slrun", funcName)
+			writeForwardedError(w, r, funcName, invocationError(ErrClassNotFound, http.StatusNotFound, err))
+			return
+		}
+
+		err := runtime.checkInvoke(fun, path, r)
+		if err == nil {
+			err = limitUpload(w, r, fun)
+		}
+		var ierr *InvocationError
+		if errors.As(err, &ierr) {
+			writeForwardedError(w, r, funcName, ierr)
+			return
+		}
+		release, err := gateway.quotas.Acquire(r)
+		if err != nil {
+			writeForwardedError(w, r, funcName, quotaError(err))
+			return
+		}
+		defer release()
+
+		log.Printf("Function %v relayed from %v\n", funcName, r.RemoteAddr)
+		serveForwarded(w, r, runtime, gateway.config.UploadDir, fun, path)
+	})
+}
+
+// relay invokes a function running on a peer for a gateway client.
+func (g *Gateway) relay(w http.ResponseWriter, r *http.Request, peer *types.Peer, funcName string, path string) {
+	release, err := g.quotas.Acquire(r)
+	if err != nil {
+		g.writeError(w, r, funcName, quotaError(err))
+		return
+	}
+	defer release()
+
+	// Usage lasts until the response has streamed to the client
+	start := time.Now()
+	resp, err := g.peers.Forward(peer, funcName, path, r)
+	defer func() {
+		timing := InvocationTiming{Execution: time.Since(start)}
+		if resp != nil {
+			timing.ColdStart = resp.ColdStart
+			timing.Execution -= resp.ColdStart
+		}
+		g.recordInvocation(funcName, r, resp, err, timing)
+	}()
+	if err != nil {
+		g.writeError(w, r, funcName, err)
+		return
+	}
+	if werr := resp.Write(w); werr != nil {
+		log.Printf("Cannot stream function %v response from peer %v: %v\n", funcName, peer.Name, werr)
+	}
+	log.Printf("Function %v called on peer %v\n", funcName, peer.Name)
+}
@@ -0,0 +1,104 @@
+package slrun
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestValidatePeers(t *testing.T) {
+	peer := func(name string, url string, functions ...string) *types.Peer {
+		return &types.Peer{Name: name, URL: url, Token: "secret", Functions: functions}
+	}
+
+	tests := []struct {
+		name    string
+		config  types.Config
+		wantErr string // Empty if valid
+	}{
+		{
+			name:   "valid",
+			config: types.Config{Peers: []*types.Peer{peer("lab", "http://lab:7950/", "train")}},
+		},
+		{
+			name:    "no name",
+			config:  types.Config{Peers: []*types.Peer{peer("", "http://lab:7950", "train")}},
+			wantErr: "peers[0].name: peer must have a name",
+		},
+		{
+			name:    "duplicate name",
+			config:  types.Config{Peers: []*types.Peer{peer("lab", "http://lab:7950", "train"), peer("lab", "http://lab2:7950", "eval")}},
+			wantErr: "peers[1].name: config has duplicate peer name: lab",
+		},
+		{
+			name:    "bad url",
+			config:  types.Config{Peers: []*types.Peer{peer("lab", "lab:7950", "train")}},
+			wantErr: "peers[0].url:",
+		},
+		{
+			name:    "no token",
+			config:  types.Config{Peers: []*types.Peer{{Name: "lab", URL: "http://lab:7950", Functions: []string{"train"}}}},
+			wantErr: "peers[0].token: peer lab has no token",
+		},
+		{
+			name:    "no functions",
+			config:  types.Config{Peers: []*types.Peer{peer("lab", "http://lab:7950")}},
+			wantErr: "peers[0].functions: peer lab has no functions",
+		},
+		{
+			name:    "function on two peers",
+			config:  types.Config{Peers: []*types.Peer{peer("lab", "http://lab:7950", "train"), peer("gpu", "http://gpu:7950", "train")}},
+			wantErr: "peers[1].functions: function train is relayed to both peers lab and gpu",
+		},
+		{
+			name: "function defined locally",
+			config: types.Config{
+				Functions: []*types.Function{{Name: "train", Image: "trainer"}},
+				Peers:     []*types.Peer{peer("lab", "http://lab:7950", "train")},
+			},
+			wantErr: "functions[0].name: function train is defined here and relayed to peer lab",
+		},
+		{
+			name:    "relay bad address",
+			config:  types.Config{Relay: &types.Relay{Address: "7950", Token: "secret"}},
+			wantErr: "relay.address:",
+		},
+		{
+			name:    "relay no token",
+			config:  types.Config{Relay: &types.Relay{Address: ":7950"}},
+			wantErr: "relay.token: relay has no token",
+		},
+		{
+			name:    "relay unknown function",
+			config:  types.Config{Relay: &types.Relay{Address: ":7950", Token: "secret", Functions: []string{"train"}}},
+			wantErr: "relay.functions: relay has unknown function: train",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePeers(&tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validatePeers() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("validatePeers() error = %v, want prefix %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("trims url", func(t *testing.T) {
+		config := types.Config{Peers: []*types.Peer{peer("lab", "http://lab:7950/", "train")}}
+		err := validatePeers(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.Peers[0].URL; got != "http://lab:7950" {
+			t.Errorf("URL = %q, want http://lab:7950", got)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package slrun
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parsePortRange parses a "from-to" host port range.
+func parsePortRange(s string) (int, int, error) {
+	fromStr, toStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q, expected from-to", s)
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(fromStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(toStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if from < 1 || to > 65535 || from > to {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return from, to, nil
+}
+
+// portAllocator hands out host ports of function containers from a range.
+type portAllocator struct {
+	mu     sync.Mutex
+	hostIP string
+	from   int
+	to     int
+	next   int            // Where the next search starts, so released ports aren't reused right away
+	used   map[int]string // Function by allocated port
+}
+
+func newPortAllocator(hostIP string, from int, to int) *portAllocator {
+	return &portAllocator{
+		hostIP: hostIP,
+		from:   from,
+		to:     to,
+		next:   from,
+		used:   make(map[int]string),
+	}
+}
+
+// reserve keeps port out of allocation, for function's static host port.
+func (p *portAllocator) reserve(port int, function string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used[port] = function
+}
+
+// free reports whether nothing else on the host is listening on port.
+func (p *portAllocator) free(port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(p.hostIP, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// allocate returns a free port of the range for function.
+// Ports used by other processes on the host are skipped.
+func (p *portAllocator) allocate(function string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	size := p.to - p.from + 1
+	for i := 0; i < size; i++ {
+		port := p.from + (p.next-p.from+i)%size
+		if _, used := p.used[port]; used || !p.free(port) {
+			continue
+		}
+		p.used[port] = function
+		p.next = port + 1
+		if p.next > p.to {
+			p.next = p.from
+		}
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port in function port range %v-%v for function %v", p.from, p.to, function)
+}
+
+func (p *portAllocator) release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.used, port)
+}
@@ -0,0 +1,34 @@
+package slrun
+
+import "testing"
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to int
+		wantErr  bool
+	}{
+		{in: "20000-20100", from: 20000, to: 20100},
+		{in: " 8000 - 8000 ", from: 8000, to: 8000},
+		{in: "1-65535", from: 1, to: 65535},
+		{in: "20000", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "a-b", wantErr: true},
+		{in: "0-100", wantErr: true},
+		{in: "100-65536", wantErr: true},
+		{in: "200-100", wantErr: true},
+		{in: "1-2-3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			from, to, err := parsePortRange(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePortRange(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if from != tt.from || to != tt.to {
+				t.Errorf("parsePortRange(%q) = %v, %v, want %v, %v", tt.in, from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package slrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// runPreStop runs the function's pre-stop hook, if any, before its container is stopped.
+func (r *Runtime) runPreStop(function *types.Function) {
+	hook := function.PreStop
+	if hook == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hook.Timeout)*time.Second)
+	defer cancel()
+
+	if len(hook.Exec) > 0 {
+		err := r.execInContainer(ctx, function, hook.Exec)
+		if err != nil {
+			log.Printf("Function %v pre-stop exec failed: %v\n", function.Name, err)
+		}
+	}
+	if hook.Path != "" {
+		err := r.requestPreStop(ctx, function, hook)
+		if err != nil {
+			log.Printf("Function %v pre-stop request failed: %v\n", function.Name, err)
+		}
+	}
+}
+
+// execInContainer runs cmd in the function's container, failing unless it exits 0 before ctx is done.
+func (r *Runtime) execInContainer(ctx context.Context, function *types.Function, cmd []string) error {
+	exec, err := r.cli.ContainerExecCreate(ctx, function.ContainerId, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	attach, err := r.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return err
+	}
+	defer attach.Close()
+
+	// Output ends when the command exits
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(io.Discard, io.Discard, attach.Reader)
+		done <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%v still running: %w", cmd, ctx.Err())
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	}
+
+	inspect, err := r.cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("%v exited with %v", cmd, inspect.ExitCode)
+	}
+	return nil
+}
+
+func (r *Runtime) requestPreStop(ctx context.Context, function *types.Function, hook *types.PreStop) error {
+	req, err := http.NewRequestWithContext(ctx, hook.Method, r.functionURL(function, hook.Path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.functionClient(function).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v %v returned %v", hook.Method, hook.Path, resp.Status)
+	}
+	return nil
+}
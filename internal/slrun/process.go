@@ -0,0 +1,680 @@
+package slrun
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/marcorentap/slrun/internal/types"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Image name of process functions, followed by the function's name. It is never built or pulled.
+const processImagePrefix = "process://"
+
+// Port of process functions without one set. They listen on their host port instead, passed in PORT
+const processPort = "8080/tcp"
+
+// Lines of output kept for the logs of each process
+const processLogLines = 10000
+
+// Variables of slrun's env passed on to processes, which find commands and write files with
+// them. Its others, e.g. credentials, are kept from functions.
+var processEnvAllowList = []string{"PATH", "HOME", "TMPDIR"}
+
+// processSignals are the stop signals of process functions, by name.
+var processSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+func validateProcess(config *types.Config, f *types.Function) error {
+	p := f.Process
+	if len(p.Command) == 0 {
+		return fieldError(functionField(config, f, "process.command"), "function %s process needs a command", f.Name)
+	}
+	if p.Dir == "" {
+		return fieldError(functionField(config, f, "process.dir"), "function %s process needs a dir", f.Name)
+	}
+	info, err := os.Stat(p.Dir)
+	if err != nil || !info.IsDir() {
+		return fieldError(functionField(config, f, "process.dir"), "function %s process dir is not a directory: %s", f.Name, p.Dir)
+	}
+	// Processes run wherever slrun is started from
+	p.Dir, err = filepath.Abs(p.Dir)
+	if err != nil {
+		return &configFieldError{Field: functionField(config, f, "process.dir"), Err: err}
+	}
+
+	// WASM modules are run by a process of their own
+	kind := "process"
+	if f.Wasm != "" {
+		kind = "wasm"
+	}
+	if f.Dockerfile != "" || len(f.BuildArgs) > 0 || f.Target != "" || f.TestCommand != "" {
+		return fieldError(functionField(config, f, kind), "function %s dockerfile, build_args, target and test_command need a build_dir, functions with %s aren't built", f.Name, kind)
+	}
+	if f.Socket || len(f.Caches) > 0 || f.Transform == TransformFormToJSON {
+		return fieldError(functionField(config, f, kind), "function %s socket, caches and the form_to_json transform mount dirs in containers, they can't be used with %s", f.Name, kind)
+	}
+	if f.CPU != 0 || f.Memory != "" || f.PidsLimit != 0 {
+		return fieldError(functionField(config, f, kind), "function %s cpu, memory and pids_limit are enforced by containers, they can't be used with %s", f.Name, kind)
+	}
+	if _, ok := processSignals[f.StopSignal]; f.StopSignal != "" && !ok {
+		return fieldError(functionField(config, f, "stop_signal"), "function %s %s can't be stopped with %s, expected one of %v", f.Name, kind, f.StopSignal, sortedKeys(processSignals))
+	}
+	return nil
+}
+
+// processImage returns the image name of a process function.
+func processImage(function *types.Function) string {
+	return processImagePrefix + function.Name
+}
+
+// sourceDir returns the dir of the function's sources, its build dir or process dir, empty
+// for prebuilt images.
+func sourceDir(function *types.Function) string {
+	if function.Process != nil {
+		return function.Process.Dir
+	}
+	return function.BuildDir
+}
+
+// processBackend runs process functions as subprocesses of slrun, as containers of their own:
+// it creates, starts, stops and inspects them, serves their logs, runs execs in their dir and
+// sends their start and die events. The containers of other functions are run by the backend
+// it wraps.
+type processBackend struct {
+	ContainerBackend
+
+	mu          sync.Mutex
+	processes   map[string]*functionProcess // By container ID
+	execs       map[string]*backendExec     // By ID
+	subscribers map[chan events.Message]struct{}
+}
+
+// functionProcess is a process function's container.
+type functionProcess struct {
+	id       string
+	config   *container.Config
+	env      []string // As processEnv returns it
+	port     nat.Port // Its port, as the function's containers have it
+	hostIP   string
+	hostPort string // It listens on
+	created  time.Time
+	output   *processOutput
+
+	// Guarded by the backend's mu
+	cmd      *exec.Cmd
+	state    container.ContainerState
+	exitCode int
+	started  time.Time
+	finished time.Time
+	exited   chan struct{} // Closed once it exits, nil until it starts
+}
+
+func newProcessBackend(backend ContainerBackend) *processBackend {
+	return &processBackend{
+		ContainerBackend: backend,
+		processes:        make(map[string]*functionProcess),
+		execs:            make(map[string]*backendExec),
+		subscribers:      make(map[chan events.Message]struct{}),
+	}
+}
+
+// process returns the process with the container ID, nil if it isn't one.
+func (b *processBackend) process(id string) *functionProcess {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.processes[id]
+}
+
+// ContainerCreate creates a process running config's Cmd in its WorkingDir if config's image
+// is a process function's. It listens on the host port bound to its port, which is passed in
+// PORT and SLRUN_PORT, or a free one if none is, on the address in SLRUN_HOST.
+func (b *processBackend) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	if !strings.HasPrefix(config.Image, processImagePrefix) {
+		return b.ContainerBackend.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+	}
+	if len(config.Cmd) == 0 {
+		return container.CreateResponse{}, fmt.Errorf("process %v has no command", config.Image)
+	}
+	p := &functionProcess{
+		config:  config,
+		created: time.Now(),
+		state:   container.StateCreated,
+		output:  newProcessOutput(),
+		port:    processPort,
+	}
+	for port, bindings := range hostConfig.PortBindings {
+		// The others are its debug port, bound as is
+		if _, exposed := config.ExposedPorts[port]; exposed || len(bindings) == 0 {
+			continue
+		}
+		p.port, p.hostIP, p.hostPort = port, bindings[0].HostIP, bindings[0].HostPort
+	}
+	if p.hostPort == "" {
+		l, err := net.Listen("tcp", net.JoinHostPort(p.hostIP, "0"))
+		if err != nil {
+			return container.CreateResponse{}, fmt.Errorf("cannot find a free port: %w", err)
+		}
+		p.hostPort = strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+		l.Close()
+	}
+	p.env = processEnv(config.Env, p.hostIP, p.hostPort)
+
+	id := make([]byte, 32)
+	rand.Read(id)
+	p.id = hex.EncodeToString(id)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.processes[p.id] = p
+	return container.CreateResponse{ID: p.id}, nil
+}
+
+// processEnv returns the env of a process with the function's env, listening on hostIP and
+// hostPort: the allowed variables of slrun's env, then the function's, then its address. The
+// last value of a variable set twice is used.
+func processEnv(env []string, hostIP, hostPort string) []string {
+	var allowed []string
+	for _, name := range processEnvAllowList {
+		if value, ok := os.LookupEnv(name); ok {
+			allowed = append(allowed, name+"="+value)
+		}
+	}
+	return slices.Concat(allowed, env, []string{"PORT=" + hostPort, "SLRUN_PORT=" + hostPort, "SLRUN_HOST=" + hostIP})
+}
+
+func (b *processBackend) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	p := b.process(containerID)
+	if p == nil {
+		return b.ContainerBackend.ContainerStart(ctx, containerID, options)
+	}
+	b.mu.Lock()
+	if p.state == container.StateRunning {
+		b.mu.Unlock()
+		return nil
+	}
+	cmd := exec.Command(p.config.Cmd[0], p.config.Cmd[1:]...)
+	cmd.Dir = p.config.WorkingDir
+	cmd.Env = p.env
+	cmd.Stdout = p.output.writer(stdcopy.Stdout)
+	cmd.Stderr = p.output.writer(stdcopy.Stderr)
+	// Children it started may keep its output open after it exits
+	cmd.WaitDelay = time.Second
+	err := cmd.Start()
+	if err != nil {
+		b.mu.Unlock()
+		return fmt.Errorf("cannot start %v: %w", strings.Join(p.config.Cmd, " "), err)
+	}
+	p.cmd, p.state, p.started = cmd, container.StateRunning, time.Now()
+	p.exited = make(chan struct{})
+	b.mu.Unlock()
+	b.publish(p, events.ActionStart, nil)
+
+	go func() {
+		cmd.Wait()
+		p.output.flush()
+		code := cmd.ProcessState.ExitCode()
+		// Killed by a signal, reported as Docker reports it
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			code = 128 + int(status.Signal())
+		}
+		b.mu.Lock()
+		p.state, p.exitCode, p.finished = container.StateExited, code, time.Now()
+		close(p.exited)
+		b.mu.Unlock()
+		p.output.close()
+		b.publish(p, events.ActionDie, map[string]string{"exitCode": strconv.Itoa(code)})
+	}()
+	return nil
+}
+
+// ContainerStop sends the process its stop signal, killing it if it hasn't exited once the
+// stop timeout passes, 10 seconds if neither the options nor its config set one.
+func (b *processBackend) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	p := b.process(containerID)
+	if p == nil {
+		return b.ContainerBackend.ContainerStop(ctx, containerID, options)
+	}
+	b.mu.Lock()
+	cmd, exited, running := p.cmd, p.exited, p.state == container.StateRunning
+	b.mu.Unlock()
+	if !running {
+		return nil
+	}
+
+	timeout := 10
+	if options.Timeout != nil {
+		timeout = *options.Timeout
+	} else if p.config.StopTimeout != nil {
+		timeout = *p.config.StopTimeout
+	}
+	signal, ok := processSignals[options.Signal]
+	if !ok {
+		signal, ok = processSignals[p.config.StopSignal]
+	}
+	if !ok {
+		signal = syscall.SIGTERM
+	}
+	if timeout == 0 || cmd.Process.Signal(signal) != nil {
+		cmd.Process.Kill()
+	} else if timeout > 0 {
+		select {
+		case <-exited:
+		case <-time.After(time.Duration(timeout) * time.Second):
+			cmd.Process.Kill()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *processBackend) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	p := b.process(containerID)
+	if p == nil {
+		return b.ContainerBackend.ContainerRemove(ctx, containerID, options)
+	}
+	b.mu.Lock()
+	running := p.state == container.StateRunning
+	b.mu.Unlock()
+	if running && !options.Force {
+		return fmt.Errorf("cannot remove running process %v, stop it first", containerID[:12])
+	}
+	if running {
+		zero := 0
+		err := b.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &zero})
+		if err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.processes, containerID)
+	return nil
+}
+
+// ContainerInspect returns the process's state, labels and the host port bound to its port.
+func (b *processBackend) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	p := b.process(containerID)
+	if p == nil {
+		return b.ContainerBackend.ContainerInspect(ctx, containerID)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := &container.State{
+		Status:   p.state,
+		Running:  p.state == container.StateRunning,
+		ExitCode: p.exitCode,
+	}
+	if p.cmd != nil {
+		state.Pid = p.cmd.Process.Pid
+		state.StartedAt = p.started.Format(time.RFC3339Nano)
+	}
+	if !p.finished.IsZero() {
+		state.FinishedAt = p.finished.Format(time.RFC3339Nano)
+	}
+	// Its debug port is the one it binds
+	ports := nat.PortMap{p.port: {{HostIP: p.hostIP, HostPort: p.hostPort}}}
+	for port := range p.config.ExposedPorts {
+		ports[port] = []nat.PortBinding{{HostIP: p.hostIP, HostPort: port.Port()}}
+	}
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:      p.id,
+			Created: p.created.Format(time.RFC3339Nano),
+			Path:    p.config.Cmd[0],
+			Args:    p.config.Cmd[1:],
+			State:   state,
+			Image:   p.config.Image,
+		},
+		Config:          p.config,
+		NetworkSettings: &container.NetworkSettings{NetworkSettingsBase: container.NetworkSettingsBase{Ports: ports}},
+	}, nil
+}
+
+// ContainerList lists the containers of the wrapped backend, then the processes matching the
+// options' label filters.
+func (b *processBackend) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	summaries, err := b.ContainerBackend.ContainerList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range b.processes {
+		if p.state != container.StateRunning && !options.All || !options.Filters.MatchKVList("label", p.config.Labels) {
+			continue
+		}
+		summaries = append(summaries, container.Summary{
+			ID:      p.id,
+			Image:   p.config.Image,
+			Command: strings.Join(p.config.Cmd, " "),
+			Created: p.created.Unix(),
+			Labels:  p.config.Labels,
+			State:   p.state,
+		})
+	}
+	return summaries, nil
+}
+
+func (b *processBackend) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	p := b.process(containerID)
+	if p == nil {
+		return b.ContainerBackend.ContainerLogs(ctx, containerID, options)
+	}
+	return p.output.reader(ctx, options), nil
+}
+
+func (b *processBackend) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	if b.process(containerID) == nil {
+		return b.ContainerBackend.ContainerExecCreate(ctx, containerID, options)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	resp := container.ExecCreateResponse{ID: hex.EncodeToString(id)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.execs[resp.ID] = &backendExec{container: containerID, options: options}
+	return resp, nil
+}
+
+// ContainerExecAttach runs the exec's command in the process's dir, with its env.
+func (b *processBackend) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (dockertypes.HijackedResponse, error) {
+	b.mu.Lock()
+	e, ok := b.execs[execID]
+	var p *functionProcess
+	if ok {
+		e.running = true
+		p = b.processes[e.container]
+	}
+	b.mu.Unlock()
+	if !ok {
+		return b.ContainerBackend.ContainerExecAttach(ctx, execID, config)
+	}
+	if p == nil || len(e.options.Cmd) == 0 {
+		return dockertypes.HijackedResponse{}, fmt.Errorf("cannot run exec %v in process %v", execID, e.container)
+	}
+
+	// Not ctx, which only bounds attaching
+	cmdCtx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(cmdCtx, e.options.Cmd[0], e.options.Cmd[1:]...)
+	cmd.Dir = p.config.WorkingDir
+	if e.options.WorkingDir != "" {
+		cmd.Dir = e.options.WorkingDir
+	}
+	cmd.Env = slices.Concat(p.env, e.options.Env)
+	out, err := streamCommand(cmd, cancel, func(code int) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		e.running, e.exitCode = false, code
+	})
+	if err != nil {
+		return dockertypes.HijackedResponse{}, err
+	}
+	return dockertypes.HijackedResponse{Conn: &execConn{out: out}, Reader: bufio.NewReader(out)}, nil
+}
+
+func (b *processBackend) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.execs[execID]
+	if !ok {
+		return b.ContainerBackend.ContainerExecInspect(ctx, execID)
+	}
+	if !e.running {
+		delete(b.execs, execID)
+	}
+	return container.ExecInspect{ExecID: execID, ContainerID: e.container, Running: e.running, ExitCode: e.exitCode}, nil
+}
+
+// ImageInspect returns an image of a process function exposing its port, with no health check.
+func (b *processBackend) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	if !strings.HasPrefix(imageID, processImagePrefix) {
+		return b.ContainerBackend.ImageInspect(ctx, imageID, inspectOpts...)
+	}
+	config := &dockerspec.DockerOCIImageConfig{}
+	config.ExposedPorts = map[string]struct{}{processPort: {}}
+	return image.InspectResponse{ID: "process", RepoTags: []string{imageID}, Config: config}, nil
+}
+
+// Events streams the events of the wrapped backend's containers and the start and die events
+// of processes, matching the options' label and event filters.
+func (b *processBackend) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	msgs, errs := b.ContainerBackend.Events(ctx, options)
+	processMsgs := make(chan events.Message, 64)
+	b.mu.Lock()
+	b.subscribers[processMsgs] = struct{}{}
+	b.mu.Unlock()
+
+	merged := make(chan events.Message)
+	mergedErrs := make(chan error, 1)
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, processMsgs)
+		}()
+		for {
+			var msg events.Message
+			select {
+			case msg = <-msgs:
+			case msg = <-processMsgs:
+				if !options.Filters.MatchKVList("label", msg.Actor.Attributes) || !options.Filters.ExactMatch("event", string(msg.Action)) {
+					continue
+				}
+			case err := <-errs:
+				// Subscribed again by the caller
+				mergedErrs <- err
+				return
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case merged <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return merged, mergedErrs
+}
+
+// publish sends an event of the process to the subscribers of Events. The event is dropped
+// for subscribers too far behind.
+func (b *processBackend) publish(p *functionProcess, action events.Action, attributes map[string]string) {
+	msg := events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: p.id, Attributes: make(map[string]string)},
+		TimeNano: time.Now().UnixNano(),
+	}
+	msg.Time = msg.TimeNano / int64(time.Second)
+	for name, value := range p.config.Labels {
+		msg.Actor.Attributes[name] = value
+	}
+	for name, value := range attributes {
+		msg.Actor.Attributes[name] = value
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- msg:
+		default:
+		}
+	}
+}
+
+// processOutput is the output of a process, its last processLogLines lines.
+type processOutput struct {
+	mu      sync.Mutex
+	lines   []processLine
+	first   int           // Number of the first line kept
+	changed chan struct{} // Closed, and replaced, as lines are added
+	closed  bool          // The process exited
+	partial map[stdcopy.StdType][]byte
+}
+
+type processLine struct {
+	stream stdcopy.StdType
+	time   time.Time
+	text   []byte // With its newline
+}
+
+func newProcessOutput() *processOutput {
+	return &processOutput{changed: make(chan struct{}), partial: make(map[stdcopy.StdType][]byte)}
+}
+
+// writer returns a writer adding the lines written to it to the stream's output.
+func (o *processOutput) writer(stream stdcopy.StdType) io.Writer {
+	return &processWriter{output: o, stream: stream}
+}
+
+// processWriter adds whole lines written to it to a stream of a process's output, holding back
+// partial lines until the process exits.
+type processWriter struct {
+	output *processOutput
+	stream stdcopy.StdType
+}
+
+func (w *processWriter) Write(p []byte) (int, error) {
+	o := w.output
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	buf := append(o.partial[w.stream], p...)
+	for {
+		end := slices.Index(buf, '\n')
+		if end < 0 {
+			break
+		}
+		o.add(w.stream, buf[:end+1])
+		buf = buf[end+1:]
+	}
+	o.partial[w.stream] = slices.Clone(buf)
+	return len(p), nil
+}
+
+// add adds a line, dropping the oldest beyond processLogLines. Called with mu held.
+func (o *processOutput) add(stream stdcopy.StdType, text []byte) {
+	o.lines = append(o.lines, processLine{stream: stream, time: time.Now(), text: slices.Clone(text)})
+	if dropped := len(o.lines) - processLogLines; dropped > 0 {
+		o.lines = slices.Delete(o.lines, 0, dropped)
+		o.first += dropped
+	}
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// flush adds the output's partial lines, as the process exited.
+func (o *processOutput) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, stream := range []stdcopy.StdType{stdcopy.Stdout, stdcopy.Stderr} {
+		if len(o.partial[stream]) > 0 {
+			o.add(stream, append(o.partial[stream], '\n'))
+			delete(o.partial, stream)
+		}
+	}
+}
+
+// close ends the readers following the output.
+func (o *processOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// reader returns the output as ContainerLogs returns it, multiplexed: its last options.Tail
+// lines, all if not a number, then with options.Follow, the lines added until the process exits,
+// ctx is done or it is closed.
+func (o *processOutput) reader(ctx context.Context, options container.LogsOptions) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	writers := make(map[stdcopy.StdType]io.Writer)
+	if options.ShowStdout {
+		writers[stdcopy.Stdout] = stdcopy.NewStdWriter(pw, stdcopy.Stdout)
+	}
+	if options.ShowStderr {
+		writers[stdcopy.Stderr] = stdcopy.NewStdWriter(pw, stdcopy.Stderr)
+	}
+	go func() {
+		o.mu.Lock()
+		next := o.first
+		if tail, err := strconv.Atoi(options.Tail); err == nil {
+			next = max(o.first, o.first+len(o.lines)-tail)
+		}
+		o.mu.Unlock()
+		for {
+			o.mu.Lock()
+			next = max(next, o.first)
+			lines := slices.Clone(o.lines[next-o.first:])
+			next += len(lines)
+			changed, closed := o.changed, o.closed
+			o.mu.Unlock()
+
+			for _, line := range lines {
+				w, ok := writers[line.stream]
+				if !ok {
+					continue
+				}
+				text := line.text
+				if options.Timestamps {
+					text = append([]byte(line.time.UTC().Format(time.RFC3339Nano)+" "), text...)
+				}
+				_, err := w.Write(text)
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			if !options.Follow || closed {
+				pw.Close()
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				pw.Close()
+				return
+			}
+		}
+	}()
+	return &streamCloser{Reader: pr, close: func() error {
+		cancel()
+		return pr.Close()
+	}}
+}
@@ -0,0 +1,173 @@
+package slrun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// noContainers is a backend running no containers, only streaming no events.
+type noContainers struct {
+	ContainerBackend
+}
+
+func (noContainers) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	return nil, nil
+}
+
+func (noContainers) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	return make(chan events.Message), make(chan error)
+}
+
+func TestProcessBackend(t *testing.T) {
+	b := newProcessBackend(noContainers{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs, _ := b.Events(ctx, events.ListOptions{Filters: filters.NewArgs(instanceFilter("3f2a1b9c7d4e"))})
+
+	timeout := 5
+	config := &container.Config{
+		Image:       processImagePrefix + "func1",
+		Cmd:         []string{"sh", "-c", `echo "listening on $PORT"; echo warn >&2; trap 'echo bye; exit 0' TERM; while true; do sleep 0.1; done`},
+		WorkingDir:  t.TempDir(),
+		Labels:      map[string]string{labelInstance: "3f2a1b9c7d4e", labelFunction: "func1"},
+		StopSignal:  "SIGTERM",
+		StopTimeout: &timeout,
+	}
+	hostConfig := &container.HostConfig{PortBindings: nat.PortMap{"8080/tcp": {{HostIP: "127.0.0.1"}}}}
+	resp, err := b.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-msgs; msg.Action != events.ActionStart || msg.Actor.ID != resp.ID {
+		t.Errorf("first event = %v of %v, want start of the process", msg.Action, msg.Actor.ID)
+	}
+
+	inspect, err := b.ContainerInspect(ctx, resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindings := inspect.NetworkSettings.Ports["8080/tcp"]
+	if !inspect.State.Running || len(bindings) != 1 || bindings[0].HostPort == "" {
+		t.Fatalf("ContainerInspect() = %+v, ports %v, want running on a host port", inspect.State, bindings)
+	}
+	summaries, _ := b.ContainerList(ctx, container.ListOptions{Filters: filters.NewArgs(instanceFilter("3f2a1b9c7d4e"))})
+	if len(summaries) != 1 || summaries[0].State != container.StateRunning {
+		t.Errorf("ContainerList() = %+v, want the running process", summaries)
+	}
+
+	// Its output is written as it starts
+	want := "listening on " + bindings[0].HostPort + "\n"
+	var stdout, stderr bytes.Buffer
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		stdout.Reset()
+		stderr.Reset()
+		out, err := b.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdcopy.StdCopy(&stdout, &stderr, out)
+		out.Close()
+		if stdout.String() == want && stderr.String() == "warn\n" {
+			break
+		}
+	}
+	if stdout.String() != want || stderr.String() != "warn\n" {
+		t.Errorf("ContainerLogs() = %q, %q, want %q, %q", stdout.String(), stderr.String(), want, "warn\n")
+	}
+
+	exec, err := b.ContainerExecCreate(ctx, resp.ID, container.ExecOptions{Cmd: []string{"sh", "-c", "exit 3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attach, err := b.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, attach.Reader)
+	attach.Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		inspect, err := b.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 3 {
+				t.Errorf("ContainerExecInspect() exit code = %v, want 3", inspect.ExitCode)
+			}
+			break
+		}
+	}
+
+	if err := b.ContainerStop(ctx, resp.ID, container.StopOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-msgs; msg.Action != events.ActionDie || msg.Actor.Attributes["exitCode"] != "0" {
+		t.Errorf("event after stopping = %v exiting %v, want die exiting 0", msg.Action, msg.Actor.Attributes["exitCode"])
+	}
+	out, _ := b.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, Tail: "1"})
+	stdout.Reset()
+	stdcopy.StdCopy(&stdout, io.Discard, out)
+	if stdout.String() != "bye\n" {
+		t.Errorf("ContainerLogs() tail 1 = %q, want its last line", stdout.String())
+	}
+	if err := b.ContainerRemove(ctx, resp.ID, container.RemoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if summaries, _ := b.ContainerList(ctx, container.ListOptions{All: true}); len(summaries) != 0 {
+		t.Errorf("ContainerList() after removing = %+v, want none", summaries)
+	}
+}
+
+func TestValidateProcess(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		function *types.Function
+		err      string
+	}{
+		{&types.Function{Name: "func1", Process: &types.Process{Command: []string{"node", "index.js"}, Dir: dir}}, ""},
+		{&types.Function{Name: "func1", Process: &types.Process{Dir: dir}}, "needs a command"},
+		{&types.Function{Name: "func1", Process: &types.Process{Command: []string{"node"}, Dir: dir + "/missing"}}, "not a directory"},
+		{&types.Function{Name: "func1", Process: &types.Process{Command: []string{"node"}, Dir: dir}, Memory: "128m"}, "enforced by containers"},
+		{&types.Function{Name: "func1", Process: &types.Process{Command: []string{"node"}, Dir: dir}, StopSignal: "SIGUSR2"}, "can't be stopped with SIGUSR2"},
+	}
+	for _, test := range tests {
+		config := &types.Config{Functions: []*types.Function{test.function}}
+		err := validateProcess(config, test.function)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("validateProcess(%+v) = %v, want %q", test.function.Process, err, test.err)
+		}
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	env := processEnv([]string{"LOG_LEVEL=debug", "PATH=/opt/bin"}, "127.0.0.1", "8081")
+	if slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "AWS_SECRET_ACCESS_KEY=") }) {
+		t.Errorf("processEnv() = %v, want slrun's secrets kept out", env)
+	}
+	for _, v := range []string{"LOG_LEVEL=debug", "PORT=8081", "SLRUN_HOST=127.0.0.1"} {
+		if !slices.Contains(env, v) {
+			t.Errorf("processEnv() = %v, want %v in it", env, v)
+		}
+	}
+	// The function's PATH comes after slrun's, and is used
+	if i, j := slices.Index(env, "PATH=/usr/bin"), slices.Index(env, "PATH=/opt/bin"); i < 0 || j < i {
+		t.Errorf("processEnv() = %v, want slrun's PATH then the function's", env)
+	}
+}
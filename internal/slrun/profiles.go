@@ -0,0 +1,74 @@
+package slrun
+
+import (
+	"fmt"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// runtimeProfile holds defaults for functions written for a language runtime.
+type runtimeProfile struct {
+	stopSignal  string
+	stopTimeout int // Seconds
+	readyPath   string
+	warmup      *types.Warmup
+	debugger    string
+}
+
+var runtimeProfiles = map[string]runtimeProfile{
+	"node": {
+		stopSignal:  "SIGTERM",
+		stopTimeout: 10,
+		readyPath:   "/",
+		debugger:    "node",
+	},
+	// The JVM is slow to start and needs traffic before its JIT kicks in
+	"jvm": {
+		stopSignal:  "SIGTERM",
+		stopTimeout: 30,
+		readyPath:   "/",
+		warmup:      &types.Warmup{Count: 100, Concurrency: 4},
+		debugger:    "java",
+	},
+	"python": {
+		stopSignal:  "SIGTERM",
+		stopTimeout: 10,
+		readyPath:   "/",
+		debugger:    "python",
+	},
+	"go": {
+		stopSignal:  "SIGTERM",
+		stopTimeout: 5,
+		readyPath:   "/",
+		debugger:    "go",
+	},
+}
+
+// applyRuntimeProfile fills the function's unset settings from its runtime profile.
+func applyRuntimeProfile(f *types.Function) error {
+	if f.Profile == "" {
+		return nil
+	}
+	profile, exists := runtimeProfiles[f.Profile]
+	if !exists {
+		return fmt.Errorf("function %s has unknown profile: %s", f.Name, f.Profile)
+	}
+
+	if f.StopSignal == "" {
+		f.StopSignal = profile.stopSignal
+	}
+	if f.StopTimeout == 0 {
+		f.StopTimeout = profile.stopTimeout
+	}
+	if f.ReadyPath == "" {
+		f.ReadyPath = profile.readyPath
+	}
+	if f.Warmup == nil && profile.warmup != nil {
+		warmup := *profile.warmup
+		f.Warmup = &warmup
+	}
+	if f.Debugger == "" {
+		f.Debugger = profile.debugger
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package slrun
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Addresses of proxied hosts dialed
+const (
+	DNSStrategyAny       = "any"
+	DNSStrategyIPv4First = "ipv4_first"
+	DNSStrategyIPv6First = "ipv6_first"
+	DNSStrategyIPv4Only  = "ipv4_only"
+	DNSStrategyIPv6Only  = "ipv6_only"
+)
+
+var dnsStrategies = []string{DNSStrategyAny, DNSStrategyIPv4First, DNSStrategyIPv6First, DNSStrategyIPv4Only, DNSStrategyIPv6Only}
+
+// Resolves the hosts proxy clients dial, set by useProxyDNS
+var proxyResolver = newDNSCache(30*time.Second, DNSStrategyAny)
+
+func validateProxyDNS(config *types.Config) error {
+	if config.ProxyDNS == nil {
+		config.ProxyDNS = &types.ProxyDNS{}
+	}
+	proxyDNS := config.ProxyDNS
+	if proxyDNS.TTL == "" {
+		proxyDNS.TTL = "30s"
+	}
+	ttl, err := time.ParseDuration(proxyDNS.TTL)
+	if err != nil {
+		return fieldError("proxy_dns.ttl", "invalid proxy DNS ttl: %w", err)
+	}
+	if ttl < 0 {
+		return fieldError("proxy_dns.ttl", "invalid proxy DNS ttl: %s", proxyDNS.TTL)
+	}
+	if proxyDNS.Strategy == "" {
+		proxyDNS.Strategy = DNSStrategyAny
+	}
+	if !slices.Contains(dnsStrategies, proxyDNS.Strategy) {
+		return fieldError("proxy_dns.strategy", "unknown proxy DNS strategy: %s", proxyDNS.Strategy)
+	}
+	return nil
+}
+
+// useProxyDNS resolves the hosts of proxy clients created from now on as configured.
+func useProxyDNS(config *types.ProxyDNS) {
+	ttl, _ := time.ParseDuration(config.TTL)
+	proxyResolver = newDNSCache(ttl, config.Strategy)
+}
+
+// dnsCache resolves hosts for dialing, caching their addresses. Expired addresses are used
+// while the host is resolved again in the background, so calls don't wait on lookups.
+type dnsCache struct {
+	resolver *net.Resolver
+	dialer   *net.Dialer
+	ttl      time.Duration // Nothing is cached if 0
+	strategy string
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry // By host
+}
+
+type dnsEntry struct {
+	addrs      []netip.Addr
+	expires    time.Time
+	refreshing bool // Being resolved again
+}
+
+func newDNSCache(ttl time.Duration, strategy string) *dnsCache {
+	return &dnsCache{
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		ttl:      ttl,
+		strategy: strategy,
+		entries:  make(map[string]*dnsEntry),
+	}
+}
+
+// lookup returns the addresses of host to dial, in order.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	c.mu.Lock()
+	entry := c.entries[host]
+	if entry != nil {
+		if !entry.refreshing && !time.Now().Before(entry.expires) {
+			entry.refreshing = true
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				c.resolve(ctx, host)
+			}()
+		}
+		c.mu.Unlock()
+		return entry.addrs, nil
+	}
+	c.mu.Unlock()
+	return c.resolve(ctx, host)
+}
+
+// resolve looks host up, caching its addresses. If it fails, cached addresses are kept.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	addrs, err := c.resolver.LookupNetIP(ctx, "ip", host)
+	if err == nil {
+		addrs = orderAddrs(addrs, c.strategy)
+		if len(addrs) == 0 {
+			err = &net.DNSError{Err: "no address for strategy " + c.strategy, Name: host, IsNotFound: true}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if entry := c.entries[host]; entry != nil {
+			entry.refreshing = false
+		}
+		return nil, err
+	}
+	if c.ttl > 0 {
+		c.entries[host] = &dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	}
+	return addrs, nil
+}
+
+// orderAddrs returns the addresses dialed with strategy, in order.
+func orderAddrs(addrs []netip.Addr, strategy string) []netip.Addr {
+	var v4, v6 []netip.Addr
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() {
+			v4 = append(v4, addr.Unmap())
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	switch strategy {
+	case DNSStrategyIPv4First:
+		return append(v4, v6...)
+	case DNSStrategyIPv6First:
+		return append(v6, v4...)
+	case DNSStrategyIPv4Only:
+		return v4
+	case DNSStrategyIPv6Only:
+		return v6
+	}
+	return addrs
+}
+
+// DialContext dials addr, trying its host's addresses in order. If none accepts the connection,
+// e.g. as the host moved after a restart, the host is resolved again and any new addresses dialed.
+func (c *dnsCache) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		// As the dialer reports it, so it's handled as any dial error
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	conn, err := c.dialAddrs(ctx, network, addrs, port)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	fresh, rerr := c.resolve(ctx, host)
+	if rerr != nil || slices.Equal(fresh, addrs) {
+		return nil, err
+	}
+	return c.dialAddrs(ctx, network, fresh, port)
+}
+
+// dialAddrs dials each address in turn, returning the first connection, or the first error.
+func (c *dnsCache) dialAddrs(ctx context.Context, network string, addrs []netip.Addr, port string) (net.Conn, error) {
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,72 @@
+package slrun
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOrderAddrs(t *testing.T) {
+	v4, v6 := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")
+	addrs := []netip.Addr{v6, v4}
+	tests := []struct {
+		strategy string
+		want     []netip.Addr
+	}{
+		{DNSStrategyAny, []netip.Addr{v6, v4}},
+		{DNSStrategyIPv4First, []netip.Addr{v4, v6}},
+		{DNSStrategyIPv6First, []netip.Addr{v6, v4}},
+		{DNSStrategyIPv4Only, []netip.Addr{v4}},
+		{DNSStrategyIPv6Only, []netip.Addr{v6}},
+	}
+	for _, tt := range tests {
+		if got := orderAddrs(addrs, tt.strategy); !slices.Equal(got, tt.want) {
+			t.Errorf("orderAddrs(%v) = %v, want %v", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestDNSCacheDial(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// A stale address nothing listens on, as if localhost moved
+	c := newDNSCache(time.Minute, DNSStrategyIPv4Only)
+	stale := netip.MustParseAddr("127.0.0.2")
+	c.entries["localhost"] = &dnsEntry{addrs: []netip.Addr{stale}, expires: time.Now().Add(time.Minute)}
+
+	conn, err := c.DialContext(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("DialContext() = %v, want it to resolve localhost again", err)
+	}
+	conn.Close()
+	if got := c.entries["localhost"].addrs; slices.Contains(got, stale) {
+		t.Errorf("cached addresses after a failed dial = %v, want them resolved again", got)
+	}
+
+	// Expired addresses are used while resolved again in the background
+	c.entries["localhost"].expires = time.Now()
+	addrs, err := c.lookup(context.Background(), "localhost")
+	if err != nil || len(addrs) == 0 {
+		t.Fatalf("lookup() of expired addresses = %v, %v", addrs, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		c.mu.Lock()
+		refreshed := c.entries["localhost"].expires.After(time.Now())
+		c.mu.Unlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired addresses weren't resolved again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
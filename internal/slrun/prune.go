@@ -0,0 +1,86 @@
+package slrun
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Pruned lists what Prune removed.
+type Pruned struct {
+	Containers []string // Short IDs with their function, e.g. 3f2a1b9c7d4e (func1)
+	Images     []string
+	Networks   []string
+}
+
+// Prune removes the stopped containers of the config's slrun instance, left by keep_containers
+// or slrun versions that didn't remove them, then images built for functions no longer in the config
+// and candidate images left by interrupted builds, and the instance's network. Running containers,
+// and images and networks they use, are kept.
+func Prune(config *types.Config) (*Pruned, error) {
+	pruned := &Pruned{}
+	instance := instanceID(config.StateDir)
+	containers, err := dockerCli.ContainerList(dockerCtx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		// Unlabelled containers of function images were created before slrun labelled them
+		owner, labelled := c.Labels[labelInstance]
+		owned := owner == instance || !labelled && strings.HasPrefix(c.Image, "slrun-")
+		if !owned || c.State == container.StateRunning {
+			continue
+		}
+		err := dockerCli.ContainerRemove(dockerCtx, c.ID, container.RemoveOptions{})
+		if err != nil {
+			log.Printf("Cannot remove container %v: %v\n", c.ID[:12], err)
+			continue
+		}
+		pruned.Containers = append(pruned.Containers, fmt.Sprintf("%v (%v)", c.ID[:12], cmp.Or(c.Labels[labelFunction], c.Image)))
+	}
+
+	used := make(map[string]bool)
+	for _, f := range config.Functions {
+		used["slrun-"+f.Name] = true
+	}
+	images, err := dockerCli.ImageList(dockerCtx, image.ListOptions{Filters: filters.NewArgs(filters.Arg("reference", "slrun-*"))})
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			name, version, _ := strings.Cut(tag, ":")
+			if used[name] && version != "candidate" {
+				continue
+			}
+			_, err := dockerCli.ImageRemove(dockerCtx, tag, image.RemoveOptions{PruneChildren: true})
+			if err != nil {
+				log.Printf("Cannot remove image %v: %v\n", tag, err)
+				continue
+			}
+			pruned.Images = append(pruned.Images, tag)
+		}
+	}
+
+	// Created for the instance's functions, recreated as slrun starts
+	networks, err := dockerCli.NetworkList(dockerCtx, network.ListOptions{Filters: filters.NewArgs(instanceFilter(instance))})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range networks {
+		err := dockerCli.NetworkRemove(dockerCtx, n.ID)
+		if err != nil {
+			log.Printf("Cannot remove network %v: %v\n", n.Name, err)
+			continue
+		}
+		pruned.Networks = append(pruned.Networks, n.Name)
+	}
+	return pruned, nil
+}
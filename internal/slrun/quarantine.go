@@ -0,0 +1,285 @@
+package slrun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func validateQuarantine(config *types.Config) error {
+	quarantine := config.Quarantine
+	if quarantine == nil {
+		return nil
+	}
+	if quarantine.Violations <= 0 {
+		quarantine.Violations = 3
+	}
+	if quarantine.Window == "" {
+		quarantine.Window = "1h"
+	}
+	if _, err := time.ParseDuration(quarantine.Window); err != nil {
+		return fieldError("quarantine.window", "invalid quarantine window: %w", err)
+	}
+	for _, e := range quarantine.Events {
+		if e == EventQuarantined || !slices.Contains(notifiableEvents, e) {
+			return fieldError("quarantine.events", "quarantine has unknown violation event: %s", e)
+		}
+	}
+	return nil
+}
+
+// Violation is a function breaking a security or policy rule.
+type Violation struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"` // What reported it, e.g. an event type or egress-proxy
+	Reason string    `json:"reason"`
+}
+
+// QuarantineRecord is a quarantined function and the violations that got it quarantined.
+type QuarantineRecord struct {
+	Function   string       `json:"function"`
+	Since      time.Time    `json:"since"`
+	Violations []*Violation `json:"violations"`
+}
+
+// Quarantine disables functions that keep violating security or policy rules,
+// counting violations reported through the admin API and events of the configured types.
+// Quarantined functions stay disabled, across restarts, until released by an admin.
+type Quarantine struct {
+	config  *types.Quarantine
+	window  time.Duration
+	file    string // Quarantined functions, kept across restarts
+	events  *Events
+	runtime *Runtime
+
+	mu          sync.Mutex
+	violations  map[string][]*Violation // Recent violations of functions not quarantined
+	quarantined map[string]*QuarantineRecord
+
+	sub  chan Event
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func quarantineFile(stateDir string) string {
+	return filepath.Join(stateDir, "quarantine.json")
+}
+
+// NewQuarantine returns the quarantine of config, with the functions quarantined before,
+// or nil if config is nil.
+func NewQuarantine(config *types.Quarantine, stateDir string, events *Events) (*Quarantine, error) {
+	if config == nil {
+		return nil, nil
+	}
+	window, _ := time.ParseDuration(config.Window)
+	q := &Quarantine{
+		config:      config,
+		window:      window,
+		file:        quarantineFile(stateDir),
+		events:      events,
+		violations:  make(map[string][]*Violation),
+		quarantined: make(map[string]*QuarantineRecord),
+	}
+
+	bytes, err := os.ReadFile(q.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*QuarantineRecord
+	err = json.Unmarshal(bytes, &records)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", q.file, err)
+	}
+	for _, record := range records {
+		q.quarantined[record.Function] = record
+	}
+	return q, nil
+}
+
+// save writes the quarantined functions to the quarantine file. Must hold q.mu.
+func (q *Quarantine) save() error {
+	records := q.list()
+	bytes, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(q.file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(q.file, bytes, 0644)
+}
+
+// list returns the quarantine records by function name. Must hold q.mu.
+func (q *Quarantine) list() []*QuarantineRecord {
+	records := []*QuarantineRecord{}
+	for _, record := range q.quarantined {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Function < records[j].Function })
+	return records
+}
+
+// List returns the quarantined functions.
+func (q *Quarantine) List() []*QuarantineRecord {
+	if q == nil {
+		return []*QuarantineRecord{}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.list()
+}
+
+// Quarantined returns the quarantine record of a function, nil if it isn't quarantined.
+func (q *Quarantine) Quarantined(function string) *QuarantineRecord {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.quarantined[function]
+}
+
+// Report records a violation by function, quarantining it once it has violated
+// the configured number of times within the window. Returns whether it is quarantined.
+func (q *Quarantine) Report(function *types.Function, source string, reason string) (bool, error) {
+	q.mu.Lock()
+	if _, exists := q.quarantined[function.Name]; exists {
+		q.mu.Unlock()
+		return true, nil
+	}
+
+	now := time.Now()
+	violations := []*Violation{}
+	for _, v := range q.violations[function.Name] {
+		if now.Sub(v.Time) < q.window {
+			violations = append(violations, v)
+		}
+	}
+	violations = append(violations, &Violation{Time: now, Source: source, Reason: reason})
+	log.Printf("Function %v violation %v/%v from %v: %v\n", function.Name, len(violations), q.config.Violations, source, reason)
+	if len(violations) < q.config.Violations {
+		q.violations[function.Name] = violations
+		q.mu.Unlock()
+		return false, nil
+	}
+
+	delete(q.violations, function.Name)
+	q.quarantined[function.Name] = &QuarantineRecord{Function: function.Name, Since: now, Violations: violations}
+	err := q.save()
+	q.mu.Unlock()
+	if err != nil {
+		log.Printf("Cannot save quarantine of function %v: %v\n", function.Name, err)
+	}
+
+	log.Printf("Quarantined function %v after %v violations\n", function.Name, len(violations))
+	q.events.Publish(Event{
+		Type:     EventQuarantined,
+		Function: function.Name,
+		Data: map[string]any{
+			"error":      reason,
+			"violations": len(violations),
+			"window":     q.window.String(),
+		},
+	})
+	return true, q.runtime.SetFunctionEnabled(function, false)
+}
+
+// Release takes function out of quarantine and enables it, forgetting its violations.
+func (q *Quarantine) Release(function *types.Function) error {
+	q.mu.Lock()
+	if _, exists := q.quarantined[function.Name]; !exists {
+		q.mu.Unlock()
+		return fmt.Errorf("function %v isn't quarantined", function.Name)
+	}
+	delete(q.quarantined, function.Name)
+	err := q.save()
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Released function %v from quarantine\n", function.Name)
+	return q.runtime.SetFunctionEnabled(function, true)
+}
+
+// setState marks a quarantined function's state, with the reason of its last violation.
+func (q *Quarantine) setState(state *FunctionState) {
+	if q == nil {
+		return
+	}
+	record := q.Quarantined(state.Name)
+	if record == nil {
+		return
+	}
+	state.State = StateQuarantined
+	if len(record.Violations) > 0 {
+		state.Reason = record.Violations[len(record.Violations)-1].Reason
+	}
+}
+
+// Start counts events of the configured types as violations of the function they are about,
+// or of the function running the instance they are about.
+func (q *Quarantine) Start() {
+	if q == nil || len(q.config.Events) == 0 {
+		return
+	}
+	q.sub = q.events.Subscribe()
+	q.stop = make(chan struct{})
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for {
+			select {
+			case event := <-q.sub:
+				q.eventViolation(event)
+			case <-q.stop:
+				q.events.Unsubscribe(q.sub)
+				return
+			}
+		}
+	}()
+}
+
+func (q *Quarantine) eventViolation(event Event) {
+	if event.Function == "" || !slices.Contains(q.config.Events, event.Type) {
+		return
+	}
+	name, _, _ := strings.Cut(event.Function, "@")
+	name, _, _ = strings.Cut(name, "#")
+	function := q.runtime.FunctionByName(name)
+	if function == nil {
+		return
+	}
+
+	reason := event.Type
+	if msg, ok := event.Data["error"].(string); ok {
+		reason = msg
+	}
+	_, err := q.Report(function, event.Type, reason)
+	if err != nil {
+		log.Printf("Cannot quarantine function %v: %v\n", function.Name, err)
+	}
+}
+
+func (q *Quarantine) Stop() {
+	if q == nil || q.stop == nil {
+		return
+	}
+	close(q.stop)
+	q.wg.Wait()
+}
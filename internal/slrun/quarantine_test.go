@@ -0,0 +1,63 @@
+package slrun
+
+import (
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestQuarantineRelease(t *testing.T) {
+	config := &types.Config{Quarantine: &types.Quarantine{Violations: 2}}
+	if err := validateQuarantine(config); err != nil {
+		t.Fatal(err)
+	}
+	stateDir := t.TempDir()
+	q, err := NewQuarantine(config.Quarantine, stateDir, NewEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remote, so enabling and disabling it needs no containers
+	function := &types.Function{Name: "func1", Remote: true, IsEnabled: true}
+	q.runtime = &Runtime{}
+
+	if quarantined, err := q.Report(function, "egress-proxy", "blocked host"); quarantined || err != nil {
+		t.Fatalf("Report() under the limit = %v, %v, want not quarantined", quarantined, err)
+	}
+	if quarantined, err := q.Report(function, "egress-proxy", "blocked host"); !quarantined || err != nil {
+		t.Fatalf("Report() at the limit = %v, %v, want quarantined", quarantined, err)
+	}
+	if function.IsEnabled || q.Quarantined("func1") == nil {
+		t.Errorf("quarantined function enabled = %v, record = %v, want disabled", function.IsEnabled, q.Quarantined("func1"))
+	}
+
+	// Quarantines are kept across restarts
+	restarted, err := NewQuarantine(config.Quarantine, stateDir, NewEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.runtime = q.runtime
+	if record := restarted.Quarantined("func1"); record == nil || len(record.Violations) != 2 {
+		t.Fatalf("Quarantined() after a restart = %+v, want the record and its 2 violations", record)
+	}
+
+	// Released functions are enabled, and their violations counted afresh
+	if err := restarted.Release(function); err != nil {
+		t.Fatal(err)
+	}
+	if !function.IsEnabled || restarted.Quarantined("func1") != nil {
+		t.Errorf("released function enabled = %v, record = %v, want enabled", function.IsEnabled, restarted.Quarantined("func1"))
+	}
+	if err := restarted.Release(function); err == nil {
+		t.Errorf("Release() of a function not quarantined = nil, want an error")
+	}
+	if quarantined, _ := restarted.Report(function, "egress-proxy", "blocked host"); quarantined {
+		t.Errorf("Report() after a release = quarantined, want violations counted afresh")
+	}
+	reloaded, err := NewQuarantine(config.Quarantine, stateDir, NewEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.List()) != 0 {
+		t.Errorf("List() after a release and restart = %v, want none", reloaded.List())
+	}
+}
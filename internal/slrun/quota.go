@@ -0,0 +1,162 @@
+package slrun
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// ErrNoQuota is returned for requests with an unknown API key, or with no known key or tenant
+// when there is no anonymous quota.
+var ErrNoQuota = errors.New("request has no quota")
+
+// Usage is the usage of a quota subject.
+type Usage struct {
+	Day            string `json:"day"` // Day RequestsToday counts, YYYY-MM-DD
+	RequestsToday  int    `json:"requests_today"`
+	InFlight       int    `json:"in_flight"`
+	RequestsPerDay int    `json:"requests_per_day,omitempty"`
+	MaxConcurrent  int    `json:"max_concurrent,omitempty"`
+}
+
+// Quotas enforces per API key and per tenant quotas.
+type Quotas struct {
+	config *types.Quotas
+	keys   map[string]*types.KeyQuota // By key
+	mu     sync.Mutex
+	usage  map[string]*Usage // By "key:<name>", "tenant:<name>" or "anonymous"
+}
+
+func NewQuotas(config *types.Quotas) *Quotas {
+	q := &Quotas{
+		config: config,
+		keys:   make(map[string]*types.KeyQuota),
+		usage:  make(map[string]*Usage),
+	}
+	if config == nil {
+		return q
+	}
+
+	for _, k := range config.Keys {
+		q.keys[k.Key] = k
+		q.usage["key:"+k.Name] = &Usage{RequestsPerDay: k.RequestsPerDay, MaxConcurrent: k.MaxConcurrent}
+	}
+	for name, t := range config.Tenants {
+		q.usage["tenant:"+name] = &Usage{RequestsPerDay: t.RequestsPerDay, MaxConcurrent: t.MaxConcurrent}
+	}
+	if a := config.Anonymous; a != nil {
+		q.usage["anonymous"] = &Usage{RequestsPerDay: a.RequestsPerDay, MaxConcurrent: a.MaxConcurrent}
+	}
+	return q
+}
+
+// subjects returns the usage keys a request counts against: its key and tenant, or the
+// anonymous quota if it has neither.
+func (q *Quotas) subjects(r *http.Request) ([]string, error) {
+	var subjects []string
+	if key := r.Header.Get(q.config.KeyHeader); key != "" {
+		k, exists := q.keys[key]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown API key in %v", ErrNoQuota, q.config.KeyHeader)
+		}
+		subjects = append(subjects, "key:"+k.Name)
+	}
+	if tenant := r.Header.Get(q.config.TenantHeader); tenant != "" {
+		if _, exists := q.config.Tenants[tenant]; exists {
+			subjects = append(subjects, "tenant:"+tenant)
+		}
+	}
+	if len(subjects) == 0 {
+		if q.config.Anonymous == nil {
+			return nil, fmt.Errorf("%w: no API key in %v or known tenant in %v", ErrNoQuota, q.config.KeyHeader, q.config.TenantHeader)
+		}
+		subjects = append(subjects, "anonymous")
+	}
+	return subjects, nil
+}
+
+// KeyName returns the name of the request's API key, or "" if it has no known key.
+func (q *Quotas) KeyName(r *http.Request) string {
+	if q.config == nil {
+		return ""
+	}
+	if k, exists := q.keys[r.Header.Get(q.config.KeyHeader)]; exists {
+		return k.Name
+	}
+	return ""
+}
+
+// Acquire counts a request against its API key's and tenant's quotas, or the anonymous quota.
+// The returned function must be called when the request completes.
+// Requests with an unknown key, or no known key or tenant and no anonymous quota, fail with
+// ErrNoQuota.
+func (q *Quotas) Acquire(r *http.Request) (func(), error) {
+	if q.config == nil {
+		return func() {}, nil
+	}
+	subjects, err := q.subjects(r)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	today := time.Now().Format(time.DateOnly)
+	for _, s := range subjects {
+		u := q.usage[s]
+		if u.Day != today {
+			u.Day = today
+			u.RequestsToday = 0
+		}
+		if u.RequestsPerDay > 0 && u.RequestsToday >= u.RequestsPerDay {
+			return nil, fmt.Errorf("%v exceeded %v requests per day", s, u.RequestsPerDay)
+		}
+		if u.MaxConcurrent > 0 && u.InFlight >= u.MaxConcurrent {
+			return nil, fmt.Errorf("%v exceeded %v concurrent requests", s, u.MaxConcurrent)
+		}
+	}
+
+	for _, s := range subjects {
+		q.usage[s].RequestsToday++
+		q.usage[s].InFlight++
+	}
+
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for _, s := range subjects {
+			q.usage[s].InFlight--
+		}
+	}, nil
+}
+
+// quotaError classes an error of Acquire.
+func quotaError(err error) *InvocationError {
+	if errors.Is(err, ErrNoQuota) {
+		return invocationError(ErrClassNoQuota, http.StatusUnauthorized, err)
+	}
+	return invocationError(ErrClassQuotaExceeded, http.StatusTooManyRequests, err)
+}
+
+// Usage returns a snapshot of usage by subject.
+func (q *Quotas) Usage() map[string]Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	today := time.Now().Format(time.DateOnly)
+	usage := make(map[string]Usage)
+	for s, u := range q.usage {
+		snapshot := *u
+		if snapshot.Day != today {
+			snapshot.Day = today
+			snapshot.RequestsToday = 0
+		}
+		usage[s] = snapshot
+	}
+	return usage
+}
@@ -0,0 +1,86 @@
+package slrun
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestQuotas(t *testing.T) {
+	config := &types.Quotas{
+		Keys:    []*types.KeyQuota{{Name: "ci", Key: "ci-key", Quota: types.Quota{RequestsPerDay: 2, MaxConcurrent: 1}}},
+		Tenants: map[string]*types.Quota{"acme": nil},
+	}
+	if err := validateQuotas(config); err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuotas(config)
+	request := func(key, tenant string) (func(), error) {
+		r := httptest.NewRequest("POST", "/func1", nil)
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		return q.Acquire(r)
+	}
+
+	// Concurrent requests, then requests per day, are limited
+	release, err := request("ci-key", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := request("ci-key", ""); err == nil || errors.Is(err, ErrNoQuota) {
+		t.Errorf("Acquire() over max_concurrent = %v, want exceeded", err)
+	}
+	release()
+	release, err = request("ci-key", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := request("ci-key", ""); err == nil || quotaError(err).Class != ErrClassQuotaExceeded {
+		t.Errorf("Acquire() over requests_per_day = %v, want %v", err, ErrClassQuotaExceeded)
+	}
+
+	// Counters reset on the next day
+	q.usage["key:ci"].Day = "2000-01-01"
+	release, err = request("ci-key", "")
+	if err != nil {
+		t.Errorf("Acquire() on a new day = %v, want a reset quota", err)
+	} else {
+		release()
+	}
+	if usage := q.Usage()["key:ci"]; usage.RequestsToday != 1 || usage.InFlight != 0 {
+		t.Errorf("Usage() = %+v, want 1 request today, none in flight", usage)
+	}
+
+	// Unknown keys, and requests with no known key or tenant, have no quota
+	for _, test := range []struct{ key, tenant string }{{"wrong-key", ""}, {"wrong-key", "acme"}, {"", ""}, {"", "other"}} {
+		if _, err := request(test.key, test.tenant); quotaError(err).Class != ErrClassNoQuota || quotaError(err).Status != 401 {
+			t.Errorf("Acquire(key %q, tenant %q) = %v, want %v", test.key, test.tenant, err, ErrClassNoQuota)
+		}
+	}
+	release, err = request("", "acme")
+	if err != nil {
+		t.Errorf("Acquire() of a known tenant = %v", err)
+	} else {
+		release()
+	}
+
+	// Unless there is an anonymous quota
+	config.Anonymous = &types.Quota{RequestsPerDay: 1}
+	q = NewQuotas(config)
+	if _, err := request("", "other"); err != nil {
+		t.Errorf("Acquire() with an anonymous quota = %v", err)
+	}
+	if _, err := request("", ""); err == nil || quotaError(err).Class != ErrClassQuotaExceeded {
+		t.Errorf("Acquire() over the anonymous quota = %v, want %v", err, ErrClassQuotaExceeded)
+	}
+	if _, err := request("wrong-key", ""); !errors.Is(err, ErrNoQuota) {
+		t.Errorf("Acquire() of an unknown key with an anonymous quota = %v, want %v", err, ErrNoQuota)
+	}
+}
@@ -0,0 +1,105 @@
+package slrun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// RangeCheck is the result of one check of a function's range request handling.
+type RangeCheck struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+func rangeGet(url string, header map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := newProxyClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// CheckRange verifies that range and conditional requests to url, typically a function
+// behind the gateway, get correct 206, 304 and 416 responses.
+func CheckRange(url string) ([]RangeCheck, error) {
+	resp, full, err := rangeGet(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: expected 200, got %v", url, resp.StatusCode)
+	}
+	size := len(full)
+	if size == 0 {
+		return nil, fmt.Errorf("GET %v: empty body, nothing to range over", url)
+	}
+
+	var checks []RangeCheck
+	check := func(name string, passed bool, format string, args ...any) {
+		checks = append(checks, RangeCheck{Name: name, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	check("accept-ranges", resp.Header.Get("Accept-Ranges") == "bytes",
+		"Accept-Ranges: %q", resp.Header.Get("Accept-Ranges"))
+
+	// First half
+	end := size/2 - 1
+	if end < 0 {
+		end = 0
+	}
+	resp, body, err := rangeGet(url, map[string]string{"Range": fmt.Sprintf("bytes=0-%d", end)})
+	if err != nil {
+		return nil, err
+	}
+	want := fmt.Sprintf("bytes 0-%d/%d", end, size)
+	check("range", resp.StatusCode == http.StatusPartialContent && resp.Header.Get("Content-Range") == want && bytes.Equal(body, full[:end+1]),
+		"status %v, Content-Range %q (want 206, %q), %v bytes", resp.StatusCode, resp.Header.Get("Content-Range"), want, len(body))
+
+	// Suffix
+	n := min(10, size)
+	resp, body, err = rangeGet(url, map[string]string{"Range": fmt.Sprintf("bytes=-%d", n)})
+	if err != nil {
+		return nil, err
+	}
+	check("suffix-range", resp.StatusCode == http.StatusPartialContent && bytes.Equal(body, full[size-n:]),
+		"status %v (want 206), %v bytes", resp.StatusCode, len(body))
+
+	// Past the end
+	resp, _, err = rangeGet(url, map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)})
+	if err != nil {
+		return nil, err
+	}
+	check("unsatisfiable-range", resp.StatusCode == http.StatusRequestedRangeNotSatisfiable,
+		"status %v (want 416)", resp.StatusCode)
+
+	// Conditional
+	resp, _, err = rangeGet(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		check("if-none-match", false, "no ETag in response")
+		return checks, nil
+	}
+	resp, _, err = rangeGet(url, map[string]string{"If-None-Match": etag})
+	if err != nil {
+		return nil, err
+	}
+	check("if-none-match", resp.StatusCode == http.StatusNotModified,
+		"status %v (want 304) for ETag %v", resp.StatusCode, etag)
+
+	return checks, nil
+}
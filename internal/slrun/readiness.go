@@ -0,0 +1,131 @@
+package slrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Commands are heavier to run than connections, so exec probes are sent less often
+const execProbeInterval = 250 * time.Millisecond
+
+// Longest a single probe may take
+const probeTimeout = 5 * time.Second
+
+func validateReadiness(config *types.Config, f *types.Function) error {
+	probe := f.Readiness
+	if probe == nil {
+		return nil
+	}
+	probes := 0
+	if probe.Path != "" {
+		probes++
+		if probe.Path[0] != '/' {
+			return fieldError(functionField(config, f, "readiness.path"), "function %s readiness path must start with /: %s", f.Name, probe.Path)
+		}
+	}
+	if probe.TCP {
+		probes++
+	}
+	if len(probe.Exec) > 0 {
+		probes++
+	}
+	if probes != 1 {
+		return fieldError(functionField(config, f, "readiness"), "function %s readiness needs one of path, tcp and exec", f.Name)
+	}
+	if probe.Timeout < 0 {
+		return fieldError(functionField(config, f, "readiness.timeout"), "function %s has negative readiness timeout", f.Name)
+	}
+	return nil
+}
+
+// functionReadyTimeout returns how long a new container of the function may take to be ready,
+// extended by the time its health check may take.
+func (r *Runtime) functionReadyTimeout(function *types.Function) time.Duration {
+	timeout := r.readyTimeout
+	if probe := function.Readiness; probe != nil && probe.Timeout > 0 {
+		timeout = time.Duration(probe.Timeout) * time.Second
+	}
+	return timeout + function.HealthTimeout
+}
+
+// probeInterval returns how long to wait between the function's readiness probes.
+func probeInterval(function *types.Function) time.Duration {
+	if probe := function.Readiness; probe != nil && len(probe.Exec) > 0 {
+		return execProbeInterval
+	}
+	return 5 * time.Millisecond
+}
+
+// probeReadiness sends the function's configured readiness probe: a GET of its path answering 2xx,
+// a connection to its port or socket, or a command exiting 0 in its container.
+func (r *Runtime) probeReadiness(function *types.Function) (bool, error) {
+	probe := function.Readiness
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	switch {
+	case probe.Path != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.functionURL(function, probe.Path), nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := r.functionClient(function).Do(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return false, fmt.Errorf("%v answered %v", probe.Path, resp.Status)
+		}
+		return true, nil
+	case probe.TCP:
+		var dialer net.Dialer
+		network, address := "tcp", net.JoinHostPort(r.hostIP, strconv.Itoa(function.Port))
+		if function.SocketPath != "" {
+			network, address = "unix", function.SocketPath
+		}
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return false, err
+		}
+		conn.Close()
+		return true, nil
+	default:
+		err := r.execInContainer(ctx, function, probe.Exec)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// waitStarted waits for the functions running when the runtime starts to be ready,
+// reporting each one's readiness.
+func (r *Runtime) waitStarted() {
+	var wg sync.WaitGroup
+	for _, f := range r.functions {
+		if !f.IsRunning {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := r.waitReady(f)
+			if err != nil {
+				log.Printf("Function %v started but isn't ready: %v\n", f.Name, err)
+				return
+			}
+			log.Printf("Function %v ready after %v\n", f.Name, time.Since(start).Round(time.Millisecond))
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,303 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func validateRebuilds(config *types.Config) error {
+	rebuilds := config.Rebuilds
+	if rebuilds == nil {
+		return nil
+	}
+	if rebuilds.Interval == "" && rebuilds.CheckInterval == "" {
+		return fieldError("rebuilds", "rebuilds needs an interval or a check_interval")
+	}
+	if rebuilds.Interval != "" {
+		if _, err := time.ParseDuration(rebuilds.Interval); err != nil {
+			return fieldError("rebuilds.interval", "invalid rebuilds interval: %w", err)
+		}
+	}
+	if rebuilds.CheckInterval != "" {
+		if _, err := time.ParseDuration(rebuilds.CheckInterval); err != nil {
+			return fieldError("rebuilds.check_interval", "invalid rebuilds check_interval: %w", err)
+		}
+		if config.Offline {
+			return fieldError("rebuilds.check_interval", "rebuilds check_interval needs to reach registries, it can't be used offline")
+		}
+	}
+	return nil
+}
+
+// Rebuilder rebuilds functions on a schedule, and as their base images change upstream,
+// so long-running deployments pick up patched bases. Rebuilt functions are rolled out
+// without downtime.
+type Rebuilder struct {
+	config        *types.Config
+	runtime       *Runtime
+	events        *Events
+	compression   string
+	interval      time.Duration // Between rebuilds of every function, never if zero
+	checkInterval time.Duration // Between checks of base images upstream, never if zero
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRebuilder returns the rebuilder of the config's rebuilds, or nil if it has none.
+func NewRebuilder(config *types.Config, runtime *Runtime, events *Events, compression string) *Rebuilder {
+	if config.Rebuilds == nil {
+		return nil
+	}
+	rb := &Rebuilder{
+		config:      config,
+		runtime:     runtime,
+		events:      events,
+		compression: compression,
+	}
+	rb.interval, _ = time.ParseDuration(config.Rebuilds.Interval)
+	rb.checkInterval, _ = time.ParseDuration(config.Rebuilds.CheckInterval)
+	return rb
+}
+
+func (rb *Rebuilder) Start() {
+	if rb == nil {
+		return
+	}
+	rb.stop = make(chan struct{})
+	rb.wg.Add(1)
+	go func() {
+		defer rb.wg.Done()
+		var rebuildC, checkC <-chan time.Time
+		if rb.interval > 0 {
+			ticker := time.NewTicker(rb.interval)
+			defer ticker.Stop()
+			rebuildC = ticker.C
+		}
+		if rb.checkInterval > 0 {
+			ticker := time.NewTicker(rb.checkInterval)
+			defer ticker.Stop()
+			checkC = ticker.C
+		}
+		for {
+			select {
+			case <-rebuildC:
+				for _, f := range rb.runtime.functions {
+					if !f.Remote {
+						rb.rebuild(f, "scheduled rebuild")
+					}
+				}
+			case <-checkC:
+				rb.checkBaseImages()
+			case <-rb.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops rebuilding, waiting for a rebuild in progress.
+func (rb *Rebuilder) Stop() {
+	if rb == nil || rb.stop == nil {
+		return
+	}
+	close(rb.stop)
+	rb.wg.Wait()
+}
+
+// checkBaseImages rebuilds the functions whose base images, or prebuilt images, changed upstream.
+func (rb *Rebuilder) checkBaseImages() {
+	for _, f := range rb.runtime.functions {
+		if f.Remote {
+			continue
+		}
+		images, err := functionBaseImages(f)
+		if err != nil {
+			log.Printf("Cannot read function %v base images: %v\n", f.Name, err)
+			continue
+		}
+		var updated []string
+		for _, img := range images {
+			changed, err := baseImageChanged(img)
+			if err != nil {
+				log.Printf("Cannot check base image %v upstream: %v\n", img, err)
+				continue
+			}
+			if changed {
+				updated = append(updated, img)
+			}
+		}
+		if len(updated) > 0 {
+			rb.rebuild(f, "base image updated: "+strings.Join(updated, ", "))
+		}
+	}
+}
+
+// baseImageChanged reports whether the registry has a newer image under ref than the local one.
+func baseImageChanged(ref string) (bool, error) {
+	dist, err := dockerCli.DistributionInspect(dockerCtx, ref, registryAuth(ref))
+	if err != nil {
+		return false, err
+	}
+	local, err := dockerCli.ImageInspect(dockerCtx, ref)
+	if err != nil {
+		return true, nil // Not pulled yet
+	}
+	digest := "@" + dist.Descriptor.Digest.String()
+	for _, d := range local.RepoDigests {
+		if strings.HasSuffix(d, digest) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rebuild pulls the function's base images, rebuilds it and rolls it out.
+// If any step fails, the function keeps running its current image.
+func (rb *Rebuilder) rebuild(function *types.Function, reason string) {
+	err := rebuildFunction(rb.config, rb.runtime, rb.events, rb.compression, function, reason)
+	if err != nil {
+		log.Printf("Cannot rebuild function %v: %v\n", function.Name, err)
+	}
+}
+
+// rebuildFunction pulls the function's base images, unless offline, rebuilds it and rolls it out,
+// once the function's builds queued before are done. A rebuild requested while another waits joins it.
+// If any step fails, the function keeps running its current image.
+func rebuildFunction(config *types.Config, runtime *Runtime, events *Events, compression string, function *types.Function, reason string) error {
+	return runtime.builds.Build(function.Name, BuildRebuild, false, func(ctx context.Context) error {
+		log.Printf("Rebuilding function %v: %v\n", function.Name, reason)
+		if !config.Offline {
+			images, err := functionBaseImages(function)
+			if err != nil {
+				return fmt.Errorf("cannot read base images: %w", err)
+			}
+			for _, img := range images {
+				err := pullImage(img)
+				if err != nil {
+					return fmt.Errorf("cannot pull base image %v: %w", img, err)
+				}
+			}
+		}
+
+		err := deployFunctionImage(ctx, config, events, runtime.opa, function, compression, true)
+		if err != nil {
+			return err
+		}
+		err = runtime.RollOut(function)
+		if err != nil {
+			runtime.functionStartFailed(function, fmt.Errorf("cannot roll out rebuild: %w", err))
+			return fmt.Errorf("cannot roll out: %w", err)
+		}
+		log.Printf("Rolled out function %v\n", function.Name)
+		events.Publish(Event{
+			Type:     EventRedeployed,
+			Function: function.Name,
+			Data:     map[string]any{"reason": reason},
+		})
+		return nil
+	})
+}
+
+// RollOut replaces the running containers of the function and its instances with new ones
+// of its current image. Each new container serves before the one it replaces is stopped.
+func (r *Runtime) RollOut(function *types.Function) error {
+	if function.Remote {
+		return fmt.Errorf("function %v runs on other cluster nodes", function.Name)
+	}
+	functions := []*types.Function{function}
+	r.mu.Lock()
+	for _, instance := range r.instances {
+		if instance.Base == function.Name {
+			functions = append(functions, instance)
+		}
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, f := range functions {
+		err := r.rollContainer(f)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rollContainer starts a new container for the running function, then stops its current one
+// once the new one is ready. Functions on a Unix socket, a static host port or a debug port
+// can't run two containers at once, so they are restarted instead.
+func (r *Runtime) rollContainer(function *types.Function) error {
+	if !function.IsRunning {
+		return nil // Its next start runs the new image
+	}
+	if function.Socket || function.Tenant == "" && (function.HostPort != 0 || function.DebugPort != 0) {
+		return r.RestartFunction(function)
+	}
+
+	replacement := *function
+	replacement.ContainerId = ""
+	replacement.IsRunning = false
+	replacement.Port = 0
+	err := r.startFunction(&replacement)
+	if err != nil {
+		return err
+	}
+	err = r.waitReplacementReady(&replacement)
+	if err != nil {
+		r.stopFunction(&replacement)
+		return fmt.Errorf("new container of function %v: %w", function.Name, err)
+	}
+
+	r.mu.Lock()
+	if !function.IsRunning {
+		// Stopped meanwhile, e.g. by its policy
+		r.mu.Unlock()
+		return r.stopFunction(&replacement)
+	}
+	old := *function
+	function.ContainerId = replacement.ContainerId
+	function.Port = replacement.Port
+	function.ContainerPort = replacement.ContainerPort
+	function.Health = replacement.Health
+	function.Ready = replacement.Ready
+	function.HealthTimeout = replacement.HealthTimeout
+	r.mu.Unlock()
+
+	log.Printf("Function %v rolled over to container %v\n", function.Name, function.ContainerId)
+	return r.stopFunction(&old)
+}
+
+// waitReplacementReady waits until a new container of a running function accepts connections
+// and is healthy. Its health is inspected, as container events only reach running functions.
+func (r *Runtime) waitReplacementReady(replacement *types.Function) error {
+	timeout := r.functionReadyTimeout(replacement)
+	deadline := time.Now().Add(timeout)
+	for {
+		inspect, err := r.cli.ContainerInspect(context.Background(), replacement.ContainerId)
+		if err == nil && inspect.State != nil && inspect.State.Health != nil {
+			replacement.Health = inspect.State.Health.Status
+		}
+		ready, err := r.probeReady(replacement)
+		if ready {
+			err = healthy(replacement)
+			if err == nil {
+				replacement.Ready = true
+				return nil
+			}
+		} else if err == nil {
+			err = fmt.Errorf("%v is not healthy", replacement.ReadyPath)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not ready after %v: %w", timeout, err)
+		}
+		time.Sleep(max(100*time.Millisecond, probeInterval(replacement)))
+	}
+}
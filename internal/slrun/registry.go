@@ -0,0 +1,313 @@
+package slrun
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-connections/nat"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+const (
+	registryCacheImage     = "registry:2"
+	registryCacheContainer = "slrun-registry-cache"
+)
+
+// When prebuilt function images are pulled
+const (
+	ImagePullMissing = "missing"
+	ImagePullAlways  = "always"
+)
+
+// Credentials images are pulled with, set by UseRegistryAuths
+var registryAuths []*types.RegistryAuth
+
+func validateRegistryAuths(config *types.Config) error {
+	if config.Registry == nil {
+		return nil
+	}
+	for i, auth := range config.Registry.Auths {
+		field := fmt.Sprintf("registry.auths[%d]", i)
+		if auth.Registry == "" || auth.Username == "" {
+			return fieldError(field, "registry auth must have a registry and a username")
+		}
+		if (auth.Password == "") == (auth.PasswordEnv == "") {
+			return fieldError(field, "registry auth for %s must have one of password and password_env", auth.Registry)
+		}
+	}
+	return nil
+}
+
+// UseRegistryAuths pulls images with the registry's credentials, if it has any.
+func UseRegistryAuths(reg *types.Registry) {
+	registryAuths = nil
+	if reg != nil {
+		registryAuths = reg.Auths
+	}
+}
+
+// registryHost returns the host of a registry as image references name it, e.g. docker.io
+// for https://index.docker.io/v1/.
+func registryHost(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server, _, _ = strings.Cut(server, "/")
+	if server == "index.docker.io" || server == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return server
+}
+
+// registryAuth returns the encoded credentials of the registry ref is pulled from: the
+// config's, or else those docker login saved. Returns "" if there are none.
+func registryAuth(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	host := reference.Domain(named)
+
+	i := slices.IndexFunc(registryAuths, func(a *types.RegistryAuth) bool {
+		return registryHost(a.Registry) == host
+	})
+	var auth registry.AuthConfig
+	if i >= 0 {
+		a := registryAuths[i]
+		auth = registry.AuthConfig{
+			Username:      a.Username,
+			Password:      cmp.Or(a.Password, os.Getenv(a.PasswordEnv)),
+			ServerAddress: a.Registry,
+		}
+	} else {
+		var found bool
+		auth, found = dockerConfigAuth(host)
+		if !found {
+			return ""
+		}
+	}
+	encoded, err := registry.EncodeAuthConfig(auth)
+	if err != nil {
+		return ""
+	}
+	return encoded
+}
+
+// dockerConfigAuth returns the credentials of the registry at host saved in Docker's config
+// file by docker login. Credentials kept by credential helpers aren't read.
+func dockerConfigAuth(host string) (registry.AuthConfig, bool) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return registry.AuthConfig{}, false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return registry.AuthConfig{}, false
+	}
+	var file struct {
+		Auths map[string]registry.AuthConfig `json:"auths"`
+	}
+	if json.Unmarshal(data, &file) != nil {
+		return registry.AuthConfig{}, false
+	}
+
+	for server, auth := range file.Auths {
+		if registryHost(server) != host {
+			continue
+		}
+		if auth.Auth != "" {
+			// user:password, base64 encoded
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				continue
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+			auth.Auth = ""
+		}
+		auth.ServerAddress = server
+		return auth, true
+	}
+	return registry.AuthConfig{}, false
+}
+
+// baseImages returns the images the Dockerfile at path builds from,
+// leaving out scratch, earlier build stages and images named by build args.
+func baseImages(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var images []string
+	stages := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		// FROM [--platform=...] image [AS name]
+		args := fields[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+
+		img := args[0]
+		if img == "scratch" || stages[strings.ToLower(img)] || strings.Contains(img, "$") {
+			continue
+		}
+		images = append(images, img)
+	}
+	return images, scanner.Err()
+}
+
+// pullImage pulls ref with its registry's credentials, returning the error reported in
+// the pull progress, if any.
+func pullImage(ref string) error {
+	out, err := dockerCli.ImagePull(dockerCtx, ref, image.PullOptions{RegistryAuth: registryAuth(ref)})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return jsonmessage.DisplayJSONMessagesStream(out, io.Discard, 0, false, nil)
+}
+
+// imageExists reports whether ref is available locally.
+func imageExists(ref string) bool {
+	_, err := dockerCli.ImageInspect(dockerCtx, ref)
+	return err == nil
+}
+
+// pullThroughMirrors pulls the Docker Hub image ref through the first mirror that has it,
+// tagging it as ref so builds use it. Returns false if no mirror had it.
+func pullThroughMirrors(ref string, mirrors []string) bool {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil || reference.Domain(named) != "docker.io" {
+		return false // Mirrors only mirror Docker Hub
+	}
+	named = reference.TagNameOnly(named)
+	suffix := strings.TrimPrefix(reference.FamiliarString(named), reference.FamiliarName(named))
+	path := reference.Path(named) + suffix // e.g. library/python:3.11-slim
+
+	for _, mirror := range mirrors {
+		mirrorRef := mirror + "/" + path
+		err := pullImage(mirrorRef)
+		if err != nil {
+			log.Printf("Cannot pull %v from mirror %v: %v\n", ref, mirror, err)
+			continue
+		}
+
+		err = dockerCli.ImageTag(dockerCtx, mirrorRef, named.String())
+		if err != nil {
+			log.Printf("Cannot tag %v as %v: %v\n", mirrorRef, ref, err)
+			continue
+		}
+		// Keep only the original name
+		dockerCli.ImageRemove(dockerCtx, mirrorRef, image.RemoveOptions{})
+		fmt.Printf("Pulled %v from mirror %v\n", ref, mirror)
+		return true
+	}
+	return false
+}
+
+// startRegistryCache starts the pull-through cache container, if not already running,
+// and returns its address as a mirror.
+func startRegistryCache(cache *types.RegistryCache) (string, error) {
+	mirror := "127.0.0.1:" + strconv.Itoa(cache.Port)
+
+	existing, err := dockerCli.ContainerInspect(dockerCtx, registryCacheContainer)
+	if err == nil {
+		if existing.State.Running {
+			return mirror, nil
+		}
+		return mirror, dockerCli.ContainerStart(dockerCtx, existing.ID, container.StartOptions{})
+	}
+
+	if !imageExists(registryCacheImage) {
+		err := pullImage(registryCacheImage)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	dir, err := filepath.Abs(cache.Dir)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	port := nat.Port("5000/tcp")
+	resp, err := dockerCli.ContainerCreate(dockerCtx, &container.Config{
+		Image:        registryCacheImage,
+		Env:          []string{"REGISTRY_PROXY_REMOTEURL=https://registry-1.docker.io"},
+		ExposedPorts: nat.PortSet{port: struct{}{}},
+	}, &container.HostConfig{
+		// Kept across slrun restarts so the cache stays warm
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+		Binds:         []string{dir + ":/var/lib/registry"},
+		PortBindings: nat.PortMap{
+			port: []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: strconv.Itoa(cache.Port)}},
+		},
+	}, nil, nil, registryCacheContainer)
+	if err != nil {
+		return "", err
+	}
+	err = dockerCli.ContainerStart(dockerCtx, resp.ID, container.StartOptions{})
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Registry cache listening on %v\n", mirror)
+	return mirror, nil
+}
+
+// pullBaseImages pulls base images of functions missing locally through the registry's
+// cache and mirrors. Images no mirror has are left to the build to pull from Docker Hub.
+func pullBaseImages(registry *types.Registry, functions []*types.Function) error {
+	mirrors := registry.Mirrors
+	if registry.Cache != nil {
+		cache, err := startRegistryCache(registry.Cache)
+		if err != nil {
+			return fmt.Errorf("cannot start registry cache: %w", err)
+		}
+		mirrors = append([]string{cache}, mirrors...)
+	}
+
+	images, err := RequiredImages(functions)
+	if err != nil {
+		return err
+	}
+	for img := range images {
+		if imageExists(img) {
+			continue
+		}
+		pullThroughMirrors(img, mirrors)
+	}
+	return nil
+}
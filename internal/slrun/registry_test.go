@@ -0,0 +1,52 @@
+package slrun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("GHCR_TOKEN", "ci-token")
+	// docker login ghcr.io and docker.io as saved, base64 of user:password
+	config := `{"auths": {"ghcr.io": {"auth": "bWU6bG9naW4="}, "https://index.docker.io/v1/": {"auth": "aHViOnNlY3JldA=="}}}`
+	err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseRegistryAuths(&types.Registry{Auths: []*types.RegistryAuth{
+		{Registry: "ghcr.io", Username: "ci", PasswordEnv: "GHCR_TOKEN"},
+	}})
+	defer UseRegistryAuths(nil)
+
+	tests := []struct {
+		ref      string
+		username string
+		password string
+	}{
+		{"ghcr.io/me/fn:v1", "ci", "ci-token"}, // The config's before docker login's
+		{"python:3.11-slim", "hub", "secret"},
+		{"quay.io/me/fn", "", ""},
+	}
+	for _, tt := range tests {
+		encoded := registryAuth(tt.ref)
+		if tt.username == "" {
+			if encoded != "" {
+				t.Errorf("registryAuth(%q) = %q, want none", tt.ref, encoded)
+			}
+			continue
+		}
+		auth, err := registry.DecodeAuthConfig(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth.Username != tt.username || auth.Password != tt.password {
+			t.Errorf("registryAuth(%q) = %v:%v, want %v:%v", tt.ref, auth.Username, auth.Password, tt.username, tt.password)
+		}
+	}
+}
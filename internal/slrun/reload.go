@@ -0,0 +1,462 @@
+package slrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Changes within this long of each other are applied together, as editors and
+// checkouts write several files, or the same file several times, at once
+const reloadDebounce = 500 * time.Millisecond
+
+// Reloader watches the config file and the functions' build dirs while slrun runs.
+// Functions whose sources change are rebuilt and redeployed, and changes to the functions
+// in the config file are applied to them. Other config changes need a restart.
+type Reloader struct {
+	cfgFile     string // Absolute
+	config      *types.Config
+	runtime     *Runtime
+	events      *Events
+	compression string
+	watcher     *fsnotify.Watcher
+	buildDirs   map[string]string // Absolute build dir of each built function, by name
+
+	mu        sync.Mutex
+	pending   map[string]*time.Timer // Reloads waiting for changes to settle, by function name, "" for the config
+	functions map[string][]byte      // Functions in the config file as last read, by name, as JSON
+	settings  []byte                 // Config file without its functions as last read, as JSON
+	stopped   bool
+
+	reloading sync.RWMutex // Held while reloading the config, read held while rebuilding functions
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewReloader(config *types.Config, runtime *Runtime, events *Events, compression string) *Reloader {
+	return &Reloader{
+		config:      config,
+		runtime:     runtime,
+		events:      events,
+		compression: compression,
+		buildDirs:   make(map[string]string),
+		pending:     make(map[string]*time.Timer),
+	}
+}
+
+// Start watches the config file and the build dirs of the functions built here.
+func (rl *Reloader) Start() error {
+	cfgFile, err := filepath.Abs(rl.config.ConfigFile)
+	if err != nil {
+		return err
+	}
+	rl.cfgFile = cfgFile
+
+	// Compared against, as the config in use has command line flags and runtime state applied
+	config, err := ReadConfigFile(rl.cfgFile)
+	if err != nil {
+		return err
+	}
+	rl.functions, rl.settings = configSnapshot(config)
+
+	rl.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Editors often replace the file rather than write it, so its dir is watched
+	err = rl.watcher.Add(filepath.Dir(rl.cfgFile))
+	if err != nil {
+		rl.watcher.Close()
+		return err
+	}
+	for _, f := range rl.config.Functions {
+		if f.Image != "" || sourceDir(f) == "" || f.Remote {
+			continue
+		}
+		dir, err := filepath.Abs(sourceDir(f))
+		if err != nil {
+			rl.watcher.Close()
+			return err
+		}
+		rl.buildDirs[f.Name] = dir
+		err = rl.watchDir(dir)
+		if err != nil {
+			rl.watcher.Close()
+			return fmt.Errorf("cannot watch function %v sources: %w", f.Name, err)
+		}
+	}
+	log.Printf("Watching %v and %v function source dirs for changes\n", rl.cfgFile, len(rl.buildDirs))
+
+	rl.stop = make(chan struct{})
+	rl.wg.Add(1)
+	go func() {
+		defer rl.wg.Done()
+		for {
+			select {
+			case event := <-rl.watcher.Events:
+				rl.changed(event)
+			case err := <-rl.watcher.Errors:
+				log.Printf("Cannot watch for changes: %v\n", err)
+			case <-rl.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// watchDir watches dir and the dirs under it, but hidden ones such as .git.
+func (rl *Reloader) watchDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return rl.watcher.Add(path)
+	})
+}
+
+// changed schedules a reload of what a changed file belongs to.
+func (rl *Reloader) changed(event fsnotify.Event) {
+	if event.Has(fsnotify.Chmod) {
+		return
+	}
+	if event.Name == rl.cfgFile {
+		rl.schedule("")
+		return
+	}
+	for name, dir := range rl.buildDirs {
+		rel, err := filepath.Rel(dir, event.Name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if strings.HasPrefix(rel, ".") {
+			continue // Hidden, e.g. editor swap files
+		}
+		if event.Has(fsnotify.Create) {
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				err = rl.watchDir(event.Name)
+				if err != nil {
+					log.Printf("Cannot watch %v: %v\n", event.Name, err)
+				}
+			}
+		}
+		rl.schedule(name)
+	}
+}
+
+// schedule reloads the function, or the config if function is empty, once changes to it settle.
+func (rl *Reloader) schedule(function string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.stopped {
+		return
+	}
+	if timer, exists := rl.pending[function]; exists {
+		timer.Reset(reloadDebounce)
+		return
+	}
+	rl.pending[function] = time.AfterFunc(reloadDebounce, func() {
+		rl.mu.Lock()
+		delete(rl.pending, function)
+		stopped := rl.stopped
+		rl.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		if function == "" {
+			rl.reloading.Lock()
+			defer rl.reloading.Unlock()
+			rl.reloadConfig()
+			return
+		}
+		// Rebuilds overlap, so sources changing again supersede the function's rebuild in progress
+		rl.reloading.RLock()
+		defer rl.reloading.RUnlock()
+		rl.rebuild(function)
+	})
+}
+
+// rebuild rebuilds the function's image from its changed sources and redeploys it, cancelling
+// a rebuild from sources changed before. If the build or its tests fail, the function keeps
+// running its previous image.
+func (rl *Reloader) rebuild(name string) {
+	function := rl.runtime.FunctionByName(name)
+	if function == nil {
+		return
+	}
+	err := rl.runtime.builds.Build(name, BuildSources, true, func(ctx context.Context) error {
+		log.Printf("Function %v sources changed, rebuilding\n", name)
+		err := deployFunctionImage(ctx, rl.config, rl.events, rl.runtime.opa, function, rl.compression, false)
+		if err != nil {
+			return err
+		}
+		metadata, err := ReadFunctionMetadata(function)
+		if err != nil {
+			log.Printf("Cannot read function %v metadata: %v\n", name, err)
+		} else {
+			function.Metadata = metadata
+		}
+		rl.redeploy(function, nil, "sources changed")
+		return nil
+	})
+	if errors.Is(err, ErrBuildCancelled) {
+		log.Printf("Rebuild of function %v superseded\n", name)
+	} else if err != nil {
+		log.Printf("Cannot rebuild function %v, it keeps its previous image: %v\n", name, err)
+	}
+}
+
+// reloadConfig applies changes to the functions in the config file. An invalid config is
+// reported and ignored, and functions added or removed, or other settings changed,
+// need a restart to take effect.
+func (rl *Reloader) reloadConfig() {
+	config, err := ReadConfigFile(rl.cfgFile)
+	if err != nil {
+		log.Printf("Cannot reload config, keeping the running one: %v\n", err)
+		return
+	}
+	functions, settings := configSnapshot(config)
+	if string(settings) != string(rl.settings) {
+		log.Printf("Config settings other than functions changed, restart slrun to apply them\n")
+	}
+
+	for _, updated := range config.Functions {
+		previous, exists := rl.functions[updated.Name]
+		if !exists {
+			log.Printf("Function %v added to config, restart slrun to deploy it\n", updated.Name)
+			continue
+		}
+		if string(previous) == string(functions[updated.Name]) {
+			continue
+		}
+		function := rl.runtime.FunctionByName(updated.Name)
+		if function == nil {
+			continue
+		}
+		rl.reconfigure(function, updated)
+	}
+	for name := range rl.functions {
+		if _, exists := functions[name]; !exists {
+			log.Printf("Function %v removed from config, restart slrun to remove it\n", name)
+		}
+	}
+	rl.functions, rl.settings = functions, settings
+}
+
+// reconfigure redeploys the function with its changed config, rebuilding it first
+// if what it is built from changed.
+func (rl *Reloader) reconfigure(function *types.Function, updated *types.Function) {
+	log.Printf("Function %v config changed\n", function.Name)
+	if function.Remote {
+		applyFunctionConfig(function, updated)
+		return
+	}
+	err := rl.runtime.builds.Build(function.Name, BuildConfig, true, func(ctx context.Context) error {
+		dockerfileChanged := updated.Dockerfile != function.Dockerfile || !maps.Equal(updated.BuildArgs, function.BuildArgs) || updated.Target != function.Target
+		processChanged := (updated.Process == nil) != (function.Process == nil)
+		if dockerfileChanged || processChanged || updated.BuildDir != function.BuildDir || updated.Image != function.Image || updated.TestCommand != function.TestCommand {
+			if updated.BuildDir != function.BuildDir && updated.Image == "" {
+				log.Printf("Function %v build_dir changed, restart slrun to watch the new one\n", function.Name)
+			}
+			// Built as updated, without touching the function until the build succeeds
+			candidate := *updated
+			err := deployFunctionImage(ctx, rl.config, rl.events, rl.runtime.opa, &candidate, rl.compression, false)
+			if err != nil {
+				return err
+			}
+			updated.ImageName = candidate.ImageName
+		}
+		rl.redeploy(function, updated, "config changed")
+		return nil
+	})
+	if err != nil {
+		log.Printf("Cannot rebuild function %v, it keeps its previous config: %v\n", function.Name, err)
+	}
+}
+
+// redeploy replaces the function's containers, and those of its instances, with new ones
+// running its current image and the config of updated, if not nil.
+func (rl *Reloader) redeploy(function *types.Function, updated *types.Function, reason string) {
+	err := rl.runtime.redeployFunction(function, updated)
+	if err != nil {
+		rl.runtime.functionStartFailed(function, fmt.Errorf("cannot redeploy: %w", err))
+		log.Printf("Cannot redeploy function %v: %v\n", function.Name, err)
+		return
+	}
+	log.Printf("Redeployed function %v, %v\n", function.Name, reason)
+	rl.events.Publish(Event{
+		Type:     EventRedeployed,
+		Function: function.Name,
+		Data:     map[string]any{"reason": reason},
+	})
+}
+
+// Stop stops watching, waiting for a reload in progress.
+func (rl *Reloader) Stop() {
+	if rl.stop == nil {
+		return
+	}
+	rl.mu.Lock()
+	rl.stopped = true
+	for _, timer := range rl.pending {
+		timer.Stop()
+	}
+	rl.mu.Unlock()
+
+	close(rl.stop)
+	rl.wg.Wait()
+	rl.watcher.Close()
+	rl.reloading.Lock()
+	rl.reloading.Unlock()
+}
+
+// configSnapshot returns each function of config as JSON by name, and the rest of config as JSON,
+// to find what changed between reads of the config file.
+func configSnapshot(config *types.Config) (map[string][]byte, []byte) {
+	functions := make(map[string][]byte)
+	for _, f := range config.Functions {
+		functions[f.Name], _ = json.Marshal(f)
+	}
+	settings := *config
+	settings.Functions = nil
+	bytes, _ := json.Marshal(settings)
+	return functions, bytes
+}
+
+// applyFunctionConfig replaces the function's config with updated's, keeping its runtime state,
+// the fields not read from the config file, but its metadata and image.
+func applyFunctionConfig(function *types.Function, updated *types.Function) {
+	state := *function
+	*function = *updated
+	old := reflect.ValueOf(&state).Elem()
+	current := reflect.ValueOf(function).Elem()
+	for i, field := range reflect.VisibleFields(current.Type()) {
+		if field.Tag.Get("json") != "-" || field.Name == "Metadata" {
+			continue
+		}
+		if field.Name == "ImageName" && updated.ImageName != "" {
+			continue
+		}
+		current.Field(i).Set(old.Field(i))
+	}
+}
+
+// redeployFunction replaces the function's containers, and those of its instances, with new ones,
+// applying the config of updated first if not nil. Each new container serves before the one it
+// replaces stops, so requests aren't turned away meanwhile. Instances the new config no longer
+// has are removed. A disabled function is only reconfigured.
+func (r *Runtime) redeployFunction(function *types.Function, updated *types.Function) error {
+	enabled := function.IsEnabled
+	if updated != nil && updated.Disabled != function.Disabled {
+		enabled = !updated.Disabled && r.quarantine.Quarantined(function.Name) == nil
+	}
+	if !enabled || !function.IsEnabled {
+		// Disabled before or after, nothing to keep serving
+		err := r.SetFunctionEnabled(function, false)
+		if err != nil {
+			return err
+		}
+		if updated != nil {
+			err = r.reconfigureFunction(function, updated)
+			if err != nil {
+				return err
+			}
+		}
+		if !enabled {
+			return nil
+		}
+		return r.SetFunctionEnabled(function, true)
+	}
+
+	if updated != nil {
+		err := r.reconfigureFunction(function, updated)
+		if err != nil {
+			return err
+		}
+	}
+	return r.RollOut(function)
+}
+
+// reconfigureFunction applies the config of updated to the function and its instances,
+// removing the instances it no longer has.
+func (r *Runtime) reconfigureFunction(function *types.Function, updated *types.Function) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	applyFunctionConfig(function, updated)
+	if function.Replicas == nil {
+		delete(r.replicas, function.Name)
+	} else if set, exists := r.replicas[function.Name]; !exists {
+		r.replicas[function.Name] = &replicaSet{replicas: []*types.Function{function}, inflight: make(map[*types.Function]int)}
+	} else if len(set.replicas) > function.Replicas.Max {
+		set.replicas = set.replicas[:function.Replicas.Max]
+	}
+
+	var errs []error
+	for name, instance := range r.instances {
+		if instance.Base != function.Name {
+			continue
+		}
+		if reconfigureInstance(function, instance) {
+			continue
+		}
+		err := r.policy.RemoveFunction(instance)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		delete(r.instances, name)
+		log.Printf("Removed function %v instance %v, no longer in its config\n", function.Name, name)
+	}
+	return errors.Join(errs...)
+}
+
+// reconfigureInstance applies the function's config to one of its tenant or replica instances,
+// keeping what sets the instance apart. Returns false if the function no longer has the instance.
+func reconfigureInstance(function *types.Function, instance *types.Function) bool {
+	name := instance.Name
+	var env map[string]string
+	if instance.Tenant != "" {
+		if function.Tenancy == nil || function.Tenancy.Tenants[instance.Tenant] == nil {
+			return false
+		}
+		env = tenantEnv(function, instance.Tenant)
+	} else {
+		_, suffix, _ := strings.Cut(name, "#")
+		index, err := strconv.Atoi(suffix)
+		if err != nil || function.Replicas == nil || index > function.Replicas.Max {
+			return false
+		}
+		env = replicaEnv(function, index)
+	}
+
+	config := *function
+	applyFunctionConfig(instance, &config)
+	instance.Name = name
+	instance.Env = env
+	if instance.Tenant != "" {
+		instance.Tenancy = nil
+	} else {
+		instance.HostPort = 0
+	}
+	return true
+}
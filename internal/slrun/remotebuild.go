@@ -0,0 +1,198 @@
+package slrun
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// How long reaching a remote builder may take before building locally, with fallback
+const remoteBuildPingTimeout = 5 * time.Second
+
+// Builds function images elsewhere than the local daemon, set by UseRemoteBuild. nil builds locally.
+var remoteBuilder *RemoteBuilder
+
+// RemoteBuilder builds function images on a remote Docker daemon or a buildx builder,
+// loading them into the local daemon.
+type RemoteBuilder struct {
+	cli      *client.Client // The remote daemon, nil for a buildx builder
+	builder  string         // The buildx builder
+	fallback bool
+}
+
+func validateRemoteBuild(config *types.Config) error {
+	remote := config.RemoteBuild
+	if remote == nil {
+		return nil
+	}
+	if (remote.DockerHost == "") == (remote.Builder == "") {
+		return fieldError("remote_build", "remote build needs one of docker_host or builder")
+	}
+	if remote.DockerHost != "" {
+		u, err := client.ParseHostURL(remote.DockerHost)
+		if err != nil {
+			return fieldError("remote_build.docker_host", "invalid remote build docker host: %w", err)
+		}
+		if u.Scheme == "ssh" {
+			return fieldError("remote_build.docker_host", "remote build docker host can't be reached over ssh, use a buildx builder created with docker buildx create %s", remote.DockerHost)
+		}
+	}
+	if remote.Builder != "" && config.Builder == BuilderClassic {
+		return fieldError("remote_build.builder", "buildx builder %s builds with BuildKit, not the classic builder", remote.Builder)
+	}
+	if config.Offline {
+		return fieldError("remote_build", "remote builds can't be offline")
+	}
+	return nil
+}
+
+// UseRemoteBuild builds function images as configured, on the local daemon if remote is nil.
+func UseRemoteBuild(remote *types.RemoteBuild) error {
+	if remote == nil {
+		remoteBuilder = nil
+		return nil
+	}
+	b := &RemoteBuilder{builder: remote.Builder, fallback: remote.Fallback}
+	if remote.DockerHost != "" {
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithHost(remote.DockerHost), client.WithAPIVersionNegotiation())
+		if err != nil {
+			return fmt.Errorf("cannot connect to remote build daemon: %w", err)
+		}
+		b.cli = cli
+	}
+	remoteBuilder = b
+	return nil
+}
+
+// buildClient returns the client of the daemon building function images, the local one
+// unless they are built on a remote daemon.
+func buildClient() EngineClient {
+	if remoteBuilder != nil && remoteBuilder.cli != nil {
+		return remoteBuilder.cli
+	}
+	return dockerCli
+}
+
+// ping checks the remote builder can be reached.
+func (b *RemoteBuilder) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, remoteBuildPingTimeout)
+	defer cancel()
+	if b.cli != nil {
+		_, err := b.cli.Ping(ctx)
+		return err
+	}
+	out, err := exec.CommandContext(ctx, "docker", "buildx", "inspect", "--bootstrap", b.builder).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// build builds the function's image from buildCtx with options, then loads it into the local daemon.
+// The build's output is shown as it runs, or only if it fails if quiet.
+func (b *RemoteBuilder) build(ctx context.Context, function *types.Function, buildCtx io.Reader, options build.ImageBuildOptions, quiet bool) error {
+	if b.cli == nil {
+		return b.buildx(ctx, function, buildCtx, options, quiet)
+	}
+
+	buildResp, err := b.cli.ImageBuild(ctx, buildCtx, options)
+	if err != nil {
+		return err
+	}
+	defer buildResp.Body.Close()
+	err = showBuildOutput(buildResp.Body, buildOutput(function), quiet, options.Version)
+	if err != nil {
+		return fmt.Errorf("cannot build function %v image: %w", function.Name, err)
+	}
+
+	for _, tag := range options.Tags {
+		err := b.load(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("cannot load function %v image from the remote daemon: %w", function.Name, err)
+		}
+	}
+	return nil
+}
+
+// load copies the image from the remote daemon to the local one, then removes it from the
+// remote daemon, whose build cache keeps its layers for the next builds.
+func (b *RemoteBuilder) load(ctx context.Context, ref string) error {
+	saved, err := b.cli.ImageSave(ctx, []string{ref})
+	if err != nil {
+		return err
+	}
+	defer saved.Close()
+	resp, err := dockerCli.ImageLoad(ctx, saved)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.JSON {
+		err = jsonmessage.DisplayJSONMessagesStream(resp.Body, io.Discard, 0, false, nil)
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = b.cli.ImageRemove(ctx, ref, image.RemoveOptions{})
+	if err != nil {
+		log.Printf("Cannot remove image %v from the remote build daemon: %v\n", ref, err)
+	}
+	return nil
+}
+
+// buildx builds the image with the buildx builder from the tar build context, loading it into
+// the local daemon as docker buildx build --load does.
+func (b *RemoteBuilder) buildx(ctx context.Context, function *types.Function, tarCtx io.Reader, options build.ImageBuildOptions, quiet bool) error {
+	out := buildOutput(function)
+	defer out.Flush()
+	var buffered bytes.Buffer
+	var w io.Writer = out
+	if quiet {
+		w = &buffered
+	}
+	cmd := exec.CommandContext(ctx, "docker", buildxArgs(b.builder, options)...)
+	cmd.Stdin = tarCtx
+	cmd.Stdout, cmd.Stderr = w, w
+	err := cmd.Run()
+	if err != nil {
+		if quiet {
+			out.Write(buffered.Bytes())
+		}
+		return fmt.Errorf("cannot build function %v image with buildx builder %v: %w", function.Name, b.builder, err)
+	}
+	return nil
+}
+
+// buildxArgs returns the docker arguments building with options on the buildx builder,
+// from a tar build context read from stdin. With a SOURCE_DATE_EPOCH build arg, the
+// times of files in the image's layers are set to it too.
+func buildxArgs(builder string, options build.ImageBuildOptions) []string {
+	output := "--load"
+	if options.BuildArgs["SOURCE_DATE_EPOCH"] != nil {
+		output = "--output=type=docker,rewrite-timestamp=true"
+	}
+	args := []string{"buildx", "build", "--builder", builder, output, "--progress", "plain", "--file", options.Dockerfile}
+	for _, tag := range options.Tags {
+		args = append(args, "--tag", tag)
+	}
+	if options.Target != "" {
+		args = append(args, "--target", options.Target)
+	}
+	for _, name := range sortedKeys(options.BuildArgs) {
+		args = append(args, "--build-arg", name+"="+*options.BuildArgs[name])
+	}
+	return append(args, "-")
+}
@@ -0,0 +1,52 @@
+package slrun
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestBuildxArgs(t *testing.T) {
+	function := &types.Function{Name: "func1", Dockerfile: "Dockerfile", BuildArgs: map[string]string{"B": "2", "A": "1"}}
+	options := build.ImageBuildOptions{
+		Tags:       []string{"slrun-func1:candidate"},
+		Dockerfile: "docker/Dockerfile",
+		BuildArgs:  buildArgs(function),
+		Target:     "prod",
+	}
+	got := buildxArgs("farm", options)
+	want := []string{
+		"buildx", "build", "--builder", "farm", "--load", "--progress", "plain", "--file", "docker/Dockerfile",
+		"--tag", "slrun-func1:candidate", "--target", "prod", "--build-arg", "A=1", "--build-arg", "B=2", "-",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("buildxArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateRemoteBuild(t *testing.T) {
+	tests := []struct {
+		remote  *types.RemoteBuild
+		offline bool
+		err     string
+	}{
+		{remote: &types.RemoteBuild{DockerHost: "tcp://farm:2376"}},
+		{remote: &types.RemoteBuild{Builder: "farm", Fallback: true}},
+		{remote: &types.RemoteBuild{}, err: "one of docker_host or builder"},
+		{remote: &types.RemoteBuild{DockerHost: "tcp://farm:2376", Builder: "farm"}, err: "one of docker_host or builder"},
+		{remote: &types.RemoteBuild{DockerHost: "ssh://me@farm"}, err: "over ssh"},
+		{remote: &types.RemoteBuild{Builder: "farm"}, offline: true, err: "offline"},
+	}
+	for _, test := range tests {
+		err := validateRemoteBuild(&types.Config{RemoteBuild: test.remote, Offline: test.offline, Builder: BuilderBuildKit})
+		if test.err == "" && err != nil {
+			t.Errorf("validateRemoteBuild(%+v) = %v, want no error", test.remote, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("validateRemoteBuild(%+v) = %v, want an error containing %q", test.remote, err, test.err)
+		}
+	}
+}
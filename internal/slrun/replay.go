@@ -0,0 +1,226 @@
+package slrun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Request headers not replayed, set anew by the client and gateway
+var unreplayedHeaders = []string{"Host", "Content-Length", requestIDHeader, "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// A line of the Common or Combined Log Format, e.g.
+// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /func1/users HTTP/1.1" 200 2326
+var accessLogLine = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)(?: \S+)?" (\d{3}) `)
+
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// ReplayRequest is a recorded request to a function.
+type ReplayRequest struct {
+	Time   time.Time
+	Method string
+	Path   string // Relative to the function, with the query
+	Header http.Header
+	Body   []byte
+	Status int // Recorded response status
+}
+
+// ReplayResult is the outcome of replaying a request.
+type ReplayResult struct {
+	Request *ReplayRequest
+	Status  int
+	Elapsed time.Duration
+	Err     error
+}
+
+// Regressed reports whether the replayed request failed where the recorded one didn't,
+// or failed with a worse class of status, e.g. a 500 for a recorded 404.
+func (r *ReplayResult) Regressed() bool {
+	if r.Err != nil {
+		return true
+	}
+	return r.Status >= 400 && r.Status/100 > r.Request.Status/100
+}
+
+// ReadReplayFile reads the requests recorded in a HAR capture, or in a Common or
+// Combined Log Format access log, in the order they were made. Paths are made
+// relative to the function they were routed to as the config's gateway would.
+func ReadReplayFile(config *types.Config, path string) ([]*ReplayRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []*ReplayRequest
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		requests, err = parseHAR(config, data)
+	} else {
+		requests, err = parseAccessLog(config, bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("%v: no requests recorded", path)
+	}
+	return requests, nil
+}
+
+func parseHAR(config *types.Config, data []byte) ([]*ReplayRequest, error) {
+	var h har
+	err := json.Unmarshal(data, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []*ReplayRequest{}
+	for i, entry := range h.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %v: %w", i, err)
+		}
+		req := &ReplayRequest{
+			Time:   entry.StartedDateTime,
+			Method: entry.Request.Method,
+			Path:   replayPath(config, u),
+			Header: http.Header{},
+			Status: entry.Response.Status,
+		}
+		for _, h := range entry.Request.Headers {
+			if h.Value != redactedValue && !slices.Contains(unreplayedHeaders, http.CanonicalHeaderKey(h.Name)) {
+				req.Header.Add(h.Name, h.Value)
+			}
+		}
+		if entry.Request.PostData != nil {
+			req.Body = []byte(entry.Request.PostData.Text)
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+func parseAccessLog(config *types.Config, r io.Reader) ([]*ReplayRequest, error) {
+	requests := []*ReplayRequest{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		m := accessLogLine.FindStringSubmatch(text + " ")
+		if m == nil {
+			return nil, fmt.Errorf("line %v: not in Common Log Format", line)
+		}
+		t, err := time.Parse(accessLogTime, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", line, err)
+		}
+		u, err := url.ParseRequestURI(m[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", line, err)
+		}
+		status, _ := strconv.Atoi(m[4])
+		requests = append(requests, &ReplayRequest{
+			Time:   t,
+			Method: m[2],
+			Path:   replayPath(config, u),
+			Header: http.Header{},
+			Status: status,
+		})
+	}
+	return requests, scanner.Err()
+}
+
+// replayPath returns the path of u relative to the function the gateway routed it to.
+func replayPath(config *types.Config, u *url.URL) string {
+	path := u.EscapedPath()
+	if _, byHost := hostFunction(config.DNS, u.Hostname()); !byHost {
+		parts := strings.SplitN(path, "/", 4)
+		switch {
+		case len(parts) > 3 && parts[1] == functionsPrefix:
+			path = "/" + parts[3]
+		case len(parts) > 2 && parts[1] != functionsPrefix:
+			path = "/" + strings.Join(parts[2:], "/")
+		default:
+			path = ""
+		}
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// SendReplay sends a recorded request to the function at functionURL.
+func SendReplay(ctx context.Context, client *http.Client, functionURL string, req *ReplayRequest) (*http.Response, error) {
+	r, err := http.NewRequestWithContext(ctx, req.Method, functionURL+req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = req.Header.Clone()
+	return client.Do(r)
+}
+
+// Replay sends recorded requests to the function at functionURL, keeping the time
+// between them divided by speed, or as fast as it can if speed is 0. Requests are
+// sent when due, without waiting for earlier ones to complete, and report is called
+// with each result as it completes.
+func Replay(ctx context.Context, requests []*ReplayRequest, functionURL string, speed float64, report func(*ReplayResult)) {
+	client := &http.Client{
+		Timeout: time.Minute,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, req := range requests {
+		if speed > 0 {
+			due := time.Duration(float64(req.Time.Sub(requests[0].Time)) / speed)
+			select {
+			case <-time.After(time.Until(start.Add(due))):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reqStart := time.Now()
+			result := &ReplayResult{Request: req}
+			resp, err := SendReplay(ctx, client, functionURL, req)
+			if err != nil {
+				result.Err = err
+			} else {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				result.Status = resp.StatusCode
+			}
+			result.Elapsed = time.Since(reqStart)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report(result)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,82 @@
+package slrun
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestReplayPath(t *testing.T) {
+	config := &types.Config{DNS: &types.DNS{Domain: "slrun.local"}}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://localhost:1337/func1", ""},
+		{"http://localhost:1337/func1/users/7?full=1", "/users/7?full=1"},
+		{"http://localhost:1337/functions/func1/users/7", "/users/7"},
+		{"http://func1.slrun.local/users/7", "/users/7"},
+		{"/func1/a%2Fb", "/a%2Fb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := replayPath(config, u); got != tt.want {
+				t.Errorf("replayPath(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAccessLog(t *testing.T) {
+	log := `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /func1/users HTTP/1.1" 200 2326
+10.0.0.2 - ada [10/Oct/2000:13:55:38 -0700] "POST /func1/users?x=1 HTTP/1.1" 201 - "-" "curl/8.0"
+`
+	requests, err := parseAccessLog(&types.Config{}, strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("parseAccessLog() = %v requests, want 2", len(requests))
+	}
+	second := requests[1]
+	if second.Method != "POST" || second.Path != "/users?x=1" || second.Status != 201 {
+		t.Errorf("parseAccessLog() second request = %v %v %v", second.Method, second.Path, second.Status)
+	}
+	if gap := second.Time.Sub(requests[0].Time).Seconds(); gap != 2 {
+		t.Errorf("parseAccessLog() requests %vs apart, want 2s", gap)
+	}
+
+	_, err = parseAccessLog(&types.Config{}, strings.NewReader("not a log line\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("parseAccessLog() error = %v, want one naming line 1", err)
+	}
+}
+
+func TestReplayRegressed(t *testing.T) {
+	tests := []struct {
+		recorded, got int
+		want          bool
+	}{
+		{200, 200, false},
+		{200, 201, false},
+		{200, 404, true},
+		{404, 404, false},
+		{404, 500, true},
+		{500, 200, false},
+	}
+
+	for _, tt := range tests {
+		r := &ReplayResult{Request: &ReplayRequest{Status: tt.recorded}, Status: tt.got}
+		if got := r.Regressed(); got != tt.want {
+			t.Errorf("Regressed() for %v -> %v = %v, want %v", tt.recorded, tt.got, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package slrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Modification time of build context files and SOURCE_DATE_EPOCH of reproducible builds:
+// 1980-01-01, as zip based formats such as wheels and jars can't hold earlier times
+const reproducibleEpoch = 315532800
+
+// File in a function's build dir pinning its base images in reproducible builds
+const lockFileName = "slrun.lock"
+
+// Images recorded per function, the oldest are forgotten
+const maxSourceImages = 50
+
+// Guards the source images file
+var sourceImagesMu sync.Mutex
+
+// lockFile pins a function's base images by digest.
+type lockFile struct {
+	BaseImages map[string]string `json:"base_images"` // Digest of each base image by ref, as in its Dockerfile
+}
+
+// sourceImage is an image a reproducible build built from a build context.
+type sourceImage struct {
+	Source string    `json:"source"` // Digest of the build context, see contextDigest
+	Image  string    `json:"image"`  // ID of the image built
+	Built  time.Time `json:"built"`
+}
+
+// pinBaseImages returns the digests the function's lock file pins its base images to,
+// pinning those it doesn't pin yet to their current digest, unless pinned in the Dockerfile,
+// and forgetting those the Dockerfile no longer uses.
+func pinBaseImages(config *types.Config, function *types.Function) (map[string]string, error) {
+	file := filepath.Join(function.BuildDir, lockFileName)
+	lock := &lockFile{}
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, lock)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+	}
+
+	bases, err := baseImages(dockerfilePath(function))
+	if err != nil {
+		return nil, err
+	}
+	pins := make(map[string]string)
+	for _, img := range bases {
+		if strings.Contains(img, "@") {
+			continue
+		}
+		if digest, ok := lock.BaseImages[img]; ok {
+			pins[img] = digest
+			continue
+		}
+		digest, err := imageDigest(config.Offline, img)
+		if err != nil {
+			return nil, fmt.Errorf("cannot pin base image %v: %w", img, err)
+		}
+		pins[img] = digest
+	}
+	if maps.Equal(pins, lock.BaseImages) {
+		return pins, nil
+	}
+
+	data, err = json.MarshalIndent(&lockFile{BaseImages: pins}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(file, append(data, '\n'), 0644)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Pinned function %v base images in %v\n", function.Name, file)
+	return pins, nil
+}
+
+// imageDigest returns the digest of ref in its registry, or of the local image if offline
+// or the registry can't be reached.
+func imageDigest(offline bool, ref string) (string, error) {
+	var distErr error
+	if !offline {
+		dist, err := dockerCli.DistributionInspect(dockerCtx, ref, registryAuth(ref))
+		if err == nil {
+			return dist.Descriptor.Digest.String(), nil
+		}
+		distErr = err
+	}
+
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	local, err := dockerCli.ImageInspect(dockerCtx, ref)
+	if err != nil {
+		return "", errors.Join(distErr, err)
+	}
+	for _, d := range local.RepoDigests {
+		pulled, err := reference.ParseNormalizedNamed(d)
+		if err != nil || pulled.Name() != named.Name() {
+			continue
+		}
+		if canonical, ok := pulled.(reference.Canonical); ok {
+			return canonical.Digest().String(), nil
+		}
+	}
+	return "", errors.Join(distErr, fmt.Errorf("local image %v has no digest, it wasn't pulled", ref))
+}
+
+// pinDockerfile returns the Dockerfile with the images its FROM instructions build from
+// pinned to their digest in pins, e.g. FROM python:3.12@sha256:... for python:3.12.
+func pinDockerfile(dockerfile []byte, pins map[string]string) []byte {
+	var out bytes.Buffer
+	stages := make(map[string]bool)
+	for line := range bytes.Lines(dockerfile) {
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			out.Write(line)
+			continue
+		}
+		// FROM [--platform=...] image [AS name], as baseImages reads it
+		i := 1
+		for i < len(fields) && strings.HasPrefix(fields[i], "--") {
+			i++
+		}
+		if i == len(fields) {
+			out.Write(line)
+			continue
+		}
+		img := fields[i]
+		digest, pinned := pins[img]
+		if i+2 < len(fields) && strings.EqualFold(fields[i+1], "AS") {
+			stages[strings.ToLower(fields[i+2])] = true
+		}
+		if !pinned || stages[strings.ToLower(img)] {
+			out.Write(line)
+			continue
+		}
+		fields[i] = img + "@" + digest
+		out.WriteString(strings.Join(fields, " "))
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// reproducibleContext returns the tar build context with its files' times, owners and
+// extended attributes normalized, so they don't change the image built, and the function's
+// Dockerfile pinning its base images to pins.
+func reproducibleContext(function *types.Function, tarCtx []byte, pins map[string]string) (*bytes.Buffer, error) {
+	dockerfile := path.Clean(filepath.ToSlash(function.Dockerfile))
+	epoch := time.Unix(reproducibleEpoch, 0)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tr := tar.NewReader(bytes.NewReader(tarCtx))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == dockerfile && header.Typeflag == tar.TypeReg {
+			body = pinDockerfile(body, pins)
+			header.Size = int64(len(body))
+		}
+
+		header.ModTime, header.AccessTime, header.ChangeTime = epoch, time.Time{}, time.Time{}
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		header.PAXRecords = nil
+		header.Format = tar.FormatUnknown
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tw.Write(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func sourceImagesFile(stateDir string) string {
+	return filepath.Join(stateDir, "reproducible.json")
+}
+
+// recordSourceImage records the image a reproducible build of the function built from the
+// build context of digest source. Returns the image the same build context built before,
+// if it differs, so the build wasn't reproduced.
+func recordSourceImage(stateDir string, function string, source string, image string) (string, error) {
+	sourceImagesMu.Lock()
+	defer sourceImagesMu.Unlock()
+	file := sourceImagesFile(stateDir)
+	records := make(map[string][]*sourceImage)
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &records)
+		if err != nil {
+			return "", fmt.Errorf("%v: %w", file, err)
+		}
+	}
+
+	var previous string
+	images := records[function][:0]
+	for _, record := range records[function] {
+		if record.Source == source {
+			previous = record.Image
+			continue
+		}
+		images = append(images, record)
+	}
+	images = append(images, &sourceImage{Source: source, Image: image, Built: time.Now()})
+	records[function] = images[max(0, len(images)-maxSourceImages):]
+
+	data, err = json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(stateDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		return "", err
+	}
+	if previous == image {
+		return "", nil
+	}
+	return previous, nil
+}
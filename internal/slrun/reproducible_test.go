@@ -0,0 +1,104 @@
+package slrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestPinDockerfile(t *testing.T) {
+	dockerfile := "FROM golang:1.23 AS build\nRUN go build\nFROM --platform=linux/amd64 alpine\nFROM build AS test\nCOPY --from=build /app /app\n"
+	pins := map[string]string{"golang:1.23": "sha256:aaa", "alpine": "sha256:bbb", "build": "sha256:ccc"}
+	want := "FROM golang:1.23@sha256:aaa AS build\nRUN go build\nFROM --platform=linux/amd64 alpine@sha256:bbb\nFROM build AS test\nCOPY --from=build /app /app\n"
+	if got := string(pinDockerfile([]byte(dockerfile), pins)); got != want {
+		t.Errorf("pinDockerfile() = %q, want %q", got, want)
+	}
+}
+
+func TestReproducibleContext(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.go")
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM golang\n"), 0644)
+	os.WriteFile(main, []byte("package main"), 0644)
+	fun := &types.Function{Name: "func1", BuildDir: dir, Dockerfile: "Dockerfile"}
+	pins := map[string]string{"golang": "sha256:aaa"}
+
+	build := func() []byte {
+		t.Helper()
+		tarCtx, err := createTarContext(fun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		normalized, err := reproducibleContext(fun, tarCtx.Bytes(), pins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return normalized.Bytes()
+	}
+
+	first := build()
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(main, later, later)
+	if !bytes.Equal(build(), first) {
+		t.Errorf("build context changed when only a modification time did")
+	}
+
+	tr := tar.NewReader(bytes.NewReader(first))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !header.ModTime.Equal(time.Unix(reproducibleEpoch, 0)) || header.Uid != 0 || header.Uname != "" {
+			t.Errorf("%v not normalized: modified %v, owner %v (%v)", header.Name, header.ModTime, header.Uid, header.Uname)
+		}
+		if header.Name == "Dockerfile" {
+			body, _ := io.ReadAll(tr)
+			if want := "FROM golang@sha256:aaa\n"; string(body) != want {
+				t.Errorf("Dockerfile = %q, want %q", body, want)
+			}
+		}
+	}
+}
+
+func TestPinBaseImagesFromLockFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM golang:1.23\nFROM alpine@sha256:bbb\n"), 0644)
+	lock := `{"base_images": {"golang:1.23": "sha256:aaa", "python:3.12": "sha256:ccc"}}`
+	os.WriteFile(filepath.Join(dir, lockFileName), []byte(lock), 0644)
+	fun := &types.Function{Name: "func1", BuildDir: dir, Dockerfile: "Dockerfile"}
+
+	pins, err := pinBaseImages(&types.Config{}, fun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || pins["golang:1.23"] != "sha256:aaa" {
+		t.Errorf("pinBaseImages() = %v, want golang:1.23 pinned as locked", pins)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, lockFileName))
+	if bytes.Contains(data, []byte("python")) {
+		t.Errorf("lock file kept a base image the Dockerfile no longer uses: %s", data)
+	}
+}
+
+func TestRecordSourceImage(t *testing.T) {
+	dir := t.TempDir()
+	if previous, err := recordSourceImage(dir, "func1", "sha256:src", "sha256:img"); err != nil || previous != "" {
+		t.Fatalf("recordSourceImage() = %q, %v, want none", previous, err)
+	}
+	if previous, err := recordSourceImage(dir, "func1", "sha256:src", "sha256:img"); err != nil || previous != "" {
+		t.Errorf("recordSourceImage() of a reproduced build = %q, %v, want none", previous, err)
+	}
+	if previous, err := recordSourceImage(dir, "func1", "sha256:src", "sha256:other"); err != nil || previous != "sha256:img" {
+		t.Errorf("recordSourceImage() of a build not reproduced = %q, %v, want sha256:img", previous, err)
+	}
+}
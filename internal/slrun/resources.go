@@ -0,0 +1,48 @@
+package slrun
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Docker rejects memory limits below this
+const minMemoryLimit = 6 * 1024 * 1024
+
+func validateResources(config *types.Config) error {
+	for _, f := range config.Functions {
+		if f.CPU < 0 {
+			return fieldError(functionField(config, f, "cpu"), "function %s cpu must not be negative", f.Name)
+		}
+		if f.PidsLimit < 0 {
+			return fieldError(functionField(config, f, "pids_limit"), "function %s pids_limit must not be negative", f.Name)
+		}
+		if f.Memory == "" {
+			continue
+		}
+		memory, err := units.RAMInBytes(f.Memory)
+		if err != nil {
+			return fieldError(functionField(config, f, "memory"), "function %s has invalid memory: %w", f.Name, err)
+		}
+		if memory < minMemoryLimit {
+			return fieldError(functionField(config, f, "memory"), "function %s memory must be at least 6m", f.Name)
+		}
+	}
+	return nil
+}
+
+// functionResources returns the resource limits of the function's containers.
+func functionResources(function *types.Function) container.Resources {
+	resources := container.Resources{
+		NanoCPUs: int64(function.CPU * 1e9),
+	}
+	if function.Memory != "" {
+		resources.Memory, _ = units.RAMInBytes(function.Memory)
+		// Without swap, so the limit holds
+		resources.MemorySwap = resources.Memory
+	}
+	if function.PidsLimit > 0 {
+		resources.PidsLimit = &function.PidsLimit
+	}
+	return resources
+}
@@ -0,0 +1,121 @@
+package slrun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FunctionResponse is a function's response to an invocation.
+// Its body streams from the function and must be closed, which ends the call.
+type FunctionResponse struct {
+	Status    int
+	Header    http.Header
+	Body      io.ReadCloser
+	ColdStart time.Duration // Spent starting the function's container, zero if it was running
+}
+
+// hookedBody runs a hook once when closed, such as the policy's post call hook.
+type hookedBody struct {
+	io.ReadCloser
+	once sync.Once
+	hook func() error
+}
+
+func (b *hookedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if hookErr := b.hook(); hookErr != nil {
+			err = hookErr
+		}
+	})
+	return err
+}
+
+// onClose runs hook once the response body is closed, returning its error from Close.
+func (resp *FunctionResponse) onClose(hook func() error) {
+	resp.Body = &hookedBody{ReadCloser: resp.Body, hook: hook}
+}
+
+// errResponseTooLarge is returned buffering a response larger than its limit.
+var errResponseTooLarge = errors.New("function response too large to buffer")
+
+// ReadAll buffers and closes the response body, failing if it exceeds max bytes.
+// A max of zero or less doesn't limit it.
+func (resp *FunctionResponse) ReadAll(max int64) ([]byte, error) {
+	var body []byte
+	var err error
+	if max > 0 {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, max+1))
+		if err == nil && int64(len(body)) > max {
+			err = fmt.Errorf("%w: more than %v bytes", errResponseTooLarge, max)
+		}
+	} else {
+		body, err = io.ReadAll(resp.Body)
+	}
+
+	closeErr := resp.Body.Close()
+	if err != nil {
+		return nil, invocationError(ErrClassBadResponse, http.StatusBadGateway, err)
+	}
+	if closeErr != nil {
+		return nil, invocationError(ErrClassPolicyFailure, http.StatusInternalServerError, closeErr)
+	}
+	return body, nil
+}
+
+// Write writes the response to w and closes it, flushing the body as it streams
+// from the function so downloads and server-sent events reach the client at once.
+// Errors after the status is written can't be reported to the client, only logged.
+func (resp *FunctionResponse) Write(w http.ResponseWriter) error {
+	defer resp.Body.Close()
+	maps.Copy(w.Header(), resp.Header)
+	w.WriteHeader(resp.Status)
+
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			rc.Flush()
+		}
+		if err == io.EOF {
+			return resp.Body.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// WriteBuffered buffers the response, up to max bytes, then writes it to w with its Content-Length.
+func (resp *FunctionResponse) WriteBuffered(w http.ResponseWriter, max int64) error {
+	body, err := resp.ReadAll(max)
+	if err != nil {
+		return err
+	}
+	maps.Copy(w.Header(), resp.Header)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(resp.Status)
+	w.Write(body)
+	return nil
+}
+
+// discard drains and closes the response body, for callers that only want the status.
+func (resp *FunctionResponse) discard() error {
+	io.Copy(io.Discard, resp.Body)
+	err := resp.Body.Close()
+	if err != nil {
+		log.Printf("Cannot end function call: %v\n", err)
+	}
+	return err
+}
@@ -0,0 +1,379 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Default time between retention prunes
+const defaultRetentionInterval = time.Hour
+
+// Time prefixing daemon log lines, as the log package writes it
+const daemonLogTime = "2006/01/02 15:04:05"
+
+// retentionLimits is a parsed RetentionPolicy, unlimited where zero.
+type retentionLimits struct {
+	maxAge  time.Duration
+	maxSize int64
+}
+
+// parseRetentionPolicy parses the policy of the retention field, unlimited if nil.
+func parseRetentionPolicy(field string, p *types.RetentionPolicy) (retentionLimits, error) {
+	var limits retentionLimits
+	if p == nil {
+		return limits, nil
+	}
+	if p.MaxAge != "" {
+		age, err := time.ParseDuration(p.MaxAge)
+		if err != nil || age <= 0 {
+			return limits, fieldError(field+".max_age", "invalid retention max_age %q", p.MaxAge)
+		}
+		limits.maxAge = age
+	}
+	if p.MaxSize != "" {
+		size, err := units.RAMInBytes(p.MaxSize)
+		if err != nil || size <= 0 {
+			return limits, fieldError(field+".max_size", "invalid retention max_size %q", p.MaxSize)
+		}
+		limits.maxSize = size
+	}
+	return limits, nil
+}
+
+// retentionWindow returns the window prunes run in, nil if any time.
+func retentionWindow(retention *types.Retention) *types.ScaleProfile {
+	if retention.From == "" && retention.To == "" && len(retention.Days) == 0 {
+		return nil
+	}
+	return &types.ScaleProfile{Days: retention.Days, From: retention.From, To: retention.To}
+}
+
+func validateRetention(config *types.Config) error {
+	retention := config.Retention
+	if retention == nil {
+		return nil
+	}
+	if retention.Interval != "" {
+		if _, err := time.ParseDuration(retention.Interval); err != nil {
+			return fieldError("retention.interval", "invalid retention interval: %w", err)
+		}
+	}
+	if window := retentionWindow(retention); window != nil {
+		if err := validateScaleProfile(window); err != nil {
+			return fieldError("retention", "invalid retention window: %w", err)
+		}
+	}
+	policies := []struct {
+		field  string
+		policy *types.RetentionPolicy
+	}{
+		{"retention.history", retention.History},
+		{"retention.logs", retention.Logs},
+		{"retention.metrics", retention.Metrics},
+	}
+	for _, p := range policies {
+		if _, err := parseRetentionPolicy(p.field, p.policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retainer prunes the oldest history, logs and usage exports of a long-running slrun,
+// so what it keeps on disk stays within the configured retention.
+type Retainer struct {
+	config                 *types.Config
+	invocations            *InvocationHistory
+	history, logs, metrics retentionLimits
+	window                 *types.ScaleProfile
+	interval               time.Duration
+	stop                   chan struct{}
+	wg                     sync.WaitGroup
+}
+
+func NewRetainer(config *types.Config, invocations *InvocationHistory) *Retainer {
+	retention := config.Retention
+	if retention == nil {
+		return nil
+	}
+	r := &Retainer{
+		config:      config,
+		invocations: invocations,
+		window:      retentionWindow(retention),
+		interval:    defaultRetentionInterval,
+	}
+	// Validated with the config
+	r.history, _ = parseRetentionPolicy("", retention.History)
+	r.logs, _ = parseRetentionPolicy("", retention.Logs)
+	r.metrics, _ = parseRetentionPolicy("", retention.Metrics)
+	if retention.Interval != "" {
+		r.interval, _ = time.ParseDuration(retention.Interval)
+	}
+	return r
+}
+
+// Start prunes now and on every interval, within the window if any.
+func (r *Retainer) Start() {
+	if r == nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.prune(time.Now())
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				r.prune(t)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Retainer) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// prune prunes what is past retention at t, unless t is outside the window.
+func (r *Retainer) prune(t time.Time) {
+	if r.window != nil && !profileActive(r.window, t) {
+		return
+	}
+	var pruned []string
+	if r.history.maxAge > 0 && r.invocations != nil {
+		if n := r.invocations.Prune(t.Add(-r.history.maxAge)); n > 0 {
+			pruned = append(pruned, fmt.Sprintf("%v invocations", n))
+		}
+	}
+	n, err := pruneHistory(r.config.StateDir, r.history, t)
+	if err != nil {
+		log.Printf("Retention: cannot prune deployment history: %v\n", err)
+	}
+	if n > 0 {
+		pruned = append(pruned, fmt.Sprintf("%v deployments", n))
+	}
+
+	size, err := pruneDaemonLog(DaemonLogFile(r.config.StateDir), r.logs, t)
+	if err != nil {
+		log.Printf("Retention: cannot prune daemon log: %v\n", err)
+	}
+	if size > 0 {
+		pruned = append(pruned, fmt.Sprintf("%v of daemon log", units.BytesSize(float64(size))))
+	}
+	for _, dir := range []struct {
+		path, pattern, what string
+		limits              retentionLimits
+	}{
+		{r.config.Capture.Dir, "*.har", "captures", r.logs},
+		{r.config.UsageExport.Dir, "usage-*", "usage exports", r.metrics},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		files, err := pruneDir(dir.path, dir.pattern, dir.limits, t)
+		if err != nil {
+			log.Printf("Retention: cannot prune %v in %v: %v\n", dir.what, dir.path, err)
+		}
+		if len(files) > 0 {
+			pruned = append(pruned, fmt.Sprintf("%v %v", len(files), dir.what))
+		}
+	}
+
+	if len(pruned) > 0 {
+		log.Printf("Retention: pruned %v\n", strings.Join(pruned, ", "))
+	}
+}
+
+// pruneHistory removes deployments older than the limits' max age from each function's history,
+// then the oldest until it fits max size. The latest deployment is always kept.
+// Returns the deployments removed.
+func pruneHistory(stateDir string, limits retentionLimits, now time.Time) (int, error) {
+	if limits == (retentionLimits{}) {
+		return 0, nil
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	files, err := filepath.Glob(historyFile(stateDir, "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, file := range files {
+		function := strings.TrimSuffix(filepath.Base(file), ".json")
+		history, err := ReadHistory(stateDir, function)
+		if err != nil {
+			return pruned, fmt.Errorf("%v: %w", file, err)
+		}
+		kept := history
+		if limits.maxAge > 0 {
+			for len(kept) > 1 && now.Sub(kept[0].Time) > limits.maxAge {
+				kept = kept[1:]
+			}
+		}
+		if limits.maxSize > 0 {
+			for len(kept) > 1 {
+				data, err := json.MarshalIndent(kept, "", "  ")
+				if err != nil {
+					return pruned, err
+				}
+				if int64(len(data)) <= limits.maxSize {
+					break
+				}
+				kept = kept[1:]
+			}
+		}
+		if len(kept) == len(history) {
+			continue
+		}
+		err = writeHistory(stateDir, function, kept)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += len(history) - len(kept)
+	}
+	return pruned, nil
+}
+
+// pruneDaemonLog removes the lines of the daemon log older than the limits' max age, then
+// the oldest until it fits max size. Lines without a time, such as build output, are as old
+// as the line before them. Returns the bytes removed.
+// The daemon appends to its log as it is pruned, so lines written meanwhile may be lost.
+func pruneDaemonLog(file string, limits retentionLimits, now time.Time) (int64, error) {
+	if limits == (retentionLimits{}) {
+		return 0, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil // Not detached
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cut := 0
+	if limits.maxAge > 0 {
+		offset, timed := 0, false
+		for line := range bytes.Lines(data) {
+			t, ok := daemonLogLineTime(line)
+			if ok && now.Sub(t) <= limits.maxAge {
+				break
+			}
+			timed = timed || ok
+			offset += len(line)
+		}
+		if timed {
+			cut = offset
+		}
+	}
+	if limits.maxSize > 0 && int64(len(data)-cut) > limits.maxSize {
+		cut = len(data) - int(limits.maxSize)
+		if i := bytes.IndexByte(data[cut:], '\n'); i >= 0 {
+			cut += i + 1
+		} else {
+			cut = len(data)
+		}
+	}
+	if cut == 0 {
+		return 0, nil
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// Keep what was appended since reading it
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	kept := slices.Clone(data[cut:])
+	if appended := info.Size() - int64(len(data)); appended > 0 {
+		more := make([]byte, appended)
+		_, err = f.ReadAt(more, int64(len(data)))
+		if err != nil {
+			return 0, err
+		}
+		kept = append(kept, more...)
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.WriteAt(kept, 0)
+	if err != nil {
+		return 0, err
+	}
+	return int64(cut), nil
+}
+
+// daemonLogLineTime returns the time a daemon log line was written, if it starts with one.
+func daemonLogLineTime(line []byte) (time.Time, bool) {
+	if len(line) < len(daemonLogTime) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(daemonLogTime, string(line[:len(daemonLogTime)]), time.Local)
+	return t, err == nil
+}
+
+// pruneDir removes the files of dir matching pattern modified longer ago than the limits'
+// max age, then the oldest until they fit max size. Returns the names of the files removed.
+func pruneDir(dir string, pattern string, limits retentionLimits, now time.Time) ([]string, error) {
+	if limits == (retentionLimits{}) {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	var files []fs.FileInfo
+	var total int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	slices.SortFunc(files, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+
+	var removed []string
+	for _, info := range files {
+		old := limits.maxAge > 0 && now.Sub(info.ModTime()) > limits.maxAge
+		large := limits.maxSize > 0 && total > limits.maxSize
+		if !old && !large {
+			break
+		}
+		err := os.Remove(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, info.Name())
+		total -= info.Size()
+	}
+	return removed, nil
+}
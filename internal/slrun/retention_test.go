@@ -0,0 +1,145 @@
+package slrun
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestPruneHistory(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	history := []*Deployment{
+		{ID: 1, Time: now.Add(-72 * time.Hour), Result: DeploySucceeded},
+		{ID: 2, Time: now.Add(-48 * time.Hour), Result: DeploySucceeded},
+		{ID: 3, Time: now.Add(-time.Hour), Result: DeploySucceeded},
+	}
+	if err := writeHistory(dir, "func1", history); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHistory(dir, "func2", history[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := pruneHistory(dir, retentionLimits{maxAge: 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruneHistory() = %v, want 2", pruned)
+	}
+	if kept, _ := ReadHistory(dir, "func1"); len(kept) != 1 || kept[0].ID != 3 {
+		t.Errorf("func1 history = %v, want deployment 3", kept)
+	}
+	// The latest deployment is kept however old
+	if kept, _ := ReadHistory(dir, "func2"); len(kept) != 1 {
+		t.Errorf("func2 history = %v, want deployment 1", kept)
+	}
+
+	err = RecordDeployment(dir, &types.Function{Name: "func1"}, "sha256:abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept, _ := ReadHistory(dir, "func1"); kept[len(kept)-1].ID != 4 {
+		t.Errorf("deployment recorded after pruning has ID %v, want 4", kept[len(kept)-1].ID)
+	}
+}
+
+func TestPruneDaemonLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "slrun.log")
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	content := "2025/05/01 12:00:00 Building func1\n" +
+		"func1 | Step 1/2\n" +
+		"2025/05/31 12:00:00 Deployed func1\n" +
+		"2025/06/01 11:00:00 GET /func1 200\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := pruneDaemonLog(file, retentionLimits{maxAge: 7 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2025/05/31 12:00:00 Deployed func1\n2025/06/01 11:00:00 GET /func1 200\n"
+	if got, _ := os.ReadFile(file); string(got) != want {
+		t.Errorf("daemon log = %q, want %q", got, want)
+	}
+	if pruned != int64(len(content)-len(want)) {
+		t.Errorf("pruneDaemonLog() = %v, want %v", pruned, len(content)-len(want))
+	}
+
+	_, err = pruneDaemonLog(file, retentionLimits{maxSize: 40}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "2025/06/01 11:00:00 GET /func1 200\n"
+	if got, _ := os.ReadFile(file); string(got) != want {
+		t.Errorf("daemon log = %q, want %q", got, want)
+	}
+
+	if _, err := pruneDaemonLog(filepath.Join(t.TempDir(), "slrun.log"), retentionLimits{maxSize: 40}, now); err != nil {
+		t.Errorf("pruneDaemonLog() without a log = %v", err)
+	}
+}
+
+func TestPruneDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"usage-1.csv", "usage-2.csv", "usage-3.csv", "notes.txt"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified := now.Add(time.Duration(i-3) * 24 * time.Hour)
+		if err := os.Chtimes(file, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := pruneDir(dir, "usage-*", retentionLimits{maxAge: 60 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"usage-1.csv"}) {
+		t.Errorf("pruneDir() max age = %v, want [usage-1.csv]", removed)
+	}
+	removed, err = pruneDir(dir, "usage-*", retentionLimits{maxSize: 150}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"usage-2.csv"}) {
+		t.Errorf("pruneDir() max size = %v, want [usage-2.csv]", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("pruneDir() removed a file not matching its pattern: %v", err)
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	for _, retention := range []*types.Retention{
+		{Interval: "hourly"},
+		{History: &types.RetentionPolicy{MaxAge: "30d"}},
+		{Logs: &types.RetentionPolicy{MaxSize: "lots"}},
+		{Metrics: &types.RetentionPolicy{MaxAge: "-1h"}},
+		{From: "25:00", To: "06:00"},
+		{Days: []string{"sun"}},
+	} {
+		if err := validateRetention(&types.Config{Retention: retention}); err == nil {
+			t.Errorf("validateRetention(%+v) = nil, want an error", retention)
+		}
+	}
+	retention := &types.Retention{
+		History:  &types.RetentionPolicy{MaxAge: "720h", MaxSize: "1m"},
+		Logs:     &types.RetentionPolicy{MaxSize: "100m"},
+		Interval: "15m",
+		From:     "02:00",
+		To:       "05:00",
+	}
+	if err := validateRetention(&types.Config{Retention: retention}); err != nil {
+		t.Errorf("validateRetention() = %v", err)
+	}
+}
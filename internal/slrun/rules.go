@@ -0,0 +1,65 @@
+package slrun
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+var redirectStatuses = []int{301, 302, 307, 308}
+
+// matchRule returns the path a rule maps path to, if it matches.
+func matchRule(rule *types.PathRule, path string) (string, bool) {
+	prefix, isPrefix := strings.CutSuffix(rule.From, "*")
+	if !isPrefix {
+		return rule.To, path == rule.From
+	}
+
+	rest, found := strings.CutPrefix(path, prefix)
+	if !found {
+		// /old/* also matches /old
+		if path+"/" != prefix {
+			return "", false
+		}
+		rest = ""
+	}
+	return strings.Replace(rule.To, "*", rest, 1), true
+}
+
+// applyRules returns the path mapped by the first matching rule.
+func applyRules(rules []*types.PathRule, path string) (*types.PathRule, string, bool) {
+	for _, rule := range rules {
+		if to, ok := matchRule(rule, path); ok {
+			return rule, to, true
+		}
+	}
+	return nil, path, false
+}
+
+func validatePathRule(rule *types.PathRule, redirect bool) error {
+	if !strings.HasPrefix(rule.From, "/") {
+		return fmt.Errorf("rule from %q must start with /", rule.From)
+	}
+	if strings.Contains(strings.TrimSuffix(rule.From, "/*"), "*") {
+		return fmt.Errorf("rule from %q may only end in /*", rule.From)
+	}
+	if rule.To == "" {
+		return fmt.Errorf("rule from %q has no target", rule.From)
+	}
+	if !redirect {
+		if !strings.HasPrefix(rule.To, "/") {
+			return fmt.Errorf("rewrite to %q must start with /", rule.To)
+		}
+		return nil
+	}
+
+	if rule.Status == 0 {
+		rule.Status = 301
+	}
+	if !slices.Contains(redirectStatuses, rule.Status) {
+		return fmt.Errorf("invalid redirect status: %d", rule.Status)
+	}
+	return nil
+}
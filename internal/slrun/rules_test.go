@@ -0,0 +1,47 @@
+package slrun
+
+import (
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestApplyRules(t *testing.T) {
+	rules := []*types.PathRule{
+		{From: "/old", To: "/new"},
+		{From: "/docs/*", To: "/manual/*"},
+		{From: "/any/*", To: "/fixed"},
+		{From: "/docs/v1", To: "/unreachable"},
+	}
+
+	tests := []struct {
+		path     string
+		wantRule int // Index of the matching rule, -1 if none
+		wantPath string
+	}{
+		{"/old", 0, "/new"},
+		{"/old/sub", -1, "/old/sub"},
+		{"/docs/intro", 1, "/manual/intro"},
+		{"/docs/a/b", 1, "/manual/a/b"},
+		{"/docs", 1, "/manual/"},
+		{"/docs/v1", 1, "/manual/v1"},
+		{"/docsx", -1, "/docsx"},
+		{"/any/thing", 2, "/fixed"},
+		{"/", -1, "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rule, path, ok := applyRules(rules, tt.path)
+			if ok != (tt.wantRule >= 0) {
+				t.Fatalf("applyRules(%q) ok = %v, want %v", tt.path, ok, tt.wantRule >= 0)
+			}
+			if ok && rule != rules[tt.wantRule] {
+				t.Errorf("applyRules(%q) matched rule from %q, want %q", tt.path, rule.From, rules[tt.wantRule].From)
+			}
+			if path != tt.wantPath {
+				t.Errorf("applyRules(%q) path = %q, want %q", tt.path, path, tt.wantPath)
+			}
+		})
+	}
+}
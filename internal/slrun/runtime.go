@@ -1,17 +1,28 @@
 package slrun
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/marcorentap/slrun/internal/policy"
 	"github.com/marcorentap/slrun/internal/types"
@@ -19,79 +30,298 @@ import (
 )
 
 type Runtime struct {
-	functions []*types.Function
-	running   bool
-	cli       *client.Client // Docker client
-	policy    types.Policy
-	tickRate  time.Duration
+	functions     []*types.Function
+	running       bool
+	cli           ContainerBackend // Docker API of the container engine
+	engine        string           // Container engine, docker or podman
+	policy        types.Policy
+	tickRate      time.Duration
+	hostIP        string        // Host IP function ports are bound to
+	instance      string        // ID of this slrun instance, labelling its containers
+	network       string        // Network function containers join, none if empty
+	gatewayURL    string        // Where containers call the gateway, passed as SLRUN_GATEWAY, none if empty
+	localGateway  string        // Where process functions call the gateway
+	readyTimeout  time.Duration // How long to wait for a started function to accept connections
+	uploadDir     string        // Host dir of uploaded files, absolute
+	events        *Events
+	httpClient    *http.Client    // Proxies requests to functions
+	socketsDir    string          // Host dir of function Unix sockets, absolute
+	socketClients sync.Map        // Clients of function Unix sockets by path
+	ports         *portAllocator  // Host ports of function containers, nil if Docker picks them
+	cluster       *Cluster        // Forwards invocations of remote functions, nil outside cluster mode
+	chaos         *chaosTransport // Fails Docker API calls in chaos mode, nil otherwise
+	quarantine    *Quarantine     // Disables functions violating rules, nil if not configured
+	opa           *OPAPolicies    // Guards deploys and invocations, nil if not configured
+	flags         *FeatureFlags   // Passed to functions on each request
+	keepStopped   bool            // Stopped containers are kept rather than removed
+	dev           bool            // Development mode, function caches are mounted
+
+	startFailures startFailures      // Recent start failures, for crash loop detection
+	stopWatch     context.CancelFunc // Stops watching container events and scaling replicas
+	warmups       sync.Map           // Warm-up of each container by ID, a *sync.Once
+	stopping      sync.Map           // IDs of containers stopped by slrun, whose exit isn't a crash
+	inflights     sync.Map           // Requests in flight to each function by name, an *atomic.Int64
+	secretValues  sync.Map           // Secret values of each function's latest container by name, redacted from its logs
+	builds        *BuildQueue        // Rebuilds functions while running, one at a time per function
+	bulkheads     sync.Map           // Bulkhead of each function with one by name, a *bulkhead
+	bulkheadsMu   sync.Mutex         // Held while creating bulkheads
+
+	mu        sync.Mutex                 // Guards instances, replicas and adding or removing policy functions
+	instances map[string]*types.Function // Per-tenant and replica instances by name, "function@tenant" or "function#2"
+	replicas  map[string]*replicaSet     // Replicas of functions scaling out, by function name
 }
 
-func NewRuntime(functions []*types.Function, policyId types.PolicyID) (*Runtime, error) {
-	dockerCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+func NewRuntime(config *types.Config, events *Events, cluster *Cluster, opa *OPAPolicies) (*Runtime, error) {
+	functions := config.Functions
+	policyId := config.Policy
+
+	quarantine, err := NewQuarantine(config.Quarantine, config.StateDir, events)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := NewFeatureFlags(config.StateDir, events)
 	if err != nil {
 		return nil, err
 	}
 
+	// Disabled and quarantined functions are left out of the policy until enabled,
+	// remote functions are left out for good
+	var enabled []*types.Function
+	for _, f := range functions {
+		f.IsEnabled = !f.Disabled && quarantine.Quarantined(f.Name) == nil
+		if f.IsEnabled && !f.Remote {
+			enabled = append(enabled, f)
+		}
+	}
+
+	dockerCli, err := newBackendClient(config.Backend)
+	if err != nil {
+		return nil, err
+	}
+	var chaos *chaosTransport
+	if config.Chaos != nil && config.Chaos.DockerFailureRate > 0 {
+		chaos = &chaosTransport{rate: config.Chaos.DockerFailureRate, injected: make(map[string]int)}
+		dockerCli, err = withChaos(dockerCli, chaos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var cli ContainerBackend = dockerCli
+	if config.Backend.Engine == BackendContainerd {
+		cli = newContainerdBackend(config.Backend)
+	}
+	// Process functions may be added as the config reloads
+	cli = newProcessBackend(cli)
+
+	socketsDir, err := filepath.Abs(filepath.Join(config.StateDir, "sockets"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ports *portAllocator
+	if config.FunctionPorts != "" {
+		from, to, err := parsePortRange(config.FunctionPorts)
+		if err != nil {
+			return nil, err
+		}
+		ports = newPortAllocator(config.FunctionHost, from, to)
+		for _, f := range functions {
+			if f.HostPort != 0 && !f.Remote {
+				ports.reserve(f.HostPort, f.Name)
+			}
+		}
+	}
+
 	r := Runtime{
-		functions: functions,
-		running:   false,
-		cli:       dockerCli,
-		tickRate:  5 * time.Millisecond,
+		functions:    functions,
+		running:      false,
+		cli:          cli,
+		engine:       config.Backend.Engine,
+		tickRate:     5 * time.Millisecond,
+		hostIP:       config.FunctionHost,
+		instance:     instanceID(config.StateDir),
+		network:      functionNetwork(config.Network),
+		readyTimeout: 30 * time.Second,
+		uploadDir:    config.UploadDir,
+		events:       events,
+		httpClient:   newProxyClient(),
+		socketsDir:   socketsDir,
+		ports:        ports,
+		cluster:      cluster,
+		chaos:        chaos,
+		quarantine:   quarantine,
+		opa:          opa,
+		flags:        flags,
+		keepStopped:  config.KeepContainers,
+		dev:          config.Dev,
+		builds:       NewBuildQueue(config.BuildParallelism),
+		instances:    make(map[string]*types.Function),
+		replicas:     make(map[string]*replicaSet),
+	}
+
+	for _, f := range functions {
+		if f.Replicas != nil && !f.Remote {
+			r.replicas[f.Name] = &replicaSet{replicas: []*types.Function{f}, inflight: make(map[*types.Function]int)}
+		}
 	}
 
 	var pol types.Policy
 	switch policyId {
 	case types.AlwaysColdPolicy:
 		pol = &policy.AlwaysCold{
-			Funcs:     functions,
+			Funcs:     enabled,
 			StartFunc: r.startFunction,
 			StopFunc:  r.stopFunction,
 		}
 	case types.AlwaysHotPolicy:
 		pol = &policy.AlwaysHot{
-			Funcs:     functions,
+			Funcs:     enabled,
 			StartFunc: r.startFunction,
 			StopFunc:  r.stopFunction,
 		}
 	case types.ColdOnIdlePolicy:
 		pol = &policy.ColdOnIdle{
-			Funcs:     functions,
+			Funcs:     enabled,
 			StartFunc: r.startFunction,
 			StopFunc:  r.stopFunction,
+			InFlight:  r.callsInFlight,
 		}
 
 	default:
-		return nil, fmt.Errorf("unknown policy ID: %d", policyId)
+		return nil, fmt.Errorf("unknown policy ID: %s", policyId)
 	}
 
 	r.policy = pol
 
+	if quarantine != nil {
+		quarantine.runtime = &r
+	}
+
 	return &r, nil
 }
 
 func (r *Runtime) startFunction(function *types.Function) error {
 	ctx := context.Background()
-	config := &container.Config{
-		Image: function.ImageName,
+	image, err := r.cli.ImageInspect(ctx, function.ImageName)
+	if err != nil {
+		return err
 	}
-	networkingConfig := &network.NetworkingConfig{}
-	platform := &ocispec.Platform{}
-
-	port, err := nat.NewPort("tcp", "80")
+	if !function.Socket {
+		function.ContainerPort, err = imagePort(image, function)
+		if err != nil {
+			return err
+		}
+	}
+	function.Health, function.HealthTimeout = imageHealth(image)
+	function.Version = imageVersion(image)
+	secrets, secretValues, err := secretEnv(function)
 	if err != nil {
 		return err
 	}
+	r.secretValues.Store(function.Name, secretValues)
+	env := append(containerEnv(function.Env), secrets...)
+	config := &container.Config{
+		Image:       function.ImageName,
+		Env:         slices.Concat(env, contractEnv(function), flagsEnv(r.flags.Flags(function))),
+		Labels:      containerLabels(r.instance, function),
+		StopSignal:  function.StopSignal,
+		StopTimeout: &function.StopTimeout,
+	}
+	if function.Process != nil {
+		config.Cmd = function.Process.Command
+		config.WorkingDir = function.Process.Dir
+	}
+	networkingConfig := r.networkingConfig(function)
+	platform := &ocispec.Platform{}
+
 	portMap := nat.PortMap{}
-	portMap[port] = []nat.PortBinding{
-		{
-			HostIP:   "127.0.0.1", // Functions are directly accessible only on localhost
-			HostPort: "",          // Allocate a random port
-		},
+	var socketDir string
+	if function.Socket {
+		// Dialed through the socket, no port needed
+		socketDir = functionSocketDir(r.socketsDir, function.Name)
+		err := prepareSocketDir(socketDir)
+		if err != nil {
+			return err
+		}
+		config.Env = append(config.Env, "SLRUN_SOCKET="+containerSocket)
+	} else {
+		port, err := nat.NewPort("tcp", strconv.Itoa(function.ContainerPort))
+		if err != nil {
+			return err
+		}
+		hostPort := "" // Allocated by Docker
+		if function.HostPort != 0 && function.Tenant == "" {
+			hostPort = strconv.Itoa(function.HostPort)
+		} else if r.ports != nil {
+			allocated, err := r.ports.allocate(function.Name)
+			if err != nil {
+				return err
+			}
+			hostPort = strconv.Itoa(allocated)
+			defer func() {
+				// The port is only kept by a started container
+				if !function.IsRunning {
+					r.ports.release(allocated)
+				}
+			}()
+		}
+		portMap[port] = []nat.PortBinding{
+			{
+				HostIP:   r.hostIP, // Functions are directly accessible only on this address
+				HostPort: hostPort,
+			},
+		}
+	}
+	// Tenant instances would collide on the function's debug port
+	if function.DebugPort != 0 && function.Tenant == "" {
+		debugPort, err := nat.NewPort("tcp", strconv.Itoa(function.DebugPort))
+		if err != nil {
+			return err
+		}
+		config.ExposedPorts = nat.PortSet{debugPort: struct{}{}}
+		portMap[debugPort] = []nat.PortBinding{
+			{HostIP: r.hostIP, HostPort: strconv.Itoa(function.DebugPort)},
+		}
 	}
 	hostConfig := &container.HostConfig{
 		PortBindings: portMap,
+		Resources:    functionResources(function),
+	}
+	if r.network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(r.network)
+	}
+	if function.Process != nil && r.localGateway != "" {
+		// On the host, like slrun
+		config.Env = append(config.Env, "SLRUN_GATEWAY="+r.localGateway)
+	} else if r.gatewayURL != "" {
+		config.Env = append(config.Env, "SLRUN_GATEWAY="+r.gatewayURL)
+		if r.engine != BackendPodman {
+			hostConfig.ExtraHosts = []string{gatewayHostname + ":host-gateway"}
+		}
+	}
+	cacheMounts, cacheEnv := r.cacheMounts(function)
+	// First, so the function's env overrides it
+	config.Env = append(cacheEnv, config.Env...)
+	hostConfig.Mounts = cacheMounts
+	if socketDir != "" {
+		hostConfig.Binds = append(hostConfig.Binds, socketDir+":"+containerSocketDir)
 	}
 
+	if function.Transform == TransformFormToJSON {
+		// Tenant instances share the function's upload dir
+		name := strings.TrimSuffix(function.Name, "@"+function.Tenant)
+		dir := functionUploadDir(r.uploadDir, name)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, dir+":"+containerUploadDir+":ro")
+	}
+
+	// The function's previous container, if it died, was kept for its logs until now
+	if function.ContainerId != "" {
+		r.removeContainer(function)
+	}
 	resp, err := r.cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, "")
 	if err != nil {
 		return err
@@ -104,110 +334,671 @@ func (r *Runtime) startFunction(function *types.Function) error {
 		return err
 	}
 
-	inspResp, err := r.cli.ContainerInspect(ctx, resp.ID)
-	if err != nil {
-		return err
+	if function.Socket {
+		function.ContainerId = resp.ID
+		function.SocketPath = filepath.Join(socketDir, filepath.Base(containerSocket))
+		function.Ready = false
+		function.IsRunning = true
+		r.startFailures.started(function.Name)
+		go r.warmUp(function)
+		return nil
 	}
 
-	hostPort := inspResp.NetworkSettings.Ports["80/tcp"][0].HostPort
 	function.ContainerId = resp.ID
-	function.Port, _ = strconv.Atoi(hostPort)
+	function.Port, err = r.containerPort(ctx, function)
+	if err != nil {
+		return err
+	}
+	function.Ready = false
 	function.IsRunning = true
+	r.startFailures.started(function.Name)
+	go r.warmUp(function)
 	return nil
 }
 
+// imagePort returns the port the function listens on in its container, its configured port,
+// or the first TCP port its image exposes, not counting its debug port.
+func imagePort(inspect image.InspectResponse, function *types.Function) (int, error) {
+	if function.ListenPort != 0 {
+		return function.ListenPort, nil
+	}
+	var exposed []int
+	if inspect.Config != nil {
+		for p := range inspect.Config.ExposedPorts {
+			port := nat.Port(p)
+			if port.Proto() != "tcp" || port.Int() == function.DebugPort {
+				continue
+			}
+			exposed = append(exposed, port.Int())
+		}
+	}
+	if len(exposed) == 0 {
+		return 0, fmt.Errorf("function %v image %v exposes no TCP port, set its port or add an EXPOSE instruction to its Dockerfile", function.Name, function.ImageName)
+	}
+	return slices.Min(exposed), nil
+}
+
+// containerPort returns the host port bound to the function's port in its container.
+func (r *Runtime) containerPort(ctx context.Context, function *types.Function) (int, error) {
+	inspResp, err := r.cli.ContainerInspect(ctx, function.ContainerId)
+	if err != nil {
+		return 0, err
+	}
+
+	bindings := inspResp.NetworkSettings.Ports[nat.Port(strconv.Itoa(function.ContainerPort)+"/tcp")]
+	if len(bindings) == 0 {
+		return 0, fmt.Errorf("function %v container has no port binding", function.Name)
+	}
+	hostPort := bindings[0].HostPort
+	for _, b := range bindings {
+		if b.HostIP == r.hostIP {
+			hostPort = b.HostPort
+			break
+		}
+	}
+	return strconv.Atoi(hostPort)
+}
+
 func (r *Runtime) stopFunction(function *types.Function) error {
+	// Without a stop timeout, don't wait for graceful shutdown
+	return r.stopFunctionWithin(function, function.StopTimeout)
+}
+
+// stopFunctionWithin runs the function's pre-stop hook, then sends its container its stop signal,
+// killing it if it hasn't exited after timeout seconds.
+func (r *Runtime) stopFunctionWithin(function *types.Function, timeout int) error {
 	ctx := context.Background()
-	stopTimeout := 0 // Don't wait for graceful shutdown
+	r.runPreStop(function)
+
+	r.stopping.Store(function.ContainerId, struct{}{})
 	err := r.cli.ContainerStop(ctx, function.ContainerId, container.StopOptions{
-		Timeout: &stopTimeout,
+		Signal:  function.StopSignal,
+		Timeout: &timeout,
 	})
 	if err != nil {
+		r.stopping.Delete(function.ContainerId)
 		return err
 	}
-	function.IsRunning = false
+	r.containerStopped(function)
+	r.removeContainer(function)
 	return nil
 }
 
+// removeContainer removes the function's stopped container, unless stopped containers are kept.
+func (r *Runtime) removeContainer(function *types.Function) {
+	if r.keepStopped {
+		return
+	}
+	err := r.cli.ContainerRemove(context.Background(), function.ContainerId, container.RemoveOptions{})
+	if err != nil {
+		log.Printf("Cannot remove function %v container %v: %v\n", function.Name, function.ContainerId, err)
+		return
+	}
+	function.ContainerId = ""
+}
+
+// containerStopped marks the function stopped once its container has exited.
+func (r *Runtime) containerStopped(function *types.Function) {
+	function.IsRunning = false
+	function.Ready = false
+	function.Health = ""
+	r.warmups.Delete(function.ContainerId)
+	if r.ports != nil && function.Port != 0 && function.Port != function.HostPort {
+		r.ports.release(function.Port)
+	}
+}
+
 func (r *Runtime) clearFunctionContainers() error {
 	ctx := context.Background()
-	summary, err := r.cli.ContainerList(ctx, container.ListOptions{})
+	// Left by this instance's previous run, e.g. if it was killed
+	summary, err := r.cli.ContainerList(ctx, container.ListOptions{
+		All:     !r.keepStopped,
+		Filters: filters.NewArgs(instanceFilter(r.instance)),
+	})
 	if err != nil {
 		return err
 	}
 
 	stopTimeout := 0 // Don't wait for graceful shutdown
-	for _, fun := range r.functions {
-		// Check container state
-		for _, summ := range summary {
-			if summ.Image == fun.ImageName {
-				err := r.cli.ContainerStop(ctx, summ.ID, container.StopOptions{
-					Timeout: &stopTimeout,
-				})
-				if err != nil {
-					return err
-				}
-
-				log.Printf("Stopped existing container %v\n", summ.Names)
+	for _, summ := range summary {
+		function := summ.Labels[labelFunction]
+		if summ.State == container.StateRunning {
+			err := r.cli.ContainerStop(ctx, summ.ID, container.StopOptions{
+				Timeout: &stopTimeout,
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("Stopped existing function %v container %v\n", function, summ.ID[:12])
+		}
+		if !r.keepStopped {
+			err := r.cli.ContainerRemove(ctx, summ.ID, container.RemoveOptions{})
+			if err != nil {
+				return err
 			}
+			log.Printf("Removed existing function %v container %v\n", function, summ.ID[:12])
 		}
 	}
 
 	return nil
 }
 
-func (r *Runtime) callFunction(function *types.Function, path string, prevReq *http.Request) ([]byte, error) {
+// newProxyClient returns a client passing function responses through as they are.
+func newProxyClient() *http.Client {
+	// Keep bodies as the function encoded them, so ETags and ranges stay valid
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	transport.DialContext = proxyResolver.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // Redirects are the client's to follow
+		},
+	}
+}
+
+// Hop-by-hop headers, which apply to a single connection and aren't proxied
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes hop-by-hop headers, including those listed in Connection.
+func removeHopHeaders(h http.Header) {
+	for _, c := range h.Values("Connection") {
+		for _, name := range strings.Split(c, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// proxyHeader returns the headers of a request proxied to a function: the client's,
+// without hop-by-hop headers, and with X-Forwarded-* describing the client's request.
+func proxyHeader(r *http.Request) http.Header {
+	h := r.Header.Clone()
+	removeHopHeaders(h)
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+	if r.Host != "" && h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	return h
+}
+
+// functionURL returns the URL of path on the function's host port.
+// IPv6 host addresses are bracketed. Functions on sockets are dialed whatever the host.
+func (r *Runtime) functionURL(function *types.Function, path string) string {
+	if function.SocketPath != "" {
+		return "http://function" + path
+	}
+	return "http://" + net.JoinHostPort(r.hostIP, strconv.Itoa(function.Port)) + path
+}
+
+func (r *Runtime) callFunction(function *types.Function, path string, prevReq *http.Request) (*FunctionResponse, error) {
+	// Work whose result can't be used in time isn't started
+	deadline, hasDeadline := invocationDeadline(function, prevReq)
+	if hasDeadline && !time.Now().Before(deadline) {
+		return nil, deadlineError(function)
+	}
+
+	cold := !function.IsRunning
+	if cold {
+		err := r.crashLoopError(function)
+		if err != nil {
+			return nil, invocationError(ErrClassCrashLoop, http.StatusServiceUnavailable, err)
+		}
+	}
+	start := time.Now()
 	err := r.policy.PreFunctionCall(function)
 	if err != nil {
-		return nil, err
+		r.functionStartFailed(function, err)
+		return nil, invocationError(ErrClassStartFailed, http.StatusServiceUnavailable, err)
+	}
+
+	err = r.waitReady(function)
+	if err != nil {
+		r.functionStartFailed(function, err)
+		return nil, invocationError(ErrClassUnreachable, http.StatusBadGateway, err)
+	}
+	r.warmUp(function)
+	var coldStart time.Duration
+	if cold {
+		coldStart = time.Since(start)
 	}
 
+	reqBody := prevReq.Body
+	if reqBody != nil && reqBody != http.NoBody {
+		reqBody = io.NopCloser(reqBody) // Not closed by a failed attempt, so it can be retried
+	}
+	target := path
+	if prevReq.URL.RawQuery != "" {
+		target += "?" + prevReq.URL.RawQuery
+	}
+	header := proxyHeader(prevReq)
+	header.Del(flagsHeader)
+	if flags := r.flags.Flags(function); len(flags) > 0 {
+		header.Set(flagsHeader, flagsValue(flags))
+	}
+
+	// Calls time out, body and all, at their deadline
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if hasDeadline {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	setContextHeaders(header, function, cold, deadline, hasDeadline)
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, prevReq.Method, r.functionURL(function, target), reqBody)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		req.ContentLength = prevReq.ContentLength
+		return r.functionClient(function).Do(req)
+	}
+
+	resp, err := do()
+	if isDialError(err) && r.refreshPort(function) {
+		// Nothing was sent, retry on the container's new port
+		resp, err = do()
+	}
+	if err != nil {
+		cancel()
+		log.Printf("Error calling function %v: %v", function.Name, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, deadlineError(function)
+		}
+		return nil, bodyError(err, ErrClassUnreachable, http.StatusBadGateway)
+	}
+	removeHopHeaders(resp.Header)
+
+	// The call ends once the body has streamed to the caller
+	fresp := &FunctionResponse{Status: resp.StatusCode, Header: resp.Header, Body: resp.Body, ColdStart: coldStart}
+	fresp.onClose(func() error {
+		cancel()
+		err := r.policy.PostFunctionCall(function)
+		if err != nil {
+			log.Printf("Policy failed ending call of function %v: %v\n", function.Name, err)
+		}
+		return err
+	})
+	return fresp, nil
+}
+
+// waitReady waits until the function accepts connections and its container is healthy,
+// up to the ready timeout, extended by the time its health check may take.
+func (r *Runtime) waitReady(function *types.Function) error {
+	timeout := r.functionReadyTimeout(function)
+	deadline := time.Now().Add(timeout)
+	lastRefresh := time.Now()
 	for {
-		resp, err := http.Head("http://127.0.0.1:" + strconv.Itoa(function.Port))
-		if err == nil {
-			resp.Body.Close()
-			break
+		ready, err := r.probeReady(function)
+		if ready {
+			err = healthy(function)
+			if err == nil {
+				function.Ready = true
+				return nil
+			}
+		} else if err == nil {
+			err = fmt.Errorf("%v is not healthy", function.ReadyPath)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("function %v not ready after %v: %w", function.Name, timeout, err)
+		}
+		// Docker may have restarted the container on another port
+		if time.Since(lastRefresh) >= time.Second {
+			lastRefresh = time.Now()
+			r.refreshPort(function)
+		}
+		time.Sleep(probeInterval(function))
+	}
+}
+
+// RestartFunction stops the function's container, if running, and starts a new one.
+func (r *Runtime) RestartFunction(function *types.Function) error {
+	if function.Remote {
+		return fmt.Errorf("function %v runs on other cluster nodes", function.Name)
+	}
+	if function.IsRunning {
+		err := r.stopFunction(function)
+		if err != nil {
+			return err
 		}
-		time.Sleep(5 * time.Millisecond)
 	}
+	err := r.startFunction(function)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restarted function %v\n", function.Name)
+	return nil
+}
 
-	url := "http://127.0.0.1:" + strconv.Itoa(function.Port) + path
-	req, err := http.NewRequest(prevReq.Method, url, nil)
+// StartFunction starts the function's container if it isn't running. Its policy may stop it
+// again, e.g. once idle.
+func (r *Runtime) StartFunction(function *types.Function) error {
+	if function.Remote {
+		return fmt.Errorf("function %v runs on other cluster nodes", function.Name)
+	}
+	if function.IsRunning {
+		return nil
+	}
+	return r.startFunction(function)
+}
 
+// StopFunction stops the function's container if it is running. Unlike disabling it, its
+// policy starts it again on its next invocation.
+func (r *Runtime) StopFunction(function *types.Function) error {
+	if function.Remote {
+		return fmt.Errorf("function %v runs on other cluster nodes", function.Name)
+	}
+	if !function.IsRunning {
+		return nil
+	}
+	return r.stopFunction(function)
+}
+
+// FunctionLogs returns the last tail lines of a function container's output, with its secrets redacted.
+func (r *Runtime) FunctionLogs(function *types.Function, tail int) ([]string, error) {
+	if function.ContainerId == "" {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	out, err := r.cli.ContainerLogs(ctx, function.ContainerId, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(tail),
+	})
 	if err != nil {
 		return nil, err
 	}
+	defer out.Close()
 
-	req.Header = prevReq.Header
-	resp, err := http.DefaultClient.Do(req)
+	// Container output is multiplexed unless it has a TTY
+	var buf bytes.Buffer
+	_, err = stdcopy.StdCopy(&buf, &buf, out)
 	if err != nil {
-		log.Printf("Error calling function %v: %v", function.Name, err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	logs := buf.String()
+	if values, exists := r.secretValues.Load(function.Name); exists {
+		logs = redactSecrets(logs, values.([]string))
+	}
+	return strings.Split(strings.TrimRight(logs, "\n"), "\n"), nil
+}
+
+// redactingWriter redacts secret values from whole lines written to it, holding back partial lines.
+type redactingWriter struct {
+	w       io.Writer
+	values  []string
+	partial []byte
+	flush   func()
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.partial = append(rw.partial, p...)
+	end := bytes.LastIndexByte(rw.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	_, err := io.WriteString(rw.w, redactSecrets(string(rw.partial[:end+1]), rw.values))
+	rw.partial = slices.Clone(rw.partial[end+1:])
+	rw.flush()
+	return len(p), err
+}
+
+// StreamFunctionLogs writes the last tail lines of a function container's output to w, with its
+// secrets redacted, then with follow, its new output until ctx is done or the container stops.
+// flush is called after each write.
+func (r *Runtime) StreamFunctionLogs(ctx context.Context, function *types.Function, tail int, follow bool, w io.Writer, flush func()) error {
+	if function.ContainerId == "" {
+		return fmt.Errorf("function %v isn't running", function.Name)
+	}
+	out, err := r.cli.ContainerLogs(ctx, function.ContainerId, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Tail:       strconv.Itoa(tail),
+	})
 	if err != nil {
-		log.Printf("Cannot read function %v response: %v\n", function.Name, err)
-		return nil, err
+		return err
 	}
+	defer out.Close()
 
-	err = r.policy.PostFunctionCall(function)
+	rw := &redactingWriter{w: w, flush: flush}
+	if values, exists := r.secretValues.Load(function.Name); exists {
+		rw.values = values.([]string)
+	}
+	_, err = stdcopy.StdCopy(rw, rw, out)
+	if len(rw.partial) > 0 {
+		io.WriteString(w, redactSecrets(string(rw.partial), rw.values)+"\n")
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// FunctionByName returns the function with the given name, or nil if there is none.
+func (r *Runtime) FunctionByName(name string) *types.Function {
+	for _, fun := range r.functions {
+		if fun.Name == name {
+			return fun
+		}
+	}
+	return nil
+}
+
+// containerEnv converts env to the KEY=value list Docker expects.
+func containerEnv(env map[string]string) []string {
+	var list []string
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	return list
+}
+
+// tenantInstance returns the instance of function serving the tenant named in the request,
+// adding it to the policy on the tenant's first request.
+// Requests naming no tenant are served by the function itself.
+func (r *Runtime) tenantInstance(function *types.Function, req *http.Request) (*types.Function, error) {
+	tenancy := function.Tenancy
+	tenantName := req.Header.Get(tenancy.Header)
+	if tenantName == "" {
+		return function, nil
+	}
+
+	_, exists := tenancy.Tenants[tenantName]
+	if !exists {
+		err := fmt.Errorf("function %v has no tenant %v", function.Name, tenantName)
+		return nil, invocationError(ErrClassNotFound, http.StatusNotFound, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := function.Name + "@" + tenantName
+	if instance, exists := r.instances[name]; exists {
+		return instance, nil
+	}
+
+	// Same settings as the function, with its own containers
+	instance := &types.Function{}
+	*instance = *function
+	instance.Name = name
+	instance.Base = function.Name
+	instance.Env = tenantEnv(function, tenantName)
+	instance.Tenant = tenantName
+	instance.Tenancy = nil
+	instance.ContainerId = ""
+	instance.IsRunning = false
+	instance.Port = 0
+	instance.SocketPath = ""
+	err := r.policy.AddFunction(instance)
 	if err != nil {
-		return nil, err
+		return nil, invocationError(ErrClassStartFailed, http.StatusServiceUnavailable, err)
 	}
-	return body, nil
+	r.instances[name] = instance
+	log.Printf("Added function %v instance for tenant %v\n", function.Name, tenantName)
+	return instance, nil
 }
 
-func (r *Runtime) CallFunctionByName(name string, path string, prevReq *http.Request) ([]byte, error) {
+// tenantEnv returns the env of the function's instance for a tenant.
+func tenantEnv(function *types.Function, tenant string) map[string]string {
+	env := maps.Clone(function.Env)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	maps.Copy(env, function.Tenancy.Tenants[tenant].Env)
+	env["SLRUN_TENANT"] = tenant
+	return env
+}
+
+// SetFunctionEnabled enables or disables a function.
+// Disabling stops its containers, including those of its tenants.
+func (r *Runtime) SetFunctionEnabled(function *types.Function, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if function.IsEnabled == enabled {
+		return nil
+	}
+
+	if function.Remote {
+		// No containers here, the nodes running it enable or disable it there
+		function.IsEnabled = enabled
+		log.Printf("Set remote function %v enabled: %v\n", function.Name, enabled)
+		return nil
+	}
+
+	if enabled {
+		function.IsEnabled = true
+		log.Printf("Enabled function %v\n", function.Name)
+		return r.policy.AddFunction(function)
+	}
+
+	function.IsEnabled = false
+	err := r.policy.RemoveFunction(function)
+	if err != nil {
+		return err
+	}
+	for name, instance := range r.instances {
+		if instance.Base != function.Name {
+			continue
+		}
+		err := r.policy.RemoveFunction(instance)
+		if err != nil {
+			return err
+		}
+		delete(r.instances, name)
+	}
+	if set, exists := r.replicas[function.Name]; exists {
+		set.replicas = set.replicas[:1]
+	}
+	log.Printf("Disabled function %v\n", function.Name)
+	return nil
+}
+
+func (r *Runtime) CallFunctionByName(name string, path string, prevReq *http.Request) (*FunctionResponse, error) {
 	for _, fun := range r.functions {
 		if fun.Name == name {
-			return r.callFunction(fun, path, prevReq)
+			if node := r.cluster.route(fun, prevReq); node != nil {
+				return r.cluster.Forward(node, fun, path, prevReq)
+			}
+			if fun.Remote {
+				err := fmt.Errorf("function %v isn't placed on this node", name)
+				return nil, invocationError(ErrClassNotFound, http.StatusNotFound, err)
+			}
+			if record := r.quarantine.Quarantined(name); record != nil {
+				err := fmt.Errorf("function %v is quarantined since %v", name, record.Since.Format(time.RFC3339))
+				return nil, invocationError(ErrClassQuarantined, http.StatusServiceUnavailable, err)
+			}
+			if !fun.IsEnabled {
+				err := fmt.Errorf("function %v is disabled", name)
+				return nil, invocationError(ErrClassDisabled, http.StatusServiceUnavailable, err)
+			}
+
+			// Holds a slot of its bulkhead until its response body is closed
+			bulkheadRelease := func() {}
+			if b := r.bulkhead(fun); b != nil {
+				var err error
+				bulkheadRelease, err = b.acquire(prevReq.Context(), name)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			// In flight until its response body is closed
+			inflight := r.inflight(name)
+			inflight.Add(1)
+
+			instance := fun
+			var err error
+			switch {
+			case fun.Tenancy != nil:
+				instance, err = r.tenantInstance(fun, prevReq)
+			case fun.Replicas != nil:
+				instance, err = r.pickReplica(fun)
+			}
+			if err != nil {
+				inflight.Add(-1)
+				bulkheadRelease()
+				return nil, err
+			}
+			release := func() {
+				inflight.Add(-1)
+				bulkheadRelease()
+				if fun.Replicas != nil {
+					r.releaseReplica(fun, instance)
+				}
+			}
+
+			resp, err := r.callFunction(instance, path, prevReq)
+			if err != nil {
+				release()
+			} else {
+				resp.onClose(func() error {
+					release()
+					return nil
+				})
+			}
+
+			// Nothing was sent to a function that failed to start, other nodes may serve it
+			var ierr *InvocationError
+			if errors.As(err, &ierr) && ierr.Class == ErrClassStartFailed && r.cluster.canForward(fun, prevReq) {
+				node := r.cluster.pick(fun.Name, false)
+				log.Printf("Function %v failed to start, forwarding to node %v\n", name, node.Name)
+				return r.cluster.Forward(node, fun, path, prevReq)
+			}
+			return resp, err
 		}
 	}
 
 	log.Printf("Unknown function requested %v\n", name)
-	return nil, fmt.Errorf("function %v not found", name)
+	return nil, invocationError(ErrClassNotFound, http.StatusNotFound, fmt.Errorf("function %v not found", name))
 }
 
 func (r *Runtime) Start() error {
@@ -232,12 +1023,21 @@ func (r *Runtime) Start() error {
 	if err != nil {
 		return err
 	}
+	r.waitStarted()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stopWatch = cancel
+	go r.watchContainers(ctx)
+	go r.runScaler(ctx)
+	r.quarantine.Start()
 
 	go func() {
 		for {
 			time.Sleep(r.tickRate)
 
+			r.mu.Lock()
 			err = r.policy.OnTick()
+			r.mu.Unlock()
 			if err != nil {
 				log.Printf("Error on tick: %v\n", err)
 			}
@@ -247,16 +1047,47 @@ func (r *Runtime) Start() error {
 	return nil
 }
 
+// Seconds containers of functions without a stop timeout get to exit when slrun stops, as with docker stop
+const shutdownStopTimeout = 10
+
+// Stop stops the runtime and its functions' containers.
 func (r *Runtime) Stop() error {
+	if r.stopWatch != nil {
+		r.stopWatch()
+	}
+	r.quarantine.Stop()
+
 	// Stop function containers
-	for _, fun := range r.functions {
-		log.Printf("Stopping function %v container %v\n", fun.Name, fun.ContainerId)
-		err := r.stopFunction(fun)
-		if err != nil {
-			log.Printf("Cannot stop function %v: %v\n", fun.Name, err)
-			return err
+	functions := slices.Clone(r.functions)
+	r.mu.Lock()
+	for _, instance := range r.instances {
+		functions = append(functions, instance)
+	}
+	r.mu.Unlock()
+
+	// Stopped at once, each getting its stop timeout, or Docker's default if it has none
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, fun := range functions {
+		if !fun.IsRunning {
+			continue
 		}
-		log.Printf("Stopped function %v\n", fun.Name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("Stopping function %v container %v\n", fun.Name, fun.ContainerId)
+			err := r.stopFunctionWithin(fun, cmp.Or(fun.StopTimeout, shutdownStopTimeout))
+			if err != nil {
+				log.Printf("Cannot stop function %v: %v\n", fun.Name, err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("function %v: %w", fun.Name, err))
+				mu.Unlock()
+				return
+			}
+			log.Printf("Stopped function %v\n", fun.Name)
+		}()
 	}
-	return nil
+	wg.Wait()
+	return errors.Join(errs...)
 }
@@ -0,0 +1,96 @@
+package slrun
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const scaffoldConfig = `{
+  "policy": "cold_on_idle",
+  "admin_address": "127.0.0.1:9090",
+  "functions": [
+    {
+      "name": "%[1]v",
+      "build_dir": "./functions/%[1]v"
+    }
+  ]
+}
+`
+
+const scaffoldDockerfile = `FROM python:3.11-slim
+WORKDIR /app
+COPY . .
+EXPOSE 80
+CMD ["python", "function.py"]
+`
+
+const scaffoldFunction = `import os
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+
+class Handler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header("Content-Type", "text/plain")
+        self.end_headers()
+        self.wfile.write(b"Hello from %[1]v! path=" + self.path.encode())
+
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers.get("Content-Length", 0)))
+        self.send_response(200)
+        self.send_header("Content-Type", self.headers.get("Content-Type", "text/plain"))
+        self.end_headers()
+        self.wfile.write(body)
+
+
+if __name__ == "__main__":
+    port = int(os.environ.get("SLRUN_PORT", 80))
+    print(f"Listening on port {port}", flush=True)
+    HTTPServer(("", port), Handler).serve_forever()
+`
+
+// Scaffold writes a config at configPath with one function, and the function's build dir
+// next to it. Existing files are kept unless force is set. Returns the files written.
+func Scaffold(configPath string, function string, force bool) ([]string, error) {
+	if !functionNamePattern.MatchString(function) {
+		return nil, fmt.Errorf("invalid function name %q", function)
+	}
+	buildDir := filepath.Join(filepath.Dir(configPath), "functions", function)
+	files := []struct {
+		path    string
+		content string
+	}{
+		{configPath, fmt.Sprintf(scaffoldConfig, function)},
+		{filepath.Join(buildDir, "Dockerfile"), scaffoldDockerfile},
+		{filepath.Join(buildDir, "function.py"), fmt.Sprintf(scaffoldFunction, function)},
+	}
+
+	if !force {
+		for _, f := range files {
+			_, err := os.Stat(f.path)
+			if err == nil {
+				return nil, fmt.Errorf("%v already exists, use --force to overwrite it", f.path)
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	var written []string
+	for _, f := range files {
+		err := os.MkdirAll(filepath.Dir(f.path), 0755)
+		if err != nil {
+			return written, err
+		}
+		err = os.WriteFile(f.path, []byte(f.content), 0644)
+		if err != nil {
+			return written, err
+		}
+		written = append(written, f.path)
+	}
+	return written, nil
+}
@@ -0,0 +1,223 @@
+package slrun
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func validateReplicas(config *types.Config) error {
+	for _, f := range config.Functions {
+		replicas := f.Replicas
+		if replicas == nil {
+			continue
+		}
+		if config.Policy != types.ColdOnIdlePolicy {
+			return fieldError(functionField(config, f, "replicas"), "function %s replicas need the %s policy", f.Name, types.ColdOnIdlePolicy)
+		}
+		if f.Tenancy != nil {
+			return fieldError(functionField(config, f, "replicas"), "function %s can't have both replicas and tenancy", f.Name)
+		}
+		if replicas.Max <= 0 {
+			replicas.Max = max(replicas.Min, 4)
+		}
+		if replicas.Min < 0 || replicas.Min > replicas.Max {
+			return fieldError(functionField(config, f, "replicas.min"), "function %s replicas min must be between 0 and max", f.Name)
+		}
+		if replicas.TargetInflight <= 0 {
+			replicas.TargetInflight = 1
+		}
+		if replicas.IdleTimeout == "" {
+			replicas.IdleTimeout = "5s"
+		}
+		idle, err := time.ParseDuration(replicas.IdleTimeout)
+		if err != nil {
+			return fieldError(functionField(config, f, "replicas.idle_timeout"), "function %s has invalid replicas idle timeout: %w", f.Name, err)
+		}
+		f.IdleAfter = idle
+	}
+	return nil
+}
+
+// replicaSet is the replicas of a function, the function itself first.
+// Guarded by the runtime's mu.
+type replicaSet struct {
+	replicas []*types.Function
+	inflight map[*types.Function]int // Requests in flight to each replica
+}
+
+// pickReplica returns the replica of function to serve a request, counting the request in flight
+// until releaseReplica. It prefers the least loaded running replica under its target, then a stopped
+// replica, then a new one while under max. At max, the least loaded running replica takes it anyway.
+func (r *Runtime) pickReplica(function *types.Function) (*types.Function, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set := r.replicas[function.Name]
+	target := function.Replicas.TargetInflight
+
+	// Replicas with requests in flight are running or starting
+	var least, stopped *types.Function
+	for _, replica := range set.replicas {
+		if !replica.IsRunning && set.inflight[replica] == 0 {
+			if stopped == nil {
+				stopped = replica
+			}
+			continue
+		}
+		if least == nil || set.inflight[replica] < set.inflight[least] {
+			least = replica
+		}
+	}
+
+	pick := least
+	if least == nil || set.inflight[least] >= target {
+		switch {
+		case stopped != nil:
+			pick = stopped
+		case len(set.replicas) < function.Replicas.Max:
+			replica, err := r.addReplica(function, set)
+			if err != nil {
+				return nil, invocationError(ErrClassStartFailed, http.StatusServiceUnavailable, err)
+			}
+			pick = replica
+		}
+	}
+	set.inflight[pick]++
+	return pick, nil
+}
+
+// callsInFlight reports whether calls to the function, or instance, are in flight. Replicas
+// count their own calls, tenant instances those of their function. Must hold r.mu, as the
+// policy's ticks do.
+func (r *Runtime) callsInFlight(function *types.Function) bool {
+	base := cmp.Or(function.Base, function.Name)
+	if set, exists := r.replicas[base]; exists {
+		return set.inflight[function] > 0
+	}
+	return r.inflight(base).Load() > 0
+}
+
+func (r *Runtime) releaseReplica(function *types.Function, replica *types.Function) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set := r.replicas[function.Name]
+	set.inflight[replica]--
+	if set.inflight[replica] <= 0 {
+		delete(set.inflight, replica)
+	}
+}
+
+// addReplica adds a replica of function to the policy, which starts it on its first call. Must hold r.mu.
+func (r *Runtime) addReplica(function *types.Function, set *replicaSet) (*types.Function, error) {
+	index := len(set.replicas) + 1
+	name := function.Name + "#" + strconv.Itoa(index)
+
+	// Same settings as the function, with its own container
+	replica := &types.Function{}
+	*replica = *function
+	replica.Name = name
+	replica.Base = function.Name
+	replica.Env = replicaEnv(function, index)
+	replica.ContainerId = ""
+	replica.IsRunning = false
+	replica.Port = 0
+	replica.HostPort = 0
+	replica.SocketPath = ""
+	err := r.policy.AddFunction(replica)
+	if err != nil {
+		return nil, err
+	}
+	set.replicas = append(set.replicas, replica)
+	r.instances[name] = replica
+	log.Printf("Added function %v replica %v\n", function.Name, index)
+	return replica, nil
+}
+
+// replicaEnv returns the env of the function's replica at index, from 1.
+func replicaEnv(function *types.Function, index int) map[string]string {
+	env := maps.Clone(function.Env)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	env["SLRUN_REPLICA"] = strconv.Itoa(index)
+	return env
+}
+
+// scaleReplicas keeps the replicas each function needs running: at least its min,
+// and as many as its scaling rules ask for, up to its max. Others stop when idle.
+// Replicas start outside r.mu, so invocations aren't held up meanwhile.
+func (r *Runtime) scaleReplicas() {
+	var wanted []*types.Function
+	r.mu.Lock()
+	for _, f := range r.functions {
+		set, exists := r.replicas[f.Name]
+		if !exists || !f.IsEnabled {
+			continue
+		}
+
+		want := min(max(f.Replicas.Min, f.Desired), f.Replicas.Max)
+		for i := range want {
+			if i == len(set.replicas) {
+				_, err := r.addReplica(f, set)
+				if err != nil {
+					log.Printf("Cannot add function %v replica: %v\n", f.Name, err)
+					break
+				}
+			}
+			wanted = append(wanted, set.replicas[i])
+		}
+	}
+	r.mu.Unlock()
+
+	for _, replica := range wanted {
+		// Called like an invocation, so the policy starts it if stopped and restarts its idle timer
+		if !replica.IsRunning && r.crashLoopError(replica) != nil {
+			continue
+		}
+		err := r.policy.PreFunctionCall(replica)
+		if err != nil {
+			r.functionStartFailed(replica, err)
+			log.Printf("Cannot start function %v: %v\n", replica.Name, err)
+		}
+	}
+}
+
+// runScaler scales functions' replicas every second until ctx is done.
+func (r *Runtime) runScaler(ctx context.Context) {
+	if len(r.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.scaleReplicas()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Replicas returns how many of a function's replicas are running, zero if it doesn't scale out.
+func (r *Runtime) Replicas(function *types.Function) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set, exists := r.replicas[function.Name]
+	if !exists {
+		return 0
+	}
+	running := 0
+	for _, replica := range set.replicas {
+		if replica.IsRunning {
+			running++
+		}
+	}
+	return running
+}
@@ -0,0 +1,86 @@
+package slrun
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/policy"
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestScaleReplicas(t *testing.T) {
+	f := &types.Function{Name: "func1", IsEnabled: true, Replicas: &types.Replicas{Max: 3, TargetInflight: 1}, IdleAfter: time.Millisecond}
+	r := &Runtime{
+		functions: []*types.Function{f},
+		instances: make(map[string]*types.Function),
+		replicas:  map[string]*replicaSet{"func1": {replicas: []*types.Function{f}, inflight: make(map[*types.Function]int)}},
+	}
+	p := &policy.ColdOnIdle{
+		Funcs:     []*types.Function{f},
+		StartFunc: func(f *types.Function) error { f.IsRunning = true; return nil },
+		StopFunc:  func(f *types.Function) error { f.IsRunning = false; return nil },
+		InFlight:  r.callsInFlight,
+	}
+	p.OnRuntimeStart()
+	r.policy = p
+	pick := func() string {
+		replica, err := r.pickReplica(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return replica.Name
+	}
+
+	// Requests over the target in flight scale out, up to max, then share the least loaded
+	var picked []string
+	for range 4 {
+		picked = append(picked, pick())
+	}
+	if want := []string{"func1", "func1#2", "func1#3", "func1"}; !slices.Equal(picked, want) {
+		t.Errorf("pickReplica() = %v, want %v", picked, want)
+	}
+	r.releaseReplica(f, r.instances["func1#3"])
+	if got := pick(); got != "func1#3" {
+		t.Errorf("pickReplica() after a release = %v, want the replica with none in flight", got)
+	}
+	for _, replica := range r.replicas["func1"].replicas {
+		for r.replicas["func1"].inflight[replica] > 0 {
+			r.releaseReplica(f, replica)
+		}
+	}
+
+	// The scaling rules' desired replicas are kept running, others stop once idle
+	f.Desired = 2
+	r.scaleReplicas()
+	if got := r.Replicas(f); got != 2 {
+		t.Errorf("Replicas() with 2 desired = %v, want 2", got)
+	}
+	f.Desired = 0
+	time.Sleep(10 * time.Millisecond)
+	r.mu.Lock()
+	p.OnTick()
+	r.mu.Unlock()
+	if got := r.Replicas(f); got != 0 {
+		t.Errorf("Replicas() once idle = %v, want 0", got)
+	}
+}
+
+func TestCallsInFlight(t *testing.T) {
+	func1 := &types.Function{Name: "func1"}
+	replica := &types.Function{Name: "func1#2", Base: "func1"}
+	func2 := &types.Function{Name: "func2"}
+	tenant := &types.Function{Name: "func2@acme", Base: "func2"}
+	r := &Runtime{replicas: map[string]*replicaSet{
+		"func1": {replicas: []*types.Function{func1, replica}, inflight: map[*types.Function]int{func1: 1}},
+	}}
+	r.inflight("func1").Add(1)
+	r.inflight("func2").Add(1)
+
+	// Replicas are idle while others are busy, so they scale down
+	for f, want := range map[*types.Function]bool{func1: true, replica: false, func2: true, tenant: true} {
+		if got := r.callsInFlight(f); got != want {
+			t.Errorf("callsInFlight(%v) = %v, want %v", f.Name, got, want)
+		}
+	}
+}
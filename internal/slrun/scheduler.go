@@ -0,0 +1,117 @@
+package slrun
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Scheduler applies functions' scale profiles as time passes.
+type Scheduler struct {
+	runtime  *Runtime
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func NewScheduler(runtime *Runtime) *Scheduler {
+	return &Scheduler{
+		runtime:  runtime,
+		interval: 15 * time.Second,
+		stop:     make(chan struct{}),
+	}
+}
+
+// parseClock parses HH:MM into minutes since midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateScaleProfile(p *types.ScaleProfile) error {
+	for _, day := range p.Days {
+		if !slices.Contains(weekdays, strings.ToLower(day)) {
+			return fmt.Errorf("invalid day %q", day)
+		}
+	}
+	if _, err := parseClock(p.From); err != nil {
+		return err
+	}
+	if _, err := parseClock(p.To); err != nil {
+		return err
+	}
+	return nil
+}
+
+// profileActive reports whether a profile's window contains t.
+// Windows wrapping past midnight belong to the day they start on.
+func profileActive(p *types.ScaleProfile, t time.Time) bool {
+	from, _ := parseClock(p.From)
+	to, _ := parseClock(p.To)
+	now := t.Hour()*60 + t.Minute()
+
+	day := t.Weekday()
+	var inWindow bool
+	if from <= to {
+		inWindow = now >= from && now < to
+	} else if now >= from {
+		inWindow = true
+	} else if now < to {
+		inWindow = true
+		day = (day + 6) % 7 // Started yesterday
+	}
+	if !inWindow {
+		return false
+	}
+
+	if len(p.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(p.Days, func(d string) bool { return strings.ToLower(d) == weekdays[day] })
+}
+
+// apply sets each function's state to what its profiles prescribe at t.
+func (s *Scheduler) apply(t time.Time) {
+	for _, f := range s.runtime.functions {
+		enabled := !f.Disabled
+		for _, p := range f.ScaleProfiles {
+			if profileActive(p, t) && p.Enabled != nil {
+				enabled = *p.Enabled
+			}
+		}
+
+		err := s.runtime.SetFunctionEnabled(f, enabled)
+		if err != nil {
+			log.Printf("Scheduler: cannot apply profile to function %v: %v\n", f.Name, err)
+		}
+	}
+}
+
+// Start applies scale profiles now and on every interval.
+func (s *Scheduler) Start() {
+	s.apply(time.Now())
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				s.apply(t)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
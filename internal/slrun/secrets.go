@@ -0,0 +1,102 @@
+package slrun
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Replaces secret values in function logs slrun shows
+const redacted = "[redacted]"
+
+// Secret values shorter than this aren't redacted, they would mangle unrelated output
+const minRedactedLength = 4
+
+// validateSecrets adds the config's env and secrets to each function, the function's own
+// taking precedence, and checks every secret can be read.
+func validateSecrets(config *types.Config) error {
+	for i, s := range config.Secrets {
+		err := validateSecret(s)
+		if err != nil {
+			return fieldError(fmt.Sprintf("secrets[%d]", i), "config secret %v: %w", s.Name, err)
+		}
+	}
+
+	for _, f := range config.Functions {
+		if len(config.Env) > 0 {
+			env := maps.Clone(config.Env)
+			maps.Copy(env, f.Env)
+			f.Env = env
+		}
+
+		for i, s := range f.Secrets {
+			err := validateSecret(s)
+			if err != nil {
+				return fieldError(functionField(config, f, fmt.Sprintf("secrets[%d]", i)), "function %s secret %v: %w", f.Name, s.Name, err)
+			}
+		}
+		for _, s := range config.Secrets {
+			overridden := slices.ContainsFunc(f.Secrets, func(fs *types.Secret) bool { return fs.Name == s.Name })
+			if !overridden {
+				f.Secrets = append(f.Secrets, s)
+			}
+		}
+	}
+	return nil
+}
+
+func validateSecret(secret *types.Secret) error {
+	if secret.Name == "" {
+		return fmt.Errorf("has no name")
+	}
+	if (secret.File == "") == (secret.Env == "") {
+		return fmt.Errorf("must have one of file and env")
+	}
+	_, err := readSecret(secret)
+	return err
+}
+
+// readSecret returns the value of secret, without the trailing newline of files.
+func readSecret(secret *types.Secret) (string, error) {
+	if secret.File != "" {
+		bytes, err := os.ReadFile(secret.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(bytes), "\r\n"), nil
+	}
+	value, exists := os.LookupEnv(secret.Env)
+	if !exists {
+		return "", fmt.Errorf("env variable %v isn't set", secret.Env)
+	}
+	return value, nil
+}
+
+// secretEnv reads the function's secrets, returning them as KEY=value env variables and their values.
+// They are read on every container start, so rotated secrets are picked up by new containers.
+func secretEnv(function *types.Function) ([]string, []string, error) {
+	var env, values []string
+	for _, s := range function.Secrets {
+		value, err := readSecret(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read function %v secret %v: %w", function.Name, s.Name, err)
+		}
+		env = append(env, s.Name+"="+value)
+		values = append(values, value)
+	}
+	return env, values, nil
+}
+
+// redactSecrets replaces the secret values in s.
+func redactSecrets(s string, values []string) string {
+	for _, value := range values {
+		if len(value) >= minRedactedLength {
+			s = strings.ReplaceAll(s, value, redacted)
+		}
+	}
+	return s
+}
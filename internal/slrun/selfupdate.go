@@ -0,0 +1,265 @@
+package slrun
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"time"
+)
+
+// Release channels
+const (
+	ChannelStable     = "stable"
+	ChannelPrerelease = "prerelease" // Includes prereleases
+)
+
+// releasesURL lists slrun releases, newest first
+const releasesURL = "https://api.github.com/repos/marcorentap/slrun/releases"
+
+// UpdatePublicKey is the base64 ed25519 key release checksums are signed with, set at build time.
+// If set, self-update requires a valid checksums.txt.sig, otherwise it refuses to update unless insecure.
+var UpdatePublicKey = ""
+
+// UpdatePin is the release self-update installs, saved with --pin.
+type UpdatePin struct {
+	Channel string `json:"channel,omitempty"`
+	Version string `json:"version,omitempty"` // Exact release tag, overrides the channel
+}
+
+type release struct {
+	TagName    string         `json:"tag_name"`
+	Prerelease bool           `json:"prerelease"`
+	Draft      bool           `json:"draft"`
+	Assets     []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (r *release) asset(name string) *releaseAsset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+func updatePinFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "slrun", "update.json"), nil
+}
+
+// ReadUpdatePin returns the saved pin, empty if there is none.
+func ReadUpdatePin() (*UpdatePin, error) {
+	file, err := updatePinFile()
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &UpdatePin{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pin UpdatePin
+	err = json.Unmarshal(bytes, &pin)
+	return &pin, err
+}
+
+func WriteUpdatePin(pin *UpdatePin) error {
+	file, err := updatePinFile()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(pin, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, bytes, 0644)
+}
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+func download(url string) ([]byte, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// findRelease returns the release pin selects.
+func findRelease(pin *UpdatePin) (*release, error) {
+	body, err := download(releasesURL)
+	if err != nil {
+		return nil, err
+	}
+	var releases []*release
+	err = json.Unmarshal(body, &releases)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+		if pin.Version != "" {
+			if r.TagName == pin.Version {
+				return r, nil
+			}
+			continue
+		}
+		if r.Prerelease && pin.Channel != ChannelPrerelease {
+			continue
+		}
+		return r, nil
+	}
+
+	if pin.Version != "" {
+		return nil, fmt.Errorf("no slrun release %v", pin.Version)
+	}
+	return nil, fmt.Errorf("no slrun release on the %v channel", pin.Channel)
+}
+
+// verifyChecksum checks binary against its sha256 in checksums, lines of "<hex>  <name>".
+func verifyChecksum(checksums []byte, name string, binary []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[1] != name {
+			continue
+		}
+		sum := sha256.Sum256(binary)
+		if hex.EncodeToString(sum[:]) != fields[0] {
+			return fmt.Errorf("checksum mismatch for %v", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("no checksum for %v", name)
+}
+
+// verifySignature checks the base64 ed25519 signature of checksums with UpdatePublicKey.
+func verifySignature(checksums []byte, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(UpdatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid update public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid checksums signature: %w", err)
+	}
+	if !ed25519.Verify(key, checksums, sig) {
+		return fmt.Errorf("checksums signature verification failed")
+	}
+	return nil
+}
+
+// SelfUpdate replaces the running slrun binary with the release pin selects,
+// after verifying its checksum and the checksums' signature. Binaries built without an
+// UpdatePublicKey can't verify the signature, and only update if insecure.
+// Returns the installed version, or the current one if already up to date.
+func SelfUpdate(pin *UpdatePin, insecure bool) (string, error) {
+	if UpdatePublicKey == "" && !insecure {
+		return "", fmt.Errorf("this slrun was built without an update public key and can't verify releases, pass --insecure to update anyway")
+	}
+
+	r, err := findRelease(pin)
+	if err != nil {
+		return "", err
+	}
+	if r.TagName == Version {
+		return Version, nil
+	}
+
+	name := fmt.Sprintf("slrun_%v_%v", goruntime.GOOS, goruntime.GOARCH)
+	binaryAsset := r.asset(name)
+	checksumsAsset := r.asset("checksums.txt")
+	if binaryAsset == nil {
+		return "", fmt.Errorf("release %v has no binary for %v/%v", r.TagName, goruntime.GOOS, goruntime.GOARCH)
+	}
+	if checksumsAsset == nil {
+		return "", fmt.Errorf("release %v has no checksums.txt", r.TagName)
+	}
+
+	checksums, err := download(checksumsAsset.URL)
+	if err != nil {
+		return "", err
+	}
+	if UpdatePublicKey != "" {
+		sigAsset := r.asset("checksums.txt.sig")
+		if sigAsset == nil {
+			return "", fmt.Errorf("release %v has no checksums.txt.sig", r.TagName)
+		}
+		sig, err := download(sigAsset.URL)
+		if err != nil {
+			return "", err
+		}
+		err = verifySignature(checksums, sig)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "WARNING: not verifying the signature of release %v, its authenticity is unknown\n", r.TagName)
+	}
+
+	binary, err := download(binaryAsset.URL)
+	if err != nil {
+		return "", err
+	}
+	err = verifyChecksum(checksums, name, binary)
+	if err != nil {
+		return "", err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", err
+	}
+
+	// Write next to the binary so the rename replacing it is atomic
+	tmp := exe + ".new"
+	err = os.WriteFile(tmp, binary, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp, exe)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return r.TagName, nil
+}
@@ -0,0 +1,305 @@
+package slrun
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// SLO objectives, named in burn rate alerts
+const (
+	ObjectiveLatency = "latency"
+	ObjectiveErrors  = "errors"
+)
+
+func validateSLOs(config *types.Config) error {
+	for _, f := range config.Functions {
+		slo := f.SLO
+		if slo == nil {
+			continue
+		}
+		if slo.LatencyObjective == 0 && slo.ErrorObjective == 0 {
+			return fieldError(functionField(config, f, "slo"), "function %s slo needs a latency or error objective", f.Name)
+		}
+		for _, objective := range []float64{slo.LatencyObjective, slo.ErrorObjective} {
+			if objective < 0 || objective >= 1 {
+				return fieldError(functionField(config, f, "slo"), "function %s slo objectives must be between 0 and 1", f.Name)
+			}
+		}
+		if slo.LatencyObjective > 0 {
+			if slo.Latency == "" {
+				return fieldError(functionField(config, f, "slo.latency"), "function %s slo latency objective needs a latency", f.Name)
+			}
+			if _, err := time.ParseDuration(slo.Latency); err != nil {
+				return fieldError(functionField(config, f, "slo.latency"), "function %s has invalid slo latency: %w", f.Name, err)
+			}
+		}
+
+		if slo.Window == "" {
+			slo.Window = "24h"
+		}
+		if slo.AlertWindow == "" {
+			slo.AlertWindow = "1h"
+		}
+		if slo.BurnRate <= 0 {
+			slo.BurnRate = 14.4
+		}
+		window, err := time.ParseDuration(slo.Window)
+		if err != nil {
+			return fieldError(functionField(config, f, "slo.window"), "function %s has invalid slo window: %w", f.Name, err)
+		}
+		alertWindow, err := time.ParseDuration(slo.AlertWindow)
+		if err != nil {
+			return fieldError(functionField(config, f, "slo.alert_window"), "function %s has invalid slo alert window: %w", f.Name, err)
+		}
+		if alertWindow < 12*time.Minute || alertWindow > window {
+			return fieldError(functionField(config, f, "slo.alert_window"), "function %s slo alert window must be at least 12m and at most the window", f.Name)
+		}
+	}
+	return nil
+}
+
+// sloBucket counts a minute of a function's invocations.
+type sloBucket struct {
+	minute int64 // Unix minute counted, buckets of older minutes are stale
+	total  int
+	slow   int
+	errors int
+}
+
+// sloFunction tracks a function's invocations over its SLO window, a bucket per minute.
+type sloFunction struct {
+	name        string
+	config      *types.SLO
+	latency     time.Duration
+	window      time.Duration
+	alertWindow time.Duration
+	buckets     []sloBucket     // Ring of the window's minutes
+	burning     map[string]bool // Objectives whose burn rate is alerting
+}
+
+// sums returns the invocations counted over the last d.
+func (f *sloFunction) sums(d time.Duration, now time.Time) (total int, slow int, errors int) {
+	minute := now.Unix() / 60
+	from := minute - int64(d/time.Minute) + 1
+	for _, b := range f.buckets {
+		if b.minute >= from && b.minute <= minute {
+			total += b.total
+			slow += b.slow
+			errors += b.errors
+		}
+	}
+	return total, slow, errors
+}
+
+// burnRate is how fast a share of bad invocations spends the error budget,
+// 1 spending exactly the budget over the window.
+func burnRate(bad int, total int, objective float64) float64 {
+	if total == 0 || objective == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - objective)
+}
+
+// SLOStatus is a function's compliance with its SLO over its window.
+type SLOStatus struct {
+	Function         string   `json:"function"`
+	Window           string   `json:"window"`
+	Invocations      int      `json:"invocations"`
+	LatencyObjective float64  `json:"latency_objective,omitempty"`
+	LatencyGood      float64  `json:"latency_good,omitempty"` // Share of invocations faster than the latency
+	LatencyBurnRate  float64  `json:"latency_burn_rate"`      // Over the alert window
+	ErrorObjective   float64  `json:"error_objective,omitempty"`
+	ErrorGood        float64  `json:"error_good,omitempty"` // Share of invocations without errors
+	ErrorBurnRate    float64  `json:"error_burn_rate"`      // Over the alert window
+	BudgetRemaining  float64  `json:"budget_remaining"`     // Share of the error budget left, of the objective most spent
+	Burning          []string `json:"burning"`              // Objectives alerting on their burn rate
+}
+
+// SLOs tracks functions' latency and error objectives from their invocations,
+// alerting when an objective's error budget burns too fast.
+//
+// Alerts are multiwindow: the burn rate must exceed the threshold over both the alert
+// window and a twelfth of it, so they fire fast and stop soon after the burn does.
+type SLOs struct {
+	events    *Events
+	functions map[string]*sloFunction
+	mu        sync.Mutex
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewSLOs(functions []*types.Function, events *Events) *SLOs {
+	s := &SLOs{
+		events:    events,
+		functions: make(map[string]*sloFunction),
+		stop:      make(chan struct{}),
+	}
+	for _, f := range functions {
+		if f.SLO == nil {
+			continue
+		}
+		latency, _ := time.ParseDuration(f.SLO.Latency)
+		window, _ := time.ParseDuration(f.SLO.Window)
+		alertWindow, _ := time.ParseDuration(f.SLO.AlertWindow)
+		s.functions[f.Name] = &sloFunction{
+			name:        f.Name,
+			config:      f.SLO,
+			latency:     latency,
+			window:      window,
+			alertWindow: alertWindow,
+			buckets:     make([]sloBucket, max(int(window/time.Minute), 1)),
+			burning:     make(map[string]bool),
+		}
+	}
+	return s
+}
+
+// Record counts an invocation of function lasting d, failed if it errored or responded with a 5xx status.
+func (s *SLOs) Record(function string, d time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, exists := s.functions[function]
+	if !exists {
+		return
+	}
+
+	minute := time.Now().Unix() / 60
+	b := &f.buckets[minute%int64(len(f.buckets))]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	if f.latency > 0 && d > f.latency {
+		b.slow++
+	}
+	if failed {
+		b.errors++
+	}
+}
+
+// status returns a function's compliance. Must hold s.mu.
+func (s *SLOs) status(f *sloFunction, now time.Time) *SLOStatus {
+	total, slow, errors := f.sums(f.window, now)
+	alertTotal, alertSlow, alertErrors := f.sums(f.alertWindow, now)
+
+	status := &SLOStatus{
+		Function:         f.name,
+		Window:           f.config.Window,
+		Invocations:      total,
+		LatencyObjective: f.config.LatencyObjective,
+		ErrorObjective:   f.config.ErrorObjective,
+		LatencyBurnRate:  burnRate(alertSlow, alertTotal, f.config.LatencyObjective),
+		ErrorBurnRate:    burnRate(alertErrors, alertTotal, f.config.ErrorObjective),
+		BudgetRemaining:  1,
+		Burning:          []string{},
+	}
+	if total > 0 {
+		if f.config.LatencyObjective > 0 {
+			status.LatencyGood = 1 - float64(slow)/float64(total)
+		}
+		if f.config.ErrorObjective > 0 {
+			status.ErrorGood = 1 - float64(errors)/float64(total)
+		}
+	}
+	spent := max(burnRate(slow, total, f.config.LatencyObjective), burnRate(errors, total, f.config.ErrorObjective))
+	status.BudgetRemaining = 1 - spent
+	for _, objective := range []string{ObjectiveLatency, ObjectiveErrors} {
+		if f.burning[objective] {
+			status.Burning = append(status.Burning, objective)
+		}
+	}
+	return status
+}
+
+// Status returns the compliance of every function with an SLO, by name.
+func (s *SLOs) Status() []*SLOStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	statuses := []*SLOStatus{}
+	for _, f := range s.functions {
+		statuses = append(statuses, s.status(f, now))
+	}
+	slices.SortFunc(statuses, func(a, b *SLOStatus) int { return cmp.Compare(a.Function, b.Function) })
+	return statuses
+}
+
+// evaluate alerts on objectives starting to burn their budget too fast over both alert windows.
+func (s *SLOs) evaluate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, f := range s.functions {
+		longTotal, longSlow, longErrors := f.sums(f.alertWindow, now)
+		shortTotal, shortSlow, shortErrors := f.sums(f.alertWindow/12, now)
+		rates := map[string][2]float64{
+			ObjectiveLatency: {
+				burnRate(longSlow, longTotal, f.config.LatencyObjective),
+				burnRate(shortSlow, shortTotal, f.config.LatencyObjective),
+			},
+			ObjectiveErrors: {
+				burnRate(longErrors, longTotal, f.config.ErrorObjective),
+				burnRate(shortErrors, shortTotal, f.config.ErrorObjective),
+			},
+		}
+
+		for objective, rate := range rates {
+			burning := rate[0] > f.config.BurnRate && rate[1] > f.config.BurnRate
+			if burning == f.burning[objective] {
+				continue
+			}
+			f.burning[objective] = burning
+			if !burning {
+				log.Printf("Function %v %v SLO burn rate is back under %v\n", f.name, objective, f.config.BurnRate)
+				continue
+			}
+
+			msg := fmt.Sprintf("%v SLO error budget burning %.1fx too fast over %v", objective, rate[0], f.config.AlertWindow)
+			log.Printf("Function %v %v\n", f.name, msg)
+			s.events.Publish(Event{
+				Type:     EventSLOBurn,
+				Function: f.name,
+				Data: map[string]any{
+					"error":     msg,
+					"objective": objective,
+					"burn_rate": rate[0],
+					"threshold": f.config.BurnRate,
+				},
+			})
+		}
+	}
+}
+
+// Start evaluates burn rates every minute.
+func (s *SLOs) Start() {
+	if len(s.functions) == 0 {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.evaluate()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *SLOs) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
@@ -2,9 +2,10 @@ package slrun
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
@@ -19,34 +20,57 @@ import (
 
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
 	"github.com/marcorentap/slrun/internal/types"
 )
 
 var config *types.Config
-var dockerCli *client.Client
+var dockerCli EngineClient
 var dockerCtx context.Context
 var runtime *Runtime
 var host string
 var port int
 
-// createTarContext creates a tar archive of the directory at dirPath.
-func createTarContext(dirPath string) (io.Reader, error) {
+// createTarContext creates a tar archive of the function's build dir, leaving out files
+// its .dockerignore and context_exclude do and keeping only those its context_include
+// selects, if any. Symlinks are archived as symlinks, not followed.
+func createTarContext(function *types.Function) (*bytes.Buffer, error) {
+	dirPath := function.BuildDir
+	filter, err := newContextFilter(function)
+	if err != nil {
+		return nil, err
+	}
 	buf := new(bytes.Buffer)
 	tw := tar.NewWriter(buf)
 
-	err := filepath.Walk(dirPath, func(file string, fi os.FileInfo, err error) error {
+	err = filepath.Walk(dirPath, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		header, err := tar.FileInfoHeader(fi, fi.Name())
+		// Use relative path so the archive structure matches the relative paths in the context directory
+		relPath, err := filepath.Rel(dirPath, file)
 		if err != nil {
 			return err
 		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath != "." {
+			send, descend := filter.send(relPath, fi.IsDir())
+			if fi.IsDir() && !descend {
+				return filepath.SkipDir
+			}
+			if !send {
+				return nil
+			}
+		}
 
-		// Use relative path so the archive structure matches the relative paths in the context directory
-		relPath, err := filepath.Rel(dirPath, file)
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(fi, link)
 		if err != nil {
 			return err
 		}
@@ -80,106 +104,492 @@ func createTarContext(dirPath string) (io.Reader, error) {
 	return buf, nil
 }
 
-func BuildFunctionImage(function *types.Function) error {
-	buildCtx, err := createTarContext(function.BuildDir)
+// functionImageName returns the name of the image the function runs, its prebuilt image or the one built for it.
+func functionImageName(function *types.Function) string {
+	if function.Image != "" {
+		return function.Image
+	}
+	return "slrun-" + function.Name
+}
+
+// dockerfilePath returns the path of the Dockerfile the function's image is built from.
+func dockerfilePath(function *types.Function) string {
+	return filepath.Join(function.BuildDir, function.Dockerfile)
+}
+
+// buildArgs returns the function's build args as the Docker API takes them.
+func buildArgs(function *types.Function) map[string]*string {
+	args := make(map[string]*string, len(function.BuildArgs))
+	for name, value := range function.BuildArgs {
+		args[name] = &value
+	}
+	return args
+}
+
+// buildCandidateImage builds the function's image as a candidate from tarCtx, its build context,
+// sent with compression, and runs its tests in it. Returns the candidate's tag. The function's
+// image is left as it is until the candidate is promoted. The build's output is shown
+// as it runs, or only if it fails with quiet_builds. It is built remotely with remote_build.
+func buildCandidateImage(ctx context.Context, config *types.Config, function *types.Function, tarCtx io.Reader, compression string) (string, error) {
+	buildCtx, err := compressContext(tarCtx, compression)
+	if err != nil {
+		return "", err
+	}
+	defer buildCtx.Close()
+
+	candidate := functionImageName(function) + ":candidate"
+	options := build.ImageBuildOptions{
+		Tags:       []string{candidate},
+		Dockerfile: filepath.ToSlash(function.Dockerfile),
+		BuildArgs:  buildArgs(function),
+		Target:     function.Target,
+		Version:    build.BuilderV1,
+	}
+	if config.Builder == BuilderBuildKit {
+		options.Version = build.BuilderBuildKit
+	}
+	if len(function.Caches) > 0 {
+		cacheID := "slrun-" + function.Name
+		options.BuildArgs[cacheIDArg] = &cacheID
+	}
+	if config.ReproducibleBuilds {
+		epoch := strconv.Itoa(reproducibleEpoch)
+		options.BuildArgs["SOURCE_DATE_EPOCH"] = &epoch
+	}
+
+	builder := remoteBuilder
+	if builder != nil && builder.fallback {
+		if err := builder.ping(ctx); err != nil {
+			log.Printf("Cannot reach remote builder, building function %v locally: %v\n", function.Name, err)
+			builder = nil
+		}
+	}
+	if builder != nil {
+		err = builder.build(ctx, function, buildCtx, options, config.QuietBuilds)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		buildResp, err := dockerCli.ImageBuild(ctx, buildCtx, options)
+		if err != nil {
+			return "", err
+		}
+		defer buildResp.Body.Close()
+
+		// The build runs while its output is read
+		err = showBuildOutput(buildResp.Body, buildOutput(function), config.QuietBuilds, options.Version)
+		if err != nil {
+			return "", fmt.Errorf("cannot build function %v image: %w", function.Name, err)
+		}
+	}
+
+	if function.TestCommand != "" {
+		err = testCandidateImage(config, function, candidate)
+		if err != nil {
+			return "", err
+		}
+	}
+	return candidate, nil
+}
+
+// removeCandidateImage removes a candidate image that won't be promoted.
+func removeCandidateImage(candidate string) {
+	_, err := dockerCli.ImageRemove(dockerCtx, candidate, image.RemoveOptions{Force: true, PruneChildren: true})
+	if err != nil {
+		log.Printf("Cannot remove candidate image %v: %v\n", candidate, err)
+	}
+}
+
+// promoteImage tags candidate as the function's image, removing the image it replaces.
+func promoteImage(function *types.Function, candidate string) error {
+	imageName := functionImageName(function)
+
+	// Remember the image being replaced, if any
+	var oldID string
+	if old, err := dockerCli.ImageInspect(dockerCtx, imageName); err == nil {
+		oldID = old.ID
+	}
+
+	err := dockerCli.ImageTag(dockerCtx, candidate, imageName)
+	if err != nil {
+		return err
+	}
+	_, err = dockerCli.ImageRemove(dockerCtx, candidate, image.RemoveOptions{})
 	if err != nil {
 		return err
 	}
 
-	// Remove then rebuild image
-	imageName := "slrun-" + function.Name
-	_, err = dockerCli.ImageRemove(dockerCtx, imageName, image.RemoveOptions{
-		Force:         true,
-		PruneChildren: true,
-	})
+	if oldID != "" {
+		if updated, err := dockerCli.ImageInspect(dockerCtx, imageName); err == nil && updated.ID != oldID {
+			// Kept while containers still run it
+			_, err := dockerCli.ImageRemove(dockerCtx, oldID, image.RemoveOptions{PruneChildren: true})
+			if err != nil {
+				log.Printf("Cannot remove replaced image of function %v: %v\n", function.Name, err)
+			}
+		}
+	}
+	function.ImageName = imageName
+	return nil
+}
 
+// prepareFunctionImage builds the function's image, or pulls its prebuilt image if missing.
+// Unless force, the build is skipped if the function's image was built from the same build
+// context and base images. If check isn't nil, it must pass for the image to be deployed:
+// a built image is checked as a candidate, so one check denies leaves the function's current
+// image in place. The build stops once ctx is done.
+func prepareFunctionImage(ctx context.Context, config *types.Config, function *types.Function, compression string, force bool, check func(ref string) error) error {
+	if check == nil {
+		check = func(string) error { return nil }
+	}
+	if function.Process != nil {
+		// Run from its sources as they are
+		function.ImageName = processImage(function)
+		if function.Wasm != "" {
+			fmt.Printf("Running function as a WASM module: %v => %v\n", function.Name, function.Wasm)
+			return nil
+		}
+		fmt.Printf("Running function as a process: %v => %v\n", function.Name, strings.Join(function.Process.Command, " "))
+		return nil
+	}
+	if function.Image != "" {
+		exists := imageExists(function.Image)
+		if !exists || function.ImagePull == ImagePullAlways && !config.Offline {
+			fmt.Printf("Pulling function image: %v => %v\n", function.Name, function.Image)
+			err := pullImage(function.Image)
+			if err != nil && !exists {
+				return fmt.Errorf("cannot pull function %v image %v: %w", function.Name, function.Image, err)
+			}
+			if err != nil {
+				log.Printf("Cannot pull function %v image, using the local one: %v\n", function.Name, err)
+			}
+		}
+		err := check(function.Image)
+		if err != nil {
+			return err
+		}
+		function.ImageName = function.Image
+		fmt.Printf("Using function image: %v\n", function.ImageName)
+		return nil
+	}
+
+	tarCtx, err := createTarContext(function)
+	if err != nil {
+		return err
+	}
+	if config.ReproducibleBuilds {
+		pins, err := pinBaseImages(config, function)
+		if err != nil {
+			return err
+		}
+		tarCtx, err = reproducibleContext(function, tarCtx.Bytes(), pins)
+		if err != nil {
+			return err
+		}
+	}
+	bases, err := functionBaseImages(function)
 	if err != nil {
-		// If image doesn't exist, it's ok
-		if !strings.Contains(err.Error(), "No such image: slrun-") {
+		return err
+	}
+	digest, err := contextDigest(function, tarCtx.Bytes(), localImageIDs(bases))
+	if err != nil {
+		return err
+	}
+	if !force && builtImageCurrent(config.StateDir, function, digest) {
+		imageName := functionImageName(function)
+		err := check(imageName)
+		if err != nil {
 			return err
 		}
+		function.ImageName = imageName
+		fmt.Printf("Function image up to date: %v\n", function.ImageName)
+		return nil
 	}
 
-	buildResp, err := dockerCli.ImageBuild(dockerCtx, buildCtx, build.ImageBuildOptions{
-		Tags: []string{imageName},
-	})
+	fmt.Printf("Building function image: %v => %v\n", function.Name, function.BuildDir)
+	candidate, err := buildCandidateImage(ctx, config, function, tarCtx, compression)
+	if err != nil {
+		return err
+	}
+	err = check(candidate)
 	if err != nil {
+		removeCandidateImage(candidate)
 		return err
 	}
-	defer buildResp.Body.Close()
+	err = promoteImage(function, candidate)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Built function image: %v\n", function.ImageName)
 
-	// We have to read from the response, else it won't build
-	io.Copy(io.Discard, buildResp.Body)
+	if inspect, err := dockerCli.ImageInspect(dockerCtx, function.ImageName); err == nil {
+		err = recordBuild(config.StateDir, function.Name, &buildRecord{Digest: digest, Image: inspect.ID})
+		if err != nil {
+			log.Printf("Cannot record build of function %v: %v\n", function.Name, err)
+		}
+		if config.ReproducibleBuilds {
+			previous, err := recordSourceImage(config.StateDir, function.Name, digest, inspect.ID)
+			if err != nil {
+				log.Printf("Cannot record image of function %v: %v\n", function.Name, err)
+			}
+			if previous != "" {
+				log.Printf("Build of function %v wasn't reproduced: its sources %v built image %v, previously %v\n", function.Name, digest, inspect.ID, previous)
+			}
+		}
+	}
+	return nil
+}
 
-	function.ImageName = imageName
+// recordDeployment adds a build of function to its deployment history.
+func recordDeployment(stateDir string, function *types.Function, buildErr error) {
+	var imageID string
+	if buildErr == nil {
+		inspect, err := dockerCli.ImageInspect(dockerCtx, function.ImageName)
+		if err == nil {
+			imageID = inspect.ID
+		}
+	}
+
+	err := RecordDeployment(stateDir, function, imageID, buildErr)
+	if err != nil {
+		log.Printf("Cannot record deployment of function %v: %v\n", function.Name, err)
+	}
+}
+
+// deployFunctionImage prepares the function's image, rebuilding it even if unchanged if force,
+// checks its base images and policies allow deploying it, and records the deployment, publishing
+// a build, tests or policy failure if it fails. Events of deploys carry their duration in build_seconds.
+// A build stopped as ctx is done is only published as cancelled.
+func deployFunctionImage(ctx context.Context, config *types.Config, events *Events, opa *OPAPolicies, function *types.Function, compression string, force bool) error {
+	start := time.Now()
+	err := checkBaseImageNames(config.BaseImages, function)
+	if err == nil {
+		err = prepareFunctionImage(ctx, config, function, compression, force, func(ref string) error {
+			err := checkBaseImageAges(config.BaseImages, function)
+			if err != nil {
+				return err
+			}
+			return opa.CheckDeploy(function, ref)
+		})
+	}
+	seconds := time.Since(start).Seconds()
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Build of function %v cancelled\n", function.Name)
+		events.Publish(Event{Type: EventBuildCancelled, Function: function.Name, Data: map[string]any{"build_seconds": seconds}})
+		return err
+	}
+	recordDeployment(config.StateDir, function, err)
+	if err != nil {
+		log.Printf("Cannot prepare function %v image\n", function.Name)
+		eventType := EventBuildFailed
+		if errors.Is(err, ErrTestsFailed) {
+			eventType = EventTestsFailed
+		} else if errors.Is(err, ErrPolicyDenied) {
+			eventType = EventPolicyDenied
+		}
+		events.Publish(Event{Type: eventType, Function: function.Name, Data: map[string]any{"error": err.Error(), "build_seconds": seconds}})
+		return err
+	}
+	events.Publish(Event{Type: EventBuilt, Function: function.Name, Data: map[string]any{"image": function.ImageName, "build_seconds": seconds}})
 	return nil
 }
 
-func Start(cfgFile string, host string, port int) error {
+// shutdownServer shuts down a server started by Start, giving it up to 5s.
+func shutdownServer(name string, shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		log.Printf("Cannot shutdown %v. %v\n", name, err)
+	}
+}
+
+// Start runs slrun with the config in cfgFile. dev, offline and watch override the config's settings unless nil.
+func Start(cfgFile string, host string, port int, dev *bool, offline *bool, watch *bool) error {
 	// Init
 	config, err := ReadConfigFile(cfgFile)
 	if err != nil {
 		return err
 	}
-	dockerCli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if dev != nil {
+		config.Dev = *dev
+	}
+	if offline != nil {
+		config.Offline = *offline
+	}
+	if watch != nil {
+		config.Watch = *watch
+	}
+	err = writePIDFile(config.StateDir)
+	if err != nil {
+		return err
+	}
+	defer removePIDFile(config.StateDir)
+	err = ConnectDocker(config.Backend)
+	if err != nil {
+		return err
+	}
+	UseRegistryAuths(config.Registry)
+	err = UseRemoteBuild(config.RemoteBuild)
 	if err != nil {
 		return err
 	}
-	dockerCtx = context.Background()
+	useProxyDNS(config.ProxyDNS)
+
+	events := NewEvents()
+	metrics := NewMetrics(events)
+	metrics.Start()
+	defer metrics.Stop()
+	var notifiers *Notifiers
+	if config.Notifications != nil {
+		notifiers, err = NewNotifiers(config.Notifications, events)
+		if err != nil {
+			return err
+		}
+		notifiers.Start()
+		defer notifiers.Stop()
+	}
 
-	// Build function images
-	for _, function := range config.Functions {
-		fmt.Printf("Building function image: %v => %v\n", function.Name, function.BuildDir)
-		err := BuildFunctionImage(function)
+	// Functions placed on other nodes aren't built here
+	functions := config.Functions
+	var cluster *Cluster
+	if config.Cluster != nil {
+		cluster = NewCluster(config.Cluster)
+		functions = cluster.Place(config.Functions)
+	}
+
+	if config.Offline {
+		err = checkOfflineImages(functions)
+		if err != nil {
+			return err
+		}
+	} else if config.Registry != nil {
+		err = pullBaseImages(config.Registry, functions)
 		if err != nil {
-			log.Printf("Cannot build image %v\n", function.ImageName)
 			return err
 		}
+	}
+
+	opa, err := NewOPAPolicies(config.OPA)
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf("Built function image: %v\n", function.ImageName)
+	// Build function images
+	compression := buildContextCompression(config.BuildCompression)
+	err = deployFunctionImages(config, events, opa, NewBuildQueue(config.BuildParallelism), functions, compression)
+	if err != nil {
+		return err
+	}
+
+	if len(config.Listeners) == 0 && config.FunctionPorts != "" {
+		from, to, _ := parsePortRange(config.FunctionPorts)
+		if port >= from && port <= to {
+			return fmt.Errorf("function port range %v includes gateway port %v", config.FunctionPorts, port)
+		}
 	}
 
 	// Start function manager
 	log.Printf("Starting runtime\n")
-	runtime, err := NewRuntime(config.Functions, config.Policy)
+	runtime, err := NewRuntime(config, events, cluster, opa)
 	if err != nil {
 		return err
 	}
+	err = runtime.ensureNetwork(dockerCtx)
+	if err != nil {
+		return fmt.Errorf("cannot create function network: %w", err)
+	}
+	listeners := config.Listeners
+	if len(listeners) == 0 {
+		listeners = []*types.Listener{{Address: net.JoinHostPort(host, strconv.Itoa(port))}}
+	}
+	runtime.gatewayURL = gatewayURL(listeners, backendGatewayHostname(config.Backend.Engine))
+	runtime.localGateway = gatewayURL(listeners, "localhost")
+	// Components are stopped in reverse order of starting as Start returns, on interrupt or early
+	// on an error, so function containers and listeners never outlive it
 	runtime.Start()
+	defer func() {
+		runtime.Stop()
+		fmt.Printf("Runtime stopped\n")
+	}()
 	fmt.Printf("Runtime started\n")
 
-	// Start server
-	listenAddr := host + ":" + strconv.Itoa(port)
-
-	server := &http.Server{
-		Addr: listenAddr,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			parts := strings.Split(r.URL.Path, "/") // /funcName/other/parts
+	if cluster != nil {
+		err = cluster.Start(runtime, config.UploadDir)
+		if err != nil {
+			return err
+		}
+		defer shutdownServer("cluster server", cluster.Shutdown)
+	}
 
-			if len(parts) < 2 {
-				return
-			}
+	scheduler := NewScheduler(runtime)
+	scheduler.Start()
+	defer scheduler.Stop()
+	autoscaler := NewAutoscaler(runtime)
+	autoscaler.Start()
+	defer autoscaler.Stop()
+
+	if config.Chaos != nil {
+		chaos := NewChaos(config.Chaos, runtime)
+		chaos.Start()
+		defer chaos.Stop()
+	}
 
-			funcName := parts[1]
-			path, _ := strings.CutPrefix(r.URL.Path, "/"+funcName)
+	// Start gateway
+	billing := NewBilling(config.UsageExport)
+	err = billing.Start()
+	if err != nil {
+		return err
+	}
+	defer billing.Stop()
+	gateway, err := NewGateway(runtime, config, listeners, billing, metrics)
+	if err != nil {
+		return err
+	}
+	gateway.Start()
+	defer func() {
+		shutdownServer("server", gateway.Shutdown)
+		fmt.Printf("HTTP Server stopped\n")
+	}()
+	gateway.printFunctionURLs()
+	relay := NewRelay(config.Relay)
+	relay.Start(gateway)
+	defer shutdownServer("relay server", relay.Shutdown)
+	err = gateway.dns.Start()
+	if err != nil {
+		return err
+	}
+	defer shutdownServer("DNS server", gateway.dns.Shutdown)
+	mdns := NewMDNS(config.MDNS, gateway)
+	err = mdns.Start()
+	if err != nil {
+		return err
+	}
+	defer mdns.Stop()
 
-			resp, err := runtime.CallFunctionByName(funcName, path, r)
-			if err != nil {
-				w.Write([]byte(err.Error()))
-				return
-			}
+	rebuilder := NewRebuilder(config, runtime, events, compression)
+	rebuilder.Start()
+	defer rebuilder.Stop()
 
-			w.Write(resp)
+	if config.Watch {
+		reloader := NewReloader(config, runtime, events, compression)
+		err = reloader.Start()
+		if err != nil {
+			return err
+		}
+		defer reloader.Stop()
+	}
 
-			log.Printf("Function %v called\n", funcName)
-		}),
+	if config.AdminAddress != "" {
+		admin := NewAdmin(config.AdminAddress, runtime, gateway)
+		admin.Start()
+		defer shutdownServer("admin server", admin.Shutdown)
 	}
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
-		}
-	}()
-	fmt.Printf("HTTP server listening on %v\n", listenAddr)
+
+	fleetReporter := NewFleetReporter(config.Fleet, func() *Status { return buildStatus(runtime, gateway) }, billing.Report)
+	fleetReporter.Start()
+	defer fleetReporter.Stop()
+	retainer := NewRetainer(config, gateway.history)
+	retainer.Start()
+	defer retainer.Stop()
 
 	// Register interrupt handler
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -187,20 +597,16 @@ func Start(cfgFile string, host string, port int) error {
 
 	// On interrupt...
 	<-ctx.Done()
-	log.Println("Received interrupt signal. Shutting down server...")
 
-	// Shutdown server
-	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancelShutdown()
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Cannot shutdown server. %v\n")
-		return err
+	// Drain invocations in flight, unless interrupted again
+	gracePeriod, _ := time.ParseDuration(config.ShutdownGracePeriod)
+	log.Printf("Received interrupt signal. Waiting up to %v for %v invocations in flight, interrupt again to stop now...\n", gracePeriod, gateway.InFlight())
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancelDrain()
+	drainCtx, stopDrain := signal.NotifyContext(drainCtx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopDrain()
+	if err := gateway.Drain(drainCtx); err != nil {
+		log.Printf("Stopped waiting for %v invocations in flight\n", gateway.InFlight())
 	}
-	fmt.Printf("HTTP Server stopped\n")
-
-	// Shutdown function manager
-	runtime.Stop()
-	fmt.Printf("Runtime stopped\n")
-
 	return nil
 }
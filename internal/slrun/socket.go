@@ -0,0 +1,63 @@
+package slrun
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Functions with a socket listen on containerSocket, in a host dir mounted at containerSocketDir
+const (
+	containerSocketDir = "/run/slrun"
+	containerSocket    = containerSocketDir + "/function.sock"
+)
+
+// functionSocketDir returns the host dir of an instance's socket under the sockets dir.
+func functionSocketDir(socketsDir string, instance string) string {
+	return filepath.Join(socketsDir, instance)
+}
+
+// prepareSocketDir creates the host dir of an instance's socket, removing a stale socket.
+func prepareSocketDir(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, filepath.Base(containerSocket)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// newSocketClient returns a proxy client dialing the Unix socket at path, whatever the URL host.
+func newSocketClient(path string) *http.Client {
+	client := newProxyClient()
+	transport := client.Transport.(*http.Transport)
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+	return client
+}
+
+// functionClient returns the client proxying requests to function, its bulkhead's if it has one.
+func (r *Runtime) functionClient(function *types.Function) *http.Client {
+	if function.SocketPath == "" {
+		if b := r.bulkhead(function); b != nil {
+			return b.client
+		}
+		return r.httpClient
+	}
+	if client, ok := r.socketClients.Load(function.SocketPath); ok {
+		return client.(*http.Client)
+	}
+	client, _ := r.socketClients.LoadOrStore(function.SocketPath, newSocketClient(function.SocketPath))
+	return client.(*http.Client)
+}
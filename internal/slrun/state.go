@@ -0,0 +1,203 @@
+package slrun
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// StateSnapshot is the declarative state of a slrun deployment, to move it to another host:
+// its config, with routing, what each function last deployed, and the feature flag overrides
+// and quarantines set while it ran. Secrets are referenced, never included.
+type StateSnapshot struct {
+	Version     string                     `json:"version"` // slrun version that exported it
+	Created     time.Time                  `json:"created"`
+	Host        string                     `json:"host"`   // Host name it was exported on
+	Config      json.RawMessage            `json:"config"` // The config file, as JSON
+	Functions   []*SnapshotFunction        `json:"functions"`
+	Flags       map[string]map[string]bool `json:"flags,omitempty"` // Overrides by function, then flag
+	Quarantined []*QuarantineRecord        `json:"quarantined,omitempty"`
+}
+
+// SnapshotFunction is a function of a StateSnapshot.
+type SnapshotFunction struct {
+	Name    string          `json:"name"`
+	Enabled bool            `json:"enabled"`           // Enabled in the config and not quarantined
+	Image   string          `json:"image,omitempty"`   // ID of the image of its latest successful deployment
+	Secrets []*types.Secret `json:"secrets,omitempty"` // Files and env variables its secrets are read from
+	History []*Deployment   `json:"history,omitempty"`
+}
+
+// readStateFile reads a JSON file of the state dir into v, leaving v as is if there is none.
+func readStateFile(file string, v any) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%v: %w", file, err)
+	}
+	return nil
+}
+
+// writeStateFile writes v as JSON to a file of the state dir.
+func writeStateFile(file string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// ExportState returns the state of the config read from cfgFile and of its state dir.
+// Replicas and tenant instances are left out, they are recreated from their function's config.
+func ExportState(config *types.Config, cfgFile string) (*StateSnapshot, error) {
+	raw, err := readConfigJSON(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	var compact bytes.Buffer
+	err = json.Compact(&compact, raw)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	snapshot := &StateSnapshot{
+		Version: Version,
+		Created: time.Now(),
+		Host:    host,
+		Config:  compact.Bytes(),
+	}
+	err = readStateFile(flagsFile(config.StateDir), &snapshot.Flags)
+	if err != nil {
+		return nil, err
+	}
+	err = readStateFile(quarantineFile(config.StateDir), &snapshot.Quarantined)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range config.Functions {
+		if f.Base != "" {
+			continue
+		}
+		history, err := ReadHistory(config.StateDir, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		quarantined := slices.ContainsFunc(snapshot.Quarantined, func(r *QuarantineRecord) bool { return r.Function == f.Name })
+		state := &SnapshotFunction{
+			Name:    f.Name,
+			Enabled: !f.Disabled && !quarantined,
+			Secrets: f.Secrets,
+			History: history,
+		}
+		for _, d := range slices.Backward(history) {
+			if d.Result == DeploySucceeded {
+				state.Image = d.Image
+				break
+			}
+		}
+		snapshot.Functions = append(snapshot.Functions, state)
+	}
+	return snapshot, nil
+}
+
+// ReadStateSnapshot reads a snapshot written by ExportState.
+func ReadStateSnapshot(r io.Reader) (*StateSnapshot, error) {
+	snapshot := &StateSnapshot{}
+	err := json.NewDecoder(r).Decode(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state snapshot: %w", err)
+	}
+	if len(snapshot.Config) == 0 {
+		return nil, fmt.Errorf("invalid state snapshot: it has no config")
+	}
+	return snapshot, nil
+}
+
+// MissingSecrets returns the secrets of the snapshot's functions this host can't read, e.g.
+// function func1 secret API_KEY: env variable API_KEY isn't set.
+func (s *StateSnapshot) MissingSecrets() []string {
+	var missing []string
+	for _, f := range s.Functions {
+		for _, secret := range f.Secrets {
+			if _, err := readSecret(secret); err != nil {
+				missing = append(missing, fmt.Sprintf("function %v secret %v: %v", f.Name, secret.Name, err))
+			}
+		}
+	}
+	return missing
+}
+
+// ImportState writes the snapshot's config to cfgFile, replacing it only if force, and its
+// deployment history, flag overrides and quarantines to the config's state dir, so slrun
+// started with cfgFile deploys the same functions as the host it was exported on.
+func ImportState(snapshot *StateSnapshot, cfgFile string, force bool) error {
+	if _, err := os.Stat(cfgFile); err == nil && !force {
+		return fmt.Errorf("config file %v exists, replace it with --force", cfgFile)
+	}
+	var config struct {
+		StateDir string `json:"state_dir"`
+	}
+	err := json.Unmarshal(snapshot.Config, &config)
+	if err != nil {
+		return fmt.Errorf("invalid state snapshot config: %w", err)
+	}
+	// As validateConfig defaults it
+	stateDir := cmp.Or(config.StateDir, ".slrun")
+
+	var indented bytes.Buffer
+	err = json.Indent(&indented, snapshot.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	err = os.WriteFile(cfgFile, indented.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	for _, f := range snapshot.Functions {
+		if len(f.History) == 0 {
+			continue
+		}
+		err := writeHistory(stateDir, f.Name, f.History)
+		if err != nil {
+			return err
+		}
+	}
+	if len(snapshot.Flags) > 0 {
+		err := writeStateFile(flagsFile(stateDir), snapshot.Flags)
+		if err != nil {
+			return err
+		}
+	}
+	if len(snapshot.Quarantined) > 0 {
+		err := writeStateFile(quarantineFile(stateDir), snapshot.Quarantined)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestStateExportImport(t *testing.T) {
+	src := t.TempDir()
+	stateDir := filepath.Join(src, ".slrun")
+	cfgFile := filepath.Join(src, "config.json")
+	content := `{"state_dir": "` + stateDir + `", "functions": [{"name": "func1"}, {"name": "func2", "disabled": true}]}`
+	if err := os.WriteFile(cfgFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	func1 := &types.Function{Name: "func1", Secrets: []*types.Secret{{Name: "API_KEY", Env: "SLRUN_TEST_API_KEY"}}}
+	config := &types.Config{StateDir: stateDir, Functions: []*types.Function{
+		func1,
+		{Name: "func1#2", Base: "func1"},
+		{Name: "func2", Disabled: true},
+	}}
+	if err := RecordDeployment(stateDir, func1, "sha256:0123456789abcdef", nil); err != nil {
+		t.Fatal(err)
+	}
+	flags := map[string]map[string]bool{"func1": {"new-ui": true}}
+	if err := writeStateFile(flagsFile(stateDir), flags); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := ExportState(config, cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Functions) != 2 {
+		t.Fatalf("ExportState() functions = %v, want func1 and func2, without replicas", len(snapshot.Functions))
+	}
+	if f := snapshot.Functions[0]; !f.Enabled || f.Image != "sha256:0123456789abcdef" || len(f.History) != 1 {
+		t.Errorf("ExportState() func1 = %+v, want enabled with its deployment", f)
+	}
+	if f := snapshot.Functions[1]; f.Enabled || f.Image != "" {
+		t.Errorf("ExportState() func2 = %+v, want disabled and never deployed", f)
+	}
+
+	// Through JSON, as slrun state export writes it
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err = ReadStateSnapshot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SLRUN_TEST_API_KEY", "")
+	os.Unsetenv("SLRUN_TEST_API_KEY")
+	if missing := snapshot.MissingSecrets(); len(missing) != 1 {
+		t.Errorf("MissingSecrets() = %v, want func1's API_KEY", missing)
+	}
+
+	// On the other host, the state dir is where the config says
+	os.RemoveAll(stateDir)
+	dst := filepath.Join(t.TempDir(), "config.json")
+	if err := ImportState(snapshot, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := readConfigJSON(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want any
+	json.Unmarshal(imported, &got)
+	json.Unmarshal([]byte(content), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported config = %s, want %s", imported, content)
+	}
+	if history, _ := ReadHistory(stateDir, "func1"); len(history) != 1 {
+		t.Errorf("imported func1 history = %v, want its deployment", history)
+	}
+	var gotFlags map[string]map[string]bool
+	if err := readStateFile(flagsFile(stateDir), &gotFlags); err != nil || !reflect.DeepEqual(gotFlags, flags) {
+		t.Errorf("imported flags = %v, %v, want %v", gotFlags, err, flags)
+	}
+
+	if err := ImportState(snapshot, dst, false); err == nil {
+		t.Errorf("ImportState() over an existing config = nil, want an error")
+	}
+	if err := ImportState(snapshot, dst, true); err != nil {
+		t.Errorf("ImportState() with force = %v", err)
+	}
+}
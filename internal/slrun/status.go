@@ -0,0 +1,290 @@
+package slrun
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Function states reported by the status endpoint
+const (
+	StateRunning     = "running"
+	StateStarting    = "starting" // Running, but not ready yet
+	StateStopped     = "stopped"  // Started on demand by the policy
+	StateDisabled    = "disabled"
+	StateRemote      = "remote"             // Runs on other cluster nodes
+	StateCrashLoop   = "crash_loop_backoff" // Failing repeatedly, not started until retry_at
+	StateQuarantined = "quarantined"        // Disabled for violating rules, until released by an admin
+)
+
+// Status is the state of a slrun daemon for IDE integrations and scripts.
+// Fields are only ever added, never renamed or removed, within an API version.
+type Status struct {
+	Version    string           `json:"version"`
+	APIVersion int              `json:"api_version"`
+	Gateway    []string         `json:"gateway"` // Listener URLs
+	Functions  []*FunctionState `json:"functions"`
+}
+
+// FunctionState is the state of a function, or of a tenant or replica instance of it.
+type FunctionState struct {
+	Name        string     `json:"name"`
+	Tenant      string     `json:"tenant,omitempty"`
+	State       string     `json:"state"`
+	Health      string     `json:"health,omitempty"` // Of the container, with a Docker HEALTHCHECK
+	URL         string     `json:"url,omitempty"`    // Where the gateway serves the function
+	Image       string     `json:"image"`
+	ContainerID string     `json:"container_id,omitempty"`
+	LANURL      string     `json:"lan_url,omitempty"`    // Where other devices on the LAN reach it, if the gateway listens on them
+	Port        int        `json:"port,omitempty"`       // Host port of the container
+	DebugPort   int        `json:"debug_port,omitempty"` // Host port of the function's debugger
+	Socket      string     `json:"socket,omitempty"`     // Host path of the function's Unix socket
+	Debugger    string     `json:"debugger,omitempty"`
+	Nodes       []string   `json:"nodes,omitempty"`            // Other cluster nodes running the function
+	Desired     int        `json:"desired_replicas,omitempty"` // Replicas its scaling rules ask for
+	Replicas    int        `json:"replicas,omitempty"`         // Replicas running, of functions scaling out
+	Restarts    int        `json:"restarts,omitempty"`         // Containers started after one died or failed to start
+	ExitCodes   []int      `json:"exit_codes,omitempty"`       // Of its recently died containers, latest last
+	Reason      string     `json:"reason,omitempty"`           // Of its latest failure while crash looping, or violation while quarantined
+	RetryAt     *time.Time `json:"retry_at,omitempty"`         // When it is started again, while crash looping
+}
+
+// listenerScheme returns the scheme of listener l, https if it serves TLS.
+func listenerScheme(l *types.Listener) string {
+	if l.TLSCert != "" && l.TLSKey != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// listenerURL returns the base URL clients on this host reach listener l at.
+func listenerURL(l *types.Listener) string {
+	scheme := listenerScheme(l)
+	host, port, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return scheme + "://" + l.Address
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// GatewayURL returns the base URL of the config's first listener, or of localhost:port if it
+// has none.
+func GatewayURL(config *types.Config, port int) string {
+	if len(config.Listeners) > 0 {
+		return listenerURL(config.Listeners[0])
+	}
+	return listenerURL(&types.Listener{Address: net.JoinHostPort("", strconv.Itoa(port))})
+}
+
+// lanListenerURL returns the base URL other devices on the LAN reach listener l at, on one of
+// the host's LAN addresses if it listens on all of them, IPv4 first as phones may lack IPv6.
+// Empty if it only listens on this host.
+func lanListenerURL(l *types.Listener, lanIPs []net.IP) string {
+	host, port, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if host == "localhost" || ip != nil && ip.IsLoopback() {
+		return ""
+	}
+	if host == "" || ip != nil && ip.IsUnspecified() {
+		ipv4Only := ip != nil && ip.To4() != nil
+		host = ""
+		for _, lanIP := range lanIPs {
+			if lanIP.To4() != nil {
+				host = lanIP.String()
+				break
+			}
+			if host == "" && !ipv4Only {
+				host = lanIP.String()
+			}
+		}
+		if host == "" {
+			return ""
+		}
+	}
+	return listenerScheme(l) + "://" + net.JoinHostPort(host, port)
+}
+
+// functionListener returns the first listener routing function, nil if none does.
+func (g *Gateway) functionListener(function string) *types.Listener {
+	for _, l := range g.listeners {
+		if len(l.Functions) == 0 || slices.Contains(l.Functions, function) {
+			return l
+		}
+	}
+	return nil
+}
+
+// functionURL returns the URL of the first listener routing function, if any.
+func (g *Gateway) functionURL(function string) string {
+	if l := g.functionListener(function); l != nil {
+		return listenerURL(l) + "/" + function
+	}
+	return ""
+}
+
+// lanFunctionURL returns the URL other devices on the LAN reach function at, through the first
+// listener routing it, empty if it only listens on this host.
+func (g *Gateway) lanFunctionURL(function string) string {
+	l := g.functionListener(function)
+	if l == nil {
+		return ""
+	}
+	if base := lanListenerURL(l, lanIPs()); base != "" {
+		return base + "/" + function
+	}
+	return ""
+}
+
+// printFunctionURLs prints where each function the gateway routes is served.
+func (g *Gateway) printFunctionURLs() {
+	for _, f := range g.runtime.functions {
+		url := g.functionURL(f.Name)
+		if url == "" {
+			continue
+		}
+		if lan := g.lanFunctionURL(f.Name); lan != "" {
+			fmt.Printf("Function %v: %v, on the LAN at %v\n", f.Name, url, lan)
+		} else {
+			fmt.Printf("Function %v: %v\n", f.Name, url)
+		}
+	}
+}
+
+func functionState(f *types.Function, url string) *FunctionState {
+	state := StateStopped
+	if f.IsRunning {
+		state = StateRunning
+		if !f.Ready {
+			state = StateStarting
+		}
+	}
+	if f.Remote {
+		state = StateRemote
+	}
+	if !f.IsEnabled {
+		state = StateDisabled
+	}
+	s := &FunctionState{
+		Name:        f.Name,
+		Tenant:      f.Tenant,
+		State:       state,
+		Health:      f.Health,
+		URL:         url,
+		Image:       f.ImageName,
+		ContainerID: f.ContainerId,
+		Port:        f.Port,
+		Socket:      f.SocketPath,
+		Debugger:    f.Debugger,
+		Desired:     f.Desired,
+	}
+	if f.Tenant == "" {
+		s.DebugPort = f.DebugPort
+	}
+	return s
+}
+
+// buildStatus returns the state of the runtime's functions and the gateway serving them.
+func buildStatus(runtime *Runtime, gateway *Gateway) *Status {
+	status := &Status{
+		Version:    Version,
+		APIVersion: APIVersion,
+		Gateway:    []string{},
+		Functions:  []*FunctionState{},
+	}
+	for _, l := range gateway.listeners {
+		status.Gateway = append(status.Gateway, listenerURL(l))
+	}
+
+	for _, f := range runtime.functions {
+		state := functionState(f, gateway.functionURL(f.Name))
+		state.LANURL = gateway.lanFunctionURL(f.Name)
+		state.Replicas = runtime.Replicas(f)
+		runtime.setFailureState(state)
+		runtime.quarantine.setState(state)
+		if runtime.cluster != nil {
+			state.Nodes = runtime.cluster.Nodes(f.Name)
+		}
+		status.Functions = append(status.Functions, state)
+	}
+
+	runtime.mu.Lock()
+	var instances []*FunctionState
+	for _, instance := range runtime.instances {
+		state := functionState(instance, "")
+		runtime.setFailureState(state)
+		instances = append(instances, state)
+	}
+	runtime.mu.Unlock()
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	status.Functions = append(status.Functions, instances...)
+
+	return status
+}
+
+func (a *Admin) getStatus(w http.ResponseWriter, r *http.Request) {
+	writeEncoded(w, r, http.StatusOK, buildStatus(a.runtime, a.gateway))
+}
+
+// functionAction enables, disables, starts, stops, restarts or releases from quarantine the function named in the path.
+func (a *Admin) functionAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := a.function(w, r)
+		if !ok {
+			return
+		}
+		f := a.runtime.FunctionByName(name)
+
+		var err error
+		if record := a.runtime.quarantine.Quarantined(name); record != nil && action != "unquarantine" && action != "disable" {
+			err = fmt.Errorf("function %v is quarantined, unquarantine it first", name)
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+		switch action {
+		case "unquarantine":
+			if a.runtime.quarantine == nil {
+				writeJSONError(w, http.StatusConflict, fmt.Errorf("quarantine isn't configured"))
+				return
+			}
+			err = a.runtime.quarantine.Release(f)
+		case "enable":
+			err = a.runtime.SetFunctionEnabled(f, true)
+		case "disable":
+			err = a.runtime.SetFunctionEnabled(f, false)
+		case "start", "stop", "restart":
+			if !f.IsEnabled {
+				err = fmt.Errorf("function %v is disabled", name)
+				writeJSONError(w, http.StatusConflict, err)
+				return
+			}
+			switch action {
+			case "start":
+				err = a.runtime.StartFunction(f)
+			case "stop":
+				err = a.runtime.StopFunction(f)
+			default:
+				err = a.runtime.RestartFunction(f)
+			}
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		state := functionState(f, a.gateway.functionURL(f.Name))
+		a.runtime.quarantine.setState(state)
+		writeJSON(w, http.StatusOK, state)
+	}
+}
@@ -0,0 +1,31 @@
+package slrun
+
+import (
+	"net"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestLANListenerURL(t *testing.T) {
+	lanIPs := []net.IP{net.ParseIP("fd00::20"), net.ParseIP("192.168.1.20")}
+	tests := []struct {
+		listener *types.Listener
+		ips      []net.IP
+		want     string
+	}{
+		{&types.Listener{Address: ":8080"}, lanIPs, "http://192.168.1.20:8080"},
+		{&types.Listener{Address: "[::]:8080"}, lanIPs[:1], "http://[fd00::20]:8080"},
+		// Listening on IPv4 only
+		{&types.Listener{Address: "0.0.0.0:8080"}, lanIPs[:1], ""},
+		{&types.Listener{Address: "127.0.0.1:8080"}, lanIPs, ""},
+		{&types.Listener{Address: "localhost:8080"}, lanIPs, ""},
+		{&types.Listener{Address: "192.168.1.21:8443", TLSCert: "cert.pem", TLSKey: "key.pem"}, lanIPs, "https://192.168.1.21:8443"},
+		{&types.Listener{Address: ":8080"}, nil, ""},
+	}
+	for _, test := range tests {
+		if got := lanListenerURL(test.listener, test.ips); got != test.want {
+			t.Errorf("lanListenerURL(%v, %v) = %q, want %q", test.listener.Address, test.ips, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package slrun
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// Request body transforms
+const (
+	TransformFormToJSON = "form_to_json"
+)
+
+// Where upload dirs are mounted in function containers
+const containerUploadDir = "/slrun/uploads"
+
+// Bodies of functions without max_upload_bytes are transformed up to this size,
+// since their files are written to the host
+const defaultTransformMaxBytes = 32 << 20
+
+// uploadedFile references a file part spilled to the upload dir.
+type uploadedFile struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Path        string `json:"path"` // Path in the function container
+}
+
+// functionUploadDir returns the host dir mounted as a function's upload dir.
+func functionUploadDir(uploadDir string, function string) string {
+	return filepath.Join(uploadDir, function)
+}
+
+// transformFormToJSON replaces a form request body with a JSON object of its fields.
+// Fields with one value map to a string and others to a list. File parts are written
+// to the function's upload dir and referenced by their path in the container.
+// Bodies are read up to maxBytes, or defaultTransformMaxBytes if zero.
+// Returns a cleanup function removing the written files.
+func transformFormToJSON(r *http.Request, uploadDir string, function string, maxBytes int64) (func(), error) {
+	cleanup := func() {}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return cleanup, nil // Not a form, leave it be
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultTransformMaxBytes
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, maxBytes)
+
+	fields := make(map[string][]any)
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		err := r.ParseForm()
+		if err != nil {
+			return cleanup, err
+		}
+		for name, values := range r.PostForm {
+			for _, v := range values {
+				fields[name] = append(fields[name], v)
+			}
+		}
+
+	case "multipart/form-data":
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return cleanup, err
+		}
+
+		// Files of this request go in their own dir
+		id := make([]byte, 8)
+		_, err = rand.Read(id)
+		if err != nil {
+			return cleanup, err
+		}
+		reqDir := hex.EncodeToString(id)
+		hostDir := filepath.Join(functionUploadDir(uploadDir, function), reqDir)
+		cleanup = func() { os.RemoveAll(hostDir) }
+
+		for i := 0; ; i++ {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return cleanup, err
+			}
+
+			name := part.FormName()
+			if part.FileName() == "" {
+				value, err := io.ReadAll(part)
+				if err != nil {
+					return cleanup, err
+				}
+				fields[name] = append(fields[name], string(value))
+				continue
+			}
+
+			err = os.MkdirAll(hostDir, 0755)
+			if err != nil {
+				return cleanup, err
+			}
+
+			// Don't trust the client's file name for the path
+			file := strconv.Itoa(i) + filepath.Ext(filepath.Base(part.FileName()))
+			f, err := os.Create(filepath.Join(hostDir, file))
+			if err != nil {
+				return cleanup, err
+			}
+			size, err := io.Copy(f, part)
+			f.Close()
+			if err != nil {
+				return cleanup, err
+			}
+
+			fields[name] = append(fields[name], uploadedFile{
+				Filename:    part.FileName(),
+				ContentType: part.Header.Get("Content-Type"),
+				Size:        size,
+				Path:        path.Join(containerUploadDir, reqDir, file),
+			})
+		}
+
+	default:
+		return cleanup, nil
+	}
+
+	object := make(map[string]any)
+	for name, values := range fields {
+		if len(values) == 1 {
+			object[name] = values[0]
+		} else {
+			object[name] = values
+		}
+	}
+	body, err := json.Marshal(object)
+	if err != nil {
+		return cleanup, err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return cleanup, nil
+}
@@ -0,0 +1,143 @@
+package slrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func multipartBody(t *testing.T, fields map[string]string, files map[string]string) (string, io.Reader) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	for name, content := range files {
+		fw, err := mw.CreateFormFile(name, name+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	return mw.FormDataContentType(), &buf
+}
+
+func TestTransformFormToJSON(t *testing.T) {
+	mpType, mpBody := multipartBody(t, map[string]string{"title": "report"}, map[string]string{"doc": "hello"})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        io.Reader
+		maxBytes    int64
+		wantErr     bool
+		wantJSON    bool
+		check       func(t *testing.T, object map[string]any, uploadDir string)
+	}{
+		{
+			name:        "urlencoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        strings.NewReader("a=1&b=2&b=3"),
+			wantJSON:    true,
+			check: func(t *testing.T, object map[string]any, _ string) {
+				if object["a"] != "1" {
+					t.Errorf("a = %v, want 1", object["a"])
+				}
+				b, _ := object["b"].([]any)
+				if len(b) != 2 || b[0] != "2" || b[1] != "3" {
+					t.Errorf("b = %v, want [2 3]", object["b"])
+				}
+			},
+		},
+		{
+			name:        "multipart",
+			contentType: mpType,
+			body:        mpBody,
+			wantJSON:    true,
+			check: func(t *testing.T, object map[string]any, uploadDir string) {
+				if object["title"] != "report" {
+					t.Errorf("title = %v, want report", object["title"])
+				}
+				doc, _ := object["doc"].(map[string]any)
+				if doc["filename"] != "doc.txt" || doc["size"] != float64(5) {
+					t.Fatalf("doc = %v, want doc.txt of 5 bytes", object["doc"])
+				}
+				rel, ok := strings.CutPrefix(doc["path"].(string), containerUploadDir+"/")
+				if !ok {
+					t.Fatalf("doc path %v not in %v", doc["path"], containerUploadDir)
+				}
+				content, err := os.ReadFile(filepath.Join(functionUploadDir(uploadDir, "func1"), path.Clean(rel)))
+				if err != nil || string(content) != "hello" {
+					t.Errorf("uploaded file = %q, %v, want hello", content, err)
+				}
+			},
+		},
+		{
+			name:        "json left alone",
+			contentType: "application/json",
+			body:        strings.NewReader(`{"a":1}`),
+		},
+		{
+			name: "no content type",
+			body: strings.NewReader("a=1"),
+		},
+		{
+			name:        "over limit",
+			contentType: "application/x-www-form-urlencoded",
+			body:        strings.NewReader("a=" + strings.Repeat("x", 100)),
+			maxBytes:    10,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadDir := t.TempDir()
+			r := httptest.NewRequest(http.MethodPost, "/func1", tt.body)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			cleanup, err := transformFormToJSON(r, uploadDir, "func1", tt.maxBytes)
+			defer cleanup()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("transformFormToJSON() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			isJSON := r.Header.Get("Content-Type") == "application/json" && tt.contentType != "application/json"
+			if isJSON != tt.wantJSON {
+				t.Fatalf("Content-Type = %q, transformed %v, want %v", r.Header.Get("Content-Type"), isJSON, tt.wantJSON)
+			}
+			if !tt.wantJSON {
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.ContentLength != int64(len(body)) {
+				t.Errorf("ContentLength = %v, want %v", r.ContentLength, len(body))
+			}
+			var object map[string]any
+			err = json.Unmarshal(body, &object)
+			if err != nil {
+				t.Fatalf("body %q isn't a JSON object: %v", body, err)
+			}
+			tt.check(t, object, uploadDir)
+		})
+	}
+}
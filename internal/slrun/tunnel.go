@@ -0,0 +1,159 @@
+package slrun
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Tunnel drivers sharing a function publicly
+const (
+	TunnelCloudflared = "cloudflared"
+	TunnelNgrok       = "ngrok"
+)
+
+var tunnelDrivers = []string{TunnelCloudflared, TunnelNgrok}
+
+// How long a tunnel may take to report its public URL
+const tunnelStartTimeout = 30 * time.Second
+
+// tunnelURLPatterns find the public URL in each driver's output
+var tunnelURLPatterns = map[string]*regexp.Regexp{
+	TunnelCloudflared: regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`),
+	TunnelNgrok:       regexp.MustCompile(`"url":"(https://[^"]+)"`),
+}
+
+// tunnelCommand returns the command running driver's tunnel to the local address.
+func tunnelCommand(ctx context.Context, driver string, address string) (*exec.Cmd, error) {
+	switch driver {
+	case TunnelCloudflared:
+		return exec.CommandContext(ctx, "cloudflared", "tunnel", "--no-autoupdate", "--url", "http://"+address), nil
+	case TunnelNgrok:
+		return exec.CommandContext(ctx, "ngrok", "http", address, "--log", "stdout", "--log-format", "json"), nil
+	}
+	return nil, fmt.Errorf("unknown tunnel driver %v, expected one of %v", driver, tunnelDrivers)
+}
+
+// scanTunnelURL returns the first public URL driver writes to out.
+// The rest of out is read and discarded, so the driver never blocks writing.
+func scanTunnelURL(driver string, out io.Reader) <-chan string {
+	found := make(chan string, 1)
+	pattern := tunnelURLPatterns[driver]
+	go func() {
+		scanner := bufio.NewScanner(out)
+		sent := false
+		for scanner.Scan() {
+			match := pattern.FindStringSubmatch(scanner.Text())
+			if sent || match == nil {
+				continue
+			}
+			found <- match[len(match)-1]
+			sent = true
+		}
+		io.Copy(io.Discard, out)
+	}()
+	return found
+}
+
+// shareProxy returns a handler proxying every request to the function at functionURL,
+// so the tunnel exposes only that function rather than the whole gateway.
+// Paths are cleaned first, so /../other can't reach other functions.
+func shareProxy(functionURL *url.URL) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			cleaned := path.Clean("/" + r.Out.URL.Path)
+			if strings.HasSuffix(r.Out.URL.Path, "/") && cleaned != "/" {
+				cleaned += "/"
+			}
+			r.Out.URL.Path, r.Out.URL.RawPath = cleaned, ""
+			r.SetURL(functionURL)
+			r.SetXForwarded()
+		},
+	}
+}
+
+// Tunnel shares one function publicly through a tunnel driver.
+type Tunnel struct {
+	URL    string // Public URL of the function
+	server *http.Server
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	done   chan error // Receives the driver's exit
+}
+
+// StartTunnel shares the function served at functionURL, e.g. http://localhost:8080/func1,
+// through driver, returning once the tunnel has a public URL.
+func StartTunnel(driver string, functionURL string) (*Tunnel, error) {
+	target, err := url.Parse(functionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	t := &Tunnel{server: &http.Server{Handler: shareProxy(target)}, done: make(chan error, 1)}
+	go t.server.Serve(l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.cmd, err = tunnelCommand(ctx, driver, l.Addr().String())
+	if err != nil {
+		t.Stop()
+		return nil, err
+	}
+	// cloudflared logs to stderr, ngrok to stdout
+	out, pw := io.Pipe()
+	t.cmd.Stdout = pw
+	t.cmd.Stderr = pw
+	err = t.cmd.Start()
+	if err != nil {
+		t.Stop()
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("tunnel driver %v is not installed: %w", driver, err)
+		}
+		return nil, err
+	}
+	found := scanTunnelURL(driver, out)
+	go func() {
+		err := t.cmd.Wait()
+		pw.Close()
+		t.done <- err
+	}()
+
+	select {
+	case t.URL = <-found:
+		return t, nil
+	case err := <-t.done:
+		t.Stop()
+		return nil, fmt.Errorf("tunnel driver %v exited before sharing: %v", driver, err)
+	case <-time.After(tunnelStartTimeout):
+		t.Stop()
+		return nil, fmt.Errorf("tunnel driver %v didn't report a public URL within %v", driver, tunnelStartTimeout)
+	}
+}
+
+// Done receives the driver's exit, if the tunnel fails before it is stopped.
+func (t *Tunnel) Done() <-chan error {
+	return t.done
+}
+
+// Stop closes the tunnel and its local proxy.
+func (t *Tunnel) Stop() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.server.Close()
+}
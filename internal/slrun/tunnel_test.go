@@ -0,0 +1,74 @@
+package slrun
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanTunnelURL(t *testing.T) {
+	tests := []struct {
+		driver string
+		output string
+		want   string
+	}{
+		{
+			driver: TunnelCloudflared,
+			output: "2024-01-01T00:00:00Z INF Requesting new quick Tunnel on trycloudflare.com...\n" +
+				"2024-01-01T00:00:01Z INF |  https://brave-lion-cat.trycloudflare.com  |\n",
+			want: "https://brave-lion-cat.trycloudflare.com",
+		},
+		{
+			driver: TunnelNgrok,
+			output: `{"lvl":"info","msg":"starting web service","addr":"127.0.0.1:4040"}` + "\n" +
+				`{"lvl":"info","msg":"started tunnel","name":"command_line","addr":"http://127.0.0.1:5000","url":"https://ab12.ngrok-free.app"}` + "\n",
+			want: "https://ab12.ngrok-free.app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			select {
+			case got := <-scanTunnelURL(tt.driver, strings.NewReader(tt.output)):
+				if got != tt.want {
+					t.Errorf("scanTunnelURL() = %q, want %q", got, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("scanTunnelURL() found no URL, want %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestShareProxy(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer gateway.Close()
+	functionURL, _ := url.Parse(gateway.URL + "/func1")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/func1/"},
+		{"/users/7?full=1", "/func1/users/7?full=1"},
+		{"/../func2", "/func1/func2"},
+		{"/a/./b/", "/func1/a/b/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			shareProxy(functionURL).ServeHTTP(rec, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("proxied %q to %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
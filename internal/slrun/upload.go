@@ -0,0 +1,59 @@
+package slrun
+
+import (
+	"io"
+	"time"
+)
+
+// How often upload progress is published
+const uploadProgressInterval = 500 * time.Millisecond
+
+// progressReader publishes upload progress events as a request body is read.
+type progressReader struct {
+	io.ReadCloser
+	events    *Events
+	function  string
+	requestID string
+	total     int64 // -1 if unknown
+	read      int64
+	last      time.Time
+	done      bool
+}
+
+func newProgressReader(body io.ReadCloser, events *Events, function string, requestID string, total int64) *progressReader {
+	return &progressReader{
+		ReadCloser: body,
+		events:     events,
+		function:   function,
+		requestID:  requestID,
+		total:      total,
+		last:       time.Now(),
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.read += int64(n)
+
+	if err == io.EOF && !p.done {
+		p.done = true
+		p.publish(EventUploadComplete)
+	} else if time.Since(p.last) >= uploadProgressInterval {
+		p.last = time.Now()
+		p.publish(EventUploadProgress)
+	}
+	return n, err
+}
+
+func (p *progressReader) publish(eventType string) {
+	data := map[string]any{"bytes": p.read}
+	if p.total >= 0 {
+		data["total"] = p.total
+	}
+	p.events.Publish(Event{
+		Type:      eventType,
+		Function:  p.function,
+		RequestID: p.requestID,
+		Data:      data,
+	})
+}
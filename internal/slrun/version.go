@@ -0,0 +1,81 @@
+package slrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Version of slrun, set at build time with
+// -ldflags "-X github.com/marcorentap/slrun/internal/slrun.Version=v1.2.3"
+var Version = "dev"
+
+// APIVersion of the admin API. Bumped on incompatible changes,
+// clients and daemons of different API versions refuse to talk.
+const APIVersion = 1
+
+// apiVersionHeader carries the admin API version of clients and daemons.
+const apiVersionHeader = "Slrun-Api-Version"
+
+// VersionInfo is the version of a slrun daemon or client.
+type VersionInfo struct {
+	Version    string `json:"version"`
+	APIVersion int    `json:"api_version"`
+}
+
+func currentVersion() VersionInfo {
+	return VersionInfo{Version: Version, APIVersion: APIVersion}
+}
+
+// checkAPIVersion rejects admin requests from clients of another API version.
+// Clients that don't send their API version, e.g. curl, are let through.
+func checkAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, strconv.Itoa(APIVersion))
+
+		header := r.Header.Get(apiVersionHeader)
+		if header != "" && header != strconv.Itoa(APIVersion) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error":       fmt.Sprintf("client admin API version %v is not supported by slrun %v (API version %v)", header, Version, APIVersion),
+				"version":     Version,
+				"api_version": APIVersion,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Admin) getVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, currentVersion())
+}
+
+// DaemonVersion returns the version of the slrun daemon serving the admin API at address,
+// failing with an explanation if it isn't compatible with this client.
+func DaemonVersion(address string) (*VersionInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+"/admin/version", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(apiVersionHeader, strconv.Itoa(APIVersion))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach slrun daemon at %v: %w", address, err)
+	}
+	defer resp.Body.Close()
+
+	var daemon VersionInfo
+	err = json.NewDecoder(resp.Body).Decode(&daemon)
+	if err != nil {
+		return nil, fmt.Errorf("slrun daemon at %v sent an invalid version: %w", address, err)
+	}
+	if daemon.APIVersion != APIVersion {
+		return &daemon, fmt.Errorf("slrun daemon at %v is %v (API version %v) but this client is %v (API version %v), "+
+			"run `slrun self-update --version %v` to match it", address, daemon.Version, daemon.APIVersion, Version, APIVersion, daemon.Version)
+	}
+	return &daemon, nil
+}
@@ -0,0 +1,153 @@
+package slrun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Debuggers editor attach configs can be generated for
+var debuggers = []string{"node", "go", "python", "java"}
+
+// Tasks and launch configurations generated by slrun are labelled with this prefix,
+// and replaced when exported again. Others are kept.
+const vscodePrefix = "slrun: "
+
+// VSCodeTasks returns VS Code tasks running slrun with cfgFile, and checking or restarting its functions.
+func VSCodeTasks(config *types.Config, cfgFile string) []map[string]any {
+	tasks := []map[string]any{
+		{
+			"label":        vscodePrefix + "start",
+			"type":         "shell",
+			"command":      "slrun",
+			"args":         []string{"--config", cfgFile},
+			"isBackground": true,
+			// Lets launch configs wait for slrun, via preLaunchTask, until its runtime has started
+			"problemMatcher": map[string]any{
+				"owner":   "slrun",
+				"pattern": map[string]any{"regexp": "^__never_matches__$"},
+				"background": map[string]any{
+					"activelyBegins": true,
+					"beginsPattern":  "function image",
+					"endsPattern":    "Runtime started",
+				},
+			},
+		},
+	}
+	if config.AdminAddress == "" {
+		return tasks
+	}
+
+	tasks = append(tasks, map[string]any{
+		"label":          vscodePrefix + "status",
+		"type":           "shell",
+		"command":        "slrun",
+		"args":           []string{"status", "--config", cfgFile},
+		"problemMatcher": []any{},
+	})
+	for _, f := range config.Functions {
+		tasks = append(tasks, map[string]any{
+			"label":          vscodePrefix + "restart " + f.Name,
+			"type":           "shell",
+			"command":        "slrun",
+			"args":           []string{"restart", f.Name, "--config", cfgFile},
+			"problemMatcher": []any{},
+		})
+	}
+	return tasks
+}
+
+// VSCodeLaunch returns VS Code configurations attaching to the debuggers of functions with a debug port.
+func VSCodeLaunch(config *types.Config) []map[string]any {
+	host := config.FunctionHost
+	if host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	launch := []map[string]any{}
+	for _, f := range config.Functions {
+		if f.DebugPort == 0 || f.Debugger == "" {
+			continue
+		}
+		c := map[string]any{
+			"name":    vscodePrefix + "attach " + f.Name,
+			"request": "attach",
+		}
+		switch f.Debugger {
+		case "node":
+			c["type"] = "node"
+			c["address"] = host
+			c["port"] = f.DebugPort
+		case "go":
+			c["type"] = "go"
+			c["mode"] = "remote"
+			c["host"] = host
+			c["port"] = f.DebugPort
+		case "python":
+			c["type"] = "debugpy"
+			c["connect"] = map[string]any{"host": host, "port": f.DebugPort}
+		case "java":
+			c["type"] = "java"
+			c["hostName"] = host
+			c["port"] = f.DebugPort
+		}
+		launch = append(launch, c)
+	}
+	return launch
+}
+
+// mergeVSCodeFile replaces the slrun entries under key in a VS Code JSON file with entries,
+// keeping everything else. The file is created if missing.
+func mergeVSCodeFile(file string, version string, key string, label string, entries []map[string]any) error {
+	doc := map[string]any{"version": version}
+	bytes, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(bytes, &doc)
+		if err != nil {
+			return fmt.Errorf("cannot merge into %v, it may have comments: %w", file, err)
+		}
+	}
+
+	merged := []any{}
+	existing, _ := doc[key].([]any)
+	for _, e := range existing {
+		if m, ok := e.(map[string]any); ok {
+			if name, _ := m[label].(string); strings.HasPrefix(name, vscodePrefix) {
+				continue
+			}
+		}
+		merged = append(merged, e)
+	}
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	doc[key] = merged
+
+	bytes, err = json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(bytes, '\n'), 0644)
+}
+
+// ExportVSCode writes slrun tasks and launch configurations into dir's tasks.json and launch.json.
+func ExportVSCode(config *types.Config, cfgFile string, dir string) error {
+	err := mergeVSCodeFile(filepath.Join(dir, "tasks.json"), "2.0.0", "tasks", "label", VSCodeTasks(config, cfgFile))
+	if err != nil {
+		return err
+	}
+	return mergeVSCodeFile(filepath.Join(dir, "launch.json"), "0.2.0", "configurations", "name", VSCodeLaunch(config))
+}
@@ -0,0 +1,82 @@
+package slrun
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// warmOnce returns the warm-up of the function's current container, run at most once.
+func (r *Runtime) warmOnce(function *types.Function) *sync.Once {
+	once, _ := r.warmups.LoadOrStore(function.ContainerId, &sync.Once{})
+	return once.(*sync.Once)
+}
+
+// warmUp sends the function's warm-up requests to its container, once it is ready.
+// Calls wait for it, so it is done before the container serves traffic.
+func (r *Runtime) warmUp(function *types.Function) {
+	if function.Warmup == nil {
+		return
+	}
+	r.warmOnce(function).Do(func() {
+		err := r.waitReady(function)
+		if err != nil {
+			log.Printf("Cannot warm up function %v: %v\n", function.Name, err)
+			return
+		}
+
+		warmup := function.Warmup
+		start := time.Now()
+		var failed atomic.Int64
+		requests := make(chan struct{})
+		var wg sync.WaitGroup
+		for range warmup.Concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range requests {
+					if !r.warmupRequest(function, warmup) {
+						failed.Add(1)
+					}
+				}
+			}()
+		}
+		for range warmup.Count {
+			requests <- struct{}{}
+		}
+		close(requests)
+		wg.Wait()
+
+		log.Printf("Warmed up function %v with %v requests in %v, %v failed\n", function.Name, warmup.Count, time.Since(start), failed.Load())
+		r.events.Publish(Event{
+			Type:     EventWarmedUp,
+			Function: function.Name,
+			Data: map[string]any{
+				"requests": warmup.Count,
+				"failed":   failed.Load(),
+				"duration": time.Since(start).String(),
+			},
+		})
+	})
+}
+
+// warmupRequest sends one warm-up request, returning whether it got a non-5xx response.
+func (r *Runtime) warmupRequest(function *types.Function, warmup *types.Warmup) bool {
+	req, err := http.NewRequest(warmup.Method, r.functionURL(function, warmup.Path), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("X-Slrun-Warmup", "1")
+	resp, err := r.functionClient(function).Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode < 500
+}
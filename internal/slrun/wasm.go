@@ -0,0 +1,244 @@
+package slrun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Magic number starting WASM modules
+const wasmMagic = "\x00asm"
+
+// validateWasm checks the function's WASM module and runs it as a process function: slrun
+// serving the module, as ServeWasm.
+func validateWasm(config *types.Config, f *types.Function) error {
+	file, err := os.Open(f.Wasm)
+	if err != nil {
+		return fieldError(functionField(config, f, "wasm"), "function %s wasm module can't be read: %s", f.Name, f.Wasm)
+	}
+	defer file.Close()
+	magic := make([]byte, len(wasmMagic))
+	_, err = file.Read(magic)
+	if err != nil || string(magic) != wasmMagic {
+		return fieldError(functionField(config, f, "wasm"), "function %s wasm is not a WASM module: %s", f.Name, f.Wasm)
+	}
+	// Modules run wherever slrun is started from, like processes
+	f.Wasm, err = filepath.Abs(f.Wasm)
+	if err != nil {
+		return &configFieldError{Field: functionField(config, f, "wasm"), Err: err}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	f.Process = &types.Process{
+		Command: []string{exe, "wasm", f.Wasm},
+		Dir:     filepath.Dir(f.Wasm),
+	}
+	return nil
+}
+
+// wasmEnv returns the function's env in environ, the env of the process serving its module:
+// without the variables slrun passes every process.
+func wasmEnv(environ []string) []string {
+	var env []string
+	for _, v := range environ {
+		name, _, _ := strings.Cut(v, "=")
+		if !slices.Contains(processEnvAllowList, name) && name != "PORT" && name != "SLRUN_PORT" && name != "SLRUN_HOST" {
+			env = append(env, v)
+		}
+	}
+	return env
+}
+
+// wasmModule is a WASI module compiled once, then run for each request as a WAGI handler:
+// the request is in its CGI env and stdin, and it writes the response to stdout, headers
+// first. It has the function's env and reads its dir, mounted read-only as /.
+type wasmModule struct {
+	path     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	env      []string
+}
+
+// newWasmModule compiles the module at path, with the cache's compiled modules if it has it.
+func newWasmModule(ctx context.Context, path string, env []string, cache wazero.CompilationCache) (*wasmModule, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := wazero.NewRuntimeConfig().WithCompilationCache(cache).WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("cannot compile %v: %w", path, err)
+	}
+	return &wasmModule{path: path, runtime: runtime, compiled: compiled, env: env}, nil
+}
+
+func (m *wasmModule) Close(ctx context.Context) error {
+	return m.runtime.Close(ctx)
+}
+
+func (m *wasmModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var stdout bytes.Buffer
+	config := wazero.NewModuleConfig().
+		// Unnamed, so requests run their own instance at once
+		WithName("").
+		WithArgs(filepath.Base(m.path)).
+		WithStdin(r.Body).
+		WithStdout(&stdout).
+		WithStderr(os.Stderr).
+		WithFSConfig(wazero.NewFSConfig().WithReadOnlyDirMount(filepath.Dir(m.path), "/")).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	for _, v := range slices.Concat(m.env, cgiEnv(r)) {
+		name, value, _ := strings.Cut(v, "=")
+		config = config.WithEnv(name, value)
+	}
+
+	// Its instance is closed as the request is canceled
+	mod, err := m.runtime.InstantiateModule(r.Context(), m.compiled, config)
+	if mod != nil {
+		mod.Close(r.Context())
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		log.Printf("Cannot run %v: %v\n", m.path, err)
+		http.Error(w, "module failed", http.StatusInternalServerError)
+		return
+	}
+	writeWagiResponse(w, &stdout)
+}
+
+// cgiEnv returns the CGI env of the request.
+func cgiEnv(r *http.Request) []string {
+	host, port, _ := net.SplitHostPort(r.Host)
+	remoteHost, remotePort, _ := net.SplitHostPort(r.RemoteAddr)
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_SOFTWARE=slrun",
+		"SERVER_PROTOCOL=" + r.Proto,
+		"SERVER_NAME=" + host,
+		"SERVER_PORT=" + port,
+		"REQUEST_METHOD=" + r.Method,
+		"REQUEST_URI=" + r.URL.RequestURI(),
+		"SCRIPT_NAME=",
+		"PATH_INFO=" + r.URL.Path,
+		"QUERY_STRING=" + r.URL.RawQuery,
+		"REMOTE_ADDR=" + remoteHost,
+		"REMOTE_HOST=" + remoteHost,
+		"REMOTE_PORT=" + remotePort,
+		// WAGI's own
+		"X_MATCHED_ROUTE=/...",
+		"X_RAW_PATH_INFO=" + r.URL.EscapedPath(),
+		"X_FULL_URL=http://" + r.Host + r.URL.RequestURI(),
+	}
+	if r.ContentLength > 0 {
+		env = append(env, "CONTENT_LENGTH="+strconv.FormatInt(r.ContentLength, 10))
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		env = append(env, "CONTENT_TYPE="+contentType)
+	}
+	for _, name := range sortedKeys(r.Header) {
+		if name == "Content-Type" || name == "Content-Length" {
+			continue
+		}
+		env = append(env, "HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))+"="+strings.Join(r.Header[name], ", "))
+	}
+	return env
+}
+
+// writeWagiResponse writes the response a module wrote to stdout: its headers, with its status
+// in Status, or a redirect to Location, then an empty line and the body.
+func writeWagiResponse(w http.ResponseWriter, stdout io.Reader) {
+	br := bufio.NewReader(stdout)
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		http.Error(w, "module wrote no response headers", http.StatusBadGateway)
+		return
+	}
+	status := http.StatusOK
+	if s := header.Get("Status"); s != "" {
+		code, _, _ := strings.Cut(s, " ")
+		status, err = strconv.Atoi(code)
+		if err != nil || status < 100 || status > 999 {
+			http.Error(w, "module wrote an invalid status", http.StatusBadGateway)
+			return
+		}
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+	header.Del("Status")
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(status)
+	io.Copy(w, br)
+}
+
+// ServeWasm serves a WASM function's module on the port in PORT of the address in SLRUN_HOST,
+// running it for each request, until it is sent SIGTERM or SIGINT. The module is compiled on
+// start, into slrun's user cache dir, so it is only compiled again when it changes.
+func ServeWasm(module string) error {
+	ctx := context.Background()
+	var cache wazero.CompilationCache
+	cacheDir, err := os.UserCacheDir()
+	if err == nil {
+		cache, err = wazero.NewCompilationCacheWithDir(filepath.Join(cacheDir, "slrun", "wasm"))
+	}
+	if err != nil {
+		log.Printf("Cannot cache compiled WASM modules: %v\n", err)
+		cache = wazero.NewCompilationCache()
+	}
+	defer cache.Close(ctx)
+	handler, err := newWasmModule(ctx, module, wasmEnv(os.Environ()), cache)
+	if err != nil {
+		return err
+	}
+	defer handler.Close(ctx)
+
+	address := net.JoinHostPort(os.Getenv("SLRUN_HOST"), os.Getenv("PORT"))
+	server := &http.Server{Addr: address, Handler: handler}
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	shutdown := make(chan error, 1)
+	go func() {
+		<-sigCtx.Done()
+		// Until slrun kills it after its stop timeout
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	log.Printf("Serving %v on %v\n", module, address)
+	err = server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Requests in flight are answered first
+	return <-shutdown
+}
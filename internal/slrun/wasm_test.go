@@ -0,0 +1,172 @@
+package slrun
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/marcorentap/slrun/internal/types"
+	"github.com/tetratelabs/wazero"
+)
+
+// wagiModule returns a WASI module answering with headers, then its env, each variable
+// followed by a NUL byte.
+func wagiModule(headers string) []byte {
+	uleb := func(n int) []byte { return binary.AppendUvarint(nil, uint64(n)) }
+	vec := func(items ...[]byte) []byte { return slices.Concat(append([][]byte{uleb(len(items))}, items...)...) }
+	name := func(s string) []byte { return append(uleb(len(s)), s...) }
+	section := func(id byte, content []byte) []byte { return slices.Concat([]byte{id}, uleb(len(content)), content) }
+	i32 := func(n int) []byte {
+		code := []byte{0x41}
+		for ; n >= 0x40; n >>= 7 {
+			code = append(code, byte(n&0x7f)|0x80)
+		}
+		return append(code, byte(n))
+	}
+	call := func(f byte) []byte { return []byte{0x10, f, 0x1a} } // Dropping its errno
+	wasi := func(field string, typ byte) []byte {
+		return slices.Concat(name("wasi_snapshot_preview1"), name(field), []byte{0x00, typ})
+	}
+
+	// Headers at 16, their iovec at 0, env sizes at 256, env pointers at 512, env at 1024 and
+	// its iovec at 264
+	iovec := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 16), uint32(len(headers)))
+	code := slices.Concat(
+		i32(1), i32(0), i32(1), i32(8), call(0), // fd_write(stdout, headers)
+		i32(256), i32(260), call(1), // environ_sizes_get
+		i32(512), i32(1024), call(2), // environ_get
+		i32(264), i32(1024), []byte{0x36, 0x02, 0x00}, // iovec pointer
+		i32(268), i32(260), []byte{0x28, 0x02, 0x00, 0x36, 0x02, 0x00}, // iovec length, the env size
+		i32(1), i32(264), i32(1), i32(8), call(0), // fd_write(stdout, env)
+		[]byte{0x0b},
+	)
+	body := slices.Concat([]byte{0x00}, code) // No locals
+	return slices.Concat(
+		[]byte(wasmMagic), []byte{0x01, 0x00, 0x00, 0x00},
+		section(1, vec(
+			[]byte{0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f}, // fd_write
+			[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f},             // environ_sizes_get and environ_get
+			[]byte{0x60, 0x00, 0x00},                               // _start
+		)),
+		section(2, vec(wasi("fd_write", 0), wasi("environ_sizes_get", 1), wasi("environ_get", 1))),
+		section(3, vec([]byte{0x02})),
+		section(5, vec([]byte{0x00, 0x01})),
+		section(7, vec(slices.Concat(name("memory"), []byte{0x02, 0x00}), slices.Concat(name("_start"), []byte{0x00, 0x03}))),
+		section(10, vec(append(uleb(len(body)), body...))),
+		section(11, vec(
+			slices.Concat([]byte{0x00}, i32(0), []byte{0x0b}, name(string(iovec))),
+			slices.Concat([]byte{0x00}, i32(16), []byte{0x0b}, name(headers)),
+		)),
+	)
+}
+
+func TestWasmModule(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "fn.wasm")
+	if err := os.WriteFile(path, wagiModule("Content-Type: text/plain\nStatus: 201 Created\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := wazero.NewCompilationCache()
+	defer cache.Close(ctx)
+	module, err := newWasmModule(ctx, path, []string{"GREETING=hello"}, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer module.Close(ctx)
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	for range 2 {
+		request := httptest.NewRequest("POST", "/func1?name=world", strings.NewReader("body"))
+		request.Header.Set("X-Tenant", "acme")
+		recorder := httptest.NewRecorder()
+		module.ServeHTTP(recorder, request)
+
+		env := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\x00"), "\x00")
+		if recorder.Code != 201 || recorder.Header().Get("Content-Type") != "text/plain" || recorder.Header().Get("Status") != "" {
+			t.Errorf("module response = %v %v, want 201 text/plain", recorder.Code, recorder.Header())
+		}
+		for _, v := range []string{"GREETING=hello", "REQUEST_METHOD=POST", "QUERY_STRING=name=world", "PATH_INFO=/func1", "HTTP_X_TENANT=acme", "CONTENT_LENGTH=4"} {
+			if !slices.Contains(env, v) {
+				t.Errorf("module env = %q, want %v in it", env, v)
+			}
+		}
+		if slices.ContainsFunc(env, func(v string) bool {
+			return strings.HasPrefix(v, "AWS_SECRET_ACCESS_KEY=") || strings.HasPrefix(v, "PATH=")
+		}) {
+			t.Errorf("module env = %q, want only the function's and the request's", env)
+		}
+	}
+}
+
+func TestWasmEnv(t *testing.T) {
+	got := wasmEnv([]string{"PATH=/usr/bin", "HOME=/root", "GREETING=hello", "PORT=8081", "SLRUN_HOST=127.0.0.1", "SLRUN_GATEWAY=http://localhost:8080"})
+	want := []string{"GREETING=hello", "SLRUN_GATEWAY=http://localhost:8080"}
+	if !slices.Equal(got, want) {
+		t.Errorf("wasmEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestWagiResponse(t *testing.T) {
+	tests := []struct {
+		stdout string
+		code   int
+		body   string
+	}{
+		{"Content-Type: text/plain\n\nhello", 200, "hello"},
+		{"Status: 404 Not Found\n\nmissing", 404, "missing"},
+		{"Location: /other\n\n", 302, ""},
+		{"Status: OK\n\n", 502, "module wrote an invalid status\n"},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		writeWagiResponse(recorder, strings.NewReader(test.stdout))
+		if recorder.Code != test.code || recorder.Body.String() != test.body {
+			t.Errorf("writeWagiResponse(%q) = %v %q, want %v %q", test.stdout, recorder.Code, recorder.Body.String(), test.code, test.body)
+		}
+	}
+}
+
+func TestValidateWasm(t *testing.T) {
+	dir := t.TempDir()
+	module := filepath.Join(dir, "fn.wasm")
+	if err := os.WriteFile(module, []byte(wasmMagic+"\x01\x00\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "fn.js")
+	if err := os.WriteFile(script, []byte("console.log()"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		function *types.Function
+		err      string
+	}{
+		{&types.Function{Name: "func1", Wasm: module}, ""},
+		{&types.Function{Name: "func1", Wasm: filepath.Join(dir, "missing.wasm")}, "can't be read"},
+		{&types.Function{Name: "func1", Wasm: script}, "not a WASM module"},
+	}
+	for _, test := range tests {
+		config := &types.Config{Functions: []*types.Function{test.function}}
+		err := validateWasm(config, test.function)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("validateWasm(%v) = %v, want %q", test.function.Wasm, err, test.err)
+		}
+	}
+
+	// Served by slrun, as a process in the module's dir
+	f := &types.Function{Name: "func1", Wasm: module}
+	if err := validateWasm(&types.Config{Functions: []*types.Function{f}}, f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Process == nil || f.Process.Dir != dir || f.Process.Command[len(f.Process.Command)-1] != module {
+		t.Errorf("validateWasm() process = %+v, want serving %v in %v", f.Process, module, dir)
+	}
+	if err := validateProcess(&types.Config{Functions: []*types.Function{f}}, f); err != nil {
+		t.Errorf("validateProcess() of the WASM function = %v", err)
+	}
+}
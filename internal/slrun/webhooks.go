@@ -0,0 +1,230 @@
+package slrun
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+// Webhook signature schemes
+const (
+	WebhookGitHub = "github"
+	WebhookStripe = "stripe"
+	WebhookSlack  = "slack"
+	WebhookHMAC   = "hmac" // Hex HMAC-SHA256 of the body in a configured header
+)
+
+var webhookProviders = []string{WebhookGitHub, WebhookStripe, WebhookSlack, WebhookHMAC}
+
+// Path prefix webhooks are received under: /_slrun/webhooks/name
+const webhooksPrefix = "/_slrun/webhooks/"
+
+// Largest webhook body read for verification, of functions without max_upload_bytes
+const defaultWebhookMaxBytes = 10 << 20
+
+var errBadSignature = errors.New("invalid webhook signature")
+
+func validateWebhooks(config *types.Config) error {
+	names := make(map[string]bool)
+	for i, wh := range config.Webhooks {
+		field := fmt.Sprintf("webhooks[%d]", i)
+		if !functionNamePattern.MatchString(wh.Name) {
+			return fieldError(field+".name", "invalid webhook name %q", wh.Name)
+		}
+		if names[wh.Name] {
+			return fieldError(field+".name", "config has duplicate webhook name: %s", wh.Name)
+		}
+		names[wh.Name] = true
+
+		if !hasFunction(config, wh.Function) && !slices.ContainsFunc(config.Peers, func(p *types.Peer) bool { return slices.Contains(p.Functions, wh.Function) }) {
+			return fieldError(field+".function", "webhook %s has unknown function: %s", wh.Name, wh.Function)
+		}
+		if wh.Path == "" {
+			wh.Path = "/"
+		}
+		if !strings.HasPrefix(wh.Path, "/") {
+			return fieldError(field+".path", "webhook %s path must start with /: %s", wh.Name, wh.Path)
+		}
+		if !slices.Contains(webhookProviders, wh.Provider) {
+			return fieldError(field+".provider", "webhook %s has unknown provider %s, expected one of %v", wh.Name, wh.Provider, webhookProviders)
+		}
+		if wh.Provider == WebhookHMAC && wh.SignatureHeader == "" {
+			wh.SignatureHeader = "X-Signature"
+		}
+		if wh.Secret == nil {
+			return fieldError(field+".secret", "webhook %s has no secret", wh.Name)
+		}
+		if wh.Secret.Name == "" {
+			wh.Secret.Name = wh.Name
+		}
+		err := validateSecret(wh.Secret)
+		if err != nil {
+			return fieldError(field+".secret", "webhook %s secret: %w", wh.Name, err)
+		}
+		if wh.Tolerance == "" {
+			wh.Tolerance = "5m"
+		}
+		if _, err := time.ParseDuration(wh.Tolerance); err != nil {
+			return fieldError(field+".tolerance", "invalid webhook tolerance: %w", err)
+		}
+	}
+
+	for _, f := range config.Functions {
+		if !f.WebhookOnly {
+			continue
+		}
+		if !slices.ContainsFunc(config.Webhooks, func(wh *types.Webhook) bool { return wh.Function == f.Name }) {
+			return fieldError(functionField(config, f, "webhook_only"), "function %s is webhook_only but no webhook invokes it", f.Name)
+		}
+		fallback := config.Fallback == f.Name || slices.ContainsFunc(config.Listeners, func(l *types.Listener) bool { return l.Fallback == f.Name })
+		if fallback {
+			return fieldError(functionField(config, f, "webhook_only"), "function %s is webhook_only, it can't be a fallback", f.Name)
+		}
+	}
+	return nil
+}
+
+// hmacHex returns the hex HMAC-SHA256 of the parts with key.
+func hmacHex(key string, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// equalSignature compares signatures in constant time.
+func equalSignature(got string, want string) bool {
+	return hmac.Equal([]byte(got), []byte(want))
+}
+
+// checkTimestamp checks a signed unix timestamp is within tolerance of now, against replays.
+func checkTimestamp(ts string, tolerance time.Duration, now time.Time) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp %q", errBadSignature, ts)
+	}
+	age := now.Sub(time.Unix(sec, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance of %v", errBadSignature, tolerance)
+	}
+	return nil
+}
+
+// verifyWebhook checks the signature of a webhook's request with body, by its provider's scheme.
+func verifyWebhook(wh *types.Webhook, secret string, header http.Header, body []byte, now time.Time) error {
+	tolerance, _ := time.ParseDuration(wh.Tolerance)
+
+	switch wh.Provider {
+	case WebhookGitHub:
+		sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !equalSignature(sig, hmacHex(secret, body)) {
+			return errBadSignature
+		}
+
+	case WebhookStripe:
+		// t=1700000000,v1=abc,v1=def: any v1 may match, to allow rotating secrets
+		var ts string
+		var sigs []string
+		for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(part, "=")
+			switch key {
+			case "t":
+				ts = value
+			case "v1":
+				sigs = append(sigs, value)
+			}
+		}
+		if ts == "" || len(sigs) == 0 {
+			return errBadSignature
+		}
+		want := hmacHex(secret, []byte(ts), []byte("."), body)
+		if !slices.ContainsFunc(sigs, func(sig string) bool { return equalSignature(sig, want) }) {
+			return errBadSignature
+		}
+		return checkTimestamp(ts, tolerance, now)
+
+	case WebhookSlack:
+		ts := header.Get("X-Slack-Request-Timestamp")
+		want := "v0=" + hmacHex(secret, []byte("v0:"+ts+":"), body)
+		if ts == "" || !equalSignature(header.Get("X-Slack-Signature"), want) {
+			return errBadSignature
+		}
+		return checkTimestamp(ts, tolerance, now)
+
+	case WebhookHMAC:
+		sig := strings.TrimPrefix(header.Get(wh.SignatureHeader), "sha256=")
+		if !equalSignature(sig, hmacHex(secret, body)) {
+			return errBadSignature
+		}
+	}
+	return nil
+}
+
+// webhookHandler verifies webhooks received under the webhooks prefix, then invokes their
+// function at their path. Webhooks skip the listener's routing: they reach functions it doesn't
+// route to, and webhook_only functions only ever see verified requests.
+func (g *Gateway) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	r.Header.Set(requestIDHeader, requestID(r))
+	w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+
+	g.inFlight.Add(1)
+	defer g.inFlight.Add(-1)
+	if g.refuseDraining(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	i := slices.IndexFunc(g.config.Webhooks, func(wh *types.Webhook) bool { return wh.Name == name })
+	if i < 0 {
+		err := invocationError(ErrClassNotFound, http.StatusNotFound, fmt.Errorf("webhook %v not found", name))
+		g.writeError(w, r, "", err)
+		return
+	}
+	wh := g.config.Webhooks[i]
+
+	maxBytes := int64(defaultWebhookMaxBytes)
+	if fun := g.runtime.FunctionByName(wh.Function); fun != nil && fun.MaxUploadBytes > 0 {
+		maxBytes = fun.MaxUploadBytes
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		g.writeError(w, r, wh.Function, bodyError(err, ErrClassBadRequest, http.StatusBadRequest))
+		return
+	}
+	secret, err := readSecret(wh.Secret)
+	if err != nil {
+		g.writeError(w, r, wh.Function, fmt.Errorf("cannot read webhook %v secret: %w", name, err))
+		return
+	}
+	err = verifyWebhook(wh, secret, r.Header, body, time.Now())
+	if err != nil {
+		g.writeError(w, r, wh.Function, invocationError(ErrClassUnverified, http.StatusUnauthorized, fmt.Errorf("webhook %v: %w", name, err)))
+		return
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if peer := g.peers.route(wh.Function); peer != nil {
+		g.relay(w, r, peer, wh.Function, wh.Path)
+		return
+	}
+	fun := g.runtime.FunctionByName(wh.Function)
+	if fun == nil {
+		err := invocationError(ErrClassNotFound, http.StatusNotFound, fmt.Errorf("function %v not found", wh.Function))
+		g.writeError(w, r, wh.Function, err)
+		return
+	}
+	g.invoke(newHeaderPolicyWriter(w, g.config.ResponseHeaders), r, fun, wh.Path)
+}
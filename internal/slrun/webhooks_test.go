@@ -0,0 +1,99 @@
+package slrun
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcorentap/slrun/internal/types"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	const secret = "s3cret"
+	body := []byte(`{"event":"push"}`)
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	old := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		provider string
+		header   map[string]string
+		wantErr  bool
+	}{
+		{"github", WebhookGitHub, map[string]string{"X-Hub-Signature-256": "sha256=" + hmacHex(secret, body)}, false},
+		{"github wrong secret", WebhookGitHub, map[string]string{"X-Hub-Signature-256": "sha256=" + hmacHex("other", body)}, true},
+		{"github no prefix", WebhookGitHub, map[string]string{"X-Hub-Signature-256": hmacHex(secret, body)}, true},
+		{"github missing", WebhookGitHub, nil, true},
+		{"stripe", WebhookStripe, map[string]string{"Stripe-Signature": "t=" + ts + ",v1=" + hmacHex(secret, []byte(ts+"."), body)}, false},
+		{"stripe rotated secret", WebhookStripe, map[string]string{"Stripe-Signature": "t=" + ts + ",v1=" + hmacHex("old", []byte(ts+"."), body) + ",v1=" + hmacHex(secret, []byte(ts+"."), body)}, false},
+		{"stripe replayed", WebhookStripe, map[string]string{"Stripe-Signature": "t=" + old + ",v1=" + hmacHex(secret, []byte(old+"."), body)}, true},
+		{"stripe tampered timestamp", WebhookStripe, map[string]string{"Stripe-Signature": "t=" + ts + ",v1=" + hmacHex(secret, []byte(old+"."), body)}, true},
+		{"slack", WebhookSlack, map[string]string{"X-Slack-Request-Timestamp": ts, "X-Slack-Signature": "v0=" + hmacHex(secret, []byte("v0:"+ts+":"), body)}, false},
+		{"slack replayed", WebhookSlack, map[string]string{"X-Slack-Request-Timestamp": old, "X-Slack-Signature": "v0=" + hmacHex(secret, []byte("v0:"+old+":"), body)}, true},
+		{"hmac", WebhookHMAC, map[string]string{"X-Signature": hmacHex(secret, body)}, false},
+		{"hmac prefixed", WebhookHMAC, map[string]string{"X-Signature": "sha256=" + hmacHex(secret, body)}, false},
+		{"hmac missing", WebhookHMAC, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := &types.Webhook{Provider: tt.provider, SignatureHeader: "X-Signature", Tolerance: "5m"}
+			header := make(http.Header)
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+			err := verifyWebhook(wh, secret, header, body, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyWebhook() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errBadSignature) {
+				t.Errorf("verifyWebhook() error = %v, want errBadSignature", err)
+			}
+		})
+	}
+}
+
+func TestWebhookOnly(t *testing.T) {
+	t.Setenv("DEPLOY_SECRET", "s3cret")
+	config := &types.Config{
+		Functions: []*types.Function{{Name: "deploy", Image: "deploy", WebhookOnly: true}},
+		Webhooks: []*types.Webhook{{
+			Name: "github", Function: "deploy", Path: "/push", Provider: WebhookGitHub,
+			Secret: &types.Secret{Env: "DEPLOY_SECRET"}, Tolerance: "5m",
+		}},
+	}
+	l := &types.Listener{Address: ":8080"}
+	g := &Gateway{config: config, runtime: &Runtime{functions: config.Functions}, listeners: []*types.Listener{l}}
+
+	// Not routed to, even on the listener receiving its webhooks
+	w := httptest.NewRecorder()
+	g.routeHandler(l).ServeHTTP(w, httptest.NewRequest("POST", "/deploy/push", strings.NewReader("{}")))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("request routed to webhook_only function = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	r := httptest.NewRequest("POST", webhooksPrefix+"github", strings.NewReader("{}"))
+	r.SetPathValue("name", "github")
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex("other", []byte("{}")))
+	w = httptest.NewRecorder()
+	g.webhookHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unverified webhook = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// Needs a webhook, and can't be reached as a fallback
+	config.Webhooks = nil
+	if err := validateWebhooks(config); err == nil || !strings.Contains(err.Error(), "no webhook invokes it") {
+		t.Errorf("validateWebhooks() without a webhook = %v, want it refused", err)
+	}
+	config.Webhooks = []*types.Webhook{{Name: "github", Function: "deploy", Provider: WebhookGitHub, Secret: &types.Secret{Env: "DEPLOY_SECRET"}}}
+	config.Fallback = "deploy"
+	if err := validateWebhooks(config); err == nil || !strings.Contains(err.Error(), "can't be a fallback") {
+		t.Errorf("validateWebhooks() with a webhook_only fallback = %v, want it refused", err)
+	}
+}
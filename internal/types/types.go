@@ -1,19 +1,577 @@
 package types
 
+import "time"
+
 type Function struct {
-	Name     string `json:"name"`
-	BuildDir string `json:"build_dir"`
+	Name     string            `json:"name"`
+	BuildDir string            `json:"build_dir"`
+	Image    string            `json:"image"`    // Prebuilt image run instead of building BuildDir
+	Env      map[string]string `json:"env"`      // Container environment variables
+	Secrets  []*Secret         `json:"secrets"`  // Env variables read when containers start, redacted from logs
+	Tenancy  *Tenancy          `json:"tenancy"`  // Per-tenant instances selected by a request header
+	Disabled bool              `json:"disabled"` // Disabled functions reject requests
+	// Override the function's settings at certain times, last matching profile wins
+	ScaleProfiles   []*ScaleProfile `json:"scale_profiles"`
+	ResponseHeaders *HeaderPolicy   `json:"response_headers"`
+	Transform       string          `json:"transform"`        // Request body transform, e.g. form_to_json
+	MaxUploadBytes  int64           `json:"max_upload_bytes"` // Request body size limit, unlimited if zero
+	TestCommand     string          `json:"test_command"`     // Run in the built image, must pass to deploy it
+	DebugPort       int             `json:"debug_port"`       // Container port of the function's debugger, published on the same host port
+	Debugger        string          `json:"debugger"`         // node, go, python or java, for editor attach configs
+	Socket          bool            `json:"socket"`           // Listen on the Unix socket $SLRUN_SOCKET instead of a TCP port
+	HostPort        int             `json:"host_port"`        // Static host port, allocated if zero. Not used by tenant instances
+	ListenPort      int             `json:"port"`             // Port it listens on in its container, the first its image exposes if zero
+	Warmup          *Warmup         `json:"warmup"`           // Requests sent to new containers before they serve traffic
+	Profile         string          `json:"profile"`          // Runtime profile setting defaults: node, jvm, python or go
+	StopSignal      string          `json:"stop_signal"`      // Signal stopping the container, default SIGTERM
+	StopTimeout     int             `json:"stop_timeout"`     // Seconds to wait for graceful shutdown before killing
+	ReadyPath       string          `json:"ready_path"`       // Path probed for readiness, default /
+	Readiness       *Readiness      `json:"readiness"`        // Probe replacing the ready_path one, if set
+	PreStop         *PreStop        `json:"pre_stop"`         // Run before the container is stopped
+	// In cluster mode, labels a node must have to run the function, e.g. ["gpu"]
+	Requires []string `json:"requires"`
+	// In cluster mode, label values a node must have to run the function, e.g. {"arch": "arm64"}
+	Constraints map[string]string `json:"constraints"`
+	Locality    string            `json:"locality"` // In cluster mode: prefer_local, local_only or any, default prefer_local
+	Scaling     *Scaling          `json:"scaling"`  // Scale on metrics, with the cold_on_idle policy
+	Priority    string            `json:"priority"` // Of async invocations: low, normal or high, default normal
+	SLO         *SLO              `json:"slo"`      // Latency and error objectives, alerting on burn rate
+	// Buffer whole responses, up to response_buffer_bytes, instead of streaming them
+	BufferResponse bool      `json:"buffer_response"`
+	Replicas       *Replicas `json:"replicas"`   // Run more containers under load, with the cold_on_idle policy
+	Handshake      bool      `json:"handshake"`  // Follows the slrun function contract, ready once /healthz answers 2xx
+	CPU            float64   `json:"cpu"`        // CPU cores its containers may use, e.g. 0.5, unlimited if zero
+	Memory         string    `json:"memory"`     // Memory limit of its containers, e.g. 256m or 1g, unlimited if empty
+	PidsLimit      int64     `json:"pids_limit"` // Processes its containers may run, unlimited if zero
+	Timeout        int       `json:"timeout"`    // Seconds a call may take from its arrival at the gateway, unlimited if zero
+	// Build dir paths sent in its build context, .dockerignore patterns, all if empty
+	ContextInclude []string `json:"context_include"`
+	// Build dir paths left out of its build context, after those its .dockerignore leaves out
+	ContextExclude []string `json:"context_exclude"`
+	// Feature flags passed to it, e.g. {"beta-ui": true}, overridable through the admin API
+	Flags map[string]bool `json:"flags"`
+	// Caps its calls in flight on this node, the config's bulkhead if nil
+	Bulkhead *Bulkhead `json:"bulkhead"`
+	// When its prebuilt image is pulled: missing, or always to pick up a tag pushed again, default missing
+	ImagePull string `json:"image_pull"`
+	// Dockerfile its image is built from, relative to build_dir, default Dockerfile
+	Dockerfile string `json:"dockerfile"`
+	// Values of the Dockerfile's ARG instructions
+	BuildArgs map[string]string `json:"build_args"`
+	// Stage of a multi-stage Dockerfile built, the last if empty
+	Target string `json:"target"`
+	// Share one call between identical GET requests in flight at once, disabled if nil
+	Coalesce *Coalesce `json:"coalesce"`
+	// Language build caches kept in volumes, mounted in dev mode: go, npm, pip or absolute paths
+	Caches []string `json:"caches"`
+	// Run as a local subprocess instead of a container, for fast iteration in development
+	Process *Process `json:"process"`
+	// WASI module run with wazero for each request, as a WAGI handler, instead of a container
+	Wasm string `json:"wasm"`
+	// Invoked only by its webhooks once their signature is verified, never routed to by listeners
+	WebhookOnly bool `json:"webhook_only"`
+
+	ImageName     string        `json:"-"`
+	ContainerId   string        `json:"-"`
+	IsRunning     bool          `json:"-"`
+	IsEnabled     bool          `json:"-"`
+	Port          int           `json:"-"` // 127.0.0.1:X->ContainerPort/tcp
+	Metadata      *Metadata     `json:"-"` // Read from the build dir
+	Tenant        string        `json:"-"` // Tenant of a per-tenant instance
+	SocketPath    string        `json:"-"` // Host path of the function's Unix socket
+	Remote        bool          `json:"-"` // Placed on other cluster nodes only
+	Desired       int           `json:"-"` // Replicas its scaling rules ask for
+	Base          string        `json:"-"` // Function a tenant or replica instance runs, empty for functions
+	IdleAfter     time.Duration `json:"-"` // Stopped by cold_on_idle after idling this long, default 5s
+	ContainerPort int           `json:"-"` // Port it listens on in its container, its port or the first its image exposes
+	Health        string        `json:"-"` // Of its container: starting, healthy or unhealthy, empty without a HEALTHCHECK
+	HealthTimeout time.Duration `json:"-"` // How long its container may take to first report healthy
+	Ready         bool          `json:"-"` // Its running container answered its readiness probe
+	Version       string        `json:"-"` // Short ID of the image its container runs
+}
+
+// Process runs a function as a subprocess of slrun rather than in a container. Nothing is built:
+// it listens on $PORT, and restarting it picks up changes to its sources.
+type Process struct {
+	Command []string `json:"command"` // Program and its arguments, e.g. ["node", "index.js"]
+	Dir     string   `json:"dir"`     // Working dir, watched for changes with watch
+}
+
+// Secret is an env variable of a function's containers whose value is kept out of the config,
+// read from a file or from slrun's own env.
+type Secret struct {
+	Name string `json:"name"` // Env variable set in containers
+	File string `json:"file"` // File holding the value, e.g. a mounted Docker or Kubernetes secret
+	Env  string `json:"env"`  // Or slrun's env variable holding the value
+}
+
+// Replicas scales a function out to more containers as requests in flight grow,
+// and back in, down to zero if Min is, as they idle.
+type Replicas struct {
+	Min            int    `json:"min"`             // Replicas kept running, 0 stops them all when idle
+	Max            int    `json:"max"`             // Most replicas run at once, default 4
+	TargetInflight int    `json:"target_inflight"` // Requests in flight a replica serves before another starts, default 1
+	IdleTimeout    string `json:"idle_timeout"`    // Replicas idle this long are stopped, default 5s
+}
+
+// Coalesce shares a function's response between identical GET requests in flight at once,
+// so a slow function, e.g. after a cold start, is called once rather than by each of them.
+type Coalesce struct {
+	Paths        []string `json:"paths"`          // Coalesced function paths, exact or ending in /*, all if empty
+	VaryHeaders  []string `json:"vary_headers"`   // Request headers telling requests apart, besides Authorization, Cookie and Accept
+	MaxBodyBytes int64    `json:"max_body_bytes"` // Largest response shared, default 1 MiB. Requests sharing a larger one call the function themselves
+}
+
+// Bulkhead caps the calls in flight to a function and the connections proxying them,
+// so a slow function can't take the gateway's capacity from the others.
+type Bulkhead struct {
+	MaxConcurrent int    `json:"max_concurrent"` // Calls in flight at once, across its replicas, default 100
+	MaxQueued     int    `json:"max_queued"`     // Calls waiting for one to complete, beyond which calls are rejected
+	QueueTimeout  string `json:"queue_timeout"`  // How long a call may wait, default 1s
+}
+
+// ProxyDNS is how the gateway resolves the hosts it proxies calls to. Resolved addresses are
+// cached, and resolved again when none of them accepts connections.
+type ProxyDNS struct {
+	TTL      string `json:"ttl"`      // How long addresses are cached, default 30s, not cached if 0
+	Strategy string `json:"strategy"` // Addresses dialed: any, ipv4_first, ipv6_first, ipv4_only or ipv6_only, default any
+}
+
+// Scaling scales a function on metrics, such as requests in flight or a queue's depth.
+type Scaling struct {
+	Interval string         `json:"interval"` // Time between evaluations of the rules, default 15s
+	Rules    []*ScalingRule `json:"rules"`    // The function gets the most replicas any rule asks for
+}
+
+// ScalingRule asks for one replica per Target of a metric.
+type ScalingRule struct {
+	Source string  `json:"source"` // inflight, prometheus or http
+	Target float64 `json:"target"` // Metric value one replica handles
+	URL    string  `json:"url"`    // Prometheus server, or the JSON document read by http
+	Query  string  `json:"query"`  // PromQL query, vector results are summed
+	Field  string  `json:"field"`  // Dot separated path of the number in the http document, e.g. queues.jobs.depth
+}
+
+// SLO is a function's latency and error objectives over a window.
+type SLO struct {
+	Latency          string  `json:"latency"`           // Invocations slower than this count against the latency objective, e.g. 300ms
+	LatencyObjective float64 `json:"latency_objective"` // Share of invocations faster than Latency, e.g. 0.99
+	ErrorObjective   float64 `json:"error_objective"`   // Share of invocations without errors or 5xx responses, e.g. 0.999
+	Window           string  `json:"window"`            // Compliance window, default 24h
+	AlertWindow      string  `json:"alert_window"`      // Window burn rates alert over, with a twelfth of it, default 1h
+	BurnRate         float64 `json:"burn_rate"`         // Burn rate alerting over both alert windows, default 14.4
+}
+
+// Warmup is requests sent to a function's container once it is ready,
+// e.g. so JIT-compiled runtimes are warm before real traffic arrives.
+type Warmup struct {
+	Path        string `json:"path"`        // Default /
+	Method      string `json:"method"`      // Default GET
+	Count       int    `json:"count"`       // Requests sent, default 1
+	Concurrency int    `json:"concurrency"` // Requests in flight at once, default 1
+}
+
+// Readiness probes a new container of a function until it is ready to serve, with one of
+// path, tcp and exec.
+type Readiness struct {
+	Path    string   `json:"path"`    // Path answering GET with a 2xx status once ready, e.g. /ready
+	TCP     bool     `json:"tcp"`     // Ready once its port, or socket, accepts connections
+	Exec    []string `json:"exec"`    // Command exiting 0 in the container once ready
+	Timeout int      `json:"timeout"` // Seconds a new container may take to be ready, default 30
+}
+
+// PreStop is a hook run before a function's container is stopped, e.g. to drain it.
+// The container is stopped whether or not the hook succeeds.
+type PreStop struct {
+	Exec    []string `json:"exec"`    // Command run in the container
+	Path    string   `json:"path"`    // Path requested on the function, e.g. /drain
+	Method  string   `json:"method"`  // Method of the Path request, default POST
+	Timeout int      `json:"timeout"` // Seconds to wait for the hook, default 10
+}
+
+// ScaleProfile applies to a function during a daily time window.
+type ScaleProfile struct {
+	Days    []string `json:"days"`    // mon, tue, ..., every day if empty
+	From    string   `json:"from"`    // HH:MM, local time
+	To      string   `json:"to"`      // HH:MM, windows ending before they start wrap past midnight
+	Enabled *bool    `json:"enabled"` // Enable or disable the function during the window
+}
+
+// Tenancy runs a separate instance of a function for each tenant,
+// started on the tenant's first request.
+type Tenancy struct {
+	Header  string             `json:"header"` // Header naming the tenant, default X-Tenant
+	Tenants map[string]*Tenant `json:"tenants"`
+}
+
+type Tenant struct {
+	Env map[string]string `json:"env"` // Added to the function's env
+}
 
-	ImageName   string
-	ContainerId string
-	IsRunning   bool
-	Port        int // 127.0.0.1:X->80/tcp
+// Metadata describes what a function does, for discovery by other users.
+type Metadata struct {
+	Description string    `json:"description,omitempty" yaml:"description"`
+	Owner       string    `json:"owner,omitempty" yaml:"owner"`
+	Routes      []string  `json:"routes,omitempty" yaml:"routes"`
+	Examples    []Example `json:"examples,omitempty" yaml:"examples"`
+}
+
+// Example is an example request to a function.
+type Example struct {
+	Description string `json:"description,omitempty" yaml:"description"`
+	Method      string `json:"method,omitempty" yaml:"method"`
+	Path        string `json:"path" yaml:"path"`
+	Body        string `json:"body,omitempty" yaml:"body"`
 }
 
 type Config struct {
-	ConfigFile string
-	Functions  []*Function `json:"functions"`
-	Policy     PolicyID
+	ConfigFile   string            `json:"-"`
+	Functions    []*Function       `json:"functions"`
+	Env          map[string]string `json:"env"`     // Added to every function's env
+	Secrets      []*Secret         `json:"secrets"` // Added to every function's secrets
+	Policy       PolicyID
+	FunctionHost string `json:"function_host"` // Host IP function ports are bound to, e.g. 127.0.0.1 or ::1
+	// Host port range of function containers, e.g. "20000-20999", picked by Docker if empty
+	FunctionPorts string      `json:"function_ports"`
+	Listeners     []*Listener `json:"listeners"`     // Gateway listeners, defaults to one on --host:--port
+	Fallback      string      `json:"fallback"`      // Function receiving requests that match no route
+	AdminAddress  string      `json:"admin_address"` // host:port of the admin API, disabled if empty
+	Dev           bool        `json:"dev"`           // Development mode, exposes function logs to clients
+	Capture       Capture     `json:"capture"`
+	Quotas        *Quotas     `json:"quotas"`
+	UsageExport   UsageExport `json:"usage_export"`
+	Redirects     []*PathRule `json:"redirects"` // Answered by the gateway
+	Rewrites      []*PathRule `json:"rewrites"`  // Applied before routing
+	// Bearer token of the admin API, needed unless admin_address is a loopback address
+	AdminToken string `json:"admin_token"`
+	// Headers applied to every response, before the function's own
+	ResponseHeaders *HeaderPolicy  `json:"response_headers"`
+	UploadDir       string         `json:"upload_dir"` // Host dir for uploaded files handed to functions
+	StateDir        string         `json:"state_dir"`  // Where slrun keeps state such as deployment history, default .slrun
+	Notifications   *Notifications `json:"notifications"`
+	QuietBuilds     bool           `json:"quiet_builds"` // Show build output only when a build fails
+	// Builder of function images: buildkit, or classic for daemons without BuildKit, default buildkit
+	Builder string `json:"builder"`
+	// Compression of build contexts sent to the Docker daemon: auto, none, gzip or zstd
+	BuildCompression string `json:"build_compression"`
+	// Function images built at once, default 4
+	BuildParallelism int       `json:"build_parallelism"`
+	Registry         *Registry `json:"registry"` // Where base images are pulled from
+	Offline          bool      `json:"offline"`  // Never pull images, use only those available locally
+	Cluster          *Cluster  `json:"cluster"`  // Run functions across several slrun nodes
+	Async            *Async    `json:"async"`    // Queue invocations asking to respond async, disabled if nil
+	Chaos            *Chaos    `json:"chaos"`    // Kill containers and fail Docker calls to test recovery, disabled if nil
+	// Largest response buffered rather than streamed, e.g. async results, default 10 MiB
+	ResponseBufferBytes int64       `json:"response_buffer_bytes"`
+	Quarantine          *Quarantine `json:"quarantine"` // Disable functions that keep violating rules, disabled if nil
+	// Rebuild and redeploy functions as their build dirs change, and apply changes to functions in the config file
+	Watch bool `json:"watch"`
+	OPA   *OPA `json:"opa"` // Rego policies guarding deploys and invocations, disabled if nil
+	// Images functions may build from or run, any if nil
+	BaseImages *BaseImages `json:"base_images"`
+	Rebuilds   *Rebuilds   `json:"rebuilds"` // Rebuild functions to pick up patched base images, never if nil
+	Fleet      *Fleet      `json:"fleet"`    // Report to, or aggregate reports of, a fleet of slrun instances
+	Peers      []*Peer     `json:"peers"`    // Other slrun instances invocations of some functions are relayed to
+	Relay      *Relay      `json:"relay"`    // Serve invocations relayed by peers, disabled if nil
+	DNS        *DNS        `json:"dns"`      // Resolve <function>.<domain> names to the gateway, disabled if nil
+	MDNS       *MDNS       `json:"mdns"`     // Advertise the gateway and functions on the LAN, disabled if nil
+	Webhooks   []*Webhook  `json:"webhooks"` // Received on /_slrun/webhooks/<name>, verified, then invoking a function
+	Bulkhead   *Bulkhead   `json:"bulkhead"` // Of functions without their own, calls aren't capped if nil
+	// Resolution of the hosts calls are proxied to, such as cluster nodes and peers
+	ProxyDNS *ProxyDNS `json:"proxy_dns"`
+	// How long slrun waits for invocations in flight when stopped, before stopping functions, default 30s
+	ShutdownGracePeriod string `json:"shutdown_grace_period"`
+	// Keep function containers once stopped, e.g. to inspect them, instead of removing them
+	KeepContainers bool `json:"keep_containers"`
+	// Bridge network function containers join to call each other by name
+	Network *FunctionNetwork `json:"network"`
+	// Builds function images on a remote daemon or BuildKit instance instead of the local daemon
+	RemoteBuild *RemoteBuild `json:"remote_build"`
+	// Pin base images by digest and normalize build contexts, so the same sources build the same image
+	ReproducibleBuilds bool `json:"reproducible_builds"`
+	// Container engine running functions and building their images, Docker as its env says if nil
+	Backend *Backend `json:"backend"`
+	// Bounds the history, logs and usage exports kept on disk, kept forever if nil
+	Retention *Retention `json:"retention"`
+}
+
+// Retention bounds what long-running slrun instances keep, pruning the oldest in the background.
+type Retention struct {
+	History *RetentionPolicy `json:"history"` // Deployment and invocation history of functions
+	Logs    *RetentionPolicy `json:"logs"`    // The daemon log, holding access and build logs, and HAR captures
+	Metrics *RetentionPolicy `json:"metrics"` // Usage exports
+	// Time between prunes, default 1h
+	Interval string `json:"interval"`
+	// Window prunes run in, e.g. off-peak hours, any time if empty
+	Days []string `json:"days"` // mon, tue, ..., every day if empty
+	From string   `json:"from"` // HH:MM, local time
+	To   string   `json:"to"`   // HH:MM, windows ending before they start wrap past midnight
+}
+
+// RetentionPolicy limits are unlimited if empty.
+type RetentionPolicy struct {
+	MaxAge  string `json:"max_age"`  // e.g. "720h"
+	MaxSize string `json:"max_size"` // e.g. "100m", of each function's history, the daemon log, or all files of a dir
+}
+
+// Backend is the container engine slrun drives through its Docker compatible API, or for
+// containerd, through nerdctl.
+type Backend struct {
+	Engine string `json:"engine"` // docker, podman or containerd, default docker
+	Host   string `json:"host"`   // API socket, e.g. unix:///run/user/1000/podman/podman.sock, default the engine's
+	// containerd namespace of function containers and images, default slrun
+	Namespace string `json:"namespace"`
+	// Dirs of containerd's CNI plugins and network configs, default /opt/cni/bin and /etc/cni/net.d
+	CNIPath       string `json:"cni_path"`
+	CNIConfigPath string `json:"cni_config_path"`
+}
+
+// RemoteBuild is where function images are built, one of a remote Docker daemon or a buildx
+// builder. Images built are loaded into the local daemon, which runs them.
+type RemoteBuild struct {
+	DockerHost string `json:"docker_host"` // Remote daemon, e.g. tcp://farm:2376, with the DOCKER_TLS_VERIFY and DOCKER_CERT_PATH TLS settings
+	Builder    string `json:"builder"`     // buildx builder, e.g. created with docker buildx create --driver remote tcp://buildkitd:1234
+	Fallback   bool   `json:"fallback"`    // Build on the local daemon while the remote one can't be reached
+}
+
+// FunctionNetwork is the Docker bridge network function containers join, on which each function
+// is reachable from the others by its name and slrun-<name>.
+type FunctionNetwork struct {
+	Name     string `json:"name"`     // Created if missing, default slrun-<instance id>
+	Disabled bool   `json:"disabled"` // Containers join Docker's default bridge network, unreachable by name
+}
+
+// Webhook verifies a provider's signature on webhooks before invoking a function with them.
+type Webhook struct {
+	Name     string  `json:"name"`
+	Function string  `json:"function"`
+	Path     string  `json:"path"`     // Of the function invoked, default /
+	Provider string  `json:"provider"` // Signature scheme: github, stripe, slack or hmac
+	Secret   *Secret `json:"secret"`   // Signing secret, read on every webhook so it can be rotated
+	// Header with the hex HMAC-SHA256 of the body, optionally prefixed sha256=, for hmac, default X-Signature
+	SignatureHeader string `json:"signature_header"`
+	Tolerance       string `json:"tolerance"` // Accepted age of signed timestamps of stripe and slack, default 5m
+}
+
+// MDNS advertises the gateway and its functions as DNS-SD services with multicast DNS.
+type MDNS struct {
+	Name string `json:"name"` // Advertised as <name>.local, default the hostname
+}
+
+// DNS serves names of functions, <function>.<domain>, resolving to the gateway. The gateway
+// routes requests for these hosts to the function they name.
+type DNS struct {
+	Address string `json:"address"` // host:port DNS is served on over UDP and TCP, default 127.0.0.1:5353
+	Domain  string `json:"domain"`  // Default slrun.local
+	IP      string `json:"ip"`      // Gateway address names resolve to, default 127.0.0.1
+}
+
+// Peer is another slrun instance, serving a relay, that runs functions for this one.
+type Peer struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`       // Of the peer's relay, e.g. http://lab-server:7950
+	Token     string   `json:"token"`     // The peer's relay token
+	Functions []string `json:"functions"` // Functions invoked on the peer, not defined here
+}
+
+// Relay serves invocations of this instance's functions to its peers.
+type Relay struct {
+	Address   string   `json:"address"`   // host:port relayed invocations are served on
+	Token     string   `json:"token"`     // Required of relayed invocations
+	Functions []string `json:"functions"` // Functions peers may invoke, all if empty
+}
+
+// Fleet reports this instance's function states and usage to a central slrun, the aggregator,
+// or makes this instance the aggregator, showing the fleet in one dashboard.
+type Fleet struct {
+	Name      string `json:"name"`      // This instance in the fleet, default its hostname
+	Report    string `json:"report"`    // Fleet address of the aggregator to report to, e.g. lab-server:9091
+	Interval  string `json:"interval"`  // Between reports, default 30s
+	Aggregate bool   `json:"aggregate"` // Receive the fleet's reports on address
+	Address   string `json:"address"`   // host:port receiving reports, apart from the admin API
+	Secret    string `json:"secret"`    // Shared by the fleet, required of reports
+}
+
+// Rebuilds rebuilds functions on a schedule, and as their base images change upstream,
+// rolling them out without downtime.
+type Rebuilds struct {
+	Interval      string `json:"interval"`       // Rebuild every function this often, e.g. 168h for weekly
+	CheckInterval string `json:"check_interval"` // Check base images for new digests upstream this often, e.g. 6h
+}
+
+// BaseImages restricts the images functions build from, named by FROM in their Dockerfile,
+// or run as their prebuilt image. Patterns are matched against images' short and full names,
+// e.g. python:3.12-slim and docker.io/library/python:3.12-slim, with * not matching /.
+type BaseImages struct {
+	Allow  []string `json:"allow"`   // Patterns of allowed images, e.g. python:3.12-*, any if empty
+	Deny   []string `json:"deny"`    // Patterns of denied images, even if allowed, e.g. *:latest
+	MaxAge string   `json:"max_age"` // Images created longer ago are denied as outdated, e.g. 2160h
+}
+
+// OPA evaluates Rego policies with an embedded OPA. Deploys are denied by the deny rule of
+// package slrun.deploy, and invocations by the deny rule of package slrun.invoke.
+type OPA struct {
+	Files []string `json:"files"` // Rego files, or dirs of them
+}
+
+// Quarantine disables a function once it violates security or policy rules Violations times within Window,
+// until an admin releases it. Violations are reported through the admin API, or are events of the listed types.
+type Quarantine struct {
+	Violations int      `json:"violations"` // Violations within Window quarantining a function, default 3
+	Window     string   `json:"window"`     // Default 1h
+	Events     []string `json:"events"`     // Event types counted as violations of their function, e.g. tests.failed
+}
+
+// Async runs invocations sent with "Prefer: respond-async" in the background, highest priority first.
+type Async struct {
+	Workers   int    `json:"workers"`    // Invocations run at once, default 4
+	MaxQueued int    `json:"max_queued"` // Invocations waiting, beyond which more are rejected, default 1000
+	Aging     string `json:"aging"`      // Waiting invocations gain a priority level every Aging, default 30s
+	ResultTTL string `json:"result_ttl"` // How long results of finished invocations are kept, default 10m
+}
+
+// Chaos tests that the runtime recovers from its functions' containers dying and Docker failing.
+type Chaos struct {
+	Interval          string   `json:"interval"`            // Time between container kills, default 1m
+	Functions         []string `json:"functions"`           // Functions whose containers may be killed, all if empty
+	DockerFailureRate float64  `json:"docker_failure_rate"` // Share of the runtime's Docker API calls failed, 0 to 1
+	RecoveryTimeout   string   `json:"recovery_timeout"`    // Time a killed function has to serve again, default 60s
+	Report            string   `json:"report"`              // Report file, default <state_dir>/chaos-report.json
+}
+
+// Cluster places each function on the nodes whose labels satisfy its requirements.
+// All nodes share the config, each finds itself in Nodes by name.
+type Cluster struct {
+	Node   string  `json:"node"`   // Name of this node, default the hostname
+	Nodes  []*Node `json:"nodes"`  // All nodes, including this one
+	Secret string  `json:"secret"` // Shared by nodes, required on invocations forwarded between them
+	Gossip *Gossip `json:"gossip"` // Discover other nodes and detect their failure by gossip, instead of listing them
+}
+
+// Gossip membership of cluster nodes. With gossip, Nodes need only list this node.
+type Gossip struct {
+	Address   string   `json:"address"`   // host:port gossiped on, TCP and UDP, default 0.0.0.0:7947
+	Advertise string   `json:"advertise"` // host:port other nodes reach Address at, if different
+	Join      []string `json:"join"`      // Gossip addresses of nodes joined through at startup
+}
+
+type Node struct {
+	Name    string            `json:"name"`
+	Address string            `json:"address"` // host:port the node serves invocations forwarded by other nodes on
+	Labels  map[string]string `json:"labels"`  // e.g. {"gpu": "true"}, arch and os are added for this node
+}
+
+// Notifications alert on failures such as crash loops, failed builds and failed tests.
+type Notifications struct {
+	Notifiers []*Notifier `json:"notifiers"`
+	// Min time between notifications of the same event type and function, default 10m
+	RateLimit string `json:"rate_limit"`
+}
+
+// Notifier sends notifications to a Slack or Discord webhook, any other webhook, or by email.
+type Notifier struct {
+	Type     string   `json:"type"`     // slack, discord, webhook or email
+	URL      string   `json:"url"`      // Webhook URL
+	Events   []string `json:"events"`   // Event types notified, all failures if empty
+	Template string   `json:"template"` // Go text/template of the message, executed on the event
+	SMTP     *SMTP    `json:"smtp"`     // Mail server of email notifiers
+}
+
+type SMTP struct {
+	Address  string   `json:"address"` // host:port
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Registry configures mirrors that Docker Hub base images of functions are pulled through,
+// and credentials of private registries images are pulled from.
+type Registry struct {
+	Mirrors []string       `json:"mirrors"` // Docker Hub mirror hosts tried in order, e.g. mirror.gcr.io
+	Cache   *RegistryCache `json:"cache"`   // Pull-through cache run by slrun, tried before the mirrors
+	// Credentials by registry, before those docker login saved
+	Auths []*RegistryAuth `json:"auths"`
+}
+
+// RegistryAuth is the credentials of a registry, with one of password and password_env.
+type RegistryAuth struct {
+	Registry    string `json:"registry"` // Host, e.g. ghcr.io, or docker.io for Docker Hub
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	PasswordEnv string `json:"password_env"` // Or slrun's env variable holding the password, e.g. a CI token
+}
+
+// RegistryCache is a registry container caching Docker Hub pulls on this host.
+type RegistryCache struct {
+	Port int    `json:"port"` // Host port on 127.0.0.1, default 5000
+	Dir  string `json:"dir"`  // Host dir of cached images, default <state_dir>/registry-cache
+}
+
+// HeaderPolicy changes response headers at the gateway.
+type HeaderPolicy struct {
+	Set      map[string]string `json:"set"`      // Set, replacing the function's value
+	Defaults map[string]string `json:"defaults"` // Set if the function didn't
+	Remove   []string          `json:"remove"`
+}
+
+// PathRule maps request paths matching From to To.
+// A From ending in /* matches the prefix, and the rest replaces * in To.
+type PathRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"` // Redirect status, 301, 302, 307 or 308, default 301
+}
+
+// UsageExport configures export of invocation usage for chargeback and cost estimation.
+type UsageExport struct {
+	Dir      string `json:"dir"`       // Scheduled exports are written here
+	Format   string `json:"format"`    // csv or json, default csv
+	Interval string `json:"interval"`  // Time between scheduled exports, e.g. "1h", none if empty
+	MemoryMB int    `json:"memory_mb"` // Memory assumed per function for GB-seconds, default 128
+}
+
+// Quotas limits usage of the gateway per API key and per tenant.
+type Quotas struct {
+	KeyHeader    string            `json:"key_header"`    // Header carrying the API key, default X-Api-Key
+	TenantHeader string            `json:"tenant_header"` // Header naming the tenant, default X-Tenant
+	Keys         []*KeyQuota       `json:"keys"`
+	Tenants      map[string]*Quota `json:"tenants"` // By tenant name
+	// Quota of requests with no known key or tenant, which are rejected if unset
+	Anonymous *Quota `json:"anonymous"`
+}
+
+type KeyQuota struct {
+	Name string `json:"name"` // Reported in usage instead of the key
+	Key  string `json:"key"`
+	Quota
+}
+
+// Quota limits are unlimited if zero.
+type Quota struct {
+	RequestsPerDay int `json:"requests_per_day"`
+	MaxConcurrent  int `json:"max_concurrent"`
+}
+
+// Capture configures recording of gateway traffic into HAR files.
+type Capture struct {
+	Dir           string   `json:"dir"`            // HAR files are written here when a capture stops
+	MaxEntries    int      `json:"max_entries"`    // Entries kept per function, oldest dropped first
+	MaxBodyBytes  int      `json:"max_body_bytes"` // Request and response bodies are truncated to this size
+	RedactHeaders []string `json:"redact_headers"` // Header values hidden in captures, in addition to auth and cookies
+	RedactQuery   []string `json:"redact_query"`   // Query parameter values hidden in captures, in addition to common credentials
+	Bodies        *bool    `json:"bodies"`         // Whether request and response bodies are captured, default true
+}
+
+// Listener is a gateway address with its own routing table, TLS and middleware chain.
+type Listener struct {
+	Address    string   `json:"address"`     // host:port to listen on
+	Functions  []string `json:"functions"`   // Functions routed by this listener, all if empty
+	TLSCert    string   `json:"tls_cert"`    // Serve HTTPS if both cert and key are set
+	TLSKey     string   `json:"tls_key"`     // Key for TLSCert
+	Middleware []string `json:"middleware"`  // Applied in order, e.g. ["logging", "auth"]
+	AuthTokens []string `json:"auth_tokens"` // Bearer tokens accepted by the auth middleware
+	Fallback   string   `json:"fallback"`    // Overrides Config.Fallback for this listener
 }
 
 type PolicyID string
@@ -29,4 +587,10 @@ type Policy interface {
 	PreFunctionCall(f *Function) error
 	PostFunctionCall(f *Function) error
 	OnTick() error
+	// AddFunction is called when a function is added after runtime start,
+	// e.g. a per-tenant instance on its first request or an enabled function
+	AddFunction(f *Function) error
+	// RemoveFunction is called when a function is removed, e.g. disabled.
+	// The policy stops its container if running.
+	RemoveFunction(f *Function) error
 }
@@ -0,0 +1,85 @@
+// Package slrunfn serves a Go function under the slrun function contract.
+//
+// Serve listens where slrun expects the function, on $SLRUN_SOCKET or port $SLRUN_PORT,
+// answers slrun's health checks at $SLRUN_READY_PATH, and on $SLRUN_STOP_SIGNAL stops
+// taking requests and finishes those in flight within $SLRUN_STOP_TIMEOUT seconds.
+package slrunfn
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Env returns the contract's environment variable, or def if slrun didn't set it.
+func Env(name string, def string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func listen() (net.Listener, error) {
+	if socket := Env("SLRUN_SOCKET", ""); socket != "" {
+		os.Remove(socket)
+		return net.Listen("unix", socket)
+	}
+	return net.Listen("tcp", ":"+Env("SLRUN_PORT", "80"))
+}
+
+func stopSignal() os.Signal {
+	switch Env("SLRUN_STOP_SIGNAL", "SIGTERM") {
+	case "SIGINT":
+		return syscall.SIGINT
+	case "SIGQUIT":
+		return syscall.SIGQUIT
+	case "SIGUSR1":
+		return syscall.SIGUSR1
+	case "SIGUSR2":
+		return syscall.SIGUSR2
+	}
+	return syscall.SIGTERM
+}
+
+// Serve serves handler until slrun stops the function.
+// The health path answers 200 once serving, unless it is / and served by handler.
+func Serve(handler http.Handler) error {
+	readyPath := Env("SLRUN_READY_PATH", "/healthz")
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	if readyPath != "/" {
+		mux.HandleFunc(readyPath, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	l, err := listen()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), stopSignal())
+	defer stop()
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(l) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Finish requests in flight before slrun kills the container
+	timeout, _ := strconv.Atoi(Env("SLRUN_STOP_TIMEOUT", "10"))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(max(timeout-1, 1))*time.Second)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	<-errs // http.ErrServerClosed
+	return err
+}
@@ -0,0 +1,50 @@
+// Serve a Node.js function under the slrun function contract.
+//
+// serve() listens where slrun expects the function, on $SLRUN_SOCKET or port $SLRUN_PORT,
+// answers slrun's health checks at $SLRUN_READY_PATH, and on $SLRUN_STOP_SIGNAL stops
+// taking requests and finishes those in flight within $SLRUN_STOP_TIMEOUT seconds.
+//
+//   const { serve } = require("./slrun-function");
+//   serve((req, res) => res.end("hello\n"));
+
+"use strict";
+
+const fs = require("fs");
+const http = require("http");
+
+// env returns the contract's environment variable, or def if slrun didn't set it.
+function env(name, def) {
+  return process.env[name] || def;
+}
+
+// serve serves handler, a request listener, until slrun stops the function.
+function serve(handler) {
+  const readyPath = env("SLRUN_READY_PATH", "/healthz");
+  const server = http.createServer((req, res) => {
+    if (readyPath !== "/" && req.url.split("?")[0] === readyPath) {
+      res.writeHead(200);
+      res.end(req.method === "HEAD" ? undefined : "ok\n");
+      return;
+    }
+    handler(req, res);
+  });
+
+  const socket = env("SLRUN_SOCKET", "");
+  if (socket) {
+    fs.rmSync(socket, { force: true });
+    server.listen(socket);
+  } else {
+    server.listen(Number(env("SLRUN_PORT", "80")));
+  }
+
+  process.once(env("SLRUN_STOP_SIGNAL", "SIGTERM"), () => {
+    // Finish requests in flight before slrun kills the container
+    const timeout = Math.max(Number(env("SLRUN_STOP_TIMEOUT", "10")) - 1, 1) * 1000;
+    server.close(() => process.exit(0));
+    server.closeIdleConnections();
+    setTimeout(() => process.exit(0), timeout).unref();
+  });
+  return server;
+}
+
+module.exports = { env, serve };
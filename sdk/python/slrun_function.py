@@ -0,0 +1,92 @@
+"""Serve a Python function under the slrun function contract.
+
+serve() listens where slrun expects the function, on $SLRUN_SOCKET or port $SLRUN_PORT,
+answers slrun's health checks at $SLRUN_READY_PATH, and on $SLRUN_STOP_SIGNAL stops
+taking requests and lets those in flight finish before exiting.
+
+    from http.server import BaseHTTPRequestHandler
+    import slrun_function
+
+    class Handler(BaseHTTPRequestHandler):
+        def do_GET(self):
+            self.send_response(200)
+            self.end_headers()
+            self.wfile.write(b"hello\\n")
+
+    slrun_function.serve(Handler)
+"""
+
+import os
+import signal
+import socketserver
+import threading
+from http.server import ThreadingHTTPServer
+
+
+def env(name, default):
+    """Returns the contract's environment variable, or default if slrun didn't set it."""
+    return os.environ.get(name) or default
+
+
+# Request threads aren't daemons, so server_close waits for requests in flight
+class _HTTPServer(ThreadingHTTPServer):
+    daemon_threads = False
+
+
+class _UnixHTTPServer(socketserver.ThreadingMixIn, socketserver.UnixStreamServer):
+    daemon_threads = False
+
+    def get_request(self):
+        request, _ = super().get_request()
+        return request, ("local", 0)
+
+
+def _health_handler(handler_class, ready_path):
+    class Handler(handler_class):
+        def _health(self, body):
+            if self.path.split("?")[0] != ready_path:
+                return False
+            self.send_response(200)
+            self.end_headers()
+            if body:
+                self.wfile.write(b"ok\n")
+            return True
+
+        def _handle(self, method, body):
+            if self._health(body):
+                return
+            if not hasattr(handler_class, "do_" + method):
+                self.send_error(501)
+                return
+            getattr(handler_class, "do_" + method)(self)
+
+        def do_GET(self):
+            self._handle("GET", True)
+
+        def do_HEAD(self):
+            self._handle("HEAD", False)
+
+    return Handler
+
+
+def serve(handler_class):
+    """Serves handler_class, a BaseHTTPRequestHandler, until slrun stops the function."""
+    ready_path = env("SLRUN_READY_PATH", "/healthz")
+    if ready_path != "/":
+        handler_class = _health_handler(handler_class, ready_path)
+
+    sock = env("SLRUN_SOCKET", "")
+    if sock:
+        if os.path.exists(sock):
+            os.remove(sock)
+        server = _UnixHTTPServer(sock, handler_class)
+    else:
+        server = _HTTPServer(("", int(env("SLRUN_PORT", "80"))), handler_class)
+
+    # shutdown() blocks until serve_forever returns, so it runs off the signal handler's thread
+    def stop(signum, frame):
+        threading.Thread(target=server.shutdown).start()
+
+    signal.signal(getattr(signal, env("SLRUN_STOP_SIGNAL", "SIGTERM")), stop)
+    server.serve_forever()
+    server.server_close()  # Waits for requests in flight